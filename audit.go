@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const defaultAuditWorkers = 4
+
+// AuditConfig is everything runAudit needs once flags and environment are resolved.
+type AuditConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	Prefix      string
+	Workers     int // blobs verified at once, 0 means defaultAuditWorkers
+	HTTPClient  *http.Client
+	Totals      *transferTotals // nil for none
+}
+
+// AuditResult summarizes an audit. Blobs without a stored Content-MD5 cannot be
+// checked and are only counted.
+type AuditResult struct {
+	Blobs      int
+	Verified   int
+	NoMD5      int
+	Mismatched []string
+	Failed     []string
+	Bytes      int64
+	Duration   time.Duration
+}
+
+// OK reports whether every blob that could be checked matched its Content-MD5.
+func (r AuditResult) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Failed) == 0
+}
+
+func (r AuditResult) String() string {
+	return fmt.Sprintf("%d blobs, %d verified, %d mismatched, %d failed, %d without Content-MD5 (%d bytes in %v)",
+		r.Blobs, r.Verified, len(r.Mismatched), len(r.Failed), r.NoMD5, r.Bytes, r.Duration.Round(time.Millisecond))
+}
+
+// runAudit downloads every blob under cfg.Prefix, discarding the content, and compares
+// its MD5 with the Content-MD5 the service has stored for it. Per-blob failures are
+// reported in the result, only a failed listing is returned as an error.
+func runAudit(cfg AuditConfig) (AuditResult, error) {
+	started := time.Now()
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultAuditWorkers
+	}
+
+	blobs, err := azure.ListAzureBlobInfo(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.Prefix, cfg.HTTPClient)
+	if err != nil {
+		return AuditResult{}, err
+	}
+
+	result := AuditResult{Blobs: len(blobs)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, b := range blobs {
+		if b.ContentMD5 == "" {
+			log.Noticef("Audit: %s has no Content-MD5, skipped", b.Name)
+			result.NoMD5++
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b azure.BlobInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			cfg.Totals.start()
+			n, sum, err := azure.HashAzureBlob(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+				cfg.Container, b.Name, cfg.Totals.counting(io.Discard), cfg.HTTPClient)
+			cfg.Totals.finish()
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Bytes += n
+			switch {
+			case err != nil:
+				log.Errorf("Audit: %s: %v", b.Name, err)
+				result.Failed = append(result.Failed, b.Name)
+			case sum != b.ContentMD5:
+				log.Errorf("Audit: %s: content MD5 %s, stored Content-MD5 %s", b.Name, sum, b.ContentMD5)
+				result.Mismatched = append(result.Mismatched, b.Name)
+			default:
+				log.Functionf("Audit: %s verified (%d bytes)", b.Name, n)
+				result.Verified++
+			}
+		}(b)
+	}
+	wg.Wait()
+	slices.Sort(result.Mismatched)
+	slices.Sort(result.Failed)
+
+	result.Duration = time.Since(started)
+	return result, nil
+}
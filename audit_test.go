@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// auditBlob is a blob of auditStore with the Content-MD5 the service reports for it.
+type auditBlob struct {
+	data       []byte
+	contentMD5 []byte
+	headers    http.Header // further response headers, e.g. metadata
+}
+
+// auditStore serves List Blobs, Get Blob and Get Blob Properties of a single container
+// for runAudit, runCompare and runInspect.
+type auditStore struct {
+	mu       sync.Mutex
+	blobs    map[string]auditBlob
+	inFlight int
+	maxGets  int // most Get Blob requests served at once
+}
+
+func (s *auditStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("comp") == "list" {
+		s.serveList(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	_, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	b, ok := s.blobs[name]
+	s.inFlight++
+	s.maxGets = max(s.maxGets, s.inFlight)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	if !ok {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+	for k, v := range b.headers {
+		w.Header()[k] = v
+	}
+	if b.contentMD5 != nil {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(b.contentMD5))
+	}
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(b.data)
+	}
+}
+
+func (s *auditStore) serveList(w http.ResponseWriter, prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, name := range names {
+		b := s.blobs[name]
+		fmt.Fprintf(w, `<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length>`, name, len(b.data))
+		if b.contentMD5 != nil {
+			fmt.Fprintf(w, `<Content-MD5>%s</Content-MD5>`, base64.StdEncoding.EncodeToString(b.contentMD5))
+		}
+		fmt.Fprint(w, `<BlobType>BlockBlob</BlobType></Properties></Blob>`)
+	}
+	fmt.Fprint(w, `</Blobs><NextMarker/></EnumerationResults>`)
+}
+
+func md5Of(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+func TestRunAudit(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{
+		"images/good-1": {data: []byte("one"), contentMD5: md5Of([]byte("one"))},
+		"images/good-2": {data: []byte("two"), contentMD5: md5Of([]byte("two"))},
+		"images/rotten": {data: []byte("flipped bit"), contentMD5: md5Of([]byte("original"))},
+		"images/no-md5": {data: []byte("committed without MD5")},
+		"other/good":    {data: []byte("out of scope"), contentMD5: md5Of([]byte("out of scope"))},
+	}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	cfg := fakeAccountOf(srv).audit()
+	cfg.Prefix = "images/"
+	cfg.Workers = 2
+	result, err := runAudit(cfg)
+	require.NoError(t, err)
+	require.False(t, result.OK())
+	require.Equal(t, 4, result.Blobs)
+	require.Equal(t, 2, result.Verified)
+	require.Equal(t, 1, result.NoMD5)
+	require.Equal(t, []string{"images/rotten"}, result.Mismatched)
+	require.Empty(t, result.Failed)
+	require.Equal(t, int64(len("one")+len("two")+len("flipped bit")), result.Bytes)
+	require.LessOrEqual(t, store.maxGets, 2)
+}
+
+func TestRunAuditReportsFailedBlobs(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{
+		"good": {data: []byte("one"), contentMD5: md5Of([]byte("one"))},
+	}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	// listed, then gone before it is read
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store.ServeHTTP(w, r)
+		if r.URL.Query().Get("comp") == "list" {
+			store.mu.Lock()
+			delete(store.blobs, "good")
+			store.mu.Unlock()
+		}
+	})
+
+	result, err := runAudit(fakeAccountOf(srv).audit())
+	require.NoError(t, err)
+	require.False(t, result.OK())
+	require.Equal(t, []string{"good"}, result.Failed)
+	require.Zero(t, result.Verified)
+}
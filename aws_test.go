@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve-libs/zedUpload/awsutil"
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+)
+
+func envOrSkip(t *testing.T, key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		t.Skipf("Skipping test: environment variable %s not set", key)
+	}
+	return v
+}
+
+// interruptingDownloader fails the transfer as soon as some parts are done,
+// like a connection dropped mid-download.
+type interruptingDownloader struct {
+	downloader
+}
+
+func (d interruptingDownloader) start(remoteFile, localFile string, objSize int64,
+	doneParts types.DownloadedParts) (<-chan transferEvent, func(), error) {
+	events, stop, err := d.downloader.start(remoteFile, localFile, objSize, doneParts)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make(chan transferEvent)
+	go func() {
+		defer close(out)
+		for e := range events {
+			if parts := e.GetDoneParts(); len(parts.Parts) > 0 {
+				out <- fakeEvent{parts: parts, err: errors.New("interrupted")}
+				return
+			}
+			out <- e
+		}
+	}()
+	return out, stop, nil
+}
+
+// TestRunDownloadResumesS3 needs AWS_REMOTE_FILE to span several S3 parts.
+func TestRunDownloadResumesS3(t *testing.T) {
+	region := envOrSkip(t, "AWS_ACCOUNT_URL")
+	bucket := envOrSkip(t, "AWS_CONTAINER")
+	remoteFile := envOrSkip(t, "AWS_REMOTE_FILE")
+	keyID := envOrSkip(t, "AWS_KEY_ID")
+	secret := envOrSkip(t, "AWS_KEY_SECRET")
+
+	dCtx, err := zedUpload.NewDronaCtx("resume-test", 0)
+	require.NoError(t, err)
+	ep, err := dCtx.NewSyncerDest(SyncAwsTr, region, bucket,
+		&zedUpload.AuthInput{AuthType: "s3", Uname: keyID, Password: secret})
+	require.NoError(t, err)
+
+	cfg := Config{
+		Downloader:     interruptingDownloader{dronaDownloader{ep: ep}},
+		RemoteFile:     remoteFile,
+		LocalFile:      filepath.Join(t.TempDir(), filepath.Base(remoteFile)),
+		ResumePartSize: awsutil.S3PartSize,
+	}
+	_, err = runDownload(cfg)
+	require.ErrorContains(t, err, "interrupted")
+
+	cfg.Downloader = dronaDownloader{ep: ep}
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Resumed, "second run should pick up the saved parts")
+}
@@ -0,0 +1,91 @@
+package azure_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestContainerAccessPolicies(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "plain.bin", []byte("x"))
+	store.acls[fakeContainer] = fakeACL{publicAccess: "blob"}
+
+	policies, err := azure.GetContainerAccessPolicies(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, nil)
+	require.NoError(t, err)
+	require.Empty(t, policies)
+
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := []azure.AccessPolicy{
+		{ID: "support", Expiry: expiry, Permissions: "r"},
+		{ID: "ci", Start: expiry.Add(-time.Hour), Expiry: expiry, Permissions: "rw"},
+	}
+	require.NoError(t, azure.SetContainerAccessPolicies(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, nil, want))
+	policies, err = azure.GetContainerAccessPolicies(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, nil)
+	require.NoError(t, err)
+	require.Len(t, policies, 2)
+	for i := range want {
+		require.Equal(t, want[i].ID, policies[i].ID)
+		require.True(t, want[i].Start.Equal(policies[i].Start))
+		require.True(t, want[i].Expiry.Equal(policies[i].Expiry))
+		require.Equal(t, want[i].Permissions, policies[i].Permissions)
+	}
+	require.Equal(t, "blob", store.acls[fakeContainer].publicAccess, "public access is kept")
+
+	err = azure.SetContainerAccessPolicies(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, nil, []azure.AccessPolicy{{Permissions: "r"}})
+	require.ErrorContains(t, err, "without an ID")
+}
+
+// TestSasWithStoredAccessPolicy mints a SAS that refers to a stored access policy
+// and revokes it by removing the policy.
+func TestSasWithStoredAccessPolicy(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "shared.bin", []byte("shared content"))
+	accountURL := newFakeAzure(t, store.ServeHTTP)
+	httpClient := &http.Client{}
+	require.NoError(t, azure.SetContainerAccessPolicies(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, httpClient, []azure.AccessPolicy{
+			{ID: "support", Expiry: time.Now().Add(time.Hour), Permissions: "r"},
+		}))
+
+	sasURL, err := azure.GenerateBlobSasURIWithOptions(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", httpClient, 0, azure.SasOptions{Identifier: "support"})
+	require.NoError(t, err)
+	q := sasQuery(t, sasURL)
+	require.Equal(t, "support", q.Get("si"))
+	for _, field := range []string{"sp", "st", "se"} {
+		require.False(t, q.Has(field), "%s is the policy's", field)
+	}
+	require.Equal(t, sdkSasSignature(t, q, "shared.bin"), q.Get("sig"))
+
+	get := func() int {
+		t.Helper()
+		resp, err := http.Get(sasURL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, get())
+
+	// revoked
+	require.NoError(t, azure.SetContainerAccessPolicies(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, httpClient, nil))
+	require.Equal(t, http.StatusForbidden, get())
+
+	// a SAS of its own still needs a duration
+	_, err = azure.GenerateBlobSasURIWithOptions(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", httpClient, 0, azure.SasOptions{})
+	require.ErrorContains(t, err, "invalid SAS duration")
+}
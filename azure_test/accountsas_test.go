@@ -0,0 +1,32 @@
+package azure_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestAccountSASSignsRequests(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "images/a.qcow2", []byte("a"))
+	var sigs []string
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		sigs = append(sigs, r.URL.Query().Get("sig"))
+		require.Len(t, r.URL.Query()["sig"], 1, "the SAS is in the query once, whatever else is")
+		store.ServeHTTP(w, r)
+	})
+	azure.SetAccountSAS(accountURL+"/", "sv=2022-11-02&sp=rl&sig=c2VjcmV0")
+	t.Cleanup(func() { azure.SetAccountSAS(accountURL, "") })
+
+	names, err := azure.ListAzureBlob(accountURL, "", "", fakeContainer, &http.Client{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"images/a.qcow2"}, names)
+	size, _, err := azure.GetAzureBlobMetaData(accountURL, "", "", fakeContainer, "images/a.qcow2", &http.Client{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), size)
+	require.Equal(t, []string{"c2VjcmV0", "c2VjcmV0"}, sigs)
+}
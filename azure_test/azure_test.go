@@ -14,16 +14,28 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/joho/godotenv/autoload"
 	"github.com/stretchr/testify/require"
 
-	azure "github.com/lf-edge/eve-libs/zedUpload/azureutil"
 	"github.com/lf-edge/eve-libs/zedUpload/types"
+	azure "testAzureDownload/azureutil"
 )
 
+// envFileCandidates are where TestMain looks for a .env file: the test directory,
+// then the module root. Without one the tests that need an account skip.
+var envFileCandidates = []string{".env", "../.env"}
+
 func TestMain(m *testing.M) {
-	// load .env from the test directory
-	_ = godotenv.Load("../.env") // or specify full path if needed
+	for _, path := range envFileCandidates {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		// a .env that is there but unreadable is a mistake, not a reason to skip
+		if err := godotenv.Load(path); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load env file %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		break
+	}
 	os.Exit(m.Run())
 }
 
@@ -36,8 +48,9 @@ func getEnvOrSkip(t *testing.T, key string) string {
 	return v
 }
 
+// newHTTPClient bounds connecting and stalls, not the whole transfer
 func newHTTPClient() *http.Client {
-	return &http.Client{Timeout: 2 * time.Minute}
+	return azure.NewHTTPClient(azure.DefaultClientTimeouts())
 }
 
 func randomBlobName(prefix string) string {
@@ -87,7 +100,7 @@ func TestListAndDeleteBlob(t *testing.T) {
 	err := os.WriteFile(localFile, []byte(content), 0644)
 	require.NoError(t, err)
 
-	url, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	url, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
 	require.NoError(t, err)
 	require.Contains(t, url, blobName)
 
@@ -131,8 +144,9 @@ func TestUploadAndGetMetaData(t *testing.T) {
 	require.NoError(t, err)
 
 	// Upload
-	_, err = azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	_, info, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
 	require.NoError(t, err)
+	require.NotEmpty(t, info.ETag)
 
 	// Get metadata
 	length, md5, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, blobName, httpClient)
@@ -158,7 +172,7 @@ func TestGenerateBlobSasURI(t *testing.T) {
 	err := os.WriteFile(localFile, []byte("sas content"), 0644)
 	require.NoError(t, err)
 
-	_, err = azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	_, _, err = azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
 	require.NoError(t, err)
 
 	// Generate SAS
@@ -194,7 +208,7 @@ func TestDownloadAzureBlobByChunks(t *testing.T) {
 	require.NoError(t, os.WriteFile(srcPath, content, 0644))
 
 	// upload
-	_, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, srcPath, httpClient)
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, srcPath, httpClient)
 	require.NoError(t, err)
 
 	// **prepare a dummy localFile path**
@@ -291,7 +305,7 @@ func TestDownloadAzureBlob(t *testing.T) {
 	require.NoError(t, os.WriteFile(srcPath, content, 0644))
 
 	// Upload the blob
-	_, err := azure.UploadAzureBlob(
+	_, _, err := azure.UploadAzureBlob(
 		accountURL, accountName, accountKey,
 		container, blobName, srcPath, httpClient,
 	)
@@ -329,3 +343,112 @@ func TestDownloadAzureBlob(t *testing.T) {
 		container, blobName, httpClient,
 	))
 }
+
+// TestSetAzureBlobExpiry needs a hierarchical-namespace account, flagged by TEST_AZURE_HNS.
+func TestSetAzureBlobExpiry(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	getEnvOrSkip(t, "TEST_AZURE_HNS")
+	httpClient := newHTTPClient()
+
+	blobName := randomBlobName("test-expiry")
+	localFile := filepath.Join(t.TempDir(), "scratch.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("scratch"), 0644))
+
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+
+	err = azure.SetAzureBlobExpiry(accountURL, accountName, accountKey, container, blobName,
+		time.Now().Add(time.Hour), httpClient)
+	require.NoError(t, err)
+
+	// Cleanup
+	require.NoError(t, azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient))
+}
+
+// TestListContainers checks the configured test container is listed
+func TestListContainers(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+
+	containers, err := azure.ListAzureContainers(accountURL, accountName, accountKey, newHTTPClient())
+	require.NoError(t, err)
+	require.Contains(t, containers, container)
+}
+
+// TestRenameBlob renames a blob and checks only the new name remains, with the same content
+func TestRenameBlob(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	httpClient := newHTTPClient()
+
+	srcName := randomBlobName("test-rename-src")
+	dstName := randomBlobName("test-rename-dst")
+	localFile := filepath.Join(t.TempDir(), "rename.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("rename me"), 0644))
+
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, srcName, localFile, httpClient)
+	require.NoError(t, err)
+	srcLen, srcMD5, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, srcName, httpClient)
+	require.NoError(t, err)
+
+	err = azure.RenameAzureBlob(accountURL, accountName, accountKey, container, srcName, dstName, httpClient, time.Minute)
+	require.NoError(t, err)
+
+	dstLen, dstMD5, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, dstName, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, srcLen, dstLen)
+	require.Equal(t, srcMD5, dstMD5)
+
+	blobs, err := azure.ListAzureBlob(accountURL, accountName, accountKey, container, httpClient)
+	require.NoError(t, err)
+	require.NotContains(t, blobs, srcName)
+
+	// Cleanup
+	require.NoError(t, azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, dstName, httpClient))
+}
+
+// TestBlobVersions needs blob versioning enabled on the account, flagged by TEST_AZURE_VERSIONING.
+func TestBlobVersions(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	getEnvOrSkip(t, "TEST_AZURE_VERSIONING")
+	httpClient := newHTTPClient()
+
+	blobName := randomBlobName("test-versions")
+	localFile := filepath.Join(t.TempDir(), "v.txt")
+
+	require.NoError(t, os.WriteFile(localFile, []byte("version one"), 0644))
+	_, v1, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(localFile, []byte("version two!"), 0644))
+	_, v2, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+	require.NotEmpty(t, v1.VersionID)
+	require.NotEqual(t, v1.VersionID, v2.VersionID)
+
+	versions, err := azure.ListAzureBlobVersions(accountURL, accountName, accountKey, container, blobName, httpClient)
+	require.NoError(t, err)
+	var ids []string
+	for _, v := range versions {
+		ids = append(ids, v.VersionID)
+	}
+	require.Contains(t, ids, v1.VersionID)
+	require.Contains(t, ids, v2.VersionID)
+
+	length, _, err := azure.GetAzureBlobMetaDataVersion(accountURL, accountName, accountKey, container,
+		blobName, v1.VersionID, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("version one")), length)
+
+	// Cleanup
+	require.NoError(t, azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient))
+}
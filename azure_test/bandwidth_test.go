@@ -0,0 +1,128 @@
+package azure_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func withMaxTotalBandwidth(t *testing.T, bytesPerSecond int64) {
+	azure.SetMaxTotalBandwidth(bytesPerSecond)
+	t.Cleanup(func() { azure.SetMaxTotalBandwidth(0) })
+}
+
+func TestMaxTotalBandwidthIsShared(t *testing.T) {
+	const size, rate = 64 << 10, 256 << 10
+	body := bytes.Repeat([]byte("x"), size)
+	url := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			_, _ = io.Copy(io.Discard, r.Body)
+			return
+		}
+		_, _ = w.Write(body)
+	})
+	withMaxTotalBandwidth(t, rate)
+	require.Equal(t, int64(rate), azure.GetMaxTotalBandwidth())
+	client := azure.NewHTTPClient(azure.ClientTimeouts{})
+
+	// a download and an upload at once
+	started := time.Now()
+	var wg sync.WaitGroup
+	took := make([]time.Duration, 2)
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		resp, err := client.Get(url)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		took[0], errs[0] = time.Since(started), err
+	}()
+	go func() {
+		defer wg.Done()
+		resp, err := client.Post(url, "application/octet-stream", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+		}
+		took[1], errs[1] = time.Since(started), err
+	}()
+	wg.Wait()
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+
+	elapsed := time.Since(started)
+	combined := float64(2*size) / elapsed.Seconds()
+	require.LessOrEqual(t, combined, float64(rate), "the cap holds for both transfers together")
+	require.Greater(t, combined, 0.7*rate, "and they use most of it")
+	for _, d := range took {
+		require.Greater(t, d, elapsed*3/4, "neither transfer gets ahead of the other")
+	}
+}
+
+func TestMaxTotalBandwidthConcurrentDownloads(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	const size, rate = 96 << 10, 256 << 10
+	store := newFakeBlobStore()
+	accountURL := newFakeAzure(t, store.ServeHTTP)
+	names := []string{"images/a.img", "images/b.img"}
+	for i, name := range names {
+		store.put(fakeContainer, name, bytes.Repeat([]byte{byte('a' + i)}, size))
+	}
+	withMaxTotalBandwidth(t, rate)
+	client := azure.NewHTTPClient(azure.ClientTimeouts{})
+	dir := t.TempDir()
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = azure.DownloadAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+				name, filepath.Join(dir, filepath.Base(name)), 0, client, types.DownloadedParts{}, nil)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+	for i, name := range names {
+		require.NoError(t, errs[i])
+		got, err := os.ReadFile(filepath.Join(dir, filepath.Base(name)))
+		require.NoError(t, err)
+		require.Equal(t, store.get(fakeContainer, name).data, got)
+	}
+	combined := float64(len(names)*size) / elapsed.Seconds()
+	require.LessOrEqual(t, combined, float64(rate), "both blob downloads are paced by the one cap")
+	require.Greater(t, combined, 0.6*rate)
+}
+
+func TestMaxTotalBandwidthOff(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	url := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	})
+	withMaxTotalBandwidth(t, 1<<10)
+	azure.SetMaxTotalBandwidth(0)
+	require.Zero(t, azure.GetMaxTotalBandwidth())
+
+	started := time.Now()
+	resp, err := azure.NewHTTPClient(azure.ClientTimeouts{}).Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(body)), n)
+	require.Less(t, time.Since(started), 2*time.Second)
+}
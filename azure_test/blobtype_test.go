@@ -0,0 +1,96 @@
+package azure_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestUploadAzureBlobPageBlob(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	// a little more than one Put Page, so the last write is a shorter range
+	data := bytes.Repeat([]byte("vhd page"), (4*1024*1024+2*azure.PageSize)/8)
+	require.Zero(t, len(data)%azure.PageSize)
+	localFile := writeTempFile(t, "disk.vhd", data)
+
+	url, info, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "disk.vhd", localFile, nil, azure.UploadOptions{BlobType: azure.PageBlobType})
+	require.NoError(t, err)
+	require.Contains(t, url, "disk.vhd")
+
+	b := store.get(fakeContainer, "disk.vhd")
+	require.Equal(t, "PageBlob", b.blobType)
+	require.Equal(t, data, b.data)
+	require.Equal(t, b.etag, info.ETag, "the ETag of the last page written")
+
+	var pages []string
+	for _, r := range store.requests {
+		if r.URL.Query().Get("comp") == "page" {
+			require.Equal(t, "update", r.Header.Get("x-ms-page-write"))
+			pages = append(pages, r.Header.Get("x-ms-range"))
+		}
+	}
+	require.Equal(t, []string{"bytes=0-4194303", "bytes=4194304-4195327"}, pages)
+}
+
+func TestUploadAzureBlobPageBlobUnaligned(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	localFile := writeTempFile(t, "disk.vhd", make([]byte, azure.PageSize+1))
+
+	_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "disk.vhd", localFile, nil, azure.UploadOptions{BlobType: azure.PageBlobType})
+	require.ErrorContains(t, err, "multiple of 512 bytes")
+	require.Nil(t, store.get(fakeContainer, "disk.vhd"))
+}
+
+func TestUploadAzureBlobAppendBlob(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	data := []byte("line 1\nline 2\n")
+	localFile := writeTempFile(t, "app.log", data)
+
+	_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "app.log", localFile, nil, azure.UploadOptions{BlobType: azure.AppendBlobType})
+	require.NoError(t, err)
+	b := store.get(fakeContainer, "app.log")
+	require.Equal(t, "AppendBlob", b.blobType)
+	require.Equal(t, data, b.data)
+}
+
+func TestUploadAzureBlobTypeValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts azure.UploadOptions
+		err  string
+	}{
+		"unknown type": {
+			opts: azure.UploadOptions{BlobType: "tape"},
+			err:  `unsupported blob type "tape"`,
+		},
+		"page blob with MD5": {
+			opts: azure.UploadOptions{BlobType: azure.PageBlobType, VerifyMD5: true},
+			err:  "MD5 verification is not supported for page blobs",
+		},
+		"append blob with MD5": {
+			opts: azure.UploadOptions{BlobType: azure.AppendBlobType, VerifyMD5: true},
+			err:  "MD5 verification is not supported for append blobs",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withRetryPolicy(t, azure.RetryPolicy{})
+			withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			})
+			localFile := writeTempFile(t, "src.bin", make([]byte, azure.PageSize))
+
+			_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "dst.bin", localFile, nil, tc.opts)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
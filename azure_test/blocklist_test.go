@@ -0,0 +1,40 @@
+package azure_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestGetAzureBlockListOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.containers[fakeContainer] = true
+	id := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	stage := func(blockID, data string) {
+		require.NoError(t, azure.UploadPartByChunk(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, "blocks.bin", blockID, nil, bytes.NewReader([]byte(data))))
+	}
+
+	committed, uncommitted, err := azure.GetAzureBlockList(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blocks.bin", nil)
+	require.NoError(t, err, "a missing blob has no blocks")
+	require.Empty(t, committed)
+	require.Empty(t, uncommitted)
+
+	stage(id("block-0"), "aaaa")
+	stage(id("block-1"), "bbbb")
+	require.NoError(t, azure.UploadBlockListToBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blocks.bin", nil, []string{id("block-0"), id("block-1")}))
+	stage(id("block-2"), "cccc")
+
+	committed, uncommitted, err = azure.GetAzureBlockList(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blocks.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{id("block-0"), id("block-1")}, committed)
+	require.Equal(t, []string{id("block-2")}, uncommitted)
+}
@@ -0,0 +1,84 @@
+package azure_test
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func withMetadataCacheTTL(t *testing.T, d time.Duration) {
+	old := azure.GetMetadataCacheTTL()
+	azure.SetMetadataCacheTTL(d)
+	t.Cleanup(func() { azure.SetMetadataCacheTTL(old) })
+}
+
+// countingTransport counts the requests that reach the network.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMetadataCache(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withMetadataCacheTTL(t, time.Minute)
+	store := newFakeBlobStore()
+	b := store.put(fakeContainer, "polled.bin", []byte("polled content"))
+	accountURL := newFakeAzure(t, store.ServeHTTP)
+	counting := &countingTransport{}
+	httpClient := &http.Client{Transport: counting}
+	exists := func() bool {
+		t.Helper()
+		ok, err := azure.BlobExists(accountURL, fakeAccountName, fakeAccountKey, fakeContainer, "polled.bin", httpClient)
+		require.NoError(t, err)
+		return ok
+	}
+
+	require.True(t, exists())
+	require.True(t, exists())
+	stat, err := azure.StatAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer, "polled.bin", httpClient)
+	require.NoError(t, err)
+	require.Equal(t, b.etag, stat.ETag)
+	require.EqualValues(t, 1, counting.n.Load(), "answered from the cache within the TTL")
+
+	// a write through this package is seen right away
+	require.NoError(t, azure.DeleteAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+		"polled.bin", httpClient))
+	before := counting.n.Load()
+	require.False(t, exists())
+	require.False(t, exists())
+	require.Equal(t, before+1, counting.n.Load(), "the missing blob is cached too")
+
+	// others' writes once the TTL passed
+	withMetadataCacheTTL(t, 20*time.Millisecond)
+	require.False(t, exists())
+	store.put(fakeContainer, "polled.bin", []byte("uploaded elsewhere"))
+	require.False(t, exists(), "still cached")
+	time.Sleep(30 * time.Millisecond)
+	require.True(t, exists())
+}
+
+func TestMetadataCacheDisabled(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withMetadataCacheTTL(t, 0)
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "polled.bin", []byte("polled content"))
+	accountURL := newFakeAzure(t, store.ServeHTTP)
+	counting := &countingTransport{}
+	httpClient := &http.Client{Transport: counting}
+
+	for i := 0; i < 3; i++ {
+		ok, err := azure.BlobExists(accountURL, fakeAccountName, fakeAccountKey, fakeContainer, "polled.bin", httpClient)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	require.EqualValues(t, 3, counting.n.Load())
+}
@@ -0,0 +1,117 @@
+package azure_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestRecordAndReplayListAzureBlob(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "images/a.qcow2", []byte("a"))
+	store.put(fakeContainer, "images/b.qcow2", []byte("b"))
+	accountURL := newFakeAzure(t, store.ServeHTTP)
+	path := filepath.Join(t.TempDir(), "list.json")
+
+	recorder := azure.NewRecorder(nil, path)
+	recorded, err := azure.ListAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+		&http.Client{Transport: recorder})
+	require.NoError(t, err)
+	require.Equal(t, []string{"images/a.qcow2", "images/b.qcow2"}, recorded)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "images/a.qcow2", "the response is kept")
+	require.Contains(t, string(data), `"REDACTED"`)
+	require.NotContains(t, string(data), "SharedKey", "the signature is scrubbed")
+
+	// offline: nothing but the cassette answers
+	replayer, err := azure.LoadCassette(path)
+	require.NoError(t, err)
+	replayed, err := azure.ListAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+		&http.Client{Transport: replayer})
+	require.NoError(t, err)
+	require.Equal(t, recorded, replayed)
+	require.Zero(t, replayer.Remaining())
+
+	_, err = azure.ListAzureBlob(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+		&http.Client{Transport: replayer})
+	require.ErrorContains(t, err, "no recorded interaction left for GET")
+}
+
+func TestRecorderScrubsSASSignature(t *testing.T) {
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0x00, 0xfe}) // not text
+	})
+	path := filepath.Join(t.TempDir(), "sas.json")
+	client := &http.Client{Transport: azure.NewRecorder(nil, path)}
+
+	resp, err := client.Get(accountURL + "/c/b?sv=2020-10-02&sp=r&sig=c2VjcmV0")
+	require.NoError(t, err)
+	resp.Body.Close()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "c2VjcmV0")
+	require.True(t, strings.Contains(string(data), `"bodyBase64":"/wD+"`), string(data))
+
+	replayer, err := azure.LoadCassette(path)
+	require.NoError(t, err)
+	// the same SAS URL matches, whatever its signature
+	resp, err = (&http.Client{Transport: replayer}).Get(accountURL + "/c/b?sv=2020-10-02&sp=r&sig=b3RoZXI=")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRecorderAppendsAndCapsBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 3<<20)
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/c/large" {
+			w.Write(large)
+			return
+		}
+		w.Write([]byte("small"))
+	})
+	path := filepath.Join(t.TempDir(), "capped.json")
+	recorder := azure.NewRecorder(nil, path)
+	t.Cleanup(func() { recorder.Close() })
+	client := &http.Client{Transport: recorder}
+
+	for _, name := range []string{"small", "large"} {
+		resp, err := client.Get(accountURL + "/c/" + name)
+		require.NoError(t, err)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		if name == "large" {
+			require.Equal(t, large, got, "the caller gets the whole body")
+		}
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2, "one line per interaction")
+	require.Less(t, len(data), 2<<20, "the large body is cut")
+
+	replayer, err := azure.LoadCassette(path)
+	require.NoError(t, err)
+	replay := &http.Client{Transport: replayer}
+	resp, err := replay.Get(accountURL + "/c/small")
+	require.NoError(t, err)
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "small", string(got))
+	resp, err = replay.Get(accountURL + "/c/large")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorContains(t, err, "truncated in the cassette")
+}
@@ -0,0 +1,88 @@
+package azure_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestDownloadAzureBlobByChunksIfModifiedSince(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "conditional.bin", []byte("changed content"))
+
+	t.Run("modified", func(t *testing.T) {
+		rc, size, err := azure.DownloadAzureBlobByChunksWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, "conditional.bin", "", nil,
+			azure.DownloadOptions{IfModifiedSince: b.modified.Add(-time.Second)})
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, int64(len("changed content")), size)
+		require.Equal(t, "changed content", string(data))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		store.requests = nil
+		_, _, err := azure.DownloadAzureBlobByChunksWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, "conditional.bin", "", nil,
+			azure.DownloadOptions{IfModifiedSince: b.modified})
+		require.ErrorIs(t, err, azure.ErrNotModified)
+		require.Len(t, store.requests, 1, "no content is requested")
+		require.Equal(t, http.MethodHead, store.requests[0].Method)
+		require.Equal(t, b.modified.Format(http.TimeFormat), store.requests[0].Header.Get("If-Modified-Since"))
+	})
+}
+
+func TestDeleteAzureBlobPreconditions(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+
+	for name, tc := range map[string]struct {
+		opts    func(picked *fakeBlob) azure.DeleteOptions
+		deleted bool
+	}{
+		"etag matched": {
+			opts:    func(picked *fakeBlob) azure.DeleteOptions { return azure.DeleteOptions{IfMatch: picked.etag} },
+			deleted: true,
+		},
+		"etag mismatched": {
+			opts: func(picked *fakeBlob) azure.DeleteOptions { return azure.DeleteOptions{IfMatch: picked.etag} },
+		},
+		"unmodified since": {
+			opts: func(picked *fakeBlob) azure.DeleteOptions {
+				return azure.DeleteOptions{IfUnmodifiedSince: picked.modified}
+			},
+			deleted: true,
+		},
+		"modified since": {
+			opts: func(picked *fakeBlob) azure.DeleteOptions {
+				return azure.DeleteOptions{IfUnmodifiedSince: picked.modified}
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			picked := *store.put(fakeContainer, "cleanup.bin", []byte("picked for deletion"))
+			if !tc.deleted {
+				// updated by someone else after the cleanup job picked it
+				store.put(fakeContainer, "cleanup.bin", []byte("updated since"))
+			}
+			err := azure.DeleteAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "cleanup.bin", nil, tc.opts(&picked))
+			if tc.deleted {
+				require.NoError(t, err)
+				require.Nil(t, store.get(fakeContainer, "cleanup.bin"))
+				return
+			}
+			require.ErrorIs(t, err, azure.ErrPreconditionFailed)
+			require.Equal(t, http.StatusPreconditionFailed, azure.StatusFromError(err))
+			require.Equal(t, "updated since", string(store.get(fakeContainer, "cleanup.bin").data))
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package azure_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestParseAzureConnectionString(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		cs         string
+		accountURL string
+		account    string
+		key        string
+		sas        string
+	}{
+		{
+			name:       "account key",
+			cs:         "DefaultEndpointsProtocol=https;AccountName=myacct;AccountKey=a2V5PT0=;EndpointSuffix=core.windows.net",
+			accountURL: "https://myacct.blob.core.windows.net",
+			account:    "myacct",
+			key:        "a2V5PT0=",
+		},
+		{
+			name:       "account key with default suffix and protocol",
+			cs:         "AccountName=myacct;AccountKey=a2V5",
+			accountURL: "https://myacct.blob.core.windows.net",
+			account:    "myacct",
+			key:        "a2V5",
+		},
+		{
+			name:       "sovereign cloud suffix",
+			cs:         "AccountName=govacct;AccountKey=a2V5;EndpointSuffix=core.usgovcloudapi.net;",
+			accountURL: "https://govacct.blob.core.usgovcloudapi.net",
+			account:    "govacct",
+			key:        "a2V5",
+		},
+		{
+			name:       "explicit blob endpoint",
+			cs:         "BlobEndpoint=https://myacct.blob.core.windows.net/;AccountName=myacct;AccountKey=a2V5",
+			accountURL: "https://myacct.blob.core.windows.net",
+			account:    "myacct",
+			key:        "a2V5",
+		},
+		{
+			name:       "sas",
+			cs:         "BlobEndpoint=https://myacct.blob.core.windows.net/;SharedAccessSignature=sv=2022-11-02&ss=b&sig=abc%3D",
+			accountURL: "https://myacct.blob.core.windows.net",
+			account:    "myacct",
+			key:        "",
+			sas:        "sv=2022-11-02&ss=b&sig=abc%3D",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			accountURL, account, key, sas, err := azure.ParseAzureConnectionString(tc.cs)
+			require.NoError(t, err)
+			require.Equal(t, tc.accountURL, accountURL)
+			require.Equal(t, tc.account, account)
+			require.Equal(t, tc.key, key)
+			require.Equal(t, tc.sas, sas)
+		})
+	}
+}
+
+func TestParseAzureConnectionStringMalformed(t *testing.T) {
+	for name, cs := range map[string]string{
+		"empty":             "",
+		"no equals sign":    "AccountName=myacct;garbage",
+		"no credentials":    "AccountName=myacct;EndpointSuffix=core.windows.net",
+		"no account":        "AccountKey=a2V5",
+		"bad blob endpoint": "BlobEndpoint=not a url;AccountKey=a2V5",
+		"development store": "UseDevelopmentStorage=true",
+		"bad sas query":     "BlobEndpoint=https://myacct.blob.core.windows.net;SharedAccessSignature=sv=%zz",
+		"empty key segment": "=value;AccountName=myacct;AccountKey=a2V5",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, _, err := azure.ParseAzureConnectionString(cs)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestBlobAccountURL(t *testing.T) {
+	require.Equal(t, "https://myacct.blob.core.windows.net", azure.BlobAccountURL("myacct", ""))
+	require.Equal(t, "https://govacct.blob.core.usgovcloudapi.net",
+		azure.BlobAccountURL("govacct", "core.usgovcloudapi.net"))
+	require.Equal(t, "https://cnacct.blob.core.chinacloudapi.cn",
+		azure.BlobAccountURL("cnacct", ".core.chinacloudapi.cn"))
+}
+
+func TestCheckAccountURL(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		accountURL string
+		account    string
+		mismatch   bool
+	}{
+		{name: "public cloud", accountURL: "https://myacct.blob.core.windows.net", account: "myacct"},
+		{name: "case of the host", accountURL: "https://MyAcct.blob.core.windows.net/", account: "myacct"},
+		{name: "sovereign cloud", accountURL: "https://govacct.blob.core.usgovcloudapi.net", account: "govacct"},
+		{name: "with SAS", accountURL: "https://myacct.blob.core.windows.net/?sv=2022-11-02&sig=abc", account: "myacct"},
+		{name: "no account name", accountURL: "https://myacct.blob.core.windows.net/?sig=abc"},
+		{name: "other account", accountURL: "https://otheracct.blob.core.windows.net", account: "myacct", mismatch: true},
+		{name: "account as a later label", accountURL: "https://cdn.myacct.example.com", account: "myacct", mismatch: true},
+		{name: "emulator", accountURL: "http://127.0.0.1:10000/devstoreaccount1", account: "devstoreaccount1", mismatch: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := azure.CheckAccountURL(tc.accountURL, tc.account)
+			if tc.mismatch {
+				require.ErrorIs(t, err, azure.ErrAccountMismatch)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	require.Error(t, azure.CheckAccountURL("myacct", "myacct"), "not a URL")
+}
+
+func TestParseAzureBlobURL(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		url        string
+		accountURL string
+		container  string
+		blob       string
+		sas        string
+	}{
+		{
+			name:       "no SAS",
+			url:        "https://myacct.blob.core.windows.net/images/disk.img",
+			accountURL: "https://myacct.blob.core.windows.net",
+			container:  "images",
+			blob:       "disk.img",
+		},
+		{
+			name:       "SAS",
+			url:        "https://myacct.blob.core.windows.net/images/disk.img?sv=2022-11-02&sr=b&sp=r&sig=c2ln%3D",
+			accountURL: "https://myacct.blob.core.windows.net",
+			container:  "images",
+			blob:       "disk.img",
+			sas:        "sv=2022-11-02&sr=b&sp=r&sig=c2ln%3D",
+		},
+		{
+			name:       "nested blob path",
+			url:        "https://myacct.blob.core.windows.net/images/2025/06/disk%20v2.img?sp=r&sig=abc",
+			accountURL: "https://myacct.blob.core.windows.net",
+			container:  "images",
+			blob:       "2025/06/disk v2.img",
+			sas:        "sp=r&sig=abc",
+		},
+		{
+			name:       "emulator",
+			url:        "http://127.0.0.1:10000/devstoreaccount1/images/os/disk.img",
+			accountURL: "http://127.0.0.1:10000/devstoreaccount1",
+			container:  "images",
+			blob:       "os/disk.img",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			accountURL, container, blob, sas, err := azure.ParseAzureBlobURL(tc.url)
+			require.NoError(t, err)
+			require.Equal(t, tc.accountURL, accountURL)
+			require.Equal(t, tc.container, container)
+			require.Equal(t, tc.blob, blob)
+			require.Equal(t, tc.sas, sas)
+		})
+	}
+}
+
+func TestParseAzureBlobURLMalformed(t *testing.T) {
+	for name, u := range map[string]string{
+		"not a URL":        "myacct/images/disk.img",
+		"other scheme":     "ftp://myacct.blob.core.windows.net/images/disk.img",
+		"no blob":          "https://myacct.blob.core.windows.net/images",
+		"container only":   "https://myacct.blob.core.windows.net/images/",
+		"virtual dir":      "https://myacct.blob.core.windows.net/images/os/",
+		"query is not SAS": "https://myacct.blob.core.windows.net/images/disk.img?snapshot=2025-06-01",
+		"bad query":        "https://myacct.blob.core.windows.net/images/disk.img?sig=%zz",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, _, err := azure.ParseAzureBlobURL(u)
+			require.Error(t, err)
+		})
+	}
+
+	_, _, _, _, err := azure.ParseAzureBlobURL("https://myacct.blob.core.windows.net/images?sp=r&sig=c2VjcmV0")
+	require.NotContains(t, err.Error(), "c2VjcmV0", "the signature is not in the error")
+}
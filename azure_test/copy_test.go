@@ -0,0 +1,120 @@
+package azure_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestCopyAzureBlobAndWaitOffline(t *testing.T) {
+	for name, pendingPolls := range map[string]int{"at once": 0, "after polling": 1} {
+		t.Run(name, func(t *testing.T) {
+			withRetryPolicy(t, azure.RetryPolicy{})
+			store := withFakeBlobStore(t)
+			store.pendingCopyPolls = pendingPolls
+			src := store.put(fakeContainer, "images/a.qcow2", []byte("copied content"))
+
+			err := azure.CopyAzureBlobAndWait(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "images/a.qcow2", "backup/a.qcow2", nil, time.Minute)
+			require.NoError(t, err)
+			dst := store.get(fakeContainer, "backup/a.qcow2")
+			require.Equal(t, src.data, dst.data)
+			require.Equal(t, src.contentMD5, dst.contentMD5)
+			require.Equal(t, "success", dst.copyStatus)
+
+			var heads int
+			for _, r := range store.requests {
+				if r.Method == http.MethodHead {
+					heads++
+				}
+			}
+			if pendingPolls == 0 {
+				require.Zero(t, heads, "nothing to wait for")
+			} else {
+				require.Equal(t, pendingPolls+1, heads)
+			}
+		})
+	}
+}
+
+func TestCopyAzureBlobAndWaitFailed(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.pendingCopyPolls = 1
+	store.put(fakeContainer, "a.bin", []byte("x"))
+	store.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		dst := store.get(fakeContainer, "b.bin")
+		if r.Method != http.MethodHead || dst == nil {
+			return false
+		}
+		w.Header().Set("x-ms-copy-id", dst.copyID)
+		w.Header().Set("x-ms-copy-status", "failed")
+		w.Header().Set("x-ms-copy-status-description", "500 InternalError \"Copy failed.\"")
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+
+	err := azure.CopyAzureBlobAndWait(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "a.bin", "b.bin", nil, time.Minute)
+	require.ErrorContains(t, err, "ended with status failed: 500 InternalError")
+	require.NotErrorIs(t, err, azure.ErrCopyTimeout)
+}
+
+func TestCopyAzureBlobAndWaitTimeout(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.pendingCopyPolls = 1000
+	store.put(fakeContainer, "a.bin", []byte("x"))
+
+	started := time.Now()
+	err := azure.CopyAzureBlobAndWait(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "a.bin", "b.bin", nil, 300*time.Millisecond)
+	require.ErrorIs(t, err, azure.ErrCopyTimeout)
+	require.ErrorContains(t, err, "still pending")
+	require.Less(t, time.Since(started), 2*time.Second)
+}
+
+func TestCopyAzureBlobAndWaitMissingSource(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "other", nil)
+
+	err := azure.CopyAzureBlobAndWait(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "missing.bin", "b.bin", nil, time.Minute)
+	require.ErrorContains(t, err, "CannotVerifyCopySource")
+}
+
+func TestCopyAzureBlobAndWait(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	httpClient := newHTTPClient()
+
+	srcName := randomBlobName("test-copy-src")
+	dstName := randomBlobName("test-copy-dst")
+	localFile := filepath.Join(t.TempDir(), "copy.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("copy me"), 0644))
+
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, srcName, localFile, httpClient)
+	require.NoError(t, err)
+	defer azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, srcName, httpClient)
+
+	err = azure.CopyAzureBlobAndWait(accountURL, accountName, accountKey, container, srcName, dstName,
+		httpClient, time.Minute)
+	require.NoError(t, err)
+	defer azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, dstName, httpClient)
+
+	srcLen, srcMD5, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, srcName, httpClient)
+	require.NoError(t, err)
+	dstLen, dstMD5, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, dstName, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, srcLen, dstLen)
+	require.Equal(t, srcMD5, dstMD5)
+}
@@ -0,0 +1,136 @@
+package azure_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestDataLakeURLFromBlob(t *testing.T) {
+	require.Equal(t, "https://acct.dfs.core.windows.net",
+		azure.DataLakeURLFromBlob("https://acct.blob.core.windows.net"))
+	require.Equal(t, "https://acct.dfs.core.chinacloudapi.cn/",
+		azure.DataLakeURLFromBlob("https://acct.blob.core.chinacloudapi.cn/"))
+	require.Equal(t, "http://127.0.0.1:10000/devstoreaccount1",
+		azure.DataLakeURLFromBlob("http://127.0.0.1:10000/devstoreaccount1"), "no blob host to swap")
+	require.Equal(t, "https://acct.dfs.core.windows.net", azure.DataLakeAccountURL("acct", ""))
+}
+
+func TestListDataLakePaths(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	modified := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	var queries []string
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, sharedKeySignedWith(t, r, fakeAccountKey), "signed with the account key")
+		require.Equal(t, "/"+fakeContainer, r.URL.Path)
+		queries = append(queries, r.URL.RawQuery)
+		q := r.URL.Query()
+		require.Equal(t, "filesystem", q.Get("resource"))
+		require.Equal(t, "true", q.Get("recursive"))
+		require.Equal(t, "images", q.Get("directory"))
+		w.Header().Set("Content-Type", "application/json")
+		if q.Get("continuation") == "" {
+			w.Header().Set("x-ms-continuation", "page2")
+			fmt.Fprintf(w, `{"paths":[{"name":"images/boot","isDirectory":"true","lastModified":%q}]}`,
+				modified.Format(http.TimeFormat))
+			return
+		}
+		require.Equal(t, "page2", q.Get("continuation"))
+		fmt.Fprintf(w, `{"paths":[{"name":"images/boot/kernel","contentLength":"42","lastModified":%q}]}`,
+			modified.Format(http.TimeFormat))
+	})
+
+	paths, err := azure.ListDataLakePaths(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "/images/", true, nil)
+	require.NoError(t, err)
+	require.Len(t, queries, 2, "follows the continuation")
+	require.Len(t, paths, 2)
+	require.Equal(t, "images/boot", paths[0].Name)
+	require.True(t, paths[0].IsDirectory)
+	require.Equal(t, "images/boot/kernel", paths[1].Name)
+	require.False(t, paths[1].IsDirectory)
+	require.EqualValues(t, 42, paths[1].Size)
+	require.True(t, modified.Equal(paths[1].LastModified))
+}
+
+func TestListDataLakePathsFails(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "FilesystemNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := azure.ListDataLakePaths(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "", false, nil)
+	require.ErrorContains(t, err, "FilesystemNotFound")
+}
+
+func TestDeleteDataLakePath(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	var calls int
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, sharedKeySignedWith(t, r, fakeAccountKey), "signed with the account key")
+		require.Equal(t, http.MethodDelete, r.Method)
+		require.Equal(t, "/"+fakeContainer+"/images/boot", r.URL.Path)
+		require.Equal(t, "true", r.URL.Query().Get("recursive"))
+		calls++
+		if calls == 1 {
+			w.Header().Set("x-ms-continuation", "more")
+		} else {
+			require.Equal(t, "more", r.URL.Query().Get("continuation"))
+		}
+	})
+
+	require.NoError(t, azure.DeleteDataLakePath(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/boot/", true, nil))
+	require.Equal(t, 2, calls, "follows the continuation")
+}
+
+// TestDataLakeListAndDelete needs an account with a hierarchical namespace: its
+// blob endpoint makes the directory of an uploaded blob, its dfs endpoint lists
+// and deletes it.
+func TestDataLakeListAndDelete(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	getEnvOrSkip(t, "TEST_AZURE_HNS_ENABLED")
+
+	httpClient := newHTTPClient()
+	dfsURL := azure.DataLakeURLFromBlob(accountURL)
+	dir := randomBlobName("test-dfs")
+	localFile := filepath.Join(t.TempDir(), "tmp.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("in a directory"), 0644))
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, dir+"/sub/file.txt",
+		localFile, httpClient)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = azure.DeleteDataLakePath(dfsURL, accountName, accountKey, container, dir, true, httpClient)
+	})
+
+	paths, err := azure.ListDataLakePaths(dfsURL, accountName, accountKey, container, dir, true, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, []azure.PathInfo{
+		{Name: dir + "/sub", IsDirectory: true},
+		{Name: dir + "/sub/file.txt", Size: int64(len("in a directory"))},
+	}, clearLastModified(paths))
+
+	require.NoError(t, azure.DeleteDataLakePath(dfsURL, accountName, accountKey, container, dir, true, httpClient))
+	exists, err := azure.BlobExists(accountURL, accountName, accountKey, container, dir+"/sub/file.txt", httpClient)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func clearLastModified(paths []azure.PathInfo) []azure.PathInfo {
+	for i := range paths {
+		paths[i].LastModified = time.Time{}
+	}
+	return paths
+}
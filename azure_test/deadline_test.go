@@ -0,0 +1,60 @@
+package azure_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func withMetadataTimeout(t *testing.T, d time.Duration) {
+	old := azure.GetMetadataTimeout()
+	azure.SetMetadataTimeout(d)
+	t.Cleanup(func() { azure.SetMetadataTimeout(old) })
+}
+
+// hangingHEAD answers nothing until the client gives up.
+func hangingHEAD(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-time.After(10 * time.Second):
+	case <-r.Context().Done():
+	}
+}
+
+func TestMetadataCallsRespectDeadline(t *testing.T) {
+	withRetryPolicy(t, azure.DefaultRetryPolicy())
+	withMetadataTimeout(t, 200*time.Millisecond)
+	accountURL := newFakeAzure(t, hangingHEAD)
+
+	started := time.Now()
+	_, _, err := azure.GetAzureBlobMetaData(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "slow.bin", newHTTPClient())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "metadata timeout of 200ms")
+	require.Less(t, time.Since(started), 2*time.Second, "retries stop at the deadline too")
+
+	started = time.Now()
+	_, err = azure.BlobExists(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "slow.bin", newHTTPClient())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(started), 2*time.Second)
+}
+
+func TestMetadataTimeoutDisabled(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withMetadataTimeout(t, 0)
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Length", "3")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	size, _, err := azure.GetAzureBlobMetaData(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "slow.bin", newHTTPClient())
+	require.NoError(t, err)
+	require.EqualValues(t, 3, size)
+}
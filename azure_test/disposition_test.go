@@ -0,0 +1,51 @@
+package azure_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestUploadAzureBlobContentDisposition(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeBlobStore(t)
+	const disposition = `attachment; filename="eve-installer.raw"`
+
+	for _, blobType := range []string{azure.BlockBlobType, azure.PageBlobType, azure.AppendBlobType} {
+		t.Run(blobType, func(t *testing.T) {
+			localFile := writeTempFile(t, "installer.raw", make([]byte, azure.PageSize))
+			_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, blobType+".raw", localFile, nil,
+				azure.UploadOptions{BlobType: blobType, ContentDisposition: disposition})
+			require.NoError(t, err)
+
+			props, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, blobType+".raw", nil)
+			require.NoError(t, err)
+			require.Equal(t, disposition, props.ContentDisposition)
+		})
+	}
+}
+
+func TestGenerateBlobSasURIContentDisposition(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "build-1234.raw", []byte("x"))
+
+	sasURL, err := azure.GenerateBlobSasURI(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "build-1234.raw", nil, time.Hour)
+	require.NoError(t, err)
+	require.NotContains(t, sasQuery(t, sasURL), "rscd", "the blob's own by default")
+
+	opts := azure.DefaultSasOptions()
+	opts.ContentDisposition = `attachment; filename="eve-installer.raw"`
+	sasURL, err = azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "build-1234.raw", nil, time.Hour, opts)
+	require.NoError(t, err)
+	q := sasQuery(t, sasURL)
+	require.Equal(t, `attachment; filename="eve-installer.raw"`, q.Get("rscd"))
+	require.Equal(t, sdkSasSignature(t, q, "build-1234.raw"), q.Get("sig"), "the override is signed")
+}
@@ -0,0 +1,63 @@
+package azure_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const fakeRequestID = "8a1e2b7c-001e-0000-0000-000000000000"
+
+func TestServiceErrorCarriesRequestID(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", fakeRequestID)
+		w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		if r.Method != http.MethodHead {
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthorizationPermissionMismatch</Code>`+
+				`<Message>This request is not authorized to perform this operation using this permission.
+RequestId:`+fakeRequestID+`</Message></Error>`)
+		}
+	})
+
+	for name, call := range map[string]func() error{
+		"properties": func() error {
+			_, _, err := azure.GetAzureBlobMetaData(accountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "a.bin", newHTTPClient())
+			return err
+		},
+		"list": func() error {
+			_, err := azure.ListAzureBlobInfo(accountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "", newHTTPClient())
+			return err
+		},
+		"delete": func() error {
+			return azure.DeleteAzureBlob(accountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "a.bin", newHTTPClient())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := call()
+			var svcErr *azure.ServiceError
+			require.True(t, errors.As(err, &svcErr), "a *ServiceError in %v", err)
+			require.Equal(t, http.StatusForbidden, svcErr.StatusCode)
+			require.Equal(t, "AuthorizationPermissionMismatch", svcErr.ErrorCode)
+			require.Equal(t, fakeRequestID, svcErr.RequestID)
+			require.Equal(t, fakeRequestID, azure.RequestIDFromError(err))
+			require.Equal(t, http.StatusForbidden, azure.StatusFromError(err))
+			require.ErrorContains(t, err, "(HTTP 403, request ID "+fakeRequestID+")")
+		})
+	}
+}
+
+func TestRequestIDFromErrorWithoutResponse(t *testing.T) {
+	require.Empty(t, azure.RequestIDFromError(nil))
+	require.Empty(t, azure.RequestIDFromError(errors.New("RESPONSE 503: Service Unavailable")))
+}
@@ -0,0 +1,42 @@
+package azure_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestSetAzureBlobExpiryOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	expiry := time.Now().Add(time.Hour).UTC()
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "expiry", r.URL.Query().Get("comp"))
+		require.Equal(t, "Absolute", r.Header.Get("x-ms-expiry-option"))
+		require.Equal(t, expiry.Format(http.TimeFormat), r.Header.Get("x-ms-expiry-time"))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	require.NoError(t, azure.SetAzureBlobExpiry(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "scratch.bin", expiry, nil))
+}
+
+func TestSetAzureBlobExpiryNotSupported(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeFakeError(w, http.StatusBadRequest, "FeatureNotSupportedForAccount")
+	})
+
+	err := azure.SetAzureBlobExpiry(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "scratch.bin", time.Now().Add(time.Hour), nil)
+	require.ErrorIs(t, err, azure.ErrExpiryNotSupported)
+}
+
+func TestSetAzureBlobExpiryInThePast(t *testing.T) {
+	err := azure.SetAzureBlobExpiry(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "scratch.bin", time.Now().Add(-time.Minute), nil)
+	require.ErrorContains(t, err, "not in the future")
+}
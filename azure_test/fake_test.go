@@ -0,0 +1,55 @@
+package azure_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// Offline tests talk to a fake storage account instead of real Azure.
+const (
+	fakeAccountName = "fakeaccount"
+	fakeContainer   = "fakecontainer"
+	fakeAccountURL  = "https://fakeaccount.blob.core.windows.net"
+)
+
+var fakeAccountKey = base64.StdEncoding.EncodeToString([]byte("fake-account-key"))
+
+// newFakeAzure starts an offline stand-in for the storage account and returns its URL.
+func newFakeAzure(t *testing.T, handler http.HandlerFunc) string {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// handlerDoer serves requests straight from an http.Handler, without any network.
+type handlerDoer struct {
+	handler http.Handler
+}
+
+func (d handlerDoer) Do(req *http.Request) (*http.Response, error) {
+	// hand the handler what a server would see: canonical header keys (the
+	// generated clients set some verbatim, e.g. "x-ms-copy-source") and a body
+	served := req.Clone(req.Context())
+	served.Header = http.Header{}
+	for k, v := range req.Header {
+		served.Header[http.CanonicalHeaderKey(k)] = append(served.Header[http.CanonicalHeaderKey(k)], v...)
+	}
+	if served.Body == nil {
+		served.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	d.handler.ServeHTTP(rec, served)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// withFakeDoer routes all azureutil traffic to handler for the duration of the test.
+func withFakeDoer(t *testing.T, handler http.HandlerFunc) {
+	azure.SetDoer(handlerDoer{handler: handler})
+	t.Cleanup(func() { azure.SetDoer(nil) })
+}
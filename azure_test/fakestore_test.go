@@ -0,0 +1,641 @@
+package azure_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBlob is one blob held by fakeBlobStore.
+type fakeBlob struct {
+	data       []byte
+	contentMD5 []byte // as recorded by the service, nil when unknown
+	headers    http.Header
+	etag       string
+	modified   time.Time
+	copyStatus string // set on blobs written by Copy Blob
+	copyID     string
+	copyPolls  int      // Get Blob Properties left before a pending copy succeeds
+	versionID  string   // set when the store has versioning on
+	blocks     []string // committed block IDs, for blobs written by Put Block List
+	blobType   string   // x-ms-blob-type, BlockBlob when empty
+	tags       url.Values
+	tier       string // x-ms-access-tier, Hot when empty
+	// x-ms-archive-status and x-ms-rehydrate-priority of a pending rehydration,
+	// which only ends when a test clears them
+	archiveStatus     string
+	rehydratePriority string
+}
+
+// fakeBlobStore is an in-memory subset of the Blob service REST API, enough for
+// the azureutil calls to run offline: containers, Put Blob, Put Block (List), Put Page, Append Block,
+// Copy Blob (completing at once unless pendingCopyPolls is set), Get Blob (ranged), Get Blob Properties, Get Blob Tags,
+// Set Blob Tier, Snapshot Blob, Delete Blob, List Blobs and Get/Set Container ACL. Of a SAS, only that its
+// stored access policy (si) exists is checked.
+type fakeBlobStore struct {
+	mu         sync.Mutex
+	containers map[string]bool
+	blobs      map[string]*fakeBlob      // keyed by container + "/" + blob
+	staged     map[string][]byte         // keyed by container + "/" + blob + "#" + block ID
+	snapshots  map[string][]fakeSnapshot // keyed like blobs, oldest first
+	acls       map[string]fakeACL        // keyed by container
+	requests   []*http.Request
+	version    int
+	versioning bool // assign version IDs, like an account with blob versioning
+	// copies stay pending for this many Get Blob Properties of the destination
+	pendingCopyPolls int
+
+	// intercept, when set, may answer a request itself by returning true
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// fakeACL is what Set Container ACL stored.
+type fakeACL struct {
+	publicAccess string // x-ms-blob-public-access, empty for a private container
+	body         []byte // the SignedIdentifiers XML
+}
+
+// hasPolicy reports whether the ACL holds the stored access policy id.
+func (a fakeACL) hasPolicy(id string) bool {
+	var ids struct {
+		IDs []string `xml:"SignedIdentifier>Id"`
+	}
+	_ = xml.Unmarshal(a.body, &ids)
+	for _, got := range ids.IDs {
+		if got == id {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeSnapshot is a copy of a blob as it was when Snapshot Blob was called.
+type fakeSnapshot struct {
+	id   string
+	blob fakeBlob
+}
+
+func newFakeBlobStore() *fakeBlobStore {
+	return &fakeBlobStore{
+		containers: map[string]bool{},
+		blobs:      map[string]*fakeBlob{},
+		staged:     map[string][]byte{},
+		snapshots:  map[string][]fakeSnapshot{},
+		acls:       map[string]fakeACL{},
+	}
+}
+
+// withFakeBlobStore routes all azureutil traffic to a fresh fake store.
+func withFakeBlobStore(t *testing.T) *fakeBlobStore {
+	store := newFakeBlobStore()
+	withFakeDoer(t, store.ServeHTTP)
+	return store
+}
+
+// put stores a blob directly, as if it had been uploaded with Put Blob.
+func (s *fakeBlobStore) put(container, name string, data []byte) *fakeBlob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[container] = true
+	sum := md5.Sum(data)
+	return s.storeLocked(container, name, data, sum[:], http.Header{})
+}
+
+// get returns the blob or nil.
+func (s *fakeBlobStore) get(container, name string) *fakeBlob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blobs[container+"/"+name]
+}
+
+func (s *fakeBlobStore) storeLocked(container, name string, data, contentMD5 []byte, headers http.Header) *fakeBlob {
+	s.version++
+	b := &fakeBlob{
+		data:       data,
+		contentMD5: contentMD5,
+		headers:    headers,
+		etag:       fmt.Sprintf(`"0x8DD%012X"`, s.version),
+		modified:   time.Date(2025, 7, 1, 10, 0, s.version, 0, time.UTC),
+	}
+	if s.versioning {
+		b.versionID = b.modified.Format("2006-01-02T15:04:05.0000000Z")
+	}
+	s.blobs[container+"/"+name] = b
+	return b
+}
+
+// touchLocked gives a blob written in place a new ETag and modification time.
+func (s *fakeBlobStore) touchLocked(b *fakeBlob) {
+	s.version++
+	b.etag = fmt.Sprintf(`"0x8DD%012X"`, s.version)
+	b.modified = time.Date(2025, 7, 1, 10, 0, s.version, 0, time.UTC)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`,
+		code, http.StatusText(status))
+}
+
+func setBlobHeaders(w http.ResponseWriter, b *fakeBlob) {
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set("Last-Modified", b.modified.Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", b.typeName())
+	if b.contentMD5 != nil {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(b.contentMD5))
+	}
+	if b.versionID != "" {
+		w.Header().Set("x-ms-version-id", b.versionID)
+	}
+	if b.copyStatus != "" {
+		w.Header().Set("x-ms-copy-status", b.copyStatus)
+		w.Header().Set("x-ms-copy-id", b.copyID)
+	}
+	if len(b.tags) > 0 {
+		w.Header().Set("x-ms-tag-count", strconv.Itoa(len(b.tags)))
+	}
+	w.Header().Set("x-ms-access-tier", b.tierName())
+	if b.archiveStatus != "" {
+		w.Header().Set("x-ms-archive-status", b.archiveStatus)
+		w.Header().Set("x-ms-rehydrate-priority", b.rehydratePriority)
+	}
+	for k, v := range b.headers {
+		w.Header()[k] = v
+	}
+}
+
+func (b *fakeBlob) typeName() string {
+	if b.blobType == "" {
+		return "BlockBlob"
+	}
+	return b.blobType
+}
+
+func (b *fakeBlob) tierName() string {
+	if b.tier == "" {
+		return "Hot"
+	}
+	return b.tier
+}
+
+func (s *fakeBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	intercept := s.intercept
+	s.mu.Unlock()
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	container, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	key := container + "/" + name
+
+	if si := q.Get("si"); si != "" && !s.acls[container].hasPolicy(si) {
+		writeFakeError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	switch {
+	case name == "" && q.Get("comp") == "acl" && r.Method == http.MethodPut:
+		if !s.containers[container] {
+			writeFakeError(w, http.StatusNotFound, "ContainerNotFound")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.acls[container] = fakeACL{publicAccess: r.Header.Get("x-ms-blob-public-access"), body: body}
+		w.WriteHeader(http.StatusOK)
+
+	case name == "" && q.Get("comp") == "acl" && r.Method == http.MethodGet:
+		if !s.containers[container] {
+			writeFakeError(w, http.StatusNotFound, "ContainerNotFound")
+			return
+		}
+		acl := s.acls[container]
+		if acl.publicAccess != "" {
+			w.Header().Set("x-ms-blob-public-access", acl.publicAccess)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		if len(acl.body) == 0 {
+			_, _ = io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><SignedIdentifiers />`)
+			return
+		}
+		_, _ = w.Write(acl.body)
+
+	case name == "" && q.Get("restype") == "container" && r.Method == http.MethodPut:
+		if s.containers[container] {
+			writeFakeError(w, http.StatusConflict, "ContainerAlreadyExists")
+			return
+		}
+		s.containers[container] = true
+		w.WriteHeader(http.StatusCreated)
+
+	case name == "" && q.Get("comp") == "list" && r.Method == http.MethodGet:
+		if !s.containers[container] {
+			writeFakeError(w, http.StatusNotFound, "ContainerNotFound")
+			return
+		}
+		s.serveListLocked(w, container, q.Get("prefix"), q.Get("delimiter"),
+			strings.Contains(q.Get("include"), "snapshots"))
+
+	case !s.containers[container]:
+		writeFakeError(w, http.StatusNotFound, "ContainerNotFound")
+
+	case q.Get("comp") == "block" && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.staged[key+"#"+q.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+
+	case q.Get("comp") == "blocklist" && r.Method == http.MethodPut:
+		var list struct {
+			IDs []string `xml:",any"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &list); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var data []byte
+		for _, id := range list.IDs {
+			block, ok := s.staged[key+"#"+id]
+			if !ok {
+				writeFakeError(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data = append(data, block...)
+		}
+		if !s.preconditionsMetLocked(w, r, key) {
+			return
+		}
+		// like Azure, Put Block List only records the MD5 the client supplies
+		var contentMD5 []byte
+		if h := r.Header.Get("x-ms-blob-content-md5"); h != "" {
+			contentMD5, _ = base64.StdEncoding.DecodeString(h)
+		}
+		b := s.storeLocked(container, name, data, contentMD5, storedHeaders(r))
+		b.blocks = list.IDs
+		if tags, err := url.ParseQuery(r.Header.Get("x-ms-tags")); err == nil && len(tags) > 0 {
+			b.tags = tags
+		}
+		// committing discards whatever else was staged
+		for k := range s.staged {
+			if strings.HasPrefix(k, key+"#") {
+				delete(s.staged, k)
+			}
+		}
+		setBlobHeaders(w, b)
+		w.Header().Del("Content-MD5")
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		src, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		srcContainer, srcName, _ := strings.Cut(strings.TrimPrefix(src.Path, "/"), "/")
+		srcBlob, ok := s.blobs[srcContainer+"/"+srcName]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "CannotVerifyCopySource")
+			return
+		}
+		b := s.storeLocked(container, name, bytes.Clone(srcBlob.data), srcBlob.contentMD5, srcBlob.headers.Clone())
+		b.copyStatus = "success"
+		b.copyID = fmt.Sprintf("copy-%d", s.version)
+		if s.pendingCopyPolls > 0 {
+			b.copyStatus, b.copyPolls = "pending", s.pendingCopyPolls
+		}
+		w.Header().Set("ETag", b.etag)
+		w.Header().Set("x-ms-copy-id", b.copyID)
+		w.Header().Set("x-ms-copy-status", b.copyStatus)
+		w.WriteHeader(http.StatusAccepted)
+
+	case q.Get("comp") == "page" && r.Method == http.MethodPut:
+		b, ok := s.blobs[key]
+		if !ok || b.blobType != "PageBlob" {
+			writeFakeError(w, http.StatusBadRequest, "InvalidBlobType")
+			return
+		}
+		start, end := parseFakeRange(r.Header.Get("x-ms-range"), int64(len(b.data)))
+		data, _ := io.ReadAll(r.Body)
+		if start%512 != 0 || (end+1)%512 != 0 || int64(len(data)) != end-start+1 {
+			writeFakeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidPageRange")
+			return
+		}
+		copy(b.data[start:], data)
+		s.touchLocked(b)
+		setBlobHeaders(w, b)
+		w.WriteHeader(http.StatusCreated)
+
+	case q.Get("comp") == "appendblock" && r.Method == http.MethodPut:
+		b, ok := s.blobs[key]
+		if !ok || b.blobType != "AppendBlob" {
+			writeFakeError(w, http.StatusConflict, "InvalidBlobType")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		b.data = append(b.data, data...)
+		s.touchLocked(b)
+		setBlobHeaders(w, b)
+		w.WriteHeader(http.StatusCreated)
+
+	case q.Get("comp") == "tier" && r.Method == http.MethodPut:
+		b, ok := s.blobs[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		tier := r.Header.Get("x-ms-access-tier")
+		switch {
+		case b.archiveStatus != "":
+			writeFakeError(w, http.StatusConflict, "BlobBeingRehydrated")
+		case b.tierName() == "Archive" && tier != "Archive":
+			// stays archived until the rehydration completes
+			b.archiveStatus = "rehydrate-pending-to-" + strings.ToLower(tier)
+			b.rehydratePriority = r.Header.Get("x-ms-rehydrate-priority")
+			if b.rehydratePriority == "" {
+				b.rehydratePriority = "Standard"
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			b.tier = tier
+			w.WriteHeader(http.StatusOK)
+		}
+
+	case q.Get("comp") == "snapshot" && r.Method == http.MethodPut:
+		b, ok := s.blobs[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		s.version++
+		id := time.Date(2025, 7, 1, 12, 0, s.version, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+		s.snapshots[key] = append(s.snapshots[key], fakeSnapshot{id: id, blob: *b})
+		w.Header().Set("ETag", b.etag)
+		w.Header().Set("Last-Modified", b.modified.Format(http.TimeFormat))
+		w.Header().Set("x-ms-snapshot", id)
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut:
+		if !s.preconditionsMetLocked(w, r, key) {
+			return
+		}
+		var b *fakeBlob
+		switch blobType := r.Header.Get("x-ms-blob-type"); blobType {
+		case "PageBlob":
+			size, _ := strconv.ParseInt(r.Header.Get("x-ms-blob-content-length"), 10, 64)
+			if size%512 != 0 {
+				writeFakeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+				return
+			}
+			b = s.storeLocked(container, name, make([]byte, size), nil, storedHeaders(r))
+			b.blobType = blobType
+		case "AppendBlob":
+			b = s.storeLocked(container, name, nil, nil, storedHeaders(r))
+			b.blobType = blobType
+		default:
+			data, _ := io.ReadAll(r.Body)
+			sum := md5.Sum(data)
+			b = s.storeLocked(container, name, data, sum[:], storedHeaders(r))
+		}
+		setBlobHeaders(w, b)
+		w.WriteHeader(http.StatusCreated)
+
+	case q.Get("comp") == "blocklist" && r.Method == http.MethodGet:
+		s.serveBlockListLocked(w, key)
+
+	case q.Get("comp") == "tags" && r.Method == http.MethodGet:
+		b, ok := s.blobs[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		var buf bytes.Buffer
+		buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><Tags><TagSet>`)
+		for k := range b.tags {
+			buf.WriteString(`<Tag><Key>`)
+			_ = xml.EscapeText(&buf, []byte(k))
+			buf.WriteString(`</Key><Value>`)
+			_ = xml.EscapeText(&buf, []byte(b.tags.Get(k)))
+			buf.WriteString(`</Value></Tag>`)
+		}
+		buf.WriteString(`</TagSet></Tags>`)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(buf.Bytes())
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		b, ok := s.blobs[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if b.copyStatus == "pending" {
+			if b.copyPolls > 0 {
+				b.copyPolls--
+			} else {
+				b.copyStatus = "success"
+			}
+		}
+		if !s.preconditionsMetLocked(w, r, key) {
+			return
+		}
+		if r.Method == http.MethodGet && b.tierName() == "Archive" {
+			writeFakeError(w, http.StatusConflict, "BlobArchived")
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !b.modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		setBlobHeaders(w, b)
+		data := b.data
+		status := http.StatusOK
+		if rng := r.Header.Get("x-ms-range"); rng != "" || r.Header.Get("Range") != "" {
+			if rng == "" {
+				rng = r.Header.Get("Range")
+			}
+			start, end := parseFakeRange(rng, int64(len(data)))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[key]; !ok {
+			writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if !s.preconditionsMetLocked(w, r, key) {
+			return
+		}
+		delete(s.blobs, key)
+		delete(s.snapshots, key)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// preconditionsMetLocked checks If-Match, If-None-Match and If-Unmodified-Since
+// against the stored blob, answering 412 ConditionNotMet when they fail.
+func (s *fakeBlobStore) preconditionsMetLocked(w http.ResponseWriter, r *http.Request, key string) bool {
+	b, exists := s.blobs[key]
+	met := true
+	if m := r.Header.Get("If-Match"); m != "" {
+		met = exists && (m == "*" || m == b.etag)
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && exists {
+		met = met && m != "*" && m != b.etag
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && exists {
+		met = met && !b.modified.After(since)
+	}
+	if !met {
+		writeFakeError(w, http.StatusPreconditionFailed, "ConditionNotMet")
+	}
+	return met
+}
+
+// storedHeaders keeps the blob HTTP headers and metadata a client sets on upload.
+func storedHeaders(r *http.Request) http.Header {
+	h := http.Header{}
+	for from, to := range map[string]string{
+		"x-ms-blob-content-type":        "Content-Type",
+		"x-ms-blob-content-encoding":    "Content-Encoding",
+		"x-ms-blob-content-language":    "Content-Language",
+		"x-ms-blob-content-disposition": "Content-Disposition",
+		"x-ms-blob-cache-control":       "Cache-Control",
+	} {
+		if v := r.Header.Get(from); v != "" {
+			h.Set(to, v)
+		}
+	}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Ms-Meta-") {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+// parseFakeRange parses "bytes=start-end" (end optional) and clamps it to size.
+func parseFakeRange(rng string, size int64) (int64, int64) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	from, to, _ := strings.Cut(spec, "-")
+	start, _ := strconv.ParseInt(from, 10, 64)
+	end := size - 1
+	if to != "" {
+		end, _ = strconv.ParseInt(to, 10, 64)
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end
+}
+
+func (s *fakeBlobStore) serveBlockListLocked(w http.ResponseWriter, key string) {
+	b, exists := s.blobs[key]
+	var staged []string
+	for k := range s.staged {
+		if id, ok := strings.CutPrefix(k, key+"#"); ok {
+			staged = append(staged, id)
+		}
+	}
+	if !exists && len(staged) == 0 {
+		writeFakeError(w, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	sort.Strings(staged)
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>`)
+	if exists {
+		for _, id := range b.blocks {
+			fmt.Fprintf(&buf, `<Block><Name>%s</Name><Size>0</Size></Block>`, id)
+		}
+	}
+	buf.WriteString(`</CommittedBlocks><UncommittedBlocks>`)
+	for _, id := range staged {
+		fmt.Fprintf(&buf, `<Block><Name>%s</Name><Size>%d</Size></Block>`, id, len(s.staged[key+"#"+id]))
+	}
+	buf.WriteString(`</UncommittedBlocks></BlockList>`)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// serveListLocked lists the blobs under prefix; with a delimiter, those with one
+// after prefix are rolled up into a BlobPrefix each.
+func (s *fakeBlobStore) serveListLocked(w http.ResponseWriter, container, prefix, delimiter string, snapshots bool) {
+	var names []string
+	prefixes := map[string]bool{}
+	for key := range s.blobs {
+		c, name, _ := strings.Cut(key, "/")
+		if c != container || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			prefixes[name[:len(prefix)+i+len(delimiter)]] = true
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	fmt.Fprintf(&buf, `<EnumerationResults ServiceEndpoint="%s/" ContainerName="%s"><Blobs>`, fakeAccountURL, container)
+	for _, p := range slices.Sorted(maps.Keys(prefixes)) {
+		buf.WriteString(`<BlobPrefix><Name>`)
+		_ = xml.EscapeText(&buf, []byte(p))
+		buf.WriteString(`</Name></BlobPrefix>`)
+	}
+	for _, name := range names {
+		if snapshots {
+			for _, snap := range s.snapshots[container+"/"+name] {
+				writeFakeListItem(&buf, name, snap.id, &snap.blob)
+			}
+		}
+		writeFakeListItem(&buf, name, "", s.blobs[container+"/"+name])
+	}
+	buf.WriteString(`</Blobs><NextMarker/></EnumerationResults>`)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeFakeListItem(buf *bytes.Buffer, name, snapshot string, b *fakeBlob) {
+	buf.WriteString(`<Blob><Name>`)
+	_ = xml.EscapeText(buf, []byte(name))
+	buf.WriteString(`</Name>`)
+	if snapshot != "" {
+		fmt.Fprintf(buf, `<Snapshot>%s</Snapshot>`, snapshot)
+	}
+	fmt.Fprintf(buf, `<Properties><Last-Modified>%s</Last-Modified><Etag>%s</Etag>`+
+		`<Content-Length>%d</Content-Length><BlobType>%s</BlobType>`,
+		b.modified.Format(http.TimeFormat), b.etag, len(b.data), b.typeName())
+	if b.contentMD5 != nil {
+		fmt.Fprintf(buf, `<Content-MD5>%s</Content-MD5>`, base64.StdEncoding.EncodeToString(b.contentMD5))
+	}
+	buf.WriteString(`</Properties></Blob>`)
+}
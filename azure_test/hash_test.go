@@ -0,0 +1,57 @@
+package azure_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestHashAzureBlob(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	data := bytes.Repeat([]byte("hash me "), 1000)
+	store.put(fakeContainer, "hashed.bin", data)
+
+	var buf bytes.Buffer
+	n, sum, err := azure.HashAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "hashed.bin", &buf, nil)
+	require.NoError(t, err)
+	want := md5.Sum(data)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, hex.EncodeToString(want[:]), sum)
+	require.Equal(t, data, buf.Bytes(), "the content is streamed to the writer")
+}
+
+func TestHashAzureBlobNotFound(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.containers[fakeContainer] = true
+
+	_, _, err := azure.HashAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "missing.bin", &bytes.Buffer{}, nil)
+	require.ErrorContains(t, err, "BlobNotFound")
+}
+
+func TestBlobExists(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+
+	exists, err := azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "late.bin", nil)
+	require.NoError(t, err)
+	require.False(t, exists, "no container yet")
+
+	store.put(fakeContainer, "other.bin", []byte("x"))
+	exists, err = azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "late.bin", nil)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	store.put(fakeContainer, "late.bin", []byte("x"))
+	exists, err = azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "late.bin", nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
@@ -0,0 +1,53 @@
+package azure_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestSetExtraHeaders(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "headers.bin", []byte("x"))
+	azure.SetExtraHeaders(http.Header{
+		"X-Debug-Session": {"session-42"},
+		"X-Ms-Version":    {"1999-01-01"},
+		"Authorization":   {"Bearer not-the-signature"},
+		"User-Agent":      {"custom-agent"}, // already set by the SDK
+	})
+	t.Cleanup(func() { azure.SetExtraHeaders(nil) })
+
+	_, _, err := azure.GetAzureBlobMetaData(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "headers.bin", nil)
+	require.NoError(t, err)
+	_, _, err = azure.HashAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "headers.bin", &strings.Builder{}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, store.requests, 2)
+	for _, r := range store.requests {
+		require.Equal(t, "session-42", r.Header.Get("X-Debug-Session"))
+		require.NotEqual(t, "1999-01-01", r.Header.Get("X-Ms-Version"))
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey "),
+			"the signature is not clobbered: %q", r.Header.Get("Authorization"))
+		require.Contains(t, r.Header.Get("User-Agent"), "azsdk-go", "headers the request has are kept")
+	}
+}
+
+func TestSetExtraHeadersNil(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "headers.bin", []byte("x"))
+	azure.SetExtraHeaders(http.Header{"X-Debug-Session": {"session-42"}})
+	azure.SetExtraHeaders(nil)
+
+	_, _, err := azure.GetAzureBlobMetaData(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "headers.bin", nil)
+	require.NoError(t, err)
+	require.Empty(t, store.requests[0].Header.Get("X-Debug-Session"))
+}
@@ -0,0 +1,251 @@
+package azure_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// trickle writes n bytes, one every interval, then stops for hang.
+func trickle(n int, interval, hang time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		for i := 0; i < n; i++ {
+			_, _ = w.Write([]byte{'x'})
+			flusher.Flush()
+			time.Sleep(interval)
+		}
+		select {
+		case <-time.After(hang):
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func TestHTTPClientSlowButSteady(t *testing.T) {
+	url := newFakeAzure(t, trickle(10, 30*time.Millisecond, 0))
+	client := azure.NewHTTPClient(azure.ClientTimeouts{Idle: 150 * time.Millisecond})
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// the whole body takes twice the idle timeout, but never pauses for that long
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Len(t, body, 10)
+}
+
+func TestHTTPClientStalled(t *testing.T) {
+	url := newFakeAzure(t, trickle(2, 10*time.Millisecond, 5*time.Second))
+	client := azure.NewHTTPClient(azure.ClientTimeouts{Idle: 100 * time.Millisecond})
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	started := time.Now()
+	body, err := io.ReadAll(resp.Body)
+	require.ErrorIs(t, err, azure.ErrStalled)
+	require.Len(t, body, 2)
+	require.Less(t, time.Since(started), 2*time.Second)
+}
+
+func TestHTTPClientResponseHeaderTimeout(t *testing.T) {
+	url := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	client := azure.NewHTTPClient(azure.ClientTimeouts{ResponseHeader: 100 * time.Millisecond})
+
+	_, err := client.Get(url)
+	require.ErrorContains(t, err, "timeout awaiting response headers")
+}
+
+func TestHTTPClientResponseHeaderTimeoutIsRetried(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{MaxRetries: 1, RetryDelay: time.Millisecond,
+		MaxRetryDelay: time.Millisecond})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "behind-a-balancer.bin", []byte("data"))
+	var hits int32
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// a black hole: the connection is up, the headers never come
+			<-r.Context().Done()
+			return
+		}
+		store.ServeHTTP(w, r)
+	})
+	client := azure.NewHTTPClient(azure.ClientTimeouts{ResponseHeader: 100 * time.Millisecond})
+
+	started := time.Now()
+	exists, err := azure.BlobExists(accountURL, fakeAccountName, fakeAccountKey, fakeContainer,
+		"behind-a-balancer.bin", client)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, int32(2), atomic.LoadInt32(&hits), "the request that got no headers is retried")
+	require.Less(t, time.Since(started), 2*time.Second)
+}
+
+// newCustomCATLSServer starts a TLS server with a certificate for 127.0.0.1 signed
+// by a freshly generated CA, and returns the pool of that CA.
+func newCustomCATLSServer(t *testing.T, maxVersion uint16) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: key}},
+		MaxVersion:   maxVersion,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return srv, pool
+}
+
+func TestHTTPClientCustomCA(t *testing.T) {
+	srv, pool := newCustomCATLSServer(t, 0)
+
+	client := azure.NewHTTPClientWithTLS(azure.DefaultClientTimeouts(), &tls.Config{RootCAs: pool})
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(body))
+
+	// the system roots do not know the CA
+	_, err = azure.NewHTTPClient(azure.DefaultClientTimeouts()).Get(srv.URL)
+	var unknown x509.UnknownAuthorityError
+	require.ErrorAs(t, err, &unknown)
+}
+
+func TestHTTPClientMinTLSVersion(t *testing.T) {
+	srv, pool := newCustomCATLSServer(t, tls.VersionTLS12)
+
+	client := azure.NewHTTPClientWithTLS(azure.DefaultClientTimeouts(),
+		&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13})
+	_, err := client.Get(srv.URL)
+	require.ErrorContains(t, err, "protocol version")
+
+	client = azure.NewHTTPClientWithTLS(azure.DefaultClientTimeouts(),
+		&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+// newConnectProxy starts an https:// proxy with a self-signed certificate that
+// tunnels CONNECT requests, and returns its URL.
+func newConnectProxy(t *testing.T) *url.URL {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() { _, _ = io.Copy(upstream, buf) }()
+		_, _ = io.Copy(conn, upstream)
+	}))
+	t.Cleanup(srv.Close)
+	proxy, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return proxy
+}
+
+func TestHTTPClientInsecureProxy(t *testing.T) {
+	origin, pool := newCustomCATLSServer(t, 0)
+	proxy := newConnectProxy(t)
+
+	var warned atomic.Int32
+	client, err := azure.NewHTTPClientWithInsecureProxy(azure.DefaultClientTimeouts(),
+		&tls.Config{RootCAs: pool}, proxy, func(*http.Request) { warned.Add(1) })
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(origin.URL)
+		require.NoError(t, err, "the self-signed proxy is accepted")
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(body))
+	}
+	require.EqualValues(t, 2, warned.Load(), "warned on every request")
+}
+
+func TestHTTPClientInsecureProxyVerifiesOrigin(t *testing.T) {
+	origin, _ := newCustomCATLSServer(t, 0)
+	proxy := newConnectProxy(t)
+
+	// the origin CA is not trusted, relaxing the proxy must not accept it
+	client, err := azure.NewHTTPClientWithInsecureProxy(azure.DefaultClientTimeouts(), nil, proxy, nil)
+	require.NoError(t, err)
+	_, err = client.Get(origin.URL)
+	var unknown x509.UnknownAuthorityError
+	require.ErrorAs(t, err, &unknown)
+
+	_, err = azure.NewHTTPClientWithInsecureProxy(azure.DefaultClientTimeouts(),
+		&tls.Config{InsecureSkipVerify: true}, proxy, nil)
+	require.ErrorContains(t, err, "origin has to be verified")
+	_, err = azure.NewHTTPClientWithInsecureProxy(azure.DefaultClientTimeouts(), nil,
+		&url.URL{Scheme: "http", Host: proxy.Host}, nil)
+	require.ErrorContains(t, err, "https:// URL")
+}
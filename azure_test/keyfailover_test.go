@@ -0,0 +1,133 @@
+package azure_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// sharedKeySignedWith reports whether r carries a Shared Key signature made with key.
+func sharedKeySignedWith(t *testing.T, r *http.Request, key string) bool {
+	t.Helper()
+	var xms []string
+	for k, v := range r.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-ms-") {
+			xms = append(xms, name+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(xms)
+	resource := "/" + fakeAccountName + r.URL.EscapedPath()
+	params, err := url.ParseQuery(r.URL.RawQuery)
+	require.NoError(t, err)
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := params[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	stringToSign := strings.Join([]string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		contentLength,
+		r.Header.Get("Content-Md5"),
+		r.Header.Get("Content-Type"),
+		"",
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+		strings.Join(xms, "\n"),
+		resource,
+	}, "\n")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, decoded)
+	mac.Write([]byte(stringToSign))
+	want := "SharedKey " + fakeAccountName + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return r.Header.Get("Authorization") == want
+}
+
+// withValidKey makes store reject every request not signed with key, like an account
+// whose other key was just regenerated. It returns the number of rejected requests.
+func withValidKey(t *testing.T, store *fakeBlobStore, key string) *int {
+	rejected := new(int)
+	store.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if sharedKeySignedWith(t, r, key) {
+			return false
+		}
+		*rejected++
+		writeFakeError(w, http.StatusForbidden, "AuthenticationFailed")
+		return true
+	}
+	return rejected
+}
+
+func TestSecondaryAccountKeyFailover(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.containers[fakeContainer] = true
+	secondaryKey := base64.StdEncoding.EncodeToString([]byte("fake-secondary-key"))
+	azure.SetSecondaryAccountKey(fakeAccountKey, secondaryKey)
+	t.Cleanup(func() { azure.SetSecondaryAccountKey(fakeAccountKey, "") })
+	rejected := withValidKey(t, store, secondaryKey)
+
+	// the body of the rejected request is sent again
+	localFile := writeTempFile(t, "src.bin", []byte("rotated"))
+	_, _, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "rotated.bin", localFile, nil)
+	require.NoError(t, err)
+	require.Equal(t, "rotated", string(store.get(fakeContainer, "rotated.bin").data))
+	require.Equal(t, 1, *rejected)
+	require.Equal(t, secondaryKey, azure.AccountKeyInUse(fakeAccountKey))
+
+	// later requests go straight to the secondary key
+	exists, err := azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "rotated.bin", nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, 1, *rejected)
+}
+
+func TestSecondaryAccountKeyAlsoRejected(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "a.bin", []byte("x"))
+	secondaryKey := base64.StdEncoding.EncodeToString([]byte("fake-secondary-key"))
+	azure.SetSecondaryAccountKey(fakeAccountKey, secondaryKey)
+	t.Cleanup(func() { azure.SetSecondaryAccountKey(fakeAccountKey, "") })
+	rejected := withValidKey(t, store, base64.StdEncoding.EncodeToString([]byte("neither")))
+
+	_, _, err := azure.GetAzureBlobMetaData(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "a.bin", nil)
+	require.ErrorContains(t, err, "403")
+	require.Equal(t, 2, *rejected, "each key is tried once")
+	require.Equal(t, fakeAccountKey, azure.AccountKeyInUse(fakeAccountKey), "no failover to a rejected key")
+}
+
+func TestWithoutSecondaryAccountKey(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "a.bin", []byte("x"))
+	rejected := withValidKey(t, store, base64.StdEncoding.EncodeToString([]byte("fake-secondary-key")))
+
+	_, _, err := azure.GetAzureBlobMetaData(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "a.bin", nil)
+	require.ErrorContains(t, err, "403")
+	require.Equal(t, 1, *rejected)
+}
@@ -0,0 +1,77 @@
+package azure_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func withMaxConcurrentRequests(t *testing.T, n int) {
+	old := azure.GetMaxConcurrentRequests()
+	azure.SetMaxConcurrentRequests(n)
+	t.Cleanup(func() { azure.SetMaxConcurrentRequests(old) })
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withMaxConcurrentRequests(t, 3)
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "busy.bin", []byte("data"))
+	var inFlight, peak atomic.Int32
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			if p := peak.Load(); n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		store.ServeHTTP(w, r)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exists, err := azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "busy.bin", nil)
+			require.NoError(t, err)
+			require.True(t, exists)
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, peak.Load(), int32(3))
+	require.Len(t, store.requests, 20)
+}
+
+func TestMaxConcurrentRequestsHoldsOpenStream(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withMaxConcurrentRequests(t, 1)
+	withMetadataTimeout(t, 50*time.Millisecond)
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "stream.bin", []byte("streamed content"))
+
+	rc, _, err := azure.DownloadAzureBlobByChunks(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "stream.bin", "", nil)
+	require.NoError(t, err)
+
+	// the open stream holds the only slot
+	_, err = azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "stream.bin", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, rc.Close())
+	exists, err := azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "stream.bin", nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
@@ -0,0 +1,205 @@
+package azure_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// listBlobsXML renders a List Blobs response page.
+func listBlobsXML(nextMarker string, names ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	fmt.Fprintf(&b, `<EnumerationResults ServiceEndpoint="%s/" ContainerName="%s"><Blobs>`,
+		fakeAccountURL, fakeContainer)
+	for _, name := range names {
+		fmt.Fprintf(&b, `<Blob><Name>%s</Name><Properties><Content-Length>1</Content-Length>`+
+			`<BlobType>BlockBlob</BlobType></Properties></Blob>`, name)
+	}
+	fmt.Fprintf(&b, `</Blobs><NextMarker>%s</NextMarker></EnumerationResults>`, nextMarker)
+	return b.String()
+}
+
+func TestListAzureBlobOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	var markers []string
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/"+fakeContainer, r.URL.Path)
+		require.Equal(t, "list", r.URL.Query().Get("comp"))
+		require.NotEmpty(t, r.Header.Get("Authorization"))
+
+		marker := r.URL.Query().Get("marker")
+		markers = append(markers, marker)
+		w.Header().Set("Content-Type", "application/xml")
+		switch marker {
+		case "":
+			fmt.Fprint(w, listBlobsXML("page2", "images/a.qcow2", "images/b.qcow2"))
+		case "page2":
+			fmt.Fprint(w, listBlobsXML("", "c &amp; d.txt"))
+		default:
+			t.Errorf("unexpected marker %q", marker)
+		}
+	})
+
+	blobs, err := azure.ListAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"images/a.qcow2", "images/b.qcow2", "c & d.txt"}, blobs)
+	require.Equal(t, []string{"", "page2"}, markers)
+}
+
+func TestListAzureBlobOfflineEmpty(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, listBlobsXML(""))
+	})
+
+	blobs, err := azure.ListAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, nil)
+	require.NoError(t, err)
+	require.Empty(t, blobs)
+}
+
+func TestListAzureBlobOfflineErrors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"server error": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"container not found": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		},
+		"malformed xml": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>trunc`)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withRetryPolicy(t, azure.RetryPolicy{})
+			withFakeDoer(t, handler)
+
+			blobs, err := azure.ListAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, nil)
+			require.Error(t, err)
+			require.Nil(t, blobs)
+		})
+	}
+}
+
+func TestListAzureBlobOfflineAzureErrorCode(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		// an expired key: Azure answers with an error document, not a blob list
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthenticationFailed</Code>`+
+			`<Message>Server failed to authenticate the request.
+RequestId:8a1e2b7c-001e-0000-0000-000000000000
+Time:2025-07-01T10:00:00.0000000Z</Message>`+
+			`<AuthenticationErrorDetail>Signature did not match.</AuthenticationErrorDetail></Error>`)
+	})
+
+	blobs, err := azure.ListAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, nil)
+	require.Nil(t, blobs)
+	require.EqualError(t, err,
+		"failed to list blobs: AuthenticationFailed (HTTP 403): Server failed to authenticate the request.")
+	require.Equal(t, http.StatusForbidden, azure.StatusFromError(err))
+}
+
+func TestListAzureContainersOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	page := func(nextMarker string, names ...string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="%s/"><Containers>`,
+			fakeAccountURL)
+		for _, name := range names {
+			fmt.Fprintf(&b, `<Container><Name>%s</Name><Properties/></Container>`, name)
+		}
+		fmt.Fprintf(&b, `</Containers><NextMarker>%s</NextMarker></EnumerationResults>`, nextMarker)
+		return b.String()
+	}
+	var markers []string
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, strings.Trim(r.URL.Path, "/"), "account level request")
+		require.Equal(t, "list", r.URL.Query().Get("comp"))
+
+		marker := r.URL.Query().Get("marker")
+		markers = append(markers, marker)
+		w.Header().Set("Content-Type", "application/xml")
+		switch marker {
+		case "":
+			fmt.Fprint(w, page("page2", "images", "logs"))
+		case "page2":
+			fmt.Fprint(w, page("", fakeContainer))
+		default:
+			t.Errorf("unexpected marker %q", marker)
+		}
+	})
+
+	containers, err := azure.ListAzureContainers(fakeAccountURL, fakeAccountName, fakeAccountKey, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"images", "logs", fakeContainer}, containers)
+	require.Equal(t, []string{"", "page2"}, markers)
+}
+
+func TestListAzureContainersOfflineAzureErrorCode(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeFakeError(w, http.StatusForbidden, "AuthorizationPermissionMismatch")
+	})
+
+	containers, err := azure.ListAzureContainers(fakeAccountURL, fakeAccountName, fakeAccountKey, nil)
+	require.Nil(t, containers)
+	require.EqualError(t, err, "failed to list containers: AuthorizationPermissionMismatch (HTTP 403): Forbidden")
+}
+
+func TestListAzureBlobInfoOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	a := store.put(fakeContainer, "images/a.qcow2", []byte("aaaa"))
+	b := store.put(fakeContainer, "images/b.qcow2", []byte("bb"))
+	b.contentMD5 = nil
+	store.put(fakeContainer, "other.txt", []byte("x"))
+
+	infos, err := azure.ListAzureBlobInfo(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/", nil)
+	require.NoError(t, err)
+	for i := range infos {
+		infos[i].LastModified = infos[i].LastModified.UTC() // parsed as GMT
+	}
+	require.Equal(t, []azure.BlobInfo{
+		{Name: "images/a.qcow2", Size: 4, LastModified: a.modified, ContentMD5: hex.EncodeToString(a.contentMD5)},
+		{Name: "images/b.qcow2", Size: 2, LastModified: b.modified},
+	}, infos)
+}
+
+func TestListAzureBlobHierarchy(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	for _, name := range []string{"readme.txt", "images/a.qcow2", "images/old/b.qcow2",
+		"images/old/older/c.qcow2", "logs/d.tar.gz"} {
+		store.put(fakeContainer, name, []byte("x"))
+	}
+	names := func(prefix string, recursive bool) []string {
+		t.Helper()
+		infos, err := azure.ListAzureBlobHierarchy(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, prefix, recursive, nil)
+		require.NoError(t, err)
+		var names []string
+		for _, info := range infos {
+			require.Equal(t, strings.HasSuffix(info.Name, "/"), info.IsPrefix, info.Name)
+			names = append(names, info.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"images/", "logs/", "readme.txt"}, names("", false))
+	require.Equal(t, []string{"images/a.qcow2", "images/old/"}, names("images/", false))
+	require.Equal(t, []string{"images/a.qcow2", "images/old/b.qcow2", "images/old/older/c.qcow2",
+		"logs/d.tar.gz", "readme.txt"}, names("", true))
+	require.Equal(t, []string{"images/old/b.qcow2", "images/old/older/c.qcow2"}, names("images/old/", true))
+}
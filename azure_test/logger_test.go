@@ -0,0 +1,95 @@
+package azure_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// fakeLogger keeps the lines logged through it, by level.
+type fakeLogger struct {
+	mu     sync.Mutex
+	debug  []string
+	errors []string
+}
+
+func (l *fakeLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *fakeLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func withLogger(t *testing.T, l azure.Logger) {
+	azure.SetLogger(l)
+	t.Cleanup(func() { azure.SetLogger(nil) })
+}
+
+func TestLoggerSeesRequestsAndRetries(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{MaxRetries: 1, RetryDelay: time.Millisecond,
+		MaxRetryDelay: time.Millisecond, StatusCodes: []int{503}})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "logged.bin", []byte("data"))
+	busy := true
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		if busy {
+			busy = false
+			writeFakeError(w, http.StatusServiceUnavailable, "ServerBusy")
+			return
+		}
+		store.ServeHTTP(w, r)
+	})
+	l := &fakeLogger{}
+	withLogger(t, l)
+
+	sasURL := fakeAccountURL + "/?sv=2022-11-02&sig=c2VjcmV0"
+	exists, err := azure.BlobExists(sasURL, fakeAccountName, "", fakeContainer, "logged.bin", nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = azure.BlobExists(sasURL, fakeAccountName, "", fakeContainer, "missing.bin", nil)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.Len(t, l.errors, 1)
+	require.Contains(t, l.errors[0], "HEAD https://fakeaccount.blob.core.windows.net/fakecontainer/logged.bin?")
+	require.Contains(t, l.errors[0], "(try 1): 503 ServerBusy")
+	require.Contains(t, l.errors[0], "retrying")
+	require.Len(t, l.debug, 2)
+	require.Contains(t, l.debug[0], "logged.bin")
+	require.Contains(t, l.debug[0], "(try 2): 200")
+	require.Contains(t, l.debug[1], "missing.bin")
+	require.Contains(t, l.debug[1], "(try 1): 404 BlobNotFound")
+	for _, line := range append(l.errors, l.debug...) {
+		require.NotContains(t, line, "c2VjcmV0", "the signature is redacted")
+		require.Contains(t, line, "sig=REDACTED")
+	}
+}
+
+func TestLoggerGivesUp(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{MaxRetries: 1, RetryDelay: time.Millisecond,
+		MaxRetryDelay: time.Millisecond, StatusCodes: []int{503}})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeFakeError(w, http.StatusServiceUnavailable, "ServerBusy")
+	})
+	l := &fakeLogger{}
+	withLogger(t, l)
+
+	_, err := azure.BlobExists(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, "busy.bin", nil)
+	require.Error(t, err)
+	require.Len(t, l.errors, 2)
+	require.Contains(t, l.errors[0], "(try 1): 503 ServerBusy")
+	require.Contains(t, l.errors[0], "; retrying")
+	require.Contains(t, l.errors[1], "(try 2): 503 ServerBusy")
+	require.Contains(t, l.errors[1], "; giving up")
+}
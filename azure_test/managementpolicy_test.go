@@ -0,0 +1,127 @@
+package azure_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const fakeAccountResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg" +
+	"/providers/Microsoft.Storage/storageAccounts/" + fakeAccountName
+
+// coolAfter30Days moves the block blobs under images/ to the cool tier 30 days
+// after they were last modified.
+const coolAfter30Days = `{"rules":[{"enabled":true,"name":"cool-images","type":"Lifecycle","definition":{` +
+	`"actions":{"baseBlob":{"tierToCool":{"daysAfterModificationGreaterThan":30}}},` +
+	`"filters":{"blobTypes":["blockBlob"],"prefixMatch":["images/"]}}}]}`
+
+// fakeManagement serves the management policy of fakeAccountResourceID, as Azure
+// Resource Manager does, to the bearer of token.
+type fakeManagement struct {
+	mu     sync.Mutex
+	token  string
+	policy json.RawMessage // nil when there is none
+}
+
+func (m *fakeManagement) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+m.token {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, `{"error":{"code":"InvalidAuthenticationToken","message":"bad token"}}`)
+		return
+	}
+	if r.URL.Host != "management.azure.com" || r.URL.Path != fakeAccountResourceID+"/managementPolicies/default" ||
+		r.URL.Query().Get("api-version") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var resource struct {
+			Properties struct {
+				Policy json.RawMessage `json:"policy"`
+			} `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.policy = resource.Properties.Policy
+	case http.MethodGet:
+		if m.policy == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":{"code":"ManagementPolicyNotFound","message":"none"}}`)
+			return
+		}
+	case http.MethodDelete:
+		m.policy = nil
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":         fakeAccountResourceID + "/managementPolicies/default",
+		"properties": map[string]json.RawMessage{"policy": m.policy},
+	})
+}
+
+func TestAzureManagementPolicy(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	arm := &fakeManagement{token: "dG9rZW4"}
+	withFakeDoer(t, arm.ServeHTTP)
+
+	policy, err := azure.GetAzureManagementPolicy("", fakeAccountResourceID, arm.token, nil)
+	require.NoError(t, err)
+	require.Nil(t, policy, "none yet")
+
+	require.NoError(t, azure.SetAzureManagementPolicy("", fakeAccountResourceID, arm.token,
+		[]byte(coolAfter30Days), nil))
+	policy, err = azure.GetAzureManagementPolicy("", fakeAccountResourceID, arm.token, nil)
+	require.NoError(t, err)
+	require.JSONEq(t, coolAfter30Days, string(policy))
+	require.NoError(t, azure.DeleteAzureManagementPolicy("", fakeAccountResourceID, arm.token, nil))
+	policy, err = azure.GetAzureManagementPolicy("", fakeAccountResourceID, arm.token, nil)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	err = azure.SetAzureManagementPolicy("", fakeAccountResourceID, "other", []byte(coolAfter30Days), nil)
+	require.ErrorContains(t, err, "InvalidAuthenticationToken")
+	require.ErrorContains(t, azure.SetAzureManagementPolicy("", fakeAccountResourceID, arm.token,
+		[]byte(`{"rules":[]}`), nil), "invalid management policy")
+	_, err = azure.GetAzureManagementPolicy("", "/storageAccounts/"+fakeAccountName, arm.token, nil)
+	require.ErrorContains(t, err, "invalid storage account resource ID")
+}
+
+// TestAzureManagementPolicyLive sets a rule on a real account and reads it back,
+// putting back the policy the account had.
+func TestAzureManagementPolicyLive(t *testing.T) {
+	resourceID := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_RESOURCE_ID")
+	token := getEnvOrSkip(t, "TEST_AZURE_MANAGEMENT_TOKEN")
+	httpClient := newHTTPClient()
+
+	before, err := azure.GetAzureManagementPolicy("", resourceID, token, httpClient)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if before == nil {
+			require.NoError(t, azure.DeleteAzureManagementPolicy("", resourceID, token, httpClient))
+		} else {
+			require.NoError(t, azure.SetAzureManagementPolicy("", resourceID, token, before, httpClient))
+		}
+	})
+	require.NoError(t, azure.SetAzureManagementPolicy("", resourceID, token, []byte(coolAfter30Days), httpClient))
+	policy, err := azure.GetAzureManagementPolicy("", resourceID, token, httpClient)
+	require.NoError(t, err)
+	require.Contains(t, string(policy), "cool-images")
+}
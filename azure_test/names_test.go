@@ -0,0 +1,61 @@
+package azure_test
+
+import (
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const specialBlobName = "folder/a b+c#d.txt"
+
+func TestEscapeBlobName(t *testing.T) {
+	require.Equal(t, "folder/a%20b+c%23d.txt", azure.EscapeBlobName(specialBlobName))
+	require.Equal(t, "%C3%BCber/q%3F.bin", azure.EscapeBlobName("über/q?.bin"))
+}
+
+func TestSpecialBlobNameRoundTrip(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	data := []byte("special characters")
+	localFile := writeTempFile(t, "src.txt", data)
+
+	_, _, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, specialBlobName, localFile, nil)
+	require.NoError(t, err)
+	require.NotNil(t, store.get(fakeContainer, specialBlobName), "stored under the unescaped name")
+
+	blobs, err := azure.ListAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey, fakeContainer, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{specialBlobName}, blobs)
+
+	rc, size, err := azure.DownloadAzureBlobByChunks(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, specialBlobName, "", nil)
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, data, got)
+}
+
+func TestGenerateBlobSasURISpecialName(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, specialBlobName, []byte("x"))
+
+	sasURL, err := azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, specialBlobName, nil, time.Hour, azure.SasOptions{})
+	require.NoError(t, err)
+	u, err := url.Parse(sasURL)
+	require.NoError(t, err)
+	require.Equal(t, "/"+fakeContainer+"/folder/a%20b+c%23d.txt", u.EscapedPath())
+	require.Equal(t, "/"+fakeContainer+"/"+specialBlobName, u.Path)
+
+	// the signature covers the unescaped name
+	require.Equal(t, sdkSasSignature(t, u.Query(), specialBlobName), u.Query().Get("sig"))
+}
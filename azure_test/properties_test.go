@@ -0,0 +1,139 @@
+package azure_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestGetAzureBlobProperties(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "plain.bin", []byte("x"))
+
+	props, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, azure.BlobProperties{}, props)
+
+	b.headers = http.Header{"Content-Type": {"application/x-qemu-disk"}, "X-Ms-Meta-Build": {"1234"}}
+	props, err = azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, "application/x-qemu-disk", props.ContentType)
+	require.Len(t, props.Metadata, 1)
+	require.Empty(t, props.Tags)
+	for _, r := range store.requests {
+		require.NotEqual(t, "tags", r.URL.Query().Get("comp"), "no tags to fetch")
+	}
+}
+
+// TestBlobPropertiesRoundTrip restores a downloaded blob with the properties of the
+// original, as an archive-and-restore would.
+func TestBlobPropertiesRoundTrip(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.containers[fakeContainer] = true
+	id := base64.StdEncoding.EncodeToString([]byte("block-0"))
+	want := azure.BlobProperties{
+		ContentType:        "application/x-qemu-disk",
+		ContentEncoding:    "identity",
+		ContentLanguage:    "en",
+		ContentDisposition: `attachment; filename="disk.qcow2"`,
+		CacheControl:       "no-cache",
+		Metadata:           map[string]string{"Build": "1234", "Owner": "release"},
+		Tags:               map[string]string{"stage": "rc", "project": "eve"},
+	}
+	upload := func(name string, props *azure.BlobProperties) {
+		require.NoError(t, azure.UploadPartByChunk(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, name, id, nil, bytes.NewReader([]byte("disk image"))))
+		require.NoError(t, azure.UploadBlockListToBlobWithProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, name, nil, []string{id}, props))
+	}
+	upload("original.qcow2", &want)
+
+	got, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "original.qcow2", nil)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	upload("restored.qcow2", &got)
+	restored, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "restored.qcow2", nil)
+	require.NoError(t, err)
+	require.Equal(t, want, restored)
+
+	upload("bare.qcow2", nil)
+	bare, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "bare.qcow2", nil)
+	require.NoError(t, err)
+	require.Equal(t, azure.BlobProperties{}, bare)
+}
+
+func TestGetAzureBlobState(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "plain.bin", []byte("x"))
+
+	state, err := azure.GetAzureBlobState(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, "BlockBlob", state.BlobType)
+	require.Equal(t, b.etag, state.ETag)
+	require.True(t, b.modified.Equal(state.LastModified))
+	require.Nil(t, state.Copy, "not written by Copy Blob")
+
+	b.copyStatus = "success"
+	b.headers = http.Header{"X-Ms-Lease-State": {"leased"}, "X-Ms-Lease-Status": {"locked"},
+		"X-Ms-Lease-Duration": {"infinite"}}
+	state, err = azure.GetAzureBlobState(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, azure.BlobLease{State: "leased", Status: "locked", Duration: "infinite"}, state.Lease)
+	require.NotNil(t, state.Copy)
+	require.Equal(t, "success", state.Copy.Status)
+}
+
+func TestCheckAzureBlobImmutable(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "artifact.bin", []byte("x"))
+	until := func(d time.Duration) string { return time.Now().Add(d).UTC().Format(http.TimeFormat) }
+	check := func() (azure.BlobImmutability, error) {
+		return azure.CheckAzureBlobImmutable(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, "artifact.bin", nil)
+	}
+
+	_, err := check()
+	require.ErrorIs(t, err, azure.ErrNotImmutable, "no policy at all")
+
+	b.headers = http.Header{"X-Ms-Legal-Hold": {"true"}}
+	i, err := check()
+	require.ErrorIs(t, err, azure.ErrNotImmutable)
+	require.ErrorContains(t, err, "legal hold only")
+	require.True(t, i.LegalHold)
+
+	b.headers = http.Header{"X-Ms-Immutability-Policy-Mode": {"Locked"},
+		"X-Ms-Immutability-Policy-Until-Date": {until(-time.Hour)}}
+	_, err = check()
+	require.ErrorIs(t, err, azure.ErrNotImmutable)
+	require.ErrorContains(t, err, "expired")
+
+	b.headers = http.Header{"X-Ms-Immutability-Policy-Mode": {"Unlocked"},
+		"X-Ms-Immutability-Policy-Until-Date": {until(time.Hour)}}
+	i, err = check()
+	require.NoError(t, err)
+	require.Equal(t, "Unlocked", i.Mode)
+	require.False(t, i.LegalHold)
+
+	props, err := azure.GetAzureBlobProperties(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "artifact.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, i, props.Immutability)
+}
@@ -0,0 +1,105 @@
+package azure_test
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// rangedBlob is a little over one chunk of DownloadAzureBlob, so it is read in two ranges.
+func rangedBlob() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), int(azure.SingleMB/16)+1)
+}
+
+func downloadToTemp(t *testing.T, accountURL, name string) (string, error) {
+	localFile := filepath.Join(t.TempDir(), "dst.bin")
+	_, err := azure.DownloadAzureBlob(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, name, localFile, 0, nil, types.DownloadedParts{}, nil)
+	return localFile, err
+}
+
+func TestDownloadAzureBlobChecksRanges(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	content := rangedBlob()
+	store.put(fakeContainer, "ranged.bin", content)
+
+	localFile, err := downloadToTemp(t, fakeAccountURL, "ranged.bin")
+	require.NoError(t, err)
+	got, err := os.ReadFile(localFile)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, got))
+}
+
+func TestDownloadAzureBlobRejectsIgnoredRange(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	content := rangedBlob()
+	// a proxy that drops the range headers and sends the whole blob every time
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	})
+
+	_, err := downloadToTemp(t, accountURL, "ranged.bin")
+	require.ErrorIs(t, err, azure.ErrRangeMismatch)
+	require.ErrorContains(t, err, "Content-Length")
+}
+
+// overwritingStore serves store, one ranged read at a time, and overwrites the blob
+// name after each of the ranged reads overwrite says to.
+func overwritingStore(store *fakeBlobStore, name string, overwrite func(read int) bool) http.HandlerFunc {
+	var mu sync.Mutex
+	reads := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("x-ms-range") == "" {
+			store.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		store.ServeHTTP(w, r)
+		if overwrite(reads) {
+			store.put(fakeContainer, name, bytes.ToUpper(store.get(fakeContainer, name).data))
+		}
+	}
+}
+
+func TestDownloadAzureBlobRestartsOnOverwrite(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "ranged.bin", rangedBlob())
+	// between the first range and the second
+	withFakeDoer(t, overwritingStore(store, "ranged.bin", func(read int) bool { return read == 1 }))
+
+	localFile := filepath.Join(t.TempDir(), "dst.bin")
+	parts, err := azure.DownloadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "ranged.bin", localFile, 0, nil, types.DownloadedParts{PartSize: azure.SingleMB}, nil)
+	require.NoError(t, err)
+	got, err := os.ReadFile(localFile)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(bytes.ToUpper(rangedBlob()), got), "only the new version is in the file")
+	require.Len(t, parts.Parts, 2, "the parts of the old version were dropped")
+}
+
+func TestDownloadAzureBlobGivesUpOnConstantOverwrites(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := newFakeBlobStore()
+	store.put(fakeContainer, "ranged.bin", rangedBlob())
+	withFakeDoer(t, overwritingStore(store, "ranged.bin", func(int) bool { return true }))
+
+	_, err := downloadToTemp(t, fakeAccountURL, "ranged.bin")
+	require.ErrorIs(t, err, azure.ErrPreconditionFailed)
+}
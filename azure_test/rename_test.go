@@ -0,0 +1,82 @@
+package azure_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestRenameAzureBlobOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "old/name.bin", []byte("renamed content"))
+
+	err := azure.RenameAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "old/name.bin", "new/name.bin", nil, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, store.get(fakeContainer, "old/name.bin"))
+	require.Equal(t, "renamed content", string(store.get(fakeContainer, "new/name.bin").data))
+}
+
+func TestRenameAzureBlobKeepsSourceOnFailure(t *testing.T) {
+	for name, intercept := range map[string]func(store *fakeBlobStore) func(w http.ResponseWriter, r *http.Request) bool{
+		"copy failed": func(store *fakeBlobStore) func(w http.ResponseWriter, r *http.Request) bool {
+			return func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Header.Get("x-ms-copy-source") == "" {
+					return false
+				}
+				w.Header().Set("x-ms-copy-id", "copy-1")
+				w.Header().Set("x-ms-copy-status", "failed")
+				w.WriteHeader(http.StatusAccepted)
+				return true
+			}
+		},
+		"copy rejected": func(store *fakeBlobStore) func(w http.ResponseWriter, r *http.Request) bool {
+			return func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Header.Get("x-ms-copy-source") == "" {
+					return false
+				}
+				writeFakeError(w, http.StatusForbidden, "CannotVerifyCopySource")
+				return true
+			}
+		},
+		"destination differs": func(store *fakeBlobStore) func(w http.ResponseWriter, r *http.Request) bool {
+			return func(w http.ResponseWriter, r *http.Request) bool {
+				if b := store.get(fakeContainer, "new.bin"); b != nil && r.Method == http.MethodHead {
+					b.data = b.data[:1]
+				}
+				return false
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withRetryPolicy(t, azure.RetryPolicy{})
+			store := withFakeBlobStore(t)
+			store.put(fakeContainer, "old.bin", []byte("keep me"))
+			store.intercept = intercept(store)
+
+			err := azure.RenameAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "old.bin", "new.bin", nil, time.Minute)
+			require.Error(t, err)
+			require.NotNil(t, store.get(fakeContainer, "old.bin"), "source must survive a failed rename")
+		})
+	}
+}
+
+func TestRenameAzureBlobTimeout(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.pendingCopyPolls = 1000
+	store.put(fakeContainer, "old.bin", []byte("keep me"))
+
+	started := time.Now()
+	err := azure.RenameAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "old.bin", "new.bin", nil, 300*time.Millisecond)
+	require.ErrorIs(t, err, azure.ErrCopyTimeout)
+	require.Less(t, time.Since(started), 2*time.Second)
+	require.NotNil(t, store.get(fakeContainer, "old.bin"), "source must survive a copy that does not finish")
+}
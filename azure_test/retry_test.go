@@ -0,0 +1,98 @@
+package azure_test
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// withRetryPolicy installs p for the duration of the test.
+func withRetryPolicy(t *testing.T, p azure.RetryPolicy) {
+	old := azure.GetRetryPolicy()
+	azure.SetRetryPolicy(p)
+	t.Cleanup(func() { azure.SetRetryPolicy(old) })
+}
+
+func TestRetryOnConfiguredStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		retryOn  string
+		expected int32
+	}{
+		{name: "500 not configured", retryOn: "503,429", expected: 1},
+		{name: "500 configured", retryOn: "500", expected: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hits int32
+			accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+
+			codes, err := azure.ParseRetryStatusCodes(tc.retryOn)
+			require.NoError(t, err)
+			withRetryPolicy(t, azure.RetryPolicy{
+				MaxRetries:    2,
+				RetryDelay:    time.Millisecond,
+				MaxRetryDelay: 5 * time.Millisecond,
+				StatusCodes:   codes,
+			})
+
+			_, _, err = azure.GetAzureBlobMetaData(accountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "blob", http.DefaultClient)
+			require.Error(t, err)
+			require.Equal(t, tc.expected, atomic.LoadInt32(&hits))
+		})
+	}
+}
+
+func TestAuthErrorsNeverRetried(t *testing.T) {
+	var hits int32
+	accountURL := newFakeAzure(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	withRetryPolicy(t, azure.RetryPolicy{
+		MaxRetries:    2,
+		RetryDelay:    time.Millisecond,
+		MaxRetryDelay: 5 * time.Millisecond,
+		StatusCodes:   []int{http.StatusForbidden, http.StatusNotFound},
+	})
+
+	_, _, err := azure.GetAzureBlobMetaData(accountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blob", http.DefaultClient)
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	require.Equal(t, http.StatusForbidden, azure.StatusFromError(err))
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := azure.RetryPolicy{RetryDelay: time.Second, MaxRetryDelay: 8 * time.Second, JitterFraction: 0.25}
+	require.True(t, p.JitterEnabled())
+	for attempt, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second,
+		5: 8 * time.Second} {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 200; i++ {
+			delay := p.Backoff(attempt)
+			require.GreaterOrEqual(t, delay, base*3/4, "attempt %d", attempt)
+			require.LessOrEqual(t, delay, base*5/4, "attempt %d", attempt)
+			seen[delay] = true
+		}
+		require.Greater(t, len(seen), 100, "attempt %d: delays are spread, not synchronized", attempt)
+	}
+}
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	p := azure.RetryPolicy{RetryDelay: time.Second, MaxRetryDelay: 3 * time.Second}
+	require.False(t, p.JitterEnabled())
+	require.Equal(t, time.Second, p.Backoff(1))
+	require.Equal(t, 2*time.Second, p.Backoff(2))
+	require.Equal(t, 3*time.Second, p.Backoff(3))
+	require.True(t, azure.DefaultRetryPolicy().JitterEnabled(), "jittered unless disabled")
+}
@@ -0,0 +1,160 @@
+package azure_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func sasQuery(t *testing.T, sasURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(sasURL)
+	require.NoError(t, err)
+	return u.Query()
+}
+
+// sdkSasSignature is the signature the SDK computes for a blob SAS with the start,
+// expiry, permissions, Content-Disposition override and stored access policy of q.
+func sdkSasSignature(t *testing.T, q url.Values, blobName string) string {
+	t.Helper()
+	var st, se time.Time // left to the stored access policy when missing
+	var err error
+	if q.Has("st") {
+		st, err = time.Parse(sas.TimeFormat, q.Get("st"))
+		require.NoError(t, err)
+	}
+	if q.Has("se") {
+		se, err = time.Parse(sas.TimeFormat, q.Get("se"))
+		require.NoError(t, err)
+	}
+	cred, err := service.NewSharedKeyCredential(fakeAccountName, fakeAccountKey)
+	require.NoError(t, err)
+	params, err := sas.BlobSignatureValues{
+		Protocol:           sas.ProtocolHTTPS,
+		StartTime:          st,
+		ExpiryTime:         se,
+		ContainerName:      fakeContainer,
+		BlobName:           blobName,
+		Permissions:        q.Get("sp"),
+		ContentDisposition: q.Get("rscd"),
+		Identifier:         q.Get("si"),
+	}.SignWithSharedKey(cred)
+	require.NoError(t, err)
+	return params.Signature()
+}
+
+func TestGenerateBlobSasURIBackdatesStart(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "shared.bin", []byte("x"))
+
+	sasURL, err := azure.GenerateBlobSasURI(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour)
+	require.NoError(t, err)
+	q := sasQuery(t, sasURL)
+	st, err := time.Parse(sas.TimeFormat, q.Get("st"))
+	require.NoError(t, err)
+	se, err := time.Parse(sas.TimeFormat, q.Get("se"))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(-azure.DefaultSasClockSkew), st, 5*time.Second)
+	require.WithinDuration(t, time.Now().Add(time.Hour), se, 5*time.Second)
+	require.Equal(t, sas.Version, q.Get("sv"))
+
+	// the SDK signs the same values to the same signature
+	require.Equal(t, sdkSasSignature(t, q, "shared.bin"), q.Get("sig"))
+}
+
+func TestGenerateBlobSasURIWithOlderVersion(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "shared.bin", []byte("x"))
+
+	sasURL, err := azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour, azure.SasOptions{Version: "2019-12-12"})
+	require.NoError(t, err)
+	q := sasQuery(t, sasURL)
+	require.Equal(t, "2019-12-12", q.Get("sv"))
+
+	// before 2020-12-06 there is no signedEncryptionScope line
+	stringToSign := strings.Join([]string{
+		"r", q.Get("st"), q.Get("se"), "/blob/" + fakeAccountName + "/" + fakeContainer + "/shared.bin",
+		"", "", "https", "2019-12-12", "b", "",
+		"", "", "", "", "",
+	}, "\n")
+	key, err := base64.StdEncoding.DecodeString(fakeAccountKey)
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	require.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), q.Get("sig"))
+
+	_, err = azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour, azure.SasOptions{Version: "2017-11-09"})
+	require.ErrorContains(t, err, "unsupported SAS version")
+}
+
+func TestGenerateBlobSasURIPermissions(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "shared.bin", []byte("x"))
+
+	sasURL, err := azure.GenerateBlobSasURI(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "r", sasQuery(t, sasURL).Get("sp"), "read only by default")
+
+	sasURL, err = azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour, azure.SasOptions{Permissions: "wdr"})
+	require.NoError(t, err)
+	q := sasQuery(t, sasURL)
+	require.Equal(t, "rwd", q.Get("sp"), "in the order of the service")
+	require.Equal(t, sdkSasSignature(t, q, "shared.bin"), q.Get("sig"))
+
+	_, err = azure.GenerateBlobSasURIWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, time.Hour, azure.SasOptions{Permissions: "rz"})
+	require.ErrorContains(t, err, `unsupported SAS permission 'z'`)
+	_, err = azure.GenerateBlobSasURI(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "shared.bin", nil, 0)
+	require.ErrorContains(t, err, "invalid SAS duration")
+}
+
+// TestGenerateBlobSasURIUsableImmediately checks that a backdated SAS is accepted
+// right away by the real service.
+func TestGenerateBlobSasURIUsableImmediately(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	httpClient := newHTTPClient()
+
+	blobName := randomBlobName("test-sas-skew")
+	localFile := t.TempDir() + "/file.txt"
+	require.NoError(t, os.WriteFile(localFile, []byte("backdated"), 0644))
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient)
+	})
+
+	sasURL, err := azure.GenerateBlobSasURI(accountURL, accountName, accountKey, container, blobName, httpClient, time.Minute)
+	require.NoError(t, err)
+	resp, err := http.Get(sasURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "backdated", string(body))
+}
@@ -0,0 +1,81 @@
+package azure_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestListAzureBlobSnapshotsOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "images/a.qcow2", []byte("first"))
+	store.put(fakeContainer, "images/b.qcow2", []byte("other"))
+
+	snapshot, err := azure.SnapshotAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/a.qcow2", nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, snapshot)
+	store.put(fakeContainer, "images/a.qcow2", []byte("second"))
+
+	blobs, err := azure.ListAzureBlobSnapshots(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/a", nil)
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+	require.Equal(t, "images/a.qcow2", blobs[0].Name)
+	require.Equal(t, snapshot, blobs[0].Snapshot)
+	require.EqualValues(t, len("first"), blobs[0].Size, "the content at the snapshot")
+	require.Equal(t, "images/a.qcow2", blobs[1].Name)
+	require.Empty(t, blobs[1].Snapshot, "the base blob")
+	require.EqualValues(t, len("second"), blobs[1].Size)
+
+	// the plain listing leaves the snapshots out
+	blobs, err = azure.ListAzureBlobInfo(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/a", nil)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.Empty(t, blobs[0].Snapshot)
+}
+
+func TestSnapshotAzureBlobNotFound(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "other", nil)
+
+	_, err := azure.SnapshotAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "missing", nil)
+	require.ErrorContains(t, err, "BlobNotFound")
+}
+
+func TestListAzureBlobSnapshots(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+
+	httpClient := newHTTPClient()
+
+	blobName := randomBlobName("test-snapshot")
+	localFile := t.TempDir() + "/tmp.txt"
+	require.NoError(t, os.WriteFile(localFile, []byte("snapshot me"), 0644))
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+	defer azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient)
+
+	snapshot, err := azure.SnapshotAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient)
+	require.NoError(t, err)
+
+	blobs, err := azure.ListAzureBlobSnapshots(accountURL, accountName, accountKey, container, blobName, httpClient)
+	require.NoError(t, err)
+	found := false
+	for _, b := range blobs {
+		if b.Name == blobName && b.Snapshot == snapshot {
+			found = true
+			break
+		}
+	}
+	require.True(t, found, "snapshot %s should appear in the listing", snapshot)
+}
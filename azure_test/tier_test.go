@@ -0,0 +1,84 @@
+package azure_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestSetAzureBlobTierRehydrates(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "cold.img", []byte("archived image"))
+	getTier := func() azure.BlobTier {
+		tier, err := azure.GetAzureBlobTier(fakeAccountURL, fakeAccountName, fakeAccountKey,
+			fakeContainer, "cold.img", nil)
+		require.NoError(t, err)
+		return tier
+	}
+	require.Equal(t, azure.BlobTier{Tier: "Hot"}, getTier())
+
+	require.NoError(t, azure.SetAzureBlobTier(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "cold.img", nil, "Archive", ""))
+	require.Equal(t, azure.BlobTier{Tier: "Archive"}, getTier())
+
+	require.NoError(t, azure.SetAzureBlobTier(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "cold.img", nil, "Hot", "High"))
+	tier := getTier()
+	require.True(t, tier.Rehydrating())
+	require.Equal(t, azure.BlobTier{Tier: "Archive", ArchiveStatus: "rehydrate-pending-to-hot",
+		RehydratePriority: "High"}, tier)
+
+	b.tier, b.archiveStatus, b.rehydratePriority = "Hot", "", ""
+	require.Equal(t, azure.BlobTier{Tier: "Hot"}, getTier())
+}
+
+func TestSetAzureBlobTierRejectsUnknownTier(t *testing.T) {
+	store := withFakeBlobStore(t)
+	store.put(fakeContainer, "blob.bin", []byte("x"))
+
+	err := azure.SetAzureBlobTier(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blob.bin", nil, "Frozen", "")
+	require.ErrorContains(t, err, `unsupported access tier "Frozen"`)
+	err = azure.SetAzureBlobTier(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "blob.bin", nil, "Hot", "Urgent")
+	require.ErrorContains(t, err, `unsupported rehydrate priority "Urgent"`)
+	require.Empty(t, store.requests, "rejected before any request")
+}
+
+// TestRehydrateStarts archives a fresh blob and starts its rehydration. It needs an
+// account that supports the Archive tier, which not every one does (e.g. premium or
+// ZRS accounts), and does not wait the hours a rehydration takes.
+func TestRehydrateStarts(t *testing.T) {
+	accountURL := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := getEnvOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := getEnvOrSkip(t, "TEST_AZURE_CONTAINER")
+	getEnvOrSkip(t, "TEST_AZURE_ARCHIVE_SUPPORTED")
+
+	httpClient := newHTTPClient()
+	blobName := randomBlobName("test-rehydrate")
+	localFile := t.TempDir() + "/tmp.txt"
+	require.NoError(t, os.WriteFile(localFile, []byte("archive me"), 0644))
+	_, _, err := azure.UploadAzureBlob(accountURL, accountName, accountKey, container, blobName, localFile, httpClient)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, blobName, httpClient)
+	})
+
+	require.NoError(t, azure.SetAzureBlobTier(accountURL, accountName, accountKey, container, blobName,
+		httpClient, "Archive", ""))
+	tier, err := azure.GetAzureBlobTier(accountURL, accountName, accountKey, container, blobName, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, "Archive", tier.Tier)
+
+	require.NoError(t, azure.SetAzureBlobTier(accountURL, accountName, accountKey, container, blobName,
+		httpClient, "Hot", "Standard"))
+	tier, err = azure.GetAzureBlobTier(accountURL, accountName, accountKey, container, blobName, httpClient)
+	require.NoError(t, err)
+	require.Equal(t, "Archive", tier.Tier)
+	require.Equal(t, "rehydrate-pending-to-hot", tier.ArchiveStatus)
+}
@@ -0,0 +1,137 @@
+package azure_test
+
+import (
+	"crypto/md5"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestUploadAzureBlobVerifyMD5(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	data := []byte("verified upload payload")
+	localFile := writeTempFile(t, "src.bin", data)
+
+	url, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "verified.bin", localFile, nil, azure.UploadOptions{VerifyMD5: true})
+	require.NoError(t, err)
+	require.Contains(t, url, "verified.bin")
+
+	// the local MD5 is recorded on the blob even though it was committed as a block list
+	sum := md5.Sum(data)
+	require.Equal(t, sum[:], store.get(fakeContainer, "verified.bin").contentMD5)
+}
+
+func TestUploadAzureBlobVerifyMD5Mismatch(t *testing.T) {
+	for name, tamper := range map[string]func(b *fakeBlob){
+		"properties": func(b *fakeBlob) {
+			b.contentMD5 = []byte("0123456789abcdef")
+		},
+		"dropped block": func(b *fakeBlob) {
+			b.data = b.data[:len(b.data)/2]
+		},
+		"corrupted content": func(b *fakeBlob) {
+			b.data[0] ^= 0xff
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withRetryPolicy(t, azure.RetryPolicy{})
+			store := withFakeBlobStore(t)
+			tampered := false
+			store.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				// corrupt the blob once it is committed, before it is read back
+				if r.Method == http.MethodHead && !tampered {
+					if b := store.get(fakeContainer, "verified.bin"); b != nil {
+						tamper(b)
+						tampered = true
+					}
+				}
+				return false
+			}
+			localFile := writeTempFile(t, "src.bin", []byte("verified upload payload"))
+
+			_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+				fakeContainer, "verified.bin", localFile, nil, azure.UploadOptions{VerifyMD5: true})
+			require.ErrorIs(t, err, azure.ErrMD5Mismatch)
+		})
+	}
+}
+
+func TestUploadAzureBlobWithoutVerify(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	localFile := writeTempFile(t, "src.bin", []byte("plain upload"))
+
+	_, _, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", localFile, nil)
+	require.NoError(t, err)
+	require.NotNil(t, store.get(fakeContainer, "plain.bin"))
+	for _, r := range store.requests {
+		require.NotEqual(t, http.MethodHead, r.Method, "no verification round trip expected")
+	}
+}
+
+func TestUploadAzureBlobReturnsETag(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	localFile := writeTempFile(t, "src.bin", []byte("tagged upload"))
+
+	_, info, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "tagged.bin", localFile, nil)
+	require.NoError(t, err)
+	b := store.get(fakeContainer, "tagged.bin")
+	require.NotEmpty(t, info.ETag)
+	require.Equal(t, b.etag, info.ETag)
+	require.True(t, b.modified.Equal(info.LastModified))
+}
+
+func TestUploadAzureBlobIfMatch(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeBlobStore(t)
+	localFile := writeTempFile(t, "src.bin", []byte("version 1"))
+
+	_, v1, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "cas.bin", localFile, nil)
+	require.NoError(t, err)
+
+	// update-if-unchanged succeeds against the ETag we hold
+	_, v2, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "cas.bin", localFile, nil, azure.UploadOptions{IfMatch: v1.ETag})
+	require.NoError(t, err)
+	require.NotEqual(t, v1.ETag, v2.ETag)
+
+	// and fails once someone else wrote the blob
+	_, _, err = azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "cas.bin", localFile, nil, azure.UploadOptions{IfMatch: v1.ETag})
+	require.ErrorIs(t, err, azure.ErrPreconditionFailed)
+	require.Equal(t, http.StatusPreconditionFailed, azure.StatusFromError(err))
+}
+
+func TestUploadAzureBlobIfNoneMatch(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	createOnly := azure.UploadOptions{IfNoneMatch: "*"}
+
+	first := writeTempFile(t, "first.bin", []byte("first writer"))
+	_, _, err := azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "once.bin", first, nil, createOnly)
+	require.NoError(t, err)
+
+	second := writeTempFile(t, "second.bin", []byte("second writer"))
+	_, _, err = azure.UploadAzureBlobWithOptions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "once.bin", second, nil, createOnly)
+	require.ErrorIs(t, err, azure.ErrPreconditionFailed)
+	require.Equal(t, "first writer", string(store.get(fakeContainer, "once.bin").data))
+}
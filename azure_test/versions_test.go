@@ -0,0 +1,98 @@
+package azure_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestUploadAzureBlobReturnsVersionID(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	store.versioning = true
+	localFile := writeTempFile(t, "src.bin", []byte("versioned"))
+
+	_, v1, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "versioned.bin", localFile, nil)
+	require.NoError(t, err)
+	_, v2, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "versioned.bin", localFile, nil)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, v1.VersionID)
+	require.NotEqual(t, v1.VersionID, v2.VersionID)
+	require.Equal(t, store.get(fakeContainer, "versioned.bin").versionID, v2.VersionID)
+}
+
+func TestUploadAzureBlobNoVersioning(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeBlobStore(t)
+	localFile := writeTempFile(t, "src.bin", []byte("plain"))
+
+	_, info, err := azure.UploadAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "plain.bin", localFile, nil)
+	require.NoError(t, err)
+	require.Empty(t, info.VersionID)
+}
+
+func TestListAzureBlobVersionsOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "versions", r.URL.Query().Get("include"))
+		require.Equal(t, "images/", r.URL.Query().Get("prefix"))
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="%s/" ContainerName="%s"><Blobs>`+
+			`<Blob><Name>images/a.qcow2</Name><VersionId>2025-07-01T10:00:01.0000000Z</VersionId><Properties/></Blob>`+
+			`<Blob><Name>images/a.qcow2</Name><VersionId>2025-07-01T10:00:02.0000000Z</VersionId><IsCurrentVersion>true</IsCurrentVersion><Properties/></Blob>`+
+			`</Blobs><NextMarker/></EnumerationResults>`, fakeAccountURL, fakeContainer)
+	})
+
+	versions, err := azure.ListAzureBlobVersions(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/", nil)
+	require.NoError(t, err)
+	require.Equal(t, []azure.BlobVersion{
+		{Name: "images/a.qcow2", VersionID: "2025-07-01T10:00:01.0000000Z"},
+		{Name: "images/a.qcow2", VersionID: "2025-07-01T10:00:02.0000000Z", IsCurrent: true},
+	}, versions)
+}
+
+func TestGetAzureBlobMetaDataVersionOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	const versionID = "2025-07-01T10:00:01.0000000Z"
+	withFakeDoer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodHead, r.Method)
+		require.Equal(t, versionID, r.URL.Query().Get("versionid"))
+		w.Header().Set("Content-Length", "3")
+		w.Header().Set("Content-MD5", "rL0Y20zC+Fzt72VPzMSk2A==")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	length, md5Hex, err := azure.GetAzureBlobMetaDataVersion(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "versioned.bin", versionID, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), length)
+	require.Equal(t, "acbd18db4cc2f85cedef654fccc4a4d8", md5Hex)
+}
+
+func TestStatAzureBlobOffline(t *testing.T) {
+	withRetryPolicy(t, azure.RetryPolicy{})
+	store := withFakeBlobStore(t)
+	b := store.put(fakeContainer, "images/a.qcow2", []byte("foo"))
+
+	stat, err := azure.StatAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/a.qcow2", nil)
+	require.NoError(t, err)
+	require.Equal(t, azure.BlobStat{Size: 3, MD5: "acbd18db4cc2f85cedef654fccc4a4d8", ETag: b.etag}, stat)
+
+	// writing the blob again gives it a new identity
+	b = store.put(fakeContainer, "images/a.qcow2", []byte("foo"))
+	again, err := azure.StatAzureBlob(fakeAccountURL, fakeAccountName, fakeAccountKey,
+		fakeContainer, "images/a.qcow2", nil)
+	require.NoError(t, err)
+	require.Equal(t, b.etag, again.ETag)
+	require.NotEqual(t, stat.ETag, again.ETag)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// azureDownloader fetches Azure blobs with the DownloadAzureBlob of azureutil, which
+// reads every range with If-Match on the ETag the download started with. zedUpload's
+// own copy of it does not, and writes the ranges of two versions of a blob overwritten
+// while it downloads into one file.
+type azureDownloader struct {
+	accountURL  string
+	accountName string
+	accountKey  string
+	container   string
+	client      *http.Client
+}
+
+func (d azureDownloader) start(remoteFile, localFile string, objSize int64,
+	doneParts types.DownloadedParts) (<-chan transferEvent, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stats := make(types.StatsNotifChan)
+	finished := make(chan httpEvent, 1)
+	go func() {
+		// like zedUpload, every part is fetched again: the parts returned are all of
+		// this attempt
+		parts, err := azure.DownloadAzureBlobContext(ctx, d.accountURL, d.accountName, d.accountKey,
+			d.container, remoteFile, localFile, objSize, d.client,
+			types.DownloadedParts{PartSize: doneParts.PartSize}, stats)
+		e := httpEvent{parts: parts, err: err}
+		if err == nil {
+			e.localName = localFile
+			for _, p := range parts.Parts {
+				e.asize += p.Size
+			}
+		}
+		finished <- e
+	}()
+
+	events := make(chan transferEvent)
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case s := <-stats:
+				parts := s.DoneParts
+				parts.Parts = slices.Clone(parts.Parts) // still appended to by the download
+				select {
+				case events <- httpEvent{parts: parts, update: true, current: s.Asize, total: s.Size,
+					localName: localFile}:
+				case <-ctx.Done():
+					return
+				}
+			case e := <-finished:
+				select {
+				case events <- e:
+				case <-ctx.Done():
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, cancel, nil
+}
+
+// trace is a no-op, net tracing is only available with the zedUpload transports.
+func (d azureDownloader) trace() error { return nil }
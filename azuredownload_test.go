@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureDownloaderPinsRanges(t *testing.T) {
+	withoutRetries(t)
+	content := []byte("one version of the image")
+	store := &auditStore{blobs: map[string]auditBlob{
+		"image.bin": {data: content, headers: http.Header{"Etag": {`"0x8DC1"`}}},
+	}}
+	var mu sync.Mutex
+	var ifMatch []string // of the range requests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+			mu.Unlock()
+		}
+		store.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	localFile := filepath.Join(t.TempDir(), "image.bin")
+	result, err := runDownload(Config{
+		Downloader: azureDownloader{accountURL: srv.URL, accountName: fakeAccountName,
+			accountKey: fakeAccountKey, container: fakeContainer, client: &http.Client{}},
+		RemoteFile: "image.bin",
+		LocalFile:  localFile,
+		ObjSize:    int64(len(content)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), result.Bytes)
+	got, err := os.ReadFile(localFile)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	require.Equal(t, []string{`"0x8DC1"`}, ifMatch, "the range is of the version the download started with")
+}
+
+func TestAzureDownloaderDropsLongerLeftover(t *testing.T) {
+	withoutRetries(t)
+	content := []byte("the new, shorter image")
+	store := &auditStore{blobs: map[string]auditBlob{"image.bin": {data: content}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	localFile := filepath.Join(t.TempDir(), "image.bin")
+	require.NoError(t, os.WriteFile(localFile, []byte("an older image that was a good deal longer than this one"), 0o644))
+	_, err := runDownload(Config{
+		Downloader: azureDownloader{accountURL: srv.URL, accountName: fakeAccountName,
+			accountKey: fakeAccountKey, container: fakeContainer, client: &http.Client{}},
+		RemoteFile: "image.bin",
+		LocalFile:  localFile,
+		ObjSize:    int64(len(content)),
+	})
+	require.NoError(t, err)
+	got, err := os.ReadFile(localFile)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+}
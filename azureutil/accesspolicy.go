@@ -0,0 +1,99 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// AccessPolicy is a stored access policy of a container, which a SAS refers to by
+// ID with SasOptions.Identifier. Whatever it leaves unset, zero times or empty
+// permissions, the SAS has to set.
+type AccessPolicy struct {
+	ID          string
+	Start       time.Time
+	Expiry      time.Time
+	Permissions string // e.g. "r"
+}
+
+// GetContainerAccessPolicies returns the stored access policies of a container.
+func GetContainerAccessPolicies(
+	accountURL, accountName, accountKey, containerName string,
+	httpClient *http.Client,
+) ([]AccessPolicy, error) {
+	containerClient, err := getContainerClient(accountURL, accountName, accountKey, containerName, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container client: %v", err)
+	}
+	resp, err := containerClient.GetAccessPolicy(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get access policies of %s: %w", containerName, compactResponseError(err))
+	}
+	var policies []AccessPolicy
+	for _, si := range resp.SignedIdentifiers {
+		p := AccessPolicy{ID: deref(si.ID)}
+		if ap := si.AccessPolicy; ap != nil {
+			if ap.Start != nil {
+				p.Start = *ap.Start
+			}
+			if ap.Expiry != nil {
+				p.Expiry = *ap.Expiry
+			}
+			p.Permissions = deref(ap.Permission)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// SetContainerAccessPolicies replaces the stored access policies of a container with
+// policies, at most 5. A SAS that refers to a policy left out stops working, so that
+// this revokes it; it may take the service up to 30 seconds. The public access level
+// of the container is kept.
+func SetContainerAccessPolicies(
+	accountURL, accountName, accountKey, containerName string,
+	httpClient *http.Client,
+	policies []AccessPolicy,
+) error {
+	containerClient, err := getContainerClient(accountURL, accountName, accountKey, containerName, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get container client: %v", err)
+	}
+	ctx := context.Background()
+	// a Set Container ACL without it would make the container private
+	current, err := containerClient.GetAccessPolicy(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not get access policies of %s: %w", containerName, compactResponseError(err))
+	}
+	acl := make([]*container.SignedIdentifier, 0, len(policies))
+	for _, p := range policies {
+		if p.ID == "" {
+			return fmt.Errorf("access policy without an ID")
+		}
+		ap := &container.AccessPolicy{}
+		if !p.Start.IsZero() {
+			ap.Start = &p.Start
+		}
+		if !p.Expiry.IsZero() {
+			ap.Expiry = &p.Expiry
+		}
+		if p.Permissions != "" {
+			ap.Permission = &p.Permissions
+		}
+		acl = append(acl, &container.SignedIdentifier{ID: &p.ID, AccessPolicy: ap})
+	}
+	_, err = containerClient.SetAccessPolicy(ctx, &container.SetAccessPolicyOptions{
+		Access:       current.BlobPublicAccess,
+		ContainerACL: acl,
+	})
+	if err != nil {
+		return fmt.Errorf("could not set access policies of %s: %w", containerName, compactResponseError(err))
+	}
+	return nil
+}
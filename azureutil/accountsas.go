@@ -0,0 +1,37 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	accountSASMu sync.RWMutex
+	accountSASes = map[string]string{}
+)
+
+// SetAccountSAS registers sas, a SAS query without its "?", as the credential of the
+// requests to accountURL that have no account key, e.g. of a SAS connection string.
+// The SAS is kept apart from accountURL, so that the URLs built from it, with their
+// own query, and the keys of the metadata cache do not carry it. Registering again
+// replaces the SAS, e.g. once it expired; an empty sas removes the registration.
+func SetAccountSAS(accountURL, sas string) {
+	accountSASMu.Lock()
+	defer accountSASMu.Unlock()
+	accountURL = strings.TrimSuffix(accountURL, "/")
+	if sas == "" {
+		delete(accountSASes, accountURL)
+		return
+	}
+	accountSASes[accountURL] = sas
+}
+
+// accountSAS returns the SAS registered for accountURL, "" for none.
+func accountSAS(accountURL string) string {
+	accountSASMu.RLock()
+	defer accountSASMu.RUnlock()
+	return accountSASes[strings.TrimSuffix(accountURL, "/")]
+}
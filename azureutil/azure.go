@@ -0,0 +1,1647 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/pageblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+)
+
+const (
+	// SingleMB contains chunk size
+	SingleMB    int64 = 4 * 1024 * 1024
+	parallelism       = 16
+)
+
+// buffer pool for streaming IO (32KB buffers)
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (r readSeekCloser) Close() error {
+	return nil
+}
+
+// sectionWriter wraps an io.WriterAt, e.g. an *os.File, to implement io.Writer by using WriteAt and advancing an offset
+type sectionWriter struct {
+	f   io.WriterAt
+	off int64
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	if err != nil {
+		return n, err
+	}
+	w.off += int64(n)
+	return n, nil
+}
+
+// pool of writerAtOffset to avoid per-chunk allocations
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return &sectionWriter{}
+	},
+}
+
+// pool of sectionWriter to avoid per-chunk allocations
+var sectionWriterPool = sync.Pool{
+	New: func() interface{} {
+		return &sectionWriter{}
+	},
+}
+
+// newSectionWriter retrieves a pooled sectionWriter set to write at f starting at off
+func newSectionWriter(f io.WriterAt, off int64) *sectionWriter {
+	w := sectionWriterPool.Get().(*sectionWriter)
+	w.f = f
+	w.off = off
+	return w
+}
+
+// Doer sends a single HTTP request. *http.Client satisfies it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+var (
+	doerMu       sync.RWMutex
+	doerOverride Doer
+)
+
+// SetDoer routes every request through d instead of the *http.Client passed to each call,
+// e.g. to serve requests from a fake in unit tests. Pass nil to restore the default.
+func SetDoer(d Doer) {
+	doerMu.Lock()
+	defer doerMu.Unlock()
+	doerOverride = d
+}
+
+var (
+	extraHeadersMu sync.RWMutex
+	extraHeaders   http.Header
+)
+
+// protectedHeaders are never taken from SetExtraHeaders: the service needs them
+// as the SDK sets them, and the shared key signature covers them.
+var protectedHeaders = map[string]bool{
+	"Authorization":          true,
+	"Content-Length":         true,
+	"Date":                   true,
+	"Host":                   true,
+	"X-Ms-Client-Request-Id": true,
+	"X-Ms-Date":              true,
+	"X-Ms-Version":           true,
+}
+
+// SetExtraHeaders adds h to every request of this package, e.g. to tag traffic for
+// a debugging proxy. Headers a request already carries and the signed and required
+// ones (Authorization, x-ms-date, x-ms-version, ...) cannot be overridden and are
+// left as they are. Pass nil to stop adding headers.
+func SetExtraHeaders(h http.Header) {
+	extraHeadersMu.Lock()
+	defer extraHeadersMu.Unlock()
+	extraHeaders = h.Clone()
+}
+
+// extraHeadersPolicy adds the SetExtraHeaders headers once per call, ahead of
+// retries and of the signing policy, so that x-ms-* headers are signed too.
+type extraHeadersPolicy struct {
+	headers http.Header
+}
+
+func (p extraHeadersPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	for k, v := range p.headers {
+		if protectedHeaders[http.CanonicalHeaderKey(k)] || hasHeader(raw.Header, k) {
+			continue
+		}
+		raw.Header[http.CanonicalHeaderKey(k)] = slices.Clone(v)
+	}
+	return req.Next()
+}
+
+// hasHeader looks key up ignoring case: the generated clients set some headers
+// under verbatim lowercase keys, which http.Header.Get does not find.
+func hasHeader(h http.Header, key string) bool {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adapter that turns a Doer (usually *http.Client) into a policy.Transporter:
+type httpClientTransporter struct {
+	client Doer
+}
+
+func (t *httpClientTransporter) Do(req *http.Request) (*http.Response, error) {
+	return limitedDo(t.client, req)
+}
+
+// clientOptionsFromHTTP wraps your *http.Client into azcore.ClientOptions.
+// A Doer installed with SetDoer takes precedence over httpClient.
+func clientOptionsFromHTTP(httpClient *http.Client) azcore.ClientOptions {
+	var doer Doer = httpClient
+	if httpClient == nil {
+		doer = http.DefaultClient
+	}
+	doerMu.RLock()
+	if doerOverride != nil {
+		doer = doerOverride
+	}
+	doerMu.RUnlock()
+	options := azcore.ClientOptions{
+		Transport: &httpClientTransporter{client: doer},
+		Retry:     GetRetryPolicy().retryOptions(),
+	}
+	extraHeadersMu.RLock()
+	if len(extraHeaders) > 0 {
+		options.PerCallPolicies = []policy.Policy{extraHeadersPolicy{headers: extraHeaders}}
+	}
+	extraHeadersMu.RUnlock()
+	if l := getLogger(); l != nil {
+		options.PerCallPolicies = append(options.PerCallPolicies, countTriesPolicy{})
+		options.PerRetryPolicies = []policy.Policy{logPolicy{logger: l, retry: GetRetryPolicy()}}
+	}
+	return options
+}
+
+// getServiceClient creates a Blob service (account level) client with your custom httpClient.
+func getServiceClient(
+	accountURL, accountName, accountKey string,
+	httpClient *http.Client,
+) (*service.Client, error) {
+	options := &service.ClientOptions{
+		ClientOptions: clientOptionsFromHTTP(httpClient),
+	}
+	// no key but a SAS of the account (see SetAccountSAS): the token is the credential
+	if sas := accountSAS(accountURL); accountKey == "" && sas != "" {
+		svcClient, err := service.NewClientWithNoCredential(strings.TrimSuffix(accountURL, "/")+"/?"+sas, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create service client: %w", err)
+		}
+		return svcClient, nil
+	}
+	cred, err := azblob.NewSharedKeyCredential(accountName, AccountKeyInUse(accountKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential: %w", err)
+	}
+	if secondary, ok := secondaryKeyFor(accountKey); ok {
+		options.PerCallPolicies = append(options.PerCallPolicies,
+			keyFailoverPolicy{cred: cred, primaryKey: accountKey, secondary: secondary})
+	}
+	svcURL := strings.TrimSuffix(accountURL, "/")
+	svcClient, err := service.NewClientWithSharedKeyCredential(
+		svcURL,
+		cred,
+		options,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service client: %w", err)
+	}
+	return svcClient, nil
+}
+
+// getContainerClient creates and returns an Azure Blob Storage container client.
+// getContainerClient creates a Container client with your custom httpClient.
+func getContainerClient(
+	accountURL, accountName, accountKey, containerName string,
+	httpClient *http.Client,
+) (*container.Client, error) {
+	svcClient, err := getServiceClient(accountURL, accountName, accountKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return svcClient.NewContainerClient(containerName), nil
+}
+
+// getContainerAndBlockBlobClients now also takes httpClient
+func getContainerAndBlockBlobClients(
+	accountURL, accountName, accountKey, containerName, blobName string,
+	httpClient *http.Client,
+) (*container.Client, *blockblob.Client, error) {
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	blobClient := containerClient.NewBlockBlobClient(blobName)
+	return containerClient, blobClient, nil
+}
+
+// ListAzureBlob lists all blobs in a container. Uses Azure's paginated listing with marker.
+// Returns a slice of blob names ([]string).
+func ListAzureBlob(
+	accountURL, accountName, accountKey, containerName string,
+	httpClient *http.Client,
+) ([]string, error) {
+	var imgList []string
+
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	pager := containerClient.NewListBlobsFlatPager(nil)
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) {
+				// non-2xx: report Azure's error code instead of the error document
+				return nil, fmt.Errorf("failed to list blobs: %w", compactResponseError(err))
+			}
+			return nil, fmt.Errorf("failed to list blobs, malformed response: %w", err)
+		}
+		for _, blob := range page.Segment.BlobItems {
+			imgList = append(imgList, *blob.Name)
+		}
+	}
+
+	return imgList, nil
+}
+
+// BlobInfo is a blob as reported by a detailed listing.
+type BlobInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	// ContentMD5 is the stored Content-MD5 as hex, empty when the blob has none
+	// (e.g. blobs committed with Put Block List without one).
+	ContentMD5 string `json:"md5,omitempty"`
+	// Snapshot is the timestamp that identifies a snapshot of the blob, empty for
+	// the base blob. Only set by ListAzureBlobSnapshots.
+	Snapshot string `json:"snapshot,omitempty"`
+	// IsPrefix marks a virtual directory, the common prefix of the blobs named
+	// Name..., with no size or time. Only set by ListAzureBlobHierarchy.
+	IsPrefix bool `json:"isPrefix,omitempty"`
+}
+
+// ListAzureBlobInfo lists the blobs whose name starts with prefix, with their size,
+// modification time and stored Content-MD5.
+func ListAzureBlobInfo(
+	accountURL, accountName, accountKey, containerName, prefix string,
+	httpClient *http.Client,
+) ([]BlobInfo, error) {
+	return listAzureBlobInfo(accountURL, accountName, accountKey, containerName, prefix,
+		httpClient, container.ListBlobsInclude{})
+}
+
+func listAzureBlobInfo(
+	accountURL, accountName, accountKey, containerName, prefix string,
+	httpClient *http.Client,
+	include container.ListBlobsInclude,
+) ([]BlobInfo, error) {
+	var infos []BlobInfo
+
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &container.ListBlobsFlatOptions{Include: include}
+	if prefix != "" {
+		opts.Prefix = &prefix
+	}
+	ctx := context.Background()
+	pager := containerClient.NewListBlobsFlatPager(opts)
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) {
+				return nil, fmt.Errorf("failed to list blobs: %w", compactResponseError(err))
+			}
+			return nil, fmt.Errorf("failed to list blobs, malformed response: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			infos = append(infos, blobInfo(item))
+		}
+	}
+
+	return infos, nil
+}
+
+func blobInfo(item *container.BlobItem) BlobInfo {
+	info := BlobInfo{Name: *item.Name, Snapshot: deref(item.Snapshot)}
+	if p := item.Properties; p != nil {
+		if p.ContentLength != nil {
+			info.Size = *p.ContentLength
+		}
+		if p.LastModified != nil {
+			info.LastModified = *p.LastModified
+		}
+		if p.ContentMD5 != nil {
+			info.ContentMD5 = hex.EncodeToString(p.ContentMD5)
+		}
+	}
+	return info
+}
+
+// ListAzureBlobHierarchy lists the level under prefix of the virtual directories
+// that "/" makes of blob names, like a directory listing: the blobs directly under
+// it and, with IsPrefix, the "dir/" prefixes of the rest. When recursive, it
+// drills into those prefixes instead, returning every blob under prefix. Names are
+// in order.
+func ListAzureBlobHierarchy(
+	accountURL, accountName, accountKey, containerName, prefix string,
+	recursive bool,
+	httpClient *http.Client,
+) ([]BlobInfo, error) {
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	var infos []BlobInfo
+	for pending := []string{prefix}; len(pending) > 0; {
+		level := pending[0]
+		pending = pending[1:]
+		opts := &container.ListBlobsHierarchyOptions{}
+		if level != "" {
+			opts.Prefix = &level
+		}
+		pager := containerClient.NewListBlobsHierarchyPager("/", opts)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				var respErr *azcore.ResponseError
+				if errors.As(err, &respErr) {
+					return nil, fmt.Errorf("failed to list blobs under %q: %w", level, compactResponseError(err))
+				}
+				return nil, fmt.Errorf("failed to list blobs under %q, malformed response: %w", level, err)
+			}
+			for _, item := range page.Segment.BlobItems {
+				infos = append(infos, blobInfo(item))
+			}
+			for _, p := range page.Segment.BlobPrefixes {
+				if recursive {
+					pending = append(pending, *p.Name)
+				} else {
+					infos = append(infos, BlobInfo{Name: *p.Name, IsPrefix: true})
+				}
+			}
+		}
+	}
+	slices.SortFunc(infos, func(a, b BlobInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos, nil
+}
+
+// BlobVersion is one version of a blob on an account with blob versioning enabled.
+type BlobVersion struct {
+	Name      string
+	VersionID string
+	IsCurrent bool
+}
+
+// ListAzureBlobVersions lists every version of the blobs whose name starts with prefix
+// (List Blobs with include=versions), oldest version of each blob first.
+func ListAzureBlobVersions(
+	accountURL, accountName, accountKey, containerName, prefix string,
+	httpClient *http.Client,
+) ([]BlobVersion, error) {
+	var versions []BlobVersion
+
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &container.ListBlobsFlatOptions{Include: container.ListBlobsInclude{Versions: true}}
+	if prefix != "" {
+		opts.Prefix = &prefix
+	}
+	ctx := context.Background()
+	pager := containerClient.NewListBlobsFlatPager(opts)
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) {
+				return nil, fmt.Errorf("failed to list blob versions: %w", compactResponseError(err))
+			}
+			return nil, fmt.Errorf("failed to list blob versions, malformed response: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			v := BlobVersion{Name: *item.Name}
+			if item.VersionID != nil {
+				v.VersionID = *item.VersionID
+			}
+			if item.IsCurrentVersion != nil {
+				v.IsCurrent = *item.IsCurrentVersion
+			}
+			versions = append(versions, v)
+		}
+	}
+
+	return versions, nil
+}
+
+// ListAzureContainers lists all containers in the storage account, following the
+// List Containers continuation markers. Returns a slice of container names.
+func ListAzureContainers(
+	accountURL, accountName, accountKey string,
+	httpClient *http.Client,
+) ([]string, error) {
+	var containers []string
+
+	svcClient, err := getServiceClient(accountURL, accountName, accountKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	pager := svcClient.NewListContainersPager(nil)
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) {
+				return nil, fmt.Errorf("failed to list containers: %w", compactResponseError(err))
+			}
+			return nil, fmt.Errorf("failed to list containers, malformed response: %w", err)
+		}
+		for _, c := range page.ContainerItems {
+			containers = append(containers, *c.Name)
+		}
+	}
+
+	return containers, nil
+}
+
+// DeleteAzureBlob deletes a blob from Azure Storage. Deletes snapshots too (DeleteSnapshotsOptionInclude).
+func DeleteAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) error {
+	return DeleteAzureBlobWithOptions(accountURL, accountName, accountKey, containerName, remoteFile,
+		httpClient, DeleteOptions{})
+}
+
+// DeleteOptions tunes DeleteAzureBlobWithOptions.
+type DeleteOptions struct {
+	// IfMatch only deletes the blob if its ETag still matches, IfUnmodifiedSince only
+	// if it was not modified after that time (zero for no condition), so that a blob
+	// updated since it was picked for deletion is kept.
+	// A failed precondition is reported as ErrPreconditionFailed.
+	IfMatch           string
+	IfUnmodifiedSince time.Time
+}
+
+// DeleteAzureBlobWithOptions is DeleteAzureBlob with preconditions.
+func DeleteAzureBlobWithOptions(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client, opts DeleteOptions,
+) error {
+	defer forgetBlobs(accountURL, containerName, remoteFile)
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient,
+	)
+	if err != nil {
+		return fmt.Errorf("Error: %v", err)
+	}
+
+	// Set deletion options: include snapshots
+	deleteSnapshots := azblob.DeleteSnapshotsOptionTypeInclude
+	deleteOpts := &azblob.DeleteBlobOptions{DeleteSnapshots: &deleteSnapshots}
+	if opts.IfMatch != "" || !opts.IfUnmodifiedSince.IsZero() {
+		conditions := &blob.ModifiedAccessConditions{}
+		if opts.IfMatch != "" {
+			etag := azcore.ETag(opts.IfMatch)
+			conditions.IfMatch = &etag
+		}
+		if !opts.IfUnmodifiedSince.IsZero() {
+			since := opts.IfUnmodifiedSince
+			conditions.IfUnmodifiedSince = &since
+		}
+		deleteOpts.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: conditions}
+	}
+
+	// Perform the delete
+	ctx := context.Background()
+	_, err = blobClient.Delete(ctx, deleteOpts)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("failed to delete blob: %w: %w", ErrPreconditionFailed, compactResponseError(err))
+		}
+		return fmt.Errorf("failed to delete blob: %w", compactResponseError(err))
+	}
+
+	return nil
+}
+
+// RenameAzureBlob renames srcBlob to dstBlob within the container. Blob storage has no
+// rename, so this server-side copies src to dst with CopyAzureBlobAndWait, waiting at
+// most timeout for the copy to succeed, checks dst has the length and Content-MD5 of
+// src and only then deletes src. A failure at any step leaves src in place; a copy
+// still pending at the timeout returns ErrCopyTimeout and goes on.
+func RenameAzureBlob(
+	accountURL, accountName, accountKey, containerName, srcBlob, dstBlob string,
+	httpClient *http.Client,
+	timeout time.Duration,
+) error {
+	defer forgetBlobs(accountURL, containerName, srcBlob, dstBlob)
+	ctx := context.Background()
+
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get container client: %v", err)
+	}
+	srcClient := containerClient.NewBlobClient(srcBlob)
+	dstClient := containerClient.NewBlobClient(dstBlob)
+
+	srcProps, err := srcClient.GetProperties(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not get properties of %s: %w", srcBlob, compactResponseError(err))
+	}
+
+	if err := CopyAzureBlobAndWait(accountURL, accountName, accountKey, containerName,
+		srcBlob, dstBlob, httpClient, timeout); err != nil {
+		return err
+	}
+	dstProps, err := dstClient.GetProperties(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not get properties of %s: %w", dstBlob, compactResponseError(err))
+	}
+	if err := sameBlobContent(srcProps, dstProps); err != nil {
+		return fmt.Errorf("copy of %s to %s does not match: %w", srcBlob, dstBlob, err)
+	}
+
+	if _, err := srcClient.Delete(ctx, nil); err != nil {
+		return fmt.Errorf("copied %s to %s but failed to delete the source: %w",
+			srcBlob, dstBlob, compactResponseError(err))
+	}
+	return nil
+}
+
+// sameBlobContent compares the length and, when both are known, the Content-MD5 of two blobs.
+func sameBlobContent(src, dst blob.GetPropertiesResponse) error {
+	var srcLen, dstLen int64
+	if src.ContentLength != nil {
+		srcLen = *src.ContentLength
+	}
+	if dst.ContentLength != nil {
+		dstLen = *dst.ContentLength
+	}
+	if srcLen != dstLen {
+		return fmt.Errorf("length %d, expected %d", dstLen, srcLen)
+	}
+	if src.ContentMD5 != nil && dst.ContentMD5 != nil && !bytes.Equal(src.ContentMD5, dst.ContentMD5) {
+		return fmt.Errorf("%w: %x, expected %x", ErrMD5Mismatch, dst.ContentMD5, src.ContentMD5)
+	}
+	return nil
+}
+
+// DownloadAzureBlob is a parallel, resumable, chunked download with progress.
+// Steps:
+//  1. Open/create local file.
+//  2. Reuse existing downloaded parts (doneParts) if resuming.
+//  3. Uses DoBatchTransfer:
+//     a. Splits download into SingleMB (1 MB) chunks.
+//     b. Downloads 16 parts in parallel (parallelism).
+//     c. Uses buffer pool (sync.Pool) to reuse memory.
+//     d. Tracks progress and sends updates via prgNotify.
+//     e. Resumable, efficient for large files. Ensures chunks are written to correct
+//     offsets using sectionWriter.
+//     f. Reads every chunk with If-Match on the ETag of the blob, starting over
+//     (up to maxOverwriteRestarts times) when the blob is overwritten meanwhile.
+func DownloadAzureBlob(
+	accountURL, accountName, accountKey, containerName, blobName, localFile string,
+	objMaxSize int64,
+	httpClient *http.Client,
+	doneParts types.DownloadedParts,
+	prgNotify types.StatsNotifChan,
+) (types.DownloadedParts, error) {
+	return DownloadAzureBlobContext(context.Background(), accountURL, accountName, accountKey,
+		containerName, blobName, localFile, objMaxSize, httpClient, doneParts, prgNotify)
+}
+
+// DownloadAzureBlobContext is DownloadAzureBlob aborting its requests once ctx is done.
+func DownloadAzureBlobContext(
+	ctx context.Context,
+	accountURL, accountName, accountKey, containerName, blobName, localFile string,
+	objMaxSize int64,
+	httpClient *http.Client,
+	doneParts types.DownloadedParts,
+	prgNotify types.StatsNotifChan,
+) (types.DownloadedParts, error) {
+	stats := &types.UpdateStats{DoneParts: doneParts}
+
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, blobName, httpClient,
+	)
+	if err != nil {
+		return stats.DoneParts, fmt.Errorf("Error: %v", err)
+	}
+
+	// Prepare file
+	if err := os.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+		return stats.DoneParts, err
+	}
+
+	f, err := os.OpenFile(localFile, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return stats.DoneParts, fmt.Errorf("cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	for restarts := 0; ; restarts++ {
+		// every pass downloads the whole blob, nothing of an earlier file or pass
+		// may be left behind it
+		if err := f.Truncate(0); err != nil {
+			return stats.DoneParts, fmt.Errorf("cannot truncate file: %v", err)
+		}
+		properties, err := blobClient.GetProperties(ctx, nil)
+		if err != nil {
+			return stats.DoneParts, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+		}
+		objSize := *properties.ContentLength
+
+		if objMaxSize > 0 && objSize > objMaxSize {
+			return stats.DoneParts, fmt.Errorf("blob too large (%d bytes), max allowed is %d", objSize, objMaxSize)
+		}
+		stats.Size = objSize
+
+		progress := int64(0)
+		// every range is of the version the properties are of, If-Match fails the
+		// ones after an overwrite instead of mixing two versions in the file
+		err = downloadChunks(ctx, blobClient, objSize, 0, objSize, parallelism, properties.ETag, f, func(c chunkStat) {
+			stats.DoneParts.Parts = append(stats.DoneParts.Parts, &types.PartDefinition{
+				Ind:  int64(c.ind),
+				Size: c.size,
+			})
+			progress += c.size
+			if prgNotify != nil {
+				stats.Asize = progress
+				select {
+				case prgNotify <- *stats:
+				default:
+				}
+			}
+		})
+		if !errors.Is(err, ErrPreconditionFailed) || restarts == maxOverwriteRestarts {
+			return stats.DoneParts, err
+		}
+		// overwritten while downloading: none of the parts can be kept
+		stats.DoneParts = types.DownloadedParts{PartSize: doneParts.PartSize}
+	}
+}
+
+// maxOverwriteRestarts bounds how many times DownloadAzureBlob starts over for a
+// blob overwritten while it downloads it, which may be overwritten all the time.
+const maxOverwriteRestarts = 3
+
+// chunkStat is how one chunk of downloadChunks went.
+type chunkStat struct {
+	ind     int
+	size    int64
+	latency time.Duration // until the response headers arrived
+	elapsed time.Duration // until the last byte was written
+}
+
+// downloadChunks reads the count bytes at offset of a blob of objSize bytes in
+// SingleMB chunks, parallel of them at a time, writing each at its offset in f.
+// done is called for each chunk written, one call at a time; a failed chunk does
+// not stop the others, the first error is returned once all were tried. A non-nil
+// etag is sent as If-Match with each chunk, a chunk of another version of the blob
+// then fails with ErrPreconditionFailed.
+func downloadChunks(
+	ctx context.Context,
+	blobClient *blockblob.Client,
+	objSize, offset, count int64,
+	parallel int,
+	etag *azcore.ETag,
+	f io.WriterAt,
+	done func(chunkStat),
+) error {
+	totalChunks := int((count + SingleMB - 1) / SingleMB)
+	errCh := make(chan error, totalChunks)
+	mu := &sync.Mutex{}
+	var wg sync.WaitGroup
+
+	// Process chunks in batches of parallel
+	for i := 0; i < totalChunks; i += parallel {
+		endChunk := i + parallel
+		if endChunk > totalChunks {
+			endChunk = totalChunks
+		}
+
+		for chunkIndex := i; chunkIndex < endChunk; chunkIndex++ {
+			start := offset + int64(chunkIndex)*SingleMB
+			end := start + SingleMB - 1
+			if end >= offset+count {
+				end = offset + count - 1
+			}
+			wg.Add(1)
+
+			go func(start, end int64, partNum int) {
+				defer wg.Done()
+				requested := time.Now()
+				opts := &blob.DownloadStreamOptions{
+					Range: azblob.HTTPRange{Offset: start, Count: end - start + 1},
+				}
+				if etag != nil {
+					opts.AccessConditions = &blob.AccessConditions{
+						ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: etag},
+					}
+				}
+				resp, err := blobClient.DownloadStream(ctx, opts)
+				var respErr *azcore.ResponseError
+				if errors.As(err, &respErr) && respErr.StatusCode == http.StatusPreconditionFailed {
+					errCh <- fmt.Errorf("chunk %d failed: %w: %w", partNum, ErrPreconditionFailed, compactResponseError(err))
+					return
+				}
+				if err != nil {
+					errCh <- fmt.Errorf("chunk %d failed: %w", partNum, compactResponseError(err))
+					return
+				}
+				latency := time.Since(requested)
+				defer resp.Body.Close()
+				// a proxy ignoring the range would have the whole blob written at start
+				if err := checkRange(resp.ContentRange, resp.ContentLength, start, end-start+1, objSize); err != nil {
+					errCh <- fmt.Errorf("chunk %d: %w", partNum, err)
+					return
+				}
+
+				// get a sectionWriter and buffer
+				w := newSectionWriter(f, start)
+				bufptr := bufPool.Get().(*[]byte)
+				buf := *bufptr
+				if _, err := io.CopyBuffer(w, resp.Body, buf); err != nil {
+					errCh <- fmt.Errorf("chunk %d copy error: %v", partNum, err)
+					return
+				}
+				// recycle writer
+				writerPool.Put(w)
+				bufPool.Put(bufptr)
+
+				mu.Lock()
+				done(chunkStat{ind: partNum, size: end - start + 1, latency: latency, elapsed: time.Since(requested)})
+				mu.Unlock()
+			}(start, end, chunkIndex)
+		}
+
+		wg.Wait() // Wait for this batch to finish before continuing
+	}
+
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrRangeMismatch is returned when a ranged read is answered with other bytes than
+// the ones asked for, e.g. by a proxy that ignores the range and sends the whole blob.
+var ErrRangeMismatch = errors.New("response does not match the requested range")
+
+// checkRange verifies that a response with contentRange and contentLength carries
+// exactly the count bytes at offset of a blob of size bytes.
+func checkRange(contentRange *string, contentLength *int64, offset, count, size int64) error {
+	if contentLength == nil || *contentLength != count {
+		got := "none"
+		if contentLength != nil {
+			got = strconv.FormatInt(*contentLength, 10)
+		}
+		return fmt.Errorf("%w: Content-Length %s for %d bytes at %d", ErrRangeMismatch, got, count, offset)
+	}
+	if contentRange == nil {
+		if offset == 0 && count == size {
+			return nil // the whole blob was asked for anyway
+		}
+		return fmt.Errorf("%w: no Content-Range for %d bytes at %d", ErrRangeMismatch, count, offset)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", offset, offset+count-1, size); *contentRange != want {
+		return fmt.Errorf("%w: Content-Range %q, requested %q", ErrRangeMismatch, *contentRange, want)
+	}
+	return nil
+}
+
+// ErrNotModified is returned by a conditional download when the blob has not changed (HTTP 304).
+var ErrNotModified = errors.New("not modified")
+
+// DownloadOptions tunes DownloadAzureBlobByChunksWithOptions.
+type DownloadOptions struct {
+	// IfModifiedSince, when set, only downloads the blob if it was modified after
+	// this time, otherwise the download fails with ErrNotModified.
+	IfModifiedSince time.Time
+}
+
+// DownloadAzureBlobByChunks will process the blob download by chunks, i.e., chunks will be
+// responded back on as and when they receive
+func DownloadAzureBlobByChunks(
+	accountURL, accountName, accountKey, containerName, remoteFile, localFile string,
+	httpClient *http.Client,
+) (io.ReadCloser, int64, error) {
+	return DownloadAzureBlobByChunksWithOptions(accountURL, accountName, accountKey, containerName,
+		remoteFile, localFile, httpClient, DownloadOptions{})
+}
+
+// DownloadAzureBlobByChunksWithOptions is DownloadAzureBlobByChunks with the conditions in opts.
+func DownloadAzureBlobByChunksWithOptions(
+	accountURL, accountName, accountKey, containerName, remoteFile, localFile string,
+	httpClient *http.Client,
+	opts DownloadOptions,
+) (io.ReadCloser, int64, error) {
+	// Get clients using helper
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get clients: %v", err)
+	}
+
+	ctx := context.Background()
+
+	var conditions *blob.AccessConditions
+	if !opts.IfModifiedSince.IsZero() {
+		since := opts.IfModifiedSince
+		conditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfModifiedSince: &since},
+		}
+	}
+
+	// Fetch blob properties to get the content length; a conditional request
+	// already answers 304 here, before any content is sent
+	props, err := blobClient.GetProperties(ctx, &blob.GetPropertiesOptions{AccessConditions: conditions})
+	if err != nil {
+		if isNotModified(err) {
+			return nil, 0, ErrNotModified
+		}
+		return nil, 0, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	size := *props.ContentLength
+
+	// Stream download (entire blob)
+	resp, err := blobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not start download: %w", compactResponseError(err))
+	}
+
+	return resp.Body, size, nil
+}
+
+func isNotModified(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotModified
+}
+
+// HashAzureBlob streams the blob into w (io.Discard to only check it) and returns
+// the number of bytes read and their MD5 as hex.
+func HashAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	w io.Writer,
+	httpClient *http.Client,
+) (int64, string, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get clients: %v", err)
+	}
+
+	resp, err := blobClient.DownloadStream(context.Background(), nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not start download: %w", compactResponseError(err))
+	}
+	defer resp.Body.Close()
+	n, sum, err := teeMD5(resp.Body, w)
+	if err != nil {
+		return n, "", fmt.Errorf("could not read blob %s: %w", remoteFile, err)
+	}
+	return n, hex.EncodeToString(sum), nil
+}
+
+// teeMD5 copies r to w, hashing what passes through.
+func teeMD5(r io.Reader, w io.Writer) (int64, []byte, error) {
+	hash := md5.New()
+	n, err := io.Copy(w, io.TeeReader(r, hash))
+	return n, hash.Sum(nil), err
+}
+
+// ErrMD5Mismatch is returned when a verified upload does not read back as the local file.
+var ErrMD5Mismatch = errors.New("MD5 mismatch")
+
+// ErrPreconditionFailed is returned when an IfMatch, IfNoneMatch or IfUnmodifiedSince
+// condition is not met (HTTP 412).
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Blob types accepted by UploadOptions.BlobType.
+const (
+	BlockBlobType  = "block"
+	PageBlobType   = "page"
+	AppendBlobType = "append"
+)
+
+const (
+	// PageSize is the alignment Azure requires of a page blob's size and writes.
+	PageSize = 512
+	// uploadWriteSize is the largest Put Page range and Append Block body sent at once (4 MiB).
+	uploadWriteSize = 4 * 1024 * 1024
+)
+
+// UploadOptions tunes UploadAzureBlobWithOptions.
+type UploadOptions struct {
+	// BlobType selects the kind of blob created: BlockBlobType (the default when
+	// empty), PageBlobType, e.g. for VHD images, whose size must be a multiple of
+	// PageSize, or AppendBlobType, e.g. for logs.
+	BlobType string
+	// VerifyMD5 stores the local file's MD5 as the blob Content-MD5, then checks the
+	// blob properties and reads the blob back, failing with ErrMD5Mismatch if they differ.
+	// Block blobs only: page and append blobs are written piecewise after creation and
+	// the service keeps no Content-MD5 for them.
+	VerifyMD5 bool
+	// IfMatch only overwrites the blob if its ETag still matches, IfNoneMatch only
+	// writes if it doesn't ("*": create the blob only if it does not exist yet).
+	// A failed precondition is reported as ErrPreconditionFailed.
+	IfMatch     string
+	IfNoneMatch string
+	// ContentDisposition is stored as the blob's Content-Disposition, e.g.
+	// `attachment; filename="disk.qcow2"` to have a browser save it under that name.
+	ContentDisposition string
+}
+
+// UploadInfo is what the service reported about a blob it just stored.
+// The ETag can be used for conditional requests later on.
+type UploadInfo struct {
+	ETag         string
+	LastModified time.Time
+	// VersionID identifies the version the upload created on accounts with
+	// blob versioning enabled, empty otherwise.
+	VersionID string
+}
+
+// UploadAzureBlob uploads a local file to Azure Blob Storage using the new SDK and block blobs.
+// Returns the blob URL and the ETag and Last-Modified time of the stored blob.
+func UploadAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile, localFile string,
+	httpClient *http.Client,
+) (string, UploadInfo, error) {
+	return UploadAzureBlobWithOptions(accountURL, accountName, accountKey, containerName,
+		remoteFile, localFile, httpClient, UploadOptions{})
+}
+
+// UploadAzureBlobWithOptions is UploadAzureBlob with the extra behaviour selected by opts.
+func UploadAzureBlobWithOptions(
+	accountURL, accountName, accountKey, containerName, remoteFile, localFile string,
+	httpClient *http.Client,
+	opts UploadOptions,
+) (string, UploadInfo, error) {
+	defer forgetBlobs(accountURL, containerName, remoteFile)
+	switch opts.BlobType {
+	case "", BlockBlobType:
+	case PageBlobType, AppendBlobType:
+		if opts.VerifyMD5 {
+			return "", UploadInfo{}, fmt.Errorf("MD5 verification is not supported for %s blobs", opts.BlobType)
+		}
+	default:
+		return "", UploadInfo{}, fmt.Errorf("unsupported blob type %q, expected %s, %s or %s",
+			opts.BlobType, BlockBlobType, PageBlobType, AppendBlobType)
+	}
+
+	ctx := context.Background()
+
+	// Get clients using helper
+	containerClient, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return "", UploadInfo{}, fmt.Errorf("failed to get clients: %v", err)
+	}
+
+	// Try to create the container (ignore if it already exists)
+	_, err = containerClient.Create(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) {
+			if respErr.ErrorCode != "ContainerAlreadyExists" {
+				return "", UploadInfo{}, fmt.Errorf("failed to create container: %w", compactResponseError(err))
+			}
+		} else {
+			return "", UploadInfo{}, fmt.Errorf("failed to create container: %w", compactResponseError(err))
+		}
+	}
+
+	// Open the local file
+	file, err := os.Open(localFile)
+	if err != nil {
+		return "", UploadInfo{}, fmt.Errorf("unable to open local file %s: %v", localFile, err)
+	}
+	defer file.Close()
+
+	var accessConditions *blob.AccessConditions
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		conditions := &blob.ModifiedAccessConditions{}
+		if opts.IfMatch != "" {
+			etag := azcore.ETag(opts.IfMatch)
+			conditions.IfMatch = &etag
+		}
+		if opts.IfNoneMatch != "" {
+			etag := azcore.ETag(opts.IfNoneMatch)
+			conditions.IfNoneMatch = &etag
+		}
+		accessConditions = &blob.AccessConditions{ModifiedAccessConditions: conditions}
+	}
+
+	headers := blob.HTTPHeaders{BlobContentDisposition: ptrOrNil(opts.ContentDisposition)}
+	switch opts.BlobType {
+	case PageBlobType:
+		return uploadPageBlob(ctx, containerClient.NewPageBlobClient(remoteFile), file, accessConditions, headers)
+	case AppendBlobType:
+		return uploadAppendBlob(ctx, containerClient.NewAppendBlobClient(remoteFile), file, accessConditions, headers)
+	}
+
+	uploadOpts := &blockblob.UploadStreamOptions{AccessConditions: accessConditions, HTTPHeaders: &headers}
+	var localMD5 []byte
+	var localSize int64
+	if opts.VerifyMD5 {
+		hash := md5.New()
+		if localSize, err = io.Copy(hash, file); err != nil {
+			return "", UploadInfo{}, fmt.Errorf("unable to hash local file %s: %v", localFile, err)
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return "", UploadInfo{}, fmt.Errorf("unable to rewind local file %s: %v", localFile, err)
+		}
+		localMD5 = hash.Sum(nil)
+		// Put Block List (files above one block) computes no MD5, record ours so downloads can check it
+		headers.BlobContentMD5 = localMD5
+	}
+
+	// Upload the file stream to the blob
+	resp, err := blobClient.UploadStream(ctx, file, uploadOpts)
+	if err != nil {
+		return "", UploadInfo{}, uploadError(err)
+	}
+
+	if opts.VerifyMD5 {
+		if err := verifyBlobMD5(ctx, blobClient, localSize, localMD5); err != nil {
+			return "", UploadInfo{}, fmt.Errorf("upload verification of %s failed: %w", remoteFile, err)
+		}
+	}
+
+	return blobClient.URL(), uploadInfo(resp.ETag, resp.LastModified, resp.VersionID), nil
+}
+
+// uploadError describes a failed upload request, as ErrPreconditionFailed on a 412.
+func uploadError(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("failed to upload file to blob: %w: %w",
+			ErrPreconditionFailed, compactResponseError(err))
+	}
+	return fmt.Errorf("failed to upload file to blob: %w", compactResponseError(err))
+}
+
+// uploadPageBlob creates a page blob of the file's size, then writes the file into it
+// with Put Page, uploadWriteSize at a time.
+func uploadPageBlob(
+	ctx context.Context,
+	pageClient *pageblob.Client,
+	file *os.File,
+	conditions *blob.AccessConditions,
+	headers blob.HTTPHeaders,
+) (string, UploadInfo, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return "", UploadInfo{}, fmt.Errorf("unable to stat local file %s: %v", file.Name(), err)
+	}
+	size := fi.Size()
+	if size%PageSize != 0 {
+		return "", UploadInfo{}, fmt.Errorf("a page blob must be a multiple of %d bytes, %s has %d",
+			PageSize, file.Name(), size)
+	}
+
+	created, err := pageClient.Create(ctx, size, &pageblob.CreateOptions{
+		AccessConditions: conditions,
+		HTTPHeaders:      &headers,
+	})
+	if err != nil {
+		return "", UploadInfo{}, uploadError(err)
+	}
+	info := uploadInfo(created.ETag, created.LastModified, created.VersionID)
+	for off := int64(0); off < size; off += uploadWriteSize {
+		count := min(uploadWriteSize, size-off)
+		body := readSeekCloser{io.NewSectionReader(file, off, count)}
+		resp, err := pageClient.UploadPages(ctx, body, blob.HTTPRange{Offset: off, Count: count}, nil)
+		if err != nil {
+			return "", UploadInfo{}, uploadError(err)
+		}
+		// the version stays the one Create made, only ETag and time move on
+		written := uploadInfo(resp.ETag, resp.LastModified, nil)
+		info.ETag, info.LastModified = written.ETag, written.LastModified
+	}
+	return pageClient.URL(), info, nil
+}
+
+// uploadAppendBlob creates an append blob, then appends the file to it with
+// Append Block, uploadWriteSize at a time.
+func uploadAppendBlob(
+	ctx context.Context,
+	appendClient *appendblob.Client,
+	file *os.File,
+	conditions *blob.AccessConditions,
+	headers blob.HTTPHeaders,
+) (string, UploadInfo, error) {
+	created, err := appendClient.Create(ctx, &appendblob.CreateOptions{
+		AccessConditions: conditions,
+		HTTPHeaders:      &headers,
+	})
+	if err != nil {
+		return "", UploadInfo{}, uploadError(err)
+	}
+	info := uploadInfo(created.ETag, created.LastModified, created.VersionID)
+	buf := make([]byte, uploadWriteSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			resp, err := appendClient.AppendBlock(ctx, readSeekCloser{bytes.NewReader(buf[:n])}, nil)
+			if err != nil {
+				return "", UploadInfo{}, uploadError(err)
+			}
+			written := uploadInfo(resp.ETag, resp.LastModified, nil)
+			info.ETag, info.LastModified = written.ETag, written.LastModified
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", UploadInfo{}, fmt.Errorf("unable to read local file %s: %v", file.Name(), err)
+		}
+	}
+	return appendClient.URL(), info, nil
+}
+
+// uploadInfo collects the optional response headers of a write.
+func uploadInfo(etag *azcore.ETag, lastModified *time.Time, versionID *string) UploadInfo {
+	var info UploadInfo
+	if etag != nil {
+		info.ETag = string(*etag)
+	}
+	if lastModified != nil {
+		info.LastModified = *lastModified
+	}
+	if versionID != nil {
+		info.VersionID = *versionID
+	}
+	return info
+}
+
+// verifyBlobMD5 checks that the stored blob has the expected size and MD5, both as
+// reported by its properties and as computed over its content.
+func verifyBlobMD5(ctx context.Context, blobClient *blockblob.Client, size int64, want []byte) error {
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	if props.ContentLength != nil && *props.ContentLength != size {
+		return fmt.Errorf("%w: blob has %d bytes, local file %d", ErrMD5Mismatch, *props.ContentLength, size)
+	}
+	if props.ContentMD5 != nil && !bytes.Equal(props.ContentMD5, want) {
+		return fmt.Errorf("%w: blob Content-MD5 %s, local %s", ErrMD5Mismatch,
+			hex.EncodeToString(props.ContentMD5), hex.EncodeToString(want))
+	}
+
+	// the properties only echo what we sent, a dropped or corrupted block shows in the content
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not read blob back: %w", compactResponseError(err))
+	}
+	defer resp.Body.Close()
+	_, got, err := teeMD5(resp.Body, io.Discard)
+	if err != nil {
+		return fmt.Errorf("could not read blob back: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: blob content %s, local %s", ErrMD5Mismatch,
+			hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+	return nil
+}
+
+// BlobExists reports whether the blob exists. A missing container counts as a
+// missing blob, other failures are returned as errors. The call is bounded by the
+// metadata timeout, see SetMetadataTimeout, and answered from the metadata cache
+// when on, see SetMetadataCacheTTL.
+func BlobExists(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (bool, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return false, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	if c, ok := cachedLookup(accountURL, containerName, remoteFile); ok {
+		return c.exists, nil
+	}
+
+	ctx, cancel := metadataContext()
+	defer cancel()
+	resp, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			cacheBlob(accountURL, containerName, remoteFile, false, BlobStat{})
+			return false, nil
+		}
+		return false, fmt.Errorf("could not get blob properties: %w", deadlineError(compactResponseError(err)))
+	}
+	cacheBlob(accountURL, containerName, remoteFile, true, blobStat(resp))
+	return true, nil
+}
+
+// GetAzureBlobMetaData gets content length and content MD5 (as hex string).
+// Useful for verifying file integrity. Bounded by the metadata timeout and cached like BlobExists.
+func GetAzureBlobMetaData(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (int64, string, error) {
+	return GetAzureBlobMetaDataVersion(accountURL, accountName, accountKey, containerName,
+		remoteFile, "", httpClient)
+}
+
+// GetAzureBlobMetaDataVersion is GetAzureBlobMetaData for a specific version of the blob,
+// see UploadInfo.VersionID and ListAzureBlobVersions. An empty versionID means the current one.
+func GetAzureBlobMetaDataVersion(
+	accountURL, accountName, accountKey, containerName, remoteFile, versionID string,
+	httpClient *http.Client,
+) (int64, string, error) {
+	stat, err := statAzureBlob(accountURL, accountName, accountKey, containerName,
+		remoteFile, versionID, httpClient)
+	return stat.Size, stat.MD5, err
+}
+
+// BlobStat is what GetAzureBlobMetaData reports about a blob, with the ETag that
+// identifies its content: it changes whenever the blob is written.
+type BlobStat struct {
+	Size int64
+	MD5  string // hex, empty when the blob has none
+	ETag string
+}
+
+// StatAzureBlob is GetAzureBlobMetaData returning the ETag as well.
+func StatAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobStat, error) {
+	return statAzureBlob(accountURL, accountName, accountKey, containerName,
+		remoteFile, "", httpClient)
+}
+
+func statAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile, versionID string,
+	httpClient *http.Client,
+) (BlobStat, error) {
+	if c, ok := cachedLookup(accountURL, containerName, remoteFile); ok && c.exists && versionID == "" {
+		return c.stat, nil
+	}
+	ctx, cancel := metadataContext()
+	defer cancel()
+
+	// Get the blob client using helper
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return BlobStat{}, fmt.Errorf("failed to get blob client: %v", err)
+	}
+	if versionID != "" {
+		if blobClient, err = blobClient.WithVersionID(versionID); err != nil {
+			return BlobStat{}, fmt.Errorf("failed to get client for version %s: %v", versionID, err)
+		}
+	}
+
+	// Get blob properties
+	resp, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return BlobStat{}, fmt.Errorf("could not get blob properties: %w", deadlineError(compactResponseError(err)))
+	}
+	stat := blobStat(resp)
+	if versionID == "" {
+		cacheBlob(accountURL, containerName, remoteFile, true, stat)
+	}
+	return stat, nil
+}
+
+func blobStat(resp blob.GetPropertiesResponse) BlobStat {
+	// Content length and ContentMD5 may be nil
+	stat := BlobStat{ETag: deref(resp.ETag)}
+	if resp.ContentLength != nil {
+		stat.Size = *resp.ContentLength
+	}
+	if resp.ContentMD5 != nil {
+		stat.MD5 = hex.EncodeToString(resp.ContentMD5)
+	}
+	return stat
+}
+
+// EscapeBlobName percent-encodes each "/" separated segment of a blob name for use
+// as a URL path, so that names with spaces, '+', '#' or '?' address the right blob.
+func EscapeBlobName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// GenerateBlobSasURI is used to generate the URI which can be used to access the blob until the the URI expries
+// Its start time is backdated by DefaultSasClockSkew.
+func GenerateBlobSasURI(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	duration time.Duration,
+) (string, error) {
+	return GenerateBlobSasURIWithOptions(accountURL, accountName, accountKey, containerName,
+		remoteFile, httpClient, duration, DefaultSasOptions())
+}
+
+// GenerateBlobSasURIWithOptions is GenerateBlobSasURI with the signed version,
+// start time backdating, permissions, Content-Disposition override and stored
+// access policy of opts. The SAS expires duration from now, or when its policy
+// says with a duration of 0.
+func GenerateBlobSasURIWithOptions(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	duration time.Duration,
+	opts SasOptions,
+) (string, error) {
+	version := opts.Version
+	if version == "" {
+		version = sas.Version
+	}
+	permissions := "r"
+	if opts.Identifier != "" {
+		permissions = "" // the policy's
+	}
+	if opts.Permissions != "" {
+		var err error
+		if permissions, err = ParseSasPermissions(opts.Permissions); err != nil {
+			return "", err
+		}
+	}
+	if duration < 0 || (duration == 0 && opts.Identifier == "") {
+		return "", fmt.Errorf("invalid SAS duration %v, it has to be positive", duration)
+	}
+
+	// Check if the blob exists
+	_, _, err := GetAzureBlobMetaData(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return "", fmt.Errorf("blob does not exist or error fetching metadata: %w", err)
+	}
+
+	var start, expiry time.Time // the policy's when zero
+	if duration > 0 {
+		now := time.Now().UTC()
+		start, expiry = now.Add(-opts.StartSkew), now.Add(duration)
+	}
+	query, err := signBlobSas(accountName, accountKey, containerName, remoteFile, version, permissions,
+		opts.ContentDisposition, opts.Identifier, start, expiry)
+	if err != nil {
+		return "", fmt.Errorf("could not generate SAS token: %v", err)
+	}
+
+	// Construct final URL, the signature covers the unescaped name
+	blobURL := fmt.Sprintf("%s/%s/%s?%s", strings.TrimSuffix(accountURL, "/"), containerName,
+		EscapeBlobName(remoteFile), query)
+	return blobURL, nil
+}
+
+// UploadPartByChunk upload an individual chunk given an io.ReadSeeker and partID
+func UploadPartByChunk(
+	accountURL, accountName, accountKey, containerName, remoteFile, partID string,
+	httpClient *http.Client,
+	chunk io.ReadSeeker,
+) error {
+	return UploadPartByChunkContext(context.Background(), accountURL, accountName, accountKey,
+		containerName, remoteFile, partID, httpClient, chunk)
+}
+
+// UploadPartByChunkContext is UploadPartByChunk aborting its requests, the one sending
+// the chunk included, once ctx is done.
+func UploadPartByChunkContext(
+	ctx context.Context,
+	accountURL, accountName, accountKey, containerName, remoteFile, partID string,
+	httpClient *http.Client,
+	chunk io.ReadSeeker,
+) error {
+	// Get container and blob clients
+	containerClient, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	// Attempt to create the container (ignore if already exists)
+	_, err = containerClient.Create(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.ErrorCode != "ContainerAlreadyExists" {
+			return fmt.Errorf("failed to create container %s: %w", containerName, compactResponseError(err))
+		} else if !errors.As(err, &respErr) {
+			return fmt.Errorf("unexpected error creating container %s: %w", containerName, err)
+		}
+	}
+
+	// Stage the block (upload the chunk)
+	_, err = blobClient.StageBlock(ctx, partID, readSeekCloser{chunk}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk %s: %w", partID, compactResponseError(err))
+	}
+
+	return nil
+}
+
+// UploadBlockListToBlob used to complete the list of parts which are already uploaded in block blob
+func UploadBlockListToBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	blocks []string,
+) error {
+	return uploadBlockList(accountURL, accountName, accountKey, containerName, remoteFile,
+		httpClient, blocks, nil)
+}
+
+func uploadBlockList(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	blocks []string,
+	opts *blockblob.CommitBlockListOptions,
+) error {
+	defer forgetBlobs(accountURL, containerName, remoteFile)
+	ctx := context.Background()
+
+	// Get container and blob clients
+	containerClient, blobClient, err := getContainerAndBlockBlobClients(accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	// Try to create the container (ignore if already exists)
+	_, err = containerClient.Create(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.ErrorCode != "ContainerAlreadyExists" {
+			return fmt.Errorf("failed to create container %s: %w", containerName, compactResponseError(err))
+		} else if !errors.As(err, &respErr) {
+			return fmt.Errorf("unexpected error creating container %s: %w", containerName, err)
+		}
+	}
+
+	// Build list of block IDs (Base64 encoded strings)
+	_, err = blobClient.CommitBlockList(ctx, blocks, opts)
+	if err != nil {
+		return fmt.Errorf("failed to commit block list: %w", compactResponseError(err))
+	}
+
+	return nil
+}
+
+// GetAzureBlockList returns the IDs of the committed and the uncommitted (staged but not
+// yet committed) blocks of a block blob. A blob that does not exist has no blocks.
+func GetAzureBlockList(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (committed, uncommitted []string, err error) {
+	committedBlocks, uncommittedBlocks, err := GetAzureBlocks(accountURL, accountName, accountKey,
+		containerName, remoteFile, httpClient)
+	for _, b := range committedBlocks {
+		committed = append(committed, b.ID)
+	}
+	for _, b := range uncommittedBlocks {
+		uncommitted = append(uncommitted, b.ID)
+	}
+	return committed, uncommitted, err
+}
+
+// Block is a block of a block blob, as reported by GetAzureBlocks.
+type Block struct {
+	ID   string // as staged, base64
+	Size int64
+}
+
+// GetAzureBlocks is GetAzureBlockList with the size of each block, in the order the
+// service lists them: the committed ones in blob order.
+func GetAzureBlocks(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (committed, uncommitted []Block, err error) {
+	ctx := context.Background()
+
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	resp, err := blobClient.GetBlockList(ctx, blockblob.BlockListTypeAll, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.ErrorCode == "BlobNotFound" {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get block list of %s: %w", remoteFile, compactResponseError(err))
+	}
+	for _, b := range resp.BlockList.CommittedBlocks {
+		committed = append(committed, blockOf(b))
+	}
+	for _, b := range resp.BlockList.UncommittedBlocks {
+		uncommitted = append(uncommitted, blockOf(b))
+	}
+	return committed, uncommitted, nil
+}
+
+func blockOf(b *blockblob.Block) Block {
+	block := Block{ID: deref(b.Name)}
+	if b.Size != nil {
+		block.Size = *b.Size
+	}
+	return block
+}
+
+// ErrExpiryNotSupported is returned by SetAzureBlobExpiry when the storage account has
+// no hierarchical namespace, the only kind of account that supports blob expiry.
+var ErrExpiryNotSupported = errors.New("blob expiry is not supported by this storage account (hierarchical namespace required)")
+
+// SetAzureBlobExpiry schedules the blob for automatic deletion at expiry (Set Blob Expiry).
+func SetAzureBlobExpiry(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	expiry time.Time,
+	httpClient *http.Client,
+) error {
+	defer forgetBlobs(accountURL, containerName, remoteFile)
+	if !expiry.After(time.Now()) {
+		return fmt.Errorf("expiry time %s is not in the future", expiry.UTC().Format(time.RFC3339))
+	}
+
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	ctx := context.Background()
+	_, err = blobClient.SetExpiry(ctx, blockblob.ExpiryTypeAbsolute(expiry.UTC()), nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && strings.Contains(respErr.ErrorCode, "NotSupported") {
+			return fmt.Errorf("failed to set expiry of %s: %w", remoteFile, ErrExpiryNotSupported)
+		}
+		return fmt.Errorf("failed to set expiry of %s: %w", remoteFile, compactResponseError(err))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	bandwidthMu    sync.RWMutex
+	bandwidthLimit *bandwidthBucket // nil for no limit
+)
+
+// SetMaxTotalBandwidth caps the bytes per second the clients of NewHTTPClient send
+// and receive, request and response bodies of all transfers together, e.g. to keep
+// parallel downloads within what the network may take. The transfers in progress
+// share it evenly: each takes its turn for a slice at a time. It applies to the
+// bodies being transferred already too. 0 removes the cap.
+func SetMaxTotalBandwidth(bytesPerSecond int64) {
+	bandwidthMu.Lock()
+	defer bandwidthMu.Unlock()
+	if bytesPerSecond <= 0 {
+		bandwidthLimit = nil
+		return
+	}
+	bandwidthLimit = newBandwidthBucket(bytesPerSecond)
+}
+
+// GetMaxTotalBandwidth returns the cap currently in effect, 0 for none.
+func GetMaxTotalBandwidth() int64 {
+	bandwidthMu.RLock()
+	defer bandwidthMu.RUnlock()
+	if bandwidthLimit == nil {
+		return 0
+	}
+	return bandwidthLimit.rate
+}
+
+func currentBandwidthLimit() *bandwidthBucket {
+	bandwidthMu.RLock()
+	defer bandwidthMu.RUnlock()
+	return bandwidthLimit
+}
+
+// bandwidthSlice is the most a body read takes at once under the cap, or a twentieth
+// of a second of the cap if that is less, so that the transfers sharing it take
+// turns often.
+const bandwidthSlice = 32 << 10
+
+// bandwidthBucket paces bytes at rate per second. Bytes are paid for before they
+// are handed on: each reservation is queued after those before it, and idle time
+// earns no credit, so no burst ever exceeds the rate.
+type bandwidthBucket struct {
+	rate  int64
+	slice int
+
+	mu   sync.Mutex
+	next time.Time // when the bytes reserved so far are paid for
+}
+
+func newBandwidthBucket(rate int64) *bandwidthBucket {
+	return &bandwidthBucket{rate: rate, slice: int(max(1, min(bandwidthSlice, rate/20)))}
+}
+
+// reserve queues n bytes and returns how long to wait before handing them on.
+func (b *bandwidthBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.rate))
+	return b.next.Sub(now)
+}
+
+// bandwidthTransport paces the request and response bodies of base within the
+// SetMaxTotalBandwidth cap.
+type bandwidthTransport struct {
+	base http.RoundTripper
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.WithContext(ctx) // a copy, the caller's request is not to be changed
+		req.Body = &pacedBody{ReadCloser: req.Body, ctx: ctx}
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return &pacedBody{ReadCloser: body, ctx: ctx}, nil
+			}
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &pacedBody{ReadCloser: resp.Body, ctx: ctx}
+	}
+	return resp, nil
+}
+
+// pacedBody reads within the SetMaxTotalBandwidth cap in effect at each read, or
+// until ctx is done.
+type pacedBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *pacedBody) Read(p []byte) (int, error) {
+	limit := currentBandwidthLimit()
+	if limit == nil {
+		return b.ReadCloser.Read(p)
+	}
+	if len(p) > limit.slice {
+		p = p[:limit.slice]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	timer := time.NewTimer(limit.reserve(n))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-b.ctx.Done():
+		return n, b.ctx.Err()
+	}
+	return n, err
+}
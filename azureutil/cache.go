@@ -0,0 +1,94 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metadataCacheMu  sync.Mutex
+	metadataCacheTTL time.Duration // 0 caches nothing
+	metadataCache    = map[string]cachedBlob{}
+)
+
+// cachedBlob is what a metadata call found out about a blob.
+type cachedBlob struct {
+	exists  bool
+	stat    BlobStat // when exists
+	expires time.Time
+}
+
+// SetMetadataCacheTTL keeps the answers of BlobExists, GetAzureBlobMetaData and
+// StatAzureBlob for d, so that a polling loop asking again and again about the
+// same blob does not send a request each time. Every write of this package to a
+// blob, e.g. an upload, a delete or a copy onto it, forgets what was kept of it;
+// changes made by others show once d passed. 0, the default, disables the cache.
+// What the cache held before is dropped.
+func SetMetadataCacheTTL(d time.Duration) {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	metadataCacheTTL = d
+	clear(metadataCache)
+}
+
+// GetMetadataCacheTTL returns the metadata cache TTL currently in effect.
+func GetMetadataCacheTTL() time.Duration {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	return metadataCacheTTL
+}
+
+func blobCacheKey(accountURL, containerName, remoteFile string) string {
+	return strings.TrimSuffix(accountURL, "/") + "/" + containerName + "/" + remoteFile
+}
+
+// cachedLookup returns what is kept of the blob, if it has not expired yet.
+func cachedLookup(accountURL, containerName, remoteFile string) (cachedBlob, bool) {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	if metadataCacheTTL <= 0 {
+		return cachedBlob{}, false
+	}
+	key := blobCacheKey(accountURL, containerName, remoteFile)
+	c, ok := metadataCache[key]
+	if ok && !time.Now().Before(c.expires) {
+		delete(metadataCache, key)
+		return cachedBlob{}, false
+	}
+	return c, ok
+}
+
+// cacheBlob keeps what a metadata call found out about the blob, if the cache is on.
+func cacheBlob(accountURL, containerName, remoteFile string, exists bool, stat BlobStat) {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	if metadataCacheTTL <= 0 {
+		return
+	}
+	metadataCache[blobCacheKey(accountURL, containerName, remoteFile)] = cachedBlob{
+		exists:  exists,
+		stat:    stat,
+		expires: time.Now().Add(metadataCacheTTL),
+	}
+}
+
+// forgetBlobs drops what is kept of the blobs, to be called once they were written.
+func forgetBlobs(accountURL, containerName string, remoteFiles ...string) {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	for _, name := range remoteFiles {
+		delete(metadataCache, blobCacheKey(accountURL, containerName, name))
+	}
+}
+
+// forgetAllBlobs drops the whole cache, for writes that do not name their blobs,
+// e.g. a recursive Data Lake delete.
+func forgetAllBlobs() {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	clear(metadataCache)
+}
@@ -0,0 +1,304 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// A cassette is a recording of HTTP interactions in a file, e.g. of a failing
+// download in the field, that a Replayer serves again offline as a regression test.
+// It holds one JSON Interaction per line, in the order the responses were read.
+// Request bodies are not kept, only their length, and response bodies only up to
+// maxRecordedBody, e.g. the XML of the service whole but not the ranges of a
+// large download. Secrets are scrubbed: the Authorization header and the
+// signature of SAS URLs.
+const maxRecordedBody = 1 << 20
+
+// Interaction is one recorded request and the response it got.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the part of a request a Replayer matches on, plus what helps
+// reading the cassette.
+type RecordedRequest struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Header        http.Header `json:"header"`
+	ContentLength int64       `json:"contentLength,omitempty"`
+}
+
+// RecordedResponse is a response as received. Body holds text bodies, e.g. the XML
+// of the service, BodyBase64 anything else. Truncated is set for a body cut at
+// maxRecordedBody; replaying it fails at the cut.
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 []byte      `json:"bodyBase64,omitempty"`
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+const scrubbed = "REDACTED"
+
+// secretQuery are the query parameters scrubbed from recorded URLs.
+var secretQuery = []string{"sig"}
+
+// scrubURL returns u without its secrets.
+func scrubURL(u *url.URL) string {
+	q := u.Query()
+	found := false
+	for _, k := range secretQuery {
+		if q.Has(k) {
+			q.Set(k, scrubbed)
+			found = true
+		}
+	}
+	if !found {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+// Recorder is an http.RoundTripper recording what passes through it to a cassette
+// file. Each interaction is appended once its response body was read, so that a
+// run that dies still leaves the ones before.
+type Recorder struct {
+	base http.RoundTripper
+	path string
+
+	mu   sync.Mutex
+	file *os.File // opened, truncated, with the first interaction
+}
+
+// NewRecorder returns a Recorder sending requests on with base, http.DefaultTransport
+// when nil, and recording them to the cassette at path.
+func NewRecorder(base http.RoundTripper, path string) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Recorder{base: base, path: path}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := req.Header.Clone()
+	if header.Get("Authorization") != "" {
+		header.Set("Authorization", scrubbed)
+	}
+	in := Interaction{
+		Request: RecordedRequest{
+			Method:        req.Method,
+			URL:           scrubURL(req.URL),
+			Header:        header,
+			ContentLength: req.ContentLength,
+		},
+		Response: RecordedResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone()},
+	}
+	in.Response.Header.Del("Set-Cookie")
+	if resp.Body == nil || resp.Body == http.NoBody {
+		if err := r.append(in); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, recorder: r, in: in}
+	return resp, nil
+}
+
+// Close closes the cassette file. Interactions whose bodies are read after are
+// not recorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.path = ""
+	return err
+}
+
+func (r *Recorder) append(in Interaction) error {
+	line, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode interaction: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		if r.path == "" {
+			return nil
+		}
+		f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create cassette: %w", err)
+		}
+		r.file = f
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// recordingBody passes a response body on as it is read, keeping up to
+// maxRecordedBody of it, and appends the interaction at its end or close.
+type recordingBody struct {
+	io.ReadCloser
+	recorder *Recorder
+	in       Interaction
+
+	kept      bytes.Buffer
+	truncated bool
+	done      bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxRecordedBody - b.kept.Len(); n > room {
+		b.kept.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.kept.Write(p[:n])
+	}
+	if err == io.EOF {
+		if rerr := b.record(); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+// Close keeps what is left of a body closed before its end up to maxRecordedBody,
+// so that the cassette has it even if the caller did not need it.
+func (b *recordingBody) Close() error {
+	if !b.done {
+		io.Copy(io.Discard, io.LimitReader(b, int64(maxRecordedBody-b.kept.Len())))
+		b.truncated = b.truncated || !b.done
+	}
+	err := b.ReadCloser.Close()
+	if rerr := b.record(); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// record appends the interaction with the body read so far, once.
+func (b *recordingBody) record() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	body := b.kept.Bytes()
+	if utf8.Valid(body) {
+		b.in.Response.Body = string(body)
+	} else {
+		b.in.Response.BodyBase64 = body
+	}
+	b.in.Response.Truncated = b.truncated
+	return b.recorder.append(b.in)
+}
+
+// Replayer is an http.RoundTripper answering from a cassette instead of the
+// network. Each request gets the first interaction not replayed yet with the same
+// method and URL, secrets scrubbed; one without is an error.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// LoadCassette reads the cassette at path for replay.
+func LoadCassette(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	defer f.Close()
+	var interactions []Interaction
+	dec := json.NewDecoder(f)
+	for {
+		var in Interaction
+		if err := dec.Decode(&in); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+		}
+		interactions = append(interactions, in)
+	}
+	return &Replayer{interactions: interactions, used: make([]bool, len(interactions))}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	target := scrubURL(req.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || in.Request.Method != req.Method || in.Request.URL != target {
+			continue
+		}
+		r.used[i] = true
+		body := in.Response.BodyBase64
+		if body == nil {
+			body = []byte(in.Response.Body)
+		}
+		var replayed io.Reader = bytes.NewReader(body)
+		size := int64(len(body))
+		if in.Response.Truncated {
+			size = -1
+			replayed = io.MultiReader(replayed, errReader{fmt.Errorf("body of %s %s truncated in the cassette", req.Method, target)})
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(replayed),
+			ContentLength: size,
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction left for %s %s", req.Method, target)
+}
+
+// Remaining returns how many recorded interactions were not replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,148 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const defaultEndpointSuffix = "core.windows.net"
+
+// BlobAccountURL returns the Blob service URL of accountName, https://<account>.blob.<suffix>.
+// An empty endpointSuffix means the public cloud; sovereign clouds use their own,
+// e.g. core.usgovcloudapi.net (Azure Government) or core.chinacloudapi.cn (Azure China).
+func BlobAccountURL(accountName, endpointSuffix string) string {
+	return blobAccountURL("https", accountName, endpointSuffix)
+}
+
+func blobAccountURL(protocol, accountName, endpointSuffix string) string {
+	if endpointSuffix == "" {
+		endpointSuffix = defaultEndpointSuffix
+	}
+	return fmt.Sprintf("%s://%s.blob.%s", protocol, accountName, strings.Trim(endpointSuffix, "./"))
+}
+
+// ErrAccountMismatch is returned by CheckAccountURL for an account URL of another account.
+var ErrAccountMismatch = errors.New("account name does not match the account URL")
+
+// CheckAccountURL verifies that accountName is the first label of the host of
+// accountURL, as in https://<account>.blob.core.windows.net, to tell a URL and a name
+// copied from different accounts apart before the service answers with a signature
+// mismatch. Custom endpoints, e.g. an emulator or a CDN, fail it too. An empty
+// accountName, e.g. of a SAS connection string, is not checked.
+func CheckAccountURL(accountURL, accountName string) error {
+	if accountName == "" {
+		return nil
+	}
+	u, err := url.Parse(accountURL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid account URL %q", accountURL)
+	}
+	label, _, _ := strings.Cut(u.Hostname(), ".")
+	if !strings.EqualFold(label, accountName) {
+		return fmt.Errorf("%w: %s is not an endpoint of account %s", ErrAccountMismatch, u.Host, accountName)
+	}
+	return nil
+}
+
+// ParseAzureConnectionString splits a storage connection string into the account URL,
+// account name and account key expected by the rest of this package.
+// For SAS-based connection strings the key is empty and sas is the SAS token, without
+// the leading "?", to register with SetAccountSAS.
+func ParseAzureConnectionString(cs string) (accountURL, name, key, sas string, err error) {
+	fields := map[string]string{}
+	for _, pair := range strings.Split(cs, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// values (keys, SAS tokens) may contain '=' themselves, only split on the first one
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return "", "", "", "", fmt.Errorf("malformed connection string segment %q", pair)
+		}
+		fields[strings.ToLower(k)] = v
+	}
+	if len(fields) == 0 {
+		return "", "", "", "", fmt.Errorf("empty connection string")
+	}
+	if fields["usedevelopmentstorage"] != "" {
+		return "", "", "", "", fmt.Errorf("development storage connection strings are not supported")
+	}
+
+	name = fields["accountname"]
+	key = fields["accountkey"]
+	sasToken := strings.TrimPrefix(fields["sharedaccesssignature"], "?")
+
+	if endpoint := fields["blobendpoint"]; endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "", "", "", "", fmt.Errorf("invalid BlobEndpoint %q", endpoint)
+		}
+		if name == "" {
+			name, _, _ = strings.Cut(u.Hostname(), ".")
+		}
+		accountURL = strings.TrimSuffix(endpoint, "/")
+	} else {
+		if name == "" {
+			return "", "", "", "", fmt.Errorf("connection string has neither AccountName nor BlobEndpoint")
+		}
+		protocol := fields["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		accountURL = blobAccountURL(protocol, name, fields["endpointsuffix"])
+	}
+
+	switch {
+	case key != "":
+		return accountURL, name, key, "", nil
+	case sasToken != "":
+		if _, err := url.ParseQuery(sasToken); err != nil {
+			return "", "", "", "", fmt.Errorf("invalid SharedAccessSignature: %v", err)
+		}
+		return accountURL, name, "", sasToken, nil
+	default:
+		return "", "", "", "", fmt.Errorf("connection string has neither AccountKey nor SharedAccessSignature")
+	}
+}
+
+// ParseAzureBlobURL splits the URL of a blob, as copied from the portal, into the
+// account URL, container and blob name the rest of this package expects, and its
+// SAS token, without the leading "?", if it has one. The blob name may have
+// slashes, its virtual directories. Path-style URLs of an IP or localhost host, as
+// of the emulator, keep the account in accountURL, e.g. http://127.0.0.1:10000/devstoreaccount1.
+func ParseAzureBlobURL(u string) (accountURL, container, blob, sas string, err error) {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		// not quoting u, it may hold a signature
+		return "", "", "", "", errors.New("invalid blob URL, expected https://<account>.blob.<suffix>/<container>/<blob>")
+	}
+	path := strings.TrimPrefix(parsed.Path, "/")
+	base := parsed.Scheme + "://" + parsed.Host
+	where := base + parsed.Path // u without its query, for the errors
+	if host := parsed.Hostname(); host == "localhost" || net.ParseIP(host) != nil {
+		account, rest, _ := strings.Cut(path, "/")
+		base, path = base+"/"+account, rest
+	}
+	container, blob, _ = strings.Cut(path, "/")
+	if container == "" || blob == "" || strings.HasSuffix(blob, "/") {
+		return "", "", "", "", fmt.Errorf("blob URL %s has no container and blob name", where)
+	}
+	if parsed.RawQuery != "" {
+		query, err := url.ParseQuery(parsed.RawQuery)
+		if err != nil {
+			return "", "", "", "", fmt.Errorf("invalid query of blob URL: %v", err)
+		}
+		if query.Get("sig") == "" {
+			return "", "", "", "", fmt.Errorf("query of blob URL %s is not a SAS, it has no signature", where)
+		}
+		sas = parsed.RawQuery
+	}
+	return base, container, blob, sas, nil
+}
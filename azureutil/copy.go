@@ -0,0 +1,111 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// ErrCopyTimeout is returned by CopyAzureBlobAndWait when the copy is still pending
+// at the timeout. The copy itself goes on, it can be aborted by its ID.
+var ErrCopyTimeout = errors.New("copy did not finish in time")
+
+// Bounds of the delay between two checks of CopyAzureBlobAndWait: it starts short,
+// most copies within an account finish at once, and doubles up to the maximum.
+var (
+	copyWaitFirstPoll = 250 * time.Millisecond
+	copyWaitMaxPoll   = 30 * time.Second
+)
+
+// CopyAzureBlob starts a server-side copy of srcBlob to dstBlob within the container
+// and returns its state, which is often already success. GetAzureBlobCopyStatus on
+// dstBlob follows a pending copy.
+func CopyAzureBlob(
+	accountURL, accountName, accountKey, containerName, srcBlob, dstBlob string,
+	httpClient *http.Client,
+) (BlobCopy, error) {
+	defer forgetBlobs(accountURL, containerName, dstBlob)
+	containerClient, err := getContainerClient(
+		accountURL, accountName, accountKey, containerName, httpClient)
+	if err != nil {
+		return BlobCopy{}, fmt.Errorf("failed to get container client: %v", err)
+	}
+	srcClient := containerClient.NewBlobClient(srcBlob)
+	dstClient := containerClient.NewBlobClient(dstBlob)
+
+	resp, err := dstClient.StartCopyFromURL(context.Background(), srcClient.URL(), nil)
+	if err != nil {
+		return BlobCopy{}, fmt.Errorf("failed to copy %s to %s: %w", srcBlob, dstBlob, compactResponseError(err))
+	}
+	return BlobCopy{
+		ID:     deref(resp.CopyID),
+		Status: deref(resp.CopyStatus),
+		Source: srcClient.URL(),
+	}, nil
+}
+
+// GetAzureBlobCopyStatus returns the state of the last copy that wrote remoteFile.
+// A blob that was not written by a copy is an error.
+func GetAzureBlobCopyStatus(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobCopy, error) {
+	state, err := GetAzureBlobState(accountURL, accountName, accountKey, containerName,
+		remoteFile, httpClient)
+	if err != nil {
+		return BlobCopy{}, err
+	}
+	if state.Copy == nil {
+		return BlobCopy{}, fmt.Errorf("%s was not written by a copy", remoteFile)
+	}
+	return *state.Copy, nil
+}
+
+// CopyAzureBlobAndWait is CopyAzureBlob waiting for the copy to end: it polls
+// GetAzureBlobCopyStatus with a growing delay until the copy succeeded, which
+// returns nil, failed or was aborted, which returns an error with the reason the
+// service gives, or timeout passed, which returns ErrCopyTimeout.
+func CopyAzureBlobAndWait(
+	accountURL, accountName, accountKey, containerName, srcBlob, dstBlob string,
+	httpClient *http.Client,
+	timeout time.Duration,
+) error {
+	deadline := time.Now().Add(timeout)
+	state, err := CopyAzureBlob(accountURL, accountName, accountKey, containerName,
+		srcBlob, dstBlob, httpClient)
+	if err != nil {
+		return err
+	}
+	copyID := state.ID
+	for delay := copyWaitFirstPoll; state.Status == string(blob.CopyStatusTypePending); delay *= 2 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%w: copy %s of %s to %s still pending after %v (%s copied)",
+				ErrCopyTimeout, copyID, srcBlob, dstBlob, timeout, state.Progress)
+		}
+		time.Sleep(min(delay, copyWaitMaxPoll, remaining))
+		if state, err = GetAzureBlobCopyStatus(accountURL, accountName, accountKey, containerName,
+			dstBlob, httpClient); err != nil {
+			return fmt.Errorf("failed to follow copy %s of %s to %s: %w", copyID, srcBlob, dstBlob, err)
+		}
+		if state.ID != "" && state.ID != copyID {
+			return fmt.Errorf("copy %s of %s to %s was replaced by copy %s", copyID, srcBlob, dstBlob, state.ID)
+		}
+	}
+	if state.Status != string(blob.CopyStatusTypeSuccess) {
+		reason := state.Description
+		if reason == "" {
+			reason = "no reason given"
+		}
+		return fmt.Errorf("copy %s of %s to %s ended with status %s: %s",
+			copyID, srcBlob, dstBlob, state.Status, reason)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// dataLakeVersion is the x-ms-version of the Data Lake Storage Gen2 REST calls.
+const dataLakeVersion = "2021-06-08"
+
+// PathInfo is an entry of a Data Lake listing. Unlike the virtual prefixes of the
+// blob endpoint, directories of an account with a hierarchical namespace are real
+// entries of their own.
+type PathInfo struct {
+	Name         string    `json:"name"`
+	IsDirectory  bool      `json:"isDirectory"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// DataLakeAccountURL returns the dfs endpoint of an account, e.g.
+// https://<account>.dfs.core.windows.net for an empty endpointSuffix.
+func DataLakeAccountURL(accountName, endpointSuffix string) string {
+	if endpointSuffix == "" {
+		endpointSuffix = defaultEndpointSuffix
+	}
+	return fmt.Sprintf("https://%s.dfs.%s", accountName, strings.Trim(endpointSuffix, "./"))
+}
+
+// DataLakeURLFromBlob returns the dfs endpoint of the account whose blob endpoint is
+// accountURL. Other URLs, e.g. of an emulator, are returned as they are.
+func DataLakeURLFromBlob(accountURL string) string {
+	u, err := url.Parse(accountURL)
+	if err != nil {
+		return accountURL
+	}
+	if account, rest, ok := strings.Cut(u.Host, ".blob."); ok {
+		u.Host = account + ".dfs." + rest
+	}
+	return u.String()
+}
+
+// ListDataLakePaths lists the paths in directory (the root when empty) of a file
+// system, the container of the blob endpoint, descending into subdirectories only
+// when recursive. accountURL is the dfs endpoint.
+func ListDataLakePaths(
+	accountURL, accountName, accountKey, fileSystem, directory string,
+	recursive bool,
+	httpClient *http.Client,
+) ([]PathInfo, error) {
+	pl, err := newDataLakePipeline(accountName, accountKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []PathInfo
+	continuation := ""
+	for {
+		q := url.Values{"resource": {"filesystem"}, "recursive": {strconv.FormatBool(recursive)}}
+		if directory != "" {
+			q.Set("directory", strings.Trim(directory, "/"))
+		}
+		if continuation != "" {
+			q.Set("continuation", continuation)
+		}
+		resp, err := dataLakeDo(pl, http.MethodGet,
+			strings.TrimSuffix(accountURL, "/")+"/"+url.PathEscape(fileSystem)+"?"+q.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("could not list paths: %w", err)
+		}
+		var page struct {
+			Paths []struct {
+				Name          string `json:"name"`
+				IsDirectory   string `json:"isDirectory"` // "true" for directories, missing for files
+				ContentLength string `json:"contentLength"`
+				LastModified  string `json:"lastModified"`
+			} `json:"paths"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode path listing: %v", err)
+		}
+		for _, p := range page.Paths {
+			info := PathInfo{Name: p.Name, IsDirectory: p.IsDirectory == "true"}
+			info.Size, _ = strconv.ParseInt(p.ContentLength, 10, 64)
+			info.LastModified, _ = http.ParseTime(p.LastModified)
+			paths = append(paths, info)
+		}
+		if continuation = resp.Header.Get("x-ms-continuation"); continuation == "" {
+			return paths, nil
+		}
+	}
+}
+
+// DeleteDataLakePath deletes a file or directory of a file system. A directory that
+// is not empty is only deleted when recursive. accountURL is the dfs endpoint.
+func DeleteDataLakePath(
+	accountURL, accountName, accountKey, fileSystem, path string,
+	recursive bool,
+	httpClient *http.Client,
+) error {
+	defer forgetAllBlobs()
+	pl, err := newDataLakePipeline(accountName, accountKey, httpClient)
+	if err != nil {
+		return err
+	}
+
+	// a large directory of an account with ACLs is deleted over several calls
+	continuation := ""
+	for {
+		q := url.Values{"recursive": {strconv.FormatBool(recursive)}}
+		if continuation != "" {
+			q.Set("continuation", continuation)
+		}
+		resp, err := dataLakeDo(pl, http.MethodDelete, strings.TrimSuffix(accountURL, "/")+"/"+
+			url.PathEscape(fileSystem)+"/"+EscapeBlobName(strings.Trim(path, "/"))+"?"+q.Encode())
+		if err != nil {
+			return fmt.Errorf("failed to delete path: %w", err)
+		}
+		resp.Body.Close()
+		if continuation = resp.Header.Get("x-ms-continuation"); continuation == "" {
+			return nil
+		}
+	}
+}
+
+// newDataLakePipeline returns a pipeline with the retries, transport and extra
+// headers of the blob clients of this package, signing with the shared key.
+func newDataLakePipeline(accountName, accountKey string, httpClient *http.Client) (runtime.Pipeline, error) {
+	key, err := base64.StdEncoding.DecodeString(AccountKeyInUse(accountKey))
+	if err != nil {
+		return runtime.Pipeline{}, fmt.Errorf("invalid account key: %v", err)
+	}
+	options := clientOptionsFromHTTP(httpClient)
+	return runtime.NewPipeline("azureutil", "v1.0.0", runtime.PipelineOptions{
+		PerRetry: []policy.Policy{sharedKeyPolicy{accountName: accountName, key: key}},
+	}, &options), nil
+}
+
+// dataLakeDo sends a request without body, returning the response of a 2xx status.
+func dataLakeDo(pl runtime.Pipeline, method, rawURL string) (*http.Response, error) {
+	req, err := runtime.NewRequest(context.Background(), method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("x-ms-version", dataLakeVersion)
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, compactResponseError(runtime.NewResponseError(resp))
+	}
+	return resp, nil
+}
+
+// sharedKeyPolicy signs each try of a request with Shared Key authorization, as
+// the blob clients do, for the calls made without them.
+type sharedKeyPolicy struct {
+	accountName string
+	key         []byte
+}
+
+func (p sharedKeyPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	raw.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(sharedKeyStringToSign(raw, p.accountName)))
+	raw.Header.Set("Authorization", "SharedKey "+p.accountName+":"+
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return req.Next()
+}
+
+// sharedKeyStringToSign is the string a Shared Key signature of r is computed over.
+func sharedKeyStringToSign(r *http.Request, accountName string) string {
+	var xms []string
+	for k, v := range r.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-ms-") {
+			xms = append(xms, name+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(xms)
+
+	resource := "/" + accountName + r.URL.EscapedPath()
+	params := r.URL.Query()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := params[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	return strings.Join([]string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		contentLength,
+		r.Header.Get("Content-MD5"),
+		r.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+		strings.Join(xms, "\n"),
+		resource,
+	}, "\n")
+}
@@ -0,0 +1,53 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataTimeout is the metadata timeout used when SetMetadataTimeout was
+// never called.
+const DefaultMetadataTimeout = 30 * time.Second
+
+var (
+	metadataMu      sync.RWMutex
+	metadataTimeout = DefaultMetadataTimeout
+)
+
+// SetMetadataTimeout bounds each metadata call of this package (BlobExists,
+// GetAzureBlobMetaData, StatAzureBlob), retries included, to d. Transfers are not affected, they
+// only abort once they stall. 0 disables the deadline.
+func SetMetadataTimeout(d time.Duration) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	metadataTimeout = d
+}
+
+// GetMetadataTimeout returns the metadata timeout currently in effect.
+func GetMetadataTimeout() time.Duration {
+	metadataMu.RLock()
+	defer metadataMu.RUnlock()
+	return metadataTimeout
+}
+
+// metadataContext returns the context of a metadata call, with its deadline.
+func metadataContext() (context.Context, context.CancelFunc) {
+	if d := GetMetadataTimeout(); d > 0 {
+		return context.WithTimeout(context.Background(), d)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// deadlineError names the metadata timeout in err when that is what ended the call.
+func deadlineError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no answer within the metadata timeout of %v: %w", GetMetadataTimeout(), err)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// ServiceError is a failed response of the storage service, as returned by the calls
+// of this package: errors.As(err, &target) with a *ServiceError target gives what
+// Azure support asks for. Its message is a compact rendering of the
+// *azcore.ResponseError, whose own dumps the whole response, and it unwraps to that
+// SDK error so errors.As keeps working for it too.
+type ServiceError struct {
+	StatusCode int
+	ErrorCode  string // e.g. BlobNotFound, empty when the service gave none
+	RequestID  string // x-ms-request-id, identifies the request in the service logs
+	Message    string // first line of the message of the service
+	resp       *azcore.ResponseError
+}
+
+func (e *ServiceError) Error() string {
+	code := e.ErrorCode
+	if code == "" {
+		code = http.StatusText(e.StatusCode)
+	}
+	status := fmt.Sprintf("HTTP %d", e.StatusCode)
+	if e.RequestID != "" {
+		status += ", request ID " + e.RequestID
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("%s (%s)", code, status)
+	}
+	return fmt.Sprintf("%s (%s): %s", code, status, e.Message)
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.resp
+}
+
+// RequestIDFromError returns the x-ms-request-id of the failed response carried by
+// err, or "" if there is none.
+func RequestIDFromError(err error) string {
+	var svcErr *ServiceError
+	if errors.As(err, &svcErr) {
+		return svcErr.RequestID
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.RawResponse != nil {
+		return respErr.RawResponse.Header.Get("x-ms-request-id")
+	}
+	return ""
+}
+
+// storageErrorBody is the XML error document returned by the Blob service.
+type storageErrorBody struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// compactResponseError replaces an *azcore.ResponseError in err by a *ServiceError
+// carrying the Azure error code, the request ID and the first line of its message.
+// Any other error, or one that already carries a *ServiceError, is returned unchanged.
+func compactResponseError(err error) error {
+	var respErr *azcore.ResponseError
+	var svcErr *ServiceError
+	if !errors.As(err, &respErr) || errors.As(err, &svcErr) {
+		return err
+	}
+	e := &ServiceError{StatusCode: respErr.StatusCode, ErrorCode: respErr.ErrorCode, resp: respErr}
+	if respErr.RawResponse != nil {
+		e.RequestID = respErr.RawResponse.Header.Get("x-ms-request-id")
+	}
+	if respErr.RawResponse != nil && respErr.RawResponse.Body != nil {
+		// the SDK has already buffered the body, Payload hands back the cached bytes
+		body, _ := runtime.Payload(respErr.RawResponse)
+		var doc storageErrorBody
+		if xml.Unmarshal(body, &doc) == nil {
+			if e.ErrorCode == "" {
+				e.ErrorCode = doc.Code
+			}
+			e.Message, _, _ = strings.Cut(strings.TrimSpace(doc.Message), "\n")
+		}
+	}
+	return e
+}
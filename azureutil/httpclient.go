@@ -0,0 +1,139 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrStalled is returned by response body reads of a client from NewHTTPClient when
+// no data arrived for ClientTimeouts.Idle.
+var ErrStalled = errors.New("transfer stalled")
+
+// ClientTimeouts bounds each phase of a request separately. Unlike http.Client.Timeout
+// nothing caps the body transfer as a whole: a large but steady download may take as
+// long as it needs, only a body that stops moving for Idle is aborted.
+// Zero values disable the respective timeout.
+type ClientTimeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	Idle           time.Duration
+}
+
+// DefaultClientTimeouts returns the timeouts NewHTTPClient is meant to be used with.
+func DefaultClientTimeouts() ClientTimeouts {
+	return ClientTimeouts{
+		Dial:           30 * time.Second,
+		TLSHandshake:   10 * time.Second,
+		ResponseHeader: 30 * time.Second,
+		Idle:           time.Minute,
+	}
+}
+
+// NewHTTPClient returns a client enforcing t, to be passed as httpClient to this package.
+// It stays within the SetMaxTotalBandwidth cap.
+func NewHTTPClient(t ClientTimeouts) *http.Client {
+	return NewHTTPClientWithTLS(t, nil)
+}
+
+// NewHTTPClientWithTLS is NewHTTPClient connecting with tlsConfig, e.g. to trust
+// a custom CA or require TLS 1.3. A nil tlsConfig uses the Go defaults.
+func NewHTTPClientWithTLS(t ClientTimeouts, tlsConfig *tls.Config) *http.Client {
+	return newHTTPClient(t, newTransport(t, tlsConfig))
+}
+
+func newTransport(t ClientTimeouts, tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	transport.DialContext = newDialer(t).DialContext
+	transport.TLSHandshakeTimeout = t.TLSHandshake
+	transport.ResponseHeaderTimeout = t.ResponseHeader
+	return transport
+}
+
+func newDialer(t ClientTimeouts) *net.Dialer {
+	return &net.Dialer{Timeout: t.Dial, KeepAlive: 30 * time.Second}
+}
+
+func newHTTPClient(t ClientTimeouts, transport http.RoundTripper) *http.Client {
+	transport = &bandwidthTransport{base: transport}
+	if t.Idle > 0 {
+		transport = &stallTransport{base: transport, idle: t.Idle}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// stallTransport aborts a request whose response body makes no progress for idle.
+type stallTransport struct {
+	base http.RoundTripper
+	idle time.Duration
+}
+
+func (t *stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newStallReader(resp.Body, t.idle, cancel)
+	return resp, nil
+}
+
+// stallReader cancels the request when idle passes between two reads that returned data.
+type stallReader struct {
+	body   io.ReadCloser
+	idle   time.Duration
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	mu      sync.Mutex
+	stalled bool
+}
+
+func newStallReader(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *stallReader {
+	r := &stallReader{body: body, idle: idle, cancel: cancel}
+	r.timer = time.AfterFunc(idle, r.stall)
+	return r
+}
+
+func (r *stallReader) stall() {
+	r.mu.Lock()
+	r.stalled = true
+	r.mu.Unlock()
+	r.cancel()
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.idle)
+	}
+	if err != nil && err != io.EOF {
+		r.mu.Lock()
+		stalled := r.stalled
+		r.mu.Unlock()
+		if stalled {
+			err = ErrStalled
+		}
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	err := r.body.Close()
+	r.cancel()
+	return err
+}
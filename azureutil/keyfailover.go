@@ -0,0 +1,99 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// keyPair is the secondary key registered for a primary account key.
+type keyPair struct {
+	secondary  string
+	failedOver bool // the primary was rejected, sign with the secondary from now on
+}
+
+var (
+	keyFailoverMu sync.Mutex
+	keyFailover   = map[string]*keyPair{}
+)
+
+// SetSecondaryAccountKey registers secondaryKey as the other key of the account whose
+// key is primaryKey, e.g. key2 while key1 is being rotated. A request signed with
+// primaryKey that the service rejects with 403 AuthenticationFailed is sent again
+// signed with secondaryKey, and once that happened requests for primaryKey are signed
+// with secondaryKey right away. An empty secondaryKey removes the registration.
+func SetSecondaryAccountKey(primaryKey, secondaryKey string) {
+	keyFailoverMu.Lock()
+	defer keyFailoverMu.Unlock()
+	if secondaryKey == "" || secondaryKey == primaryKey {
+		delete(keyFailover, primaryKey)
+		return
+	}
+	keyFailover[primaryKey] = &keyPair{secondary: secondaryKey}
+}
+
+// AccountKeyInUse returns the key requests for accountKey are currently signed with:
+// its secondary key after a failover, accountKey otherwise. For callers that sign
+// requests outside this package.
+func AccountKeyInUse(accountKey string) string {
+	keyFailoverMu.Lock()
+	defer keyFailoverMu.Unlock()
+	if p := keyFailover[accountKey]; p != nil && p.failedOver {
+		return p.secondary
+	}
+	return accountKey
+}
+
+// secondaryKeyFor returns the secondary key registered for accountKey, if any.
+func secondaryKeyFor(accountKey string) (string, bool) {
+	keyFailoverMu.Lock()
+	defer keyFailoverMu.Unlock()
+	if p := keyFailover[accountKey]; p != nil && !p.failedOver {
+		return p.secondary, true
+	}
+	return "", false
+}
+
+func markFailedOver(primaryKey string) {
+	keyFailoverMu.Lock()
+	defer keyFailoverMu.Unlock()
+	if p := keyFailover[primaryKey]; p != nil {
+		p.failedOver = true
+	}
+}
+
+// keyFailoverPolicy switches cred from the primary to the secondary key when the
+// service rejects the primary. It runs before the retry and signing policies, so the
+// request it sends again is signed anew.
+type keyFailoverPolicy struct {
+	cred       *azblob.SharedKeyCredential
+	primaryKey string
+	secondary  string
+}
+
+func (p keyFailoverPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if err != nil || resp.StatusCode != http.StatusForbidden ||
+		resp.Header.Get("x-ms-error-code") != "AuthenticationFailed" {
+		return resp, err
+	}
+	if err := p.cred.SetAccountKey(p.secondary); err != nil {
+		return resp, nil // not a valid key, report the original rejection
+	}
+	if err := req.RewindBody(); err != nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp, err = req.Next()
+	if err == nil && resp.StatusCode != http.StatusForbidden {
+		markFailedOver(p.primaryKey)
+	}
+	return resp, err
+}
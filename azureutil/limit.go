@@ -0,0 +1,96 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var (
+	requestLimitMu sync.RWMutex
+	requestLimit   chan struct{} // a slot per request in flight, nil for no limit
+)
+
+// SetMaxConcurrentRequests caps the requests of this package in flight at once, from
+// all goroutines together, at n, e.g. to stay clear of the file descriptor limit and
+// of the account's throttling when many transfers run in parallel. A request waits
+// for a slot, or for its context to end, and holds it until its response body is
+// read to the end or closed, so an open download stream counts as in flight.
+// 0 removes the cap.
+func SetMaxConcurrentRequests(n int) {
+	requestLimitMu.Lock()
+	defer requestLimitMu.Unlock()
+	if n <= 0 {
+		requestLimit = nil
+		return
+	}
+	requestLimit = make(chan struct{}, n)
+}
+
+// GetMaxConcurrentRequests returns the cap currently in effect, 0 for none.
+func GetMaxConcurrentRequests() int {
+	requestLimitMu.RLock()
+	defer requestLimitMu.RUnlock()
+	return cap(requestLimit)
+}
+
+// acquireRequestSlot waits for a slot of the SetMaxConcurrentRequests cap, nil release
+// meaning there is no cap. release hands the slot back to the limit it was taken
+// from, even if the cap changed since.
+func acquireRequestSlot(ctx context.Context) (release func(), err error) {
+	requestLimitMu.RLock()
+	limit := requestLimit
+	requestLimitMu.RUnlock()
+	if limit == nil {
+		return nil, nil
+	}
+	select {
+	case limit <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-limit }) }, nil
+}
+
+// limitedDo sends req through client within the SetMaxConcurrentRequests cap.
+func limitedDo(client Doer, req *http.Request) (*http.Response, error) {
+	release, err := acquireRequestSlot(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return client.Do(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		release()
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases the slot of its request once it is done with.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
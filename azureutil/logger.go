@@ -0,0 +1,93 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Logger receives what this package does on the wire: each try of a request with
+// its URL, SAS signature redacted, and status at Debugf, the failures retries are
+// for, with whether the request is retried, at Errorf.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger // nil logs nothing
+)
+
+// SetLogger sends the requests of this package to l. Pass nil to stop logging,
+// the default.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func getLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// tryCounter counts the tries of one call, shared by its retries.
+type tryCounter struct {
+	tries int
+}
+
+// countTriesPolicy starts the tryCounter of a call, ahead of the retries.
+type countTriesPolicy struct{}
+
+func (countTriesPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(&tryCounter{})
+	return req.Next()
+}
+
+// logPolicy logs each try of a request and, when it failed, whether it is retried
+// under retry.
+type logPolicy struct {
+	logger Logger
+	retry  RetryPolicy
+}
+
+func (p logPolicy) Do(req *policy.Request) (*http.Response, error) {
+	try := 1
+	var counter *tryCounter
+	if req.OperationValue(&counter) {
+		counter.tries++
+		try = counter.tries
+	}
+	raw := req.Raw()
+	target := scrubURL(raw.URL)
+	started := time.Now()
+	resp, err := req.Next()
+	took := time.Since(started).Round(time.Millisecond)
+	retried := "retrying"
+	if try > p.retry.MaxRetries || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		retried = "giving up"
+	}
+	switch {
+	case err != nil:
+		p.logger.Errorf("%s %s (try %d) failed after %v: %v; %s", raw.Method, target, try, took, err, retried)
+	case p.retry.IsRetryableStatus(resp.StatusCode):
+		p.logger.Errorf("%s %s (try %d): %d %s after %v; %s", raw.Method, target, try,
+			resp.StatusCode, resp.Header.Get("x-ms-error-code"), took, retried)
+	case resp.StatusCode >= http.StatusBadRequest:
+		// often expected, e.g. the 404 of an existence check
+		p.logger.Debugf("%s %s (try %d): %d %s after %v", raw.Method, target, try,
+			resp.StatusCode, resp.Header.Get("x-ms-error-code"), took)
+	default:
+		p.logger.Debugf("%s %s (try %d): %d after %v", raw.Method, target, try, resp.StatusCode, took)
+	}
+	return resp, err
+}
@@ -0,0 +1,143 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// DefaultManagementURL is the Azure Resource Manager endpoint of the public cloud.
+const DefaultManagementURL = "https://management.azure.com"
+
+const managementPolicyAPIVersion = "2023-05-01"
+
+// SetAzureManagementPolicy replaces the lifecycle management policy of a storage
+// account, rules that e.g. move blobs to the cool tier or delete them by age, with
+// policyJSON, the "policy" object of the resource: {"rules": [...]}.
+//
+// The policy is a resource of Azure Resource Manager, not of the Blob service, and
+// an account key does not authorize it: it takes the resource ID of the account,
+// /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Storage/storageAccounts/<account>,
+// and a Microsoft Entra token for managementURL, e.g. of `az account get-access-token`.
+// An empty managementURL is DefaultManagementURL.
+func SetAzureManagementPolicy(
+	managementURL, accountResourceID, token string,
+	policyJSON []byte,
+	httpClient *http.Client,
+) error {
+	var policy struct {
+		Rules []json.RawMessage `json:"rules"`
+	}
+	if err := json.Unmarshal(policyJSON, &policy); err != nil || len(policy.Rules) == 0 {
+		return fmt.Errorf("invalid management policy, expected {\"rules\": [...]}")
+	}
+	body, err := json.Marshal(map[string]any{
+		"properties": map[string]json.RawMessage{"policy": policyJSON},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := managementPolicyDo(http.MethodPut, managementURL, accountResourceID, token, body, httpClient)
+	if err != nil {
+		return fmt.Errorf("could not set the management policy: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetAzureManagementPolicy returns the "policy" object of the management policy of
+// the account, nil when it has none. It takes what SetAzureManagementPolicy does.
+func GetAzureManagementPolicy(
+	managementURL, accountResourceID, token string,
+	httpClient *http.Client,
+) ([]byte, error) {
+	resp, err := managementPolicyDo(http.MethodGet, managementURL, accountResourceID, token, nil, httpClient)
+	if StatusFromError(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the management policy: %w", err)
+	}
+	defer resp.Body.Close()
+	var resource struct {
+		Properties struct {
+			Policy json.RawMessage `json:"policy"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&resource); err != nil {
+		return nil, fmt.Errorf("could not decode the management policy: %v", err)
+	}
+	return resource.Properties.Policy, nil
+}
+
+// DeleteAzureManagementPolicy removes the management policy of the account, if it
+// has one. It takes what SetAzureManagementPolicy does.
+func DeleteAzureManagementPolicy(
+	managementURL, accountResourceID, token string,
+	httpClient *http.Client,
+) error {
+	resp, err := managementPolicyDo(http.MethodDelete, managementURL, accountResourceID, token, nil, httpClient)
+	if err != nil {
+		return fmt.Errorf("could not delete the management policy: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// managementPolicyDo sends a request for the management policy of the account,
+// returning the response of a 2xx status.
+func managementPolicyDo(
+	method, managementURL, accountResourceID, token string,
+	body []byte,
+	httpClient *http.Client,
+) (*http.Response, error) {
+	if !strings.HasPrefix(accountResourceID, "/subscriptions/") ||
+		!strings.Contains(accountResourceID, "/providers/Microsoft.Storage/storageAccounts/") {
+		return nil, fmt.Errorf("invalid storage account resource ID %q", accountResourceID)
+	}
+	if managementURL == "" {
+		managementURL = DefaultManagementURL
+	}
+	options := clientOptionsFromHTTP(httpClient)
+	pl := runtime.NewPipeline("azureutil", "v1.0.0", runtime.PipelineOptions{
+		PerRetry: []policy.Policy{bearerTokenPolicy{token: token}},
+	}, &options)
+	req, err := runtime.NewRequest(context.Background(), method, strings.TrimSuffix(managementURL, "/")+
+		strings.TrimSuffix(accountResourceID, "/")+"/managementPolicies/default?api-version="+managementPolicyAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if err := req.SetBody(readSeekCloser{bytes.NewReader(body)}, "application/json"); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, compactResponseError(runtime.NewResponseError(resp))
+	}
+	return resp, nil
+}
+
+// bearerTokenPolicy authorizes each try of a request with a Microsoft Entra token.
+type bearerTokenPolicy struct {
+	token string
+}
+
+func (p bearerTokenPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.Raw().Header.Set("Authorization", "Bearer "+p.token)
+	return req.Next()
+}
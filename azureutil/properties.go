@@ -0,0 +1,256 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+// BlobProperties describe the content of a blob rather than its storage: what has to
+// be kept to restore a downloaded blob as it was. Content-MD5 is left out, the
+// service records it from the content again.
+type BlobProperties struct {
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	// reported only, a restored blob gets the immutability of its container
+	Immutability BlobImmutability `json:"-"`
+}
+
+// BlobImmutability is the WORM state of a blob: its immutability policy, if it has
+// one, and its legal hold.
+type BlobImmutability struct {
+	Mode      string    // of the policy, Locked or Unlocked, empty without one
+	ExpiresOn time.Time // until when the policy keeps the blob unchanged
+	LegalHold bool
+}
+
+// PolicyActive reports whether an immutability policy keeps the blob unchanged at now.
+func (i BlobImmutability) PolicyActive(now time.Time) bool {
+	return (i.Mode == string(blob.ImmutabilityPolicyModeLocked) ||
+		i.Mode == string(blob.ImmutabilityPolicyModeUnlocked)) && now.Before(i.ExpiresOn)
+}
+
+// ErrNotImmutable is returned by CheckAzureBlobImmutable for a blob that no
+// immutability policy keeps unchanged.
+var ErrNotImmutable = errors.New("blob is not under an active immutability policy")
+
+// GetAzureBlobProperties returns the content properties, metadata and index tags of
+// a blob. Tags are only requested when the blob has some.
+func GetAzureBlobProperties(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobProperties, error) {
+	ctx := context.Background()
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return BlobProperties{}, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	resp, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return BlobProperties{}, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	props := BlobProperties{
+		ContentType:        deref(resp.ContentType),
+		ContentEncoding:    deref(resp.ContentEncoding),
+		ContentLanguage:    deref(resp.ContentLanguage),
+		ContentDisposition: deref(resp.ContentDisposition),
+		CacheControl:       deref(resp.CacheControl),
+		Immutability:       blobImmutability(resp),
+	}
+	for k, v := range resp.Metadata {
+		if props.Metadata == nil {
+			props.Metadata = map[string]string{}
+		}
+		props.Metadata[k] = deref(v)
+	}
+
+	if resp.TagCount != nil && *resp.TagCount > 0 {
+		tags, err := blobClient.GetTags(ctx, nil)
+		if err != nil {
+			return BlobProperties{}, fmt.Errorf("could not get blob tags: %w", compactResponseError(err))
+		}
+		props.Tags = map[string]string{}
+		for _, tag := range tags.BlobTagSet {
+			props.Tags[deref(tag.Key)] = deref(tag.Value)
+		}
+	}
+	return props, nil
+}
+
+func blobImmutability(resp blob.GetPropertiesResponse) BlobImmutability {
+	i := BlobImmutability{LegalHold: resp.LegalHold != nil && *resp.LegalHold}
+	if resp.ImmutabilityPolicyMode != nil {
+		i.Mode = string(*resp.ImmutabilityPolicyMode)
+	}
+	if resp.ImmutabilityPolicyExpiresOn != nil {
+		i.ExpiresOn = *resp.ImmutabilityPolicyExpiresOn
+	}
+	return i
+}
+
+// CheckAzureBlobImmutable returns the immutability of a blob, with ErrNotImmutable
+// unless a policy that has not expired keeps it unchanged, e.g. for content whose
+// provenance has to be shown. A legal hold alone does not do, it can be cleared at
+// any time.
+func CheckAzureBlobImmutable(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobImmutability, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return BlobImmutability{}, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	resp, err := blobClient.GetProperties(context.Background(), nil)
+	if err != nil {
+		return BlobImmutability{}, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	i := blobImmutability(resp)
+	if !i.PolicyActive(time.Now()) {
+		switch {
+		case i.Mode != "" && i.Mode != string(blob.ImmutabilityPolicyModeMutable):
+			return i, fmt.Errorf("%s: %w (%s policy expired on %v)", remoteFile, ErrNotImmutable, i.Mode,
+				i.ExpiresOn.Format(time.RFC3339))
+		case i.LegalHold:
+			return i, fmt.Errorf("%s: %w (under a legal hold only)", remoteFile, ErrNotImmutable)
+		}
+		return i, fmt.Errorf("%s: %w", remoteFile, ErrNotImmutable)
+	}
+	return i, nil
+}
+
+// BlobState is what the service keeps about a blob besides its content and tier:
+// its lease and, for a blob written by Copy Blob, the copy.
+type BlobState struct {
+	BlobType     string    `json:"blobType"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	Lease        BlobLease `json:"lease"`
+	Copy         *BlobCopy `json:"copy,omitempty"` // nil unless the blob was copied
+}
+
+// BlobLease is the lease of a blob, State is "available" when there is none.
+type BlobLease struct {
+	State    string `json:"state,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Duration string `json:"duration,omitempty"` // infinite or fixed, set while leased
+}
+
+// BlobCopy is the last Copy Blob operation that wrote a blob.
+type BlobCopy struct {
+	ID          string     `json:"id,omitempty"`
+	Status      string     `json:"status"`
+	Description string     `json:"description,omitempty"`
+	Source      string     `json:"source,omitempty"`
+	Progress    string     `json:"progress,omitempty"` // bytes copied/total
+	Completed   *time.Time `json:"completed,omitempty"`
+}
+
+// GetAzureBlobState returns the type, lease and copy state of a blob.
+func GetAzureBlobState(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobState, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return BlobState{}, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	resp, err := blobClient.GetProperties(context.Background(), nil)
+	if err != nil {
+		return BlobState{}, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	state := BlobState{
+		BlobType: deref(resp.BlobType),
+		ETag:     deref(resp.ETag),
+		Lease: BlobLease{
+			State:    deref(resp.LeaseState),
+			Status:   deref(resp.LeaseStatus),
+			Duration: deref(resp.LeaseDuration),
+		},
+	}
+	if resp.LastModified != nil {
+		state.LastModified = *resp.LastModified
+	}
+	if resp.CopyStatus != nil {
+		state.Copy = &BlobCopy{
+			ID:          deref(resp.CopyID),
+			Status:      deref(resp.CopyStatus),
+			Description: deref(resp.CopyStatusDescription),
+			Source:      deref(resp.CopySource),
+			Progress:    deref(resp.CopyProgress),
+			Completed:   resp.CopyCompletionTime,
+		}
+	}
+	return state, nil
+}
+
+// UploadBlockListToBlobWithProperties is UploadBlockListToBlob committing the blob
+// with props, e.g. as returned by GetAzureBlobProperties for the original.
+// A nil props commits the blob without any.
+func UploadBlockListToBlobWithProperties(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	blocks []string,
+	props *BlobProperties,
+) error {
+	return uploadBlockList(accountURL, accountName, accountKey, containerName, remoteFile,
+		httpClient, blocks, props.commitOptions())
+}
+
+// commitOptions returns the Put Block List options that set p, nil for a nil p.
+func (p *BlobProperties) commitOptions() *blockblob.CommitBlockListOptions {
+	if p == nil {
+		return nil
+	}
+	opts := &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType:        ptrOrNil(p.ContentType),
+			BlobContentEncoding:    ptrOrNil(p.ContentEncoding),
+			BlobContentLanguage:    ptrOrNil(p.ContentLanguage),
+			BlobContentDisposition: ptrOrNil(p.ContentDisposition),
+			BlobCacheControl:       ptrOrNil(p.CacheControl),
+		},
+	}
+	for k, v := range p.Metadata {
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]*string{}
+		}
+		opts.Metadata[k] = &v
+	}
+	if len(p.Tags) > 0 {
+		opts.Tags = p.Tags
+	}
+	return opts
+}
+
+func deref[T ~string](s *T) string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+func ptrOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
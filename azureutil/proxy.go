@@ -0,0 +1,68 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// NewHTTPClientWithInsecureProxy is NewHTTPClientWithTLS sending every request
+// through proxy, an https:// proxy whose certificate is NOT verified, e.g. a
+// corporate proxy with a self-signed certificate. Only the connection to the proxy
+// is relaxed: the origin is reached through a CONNECT tunnel and verified with
+// tlsConfig as always. warn is called with every request, for the caller to say
+// loudly that the proxy could be anyone.
+func NewHTTPClientWithInsecureProxy(t ClientTimeouts, tlsConfig *tls.Config, proxy *url.URL,
+	warn func(req *http.Request)) (*http.Client, error) {
+	if proxy == nil || proxy.Scheme != "https" {
+		return nil, fmt.Errorf("the proxy has to be an https:// URL, got %v", proxy)
+	}
+	if tlsConfig != nil && tlsConfig.InsecureSkipVerify {
+		return nil, fmt.Errorf("the origin has to be verified")
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "443")
+	}
+
+	transport := newTransport(t, tlsConfig)
+	transport.Proxy = http.ProxyURL(proxy)
+	// the transport dials TLS itself only for the first hop, which through an https://
+	// proxy is the proxy; the tunnelled origin gets TLSClientConfig
+	dialer := newDialer(t)
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != proxyAddr {
+			return nil, fmt.Errorf("refusing to connect to %s outside of the proxy %s", addr, proxyAddr)
+		}
+		if t.TLSHandshake > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t.Dial+t.TLSHandshake)
+			defer cancel()
+		}
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config:    &tls.Config{ServerName: proxy.Hostname(), InsecureSkipVerify: true},
+		}
+		return tlsDialer.DialContext(ctx, network, addr)
+	}
+	return newHTTPClient(t, &warnTransport{base: transport, warn: warn}), nil
+}
+
+// warnTransport calls warn before each request.
+type warnTransport struct {
+	base http.RoundTripper
+	warn func(req *http.Request)
+}
+
+func (t *warnTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.warn != nil {
+		t.warn(req)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,180 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// DefaultRetryStatusCodes are the HTTP statuses retried when no override is configured.
+var DefaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// neverRetryStatusCodes can not succeed on a retry (bad credentials, missing blob),
+// so they are dropped from any configured list.
+var neverRetryStatusCodes = map[int]bool{
+	http.StatusUnauthorized: true,
+	http.StatusForbidden:    true,
+	http.StatusNotFound:     true,
+}
+
+// RetryPolicy decides which failed requests are retried and how long to wait in between.
+type RetryPolicy struct {
+	MaxRetries    int           // retries after the first attempt
+	RetryDelay    time.Duration // base delay, doubled on every retry
+	MaxRetryDelay time.Duration // upper bound for a single delay, before jitter
+	StatusCodes   []int         // HTTP statuses considered transient
+	// JitterFraction spreads each Backoff delay uniformly over ±fraction of it, so
+	// that clients failing together do not retry together. 0 disables it.
+	JitterFraction float64
+}
+
+// DefaultRetryPolicy returns the policy used when SetRetryPolicy was never called.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		RetryDelay:     800 * time.Millisecond,
+		MaxRetryDelay:  60 * time.Second,
+		StatusCodes:    DefaultRetryStatusCodes,
+		JitterFraction: DefaultJitterFraction,
+	}
+}
+
+// DefaultJitterFraction is the JitterFraction of DefaultRetryPolicy.
+const DefaultJitterFraction = 0.2
+
+var (
+	retryMu     sync.RWMutex
+	retryPolicy = DefaultRetryPolicy()
+)
+
+// SetRetryPolicy replaces the retry policy used by every azureutil call.
+func SetRetryPolicy(p RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryPolicy = p
+}
+
+// GetRetryPolicy returns the retry policy currently in effect.
+func GetRetryPolicy() RetryPolicy {
+	retryMu.RLock()
+	defer retryMu.RUnlock()
+	return retryPolicy
+}
+
+// IsRetryableStatus reports whether a response with this status should be retried.
+// 401, 403 and 404 are never retryable, whatever StatusCodes contains.
+func (p RetryPolicy) IsRetryableStatus(status int) bool {
+	if neverRetryStatusCodes[status] {
+		return false
+	}
+	for _, code := range p.StatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// JitterEnabled reports whether Backoff randomizes its delays.
+func (p RetryPolicy) JitterEnabled() bool {
+	return p.JitterFraction > 0
+}
+
+// Backoff returns the delay before retry number attempt (starting at 1), within
+// JitterFraction of the exponential one.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.RetryDelay
+	for i := 1; i < attempt && delay < p.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxRetryDelay > 0 && delay > p.MaxRetryDelay {
+		delay = p.MaxRetryDelay
+	}
+	if p.JitterEnabled() {
+		fraction := min(p.JitterFraction, 1)
+		delay = time.Duration(float64(delay) * (1 - fraction + 2*fraction*rand.Float64()))
+	}
+	return delay
+}
+
+// retryOptions converts the policy into the SDK pipeline retry options. The SDK
+// jitters its delays itself, between 0.8 and 1.3 times the exponential one.
+func (p RetryPolicy) retryOptions() policy.RetryOptions {
+	codes := []int{}
+	for _, code := range p.StatusCodes {
+		if !neverRetryStatusCodes[code] {
+			codes = append(codes, code)
+		}
+	}
+	maxRetries := int32(p.MaxRetries)
+	if maxRetries == 0 {
+		// zero means "use the SDK default" to azcore, we mean "do not retry"
+		maxRetries = -1
+	}
+	return policy.RetryOptions{
+		MaxRetries:    maxRetries,
+		RetryDelay:    p.RetryDelay,
+		MaxRetryDelay: p.MaxRetryDelay,
+		StatusCodes:   codes,
+	}
+}
+
+// ParseRetryStatusCodes parses a comma-separated list of HTTP statuses, e.g. "429,503".
+func ParseRetryStatusCodes(s string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status %q", field)
+		}
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes, nil
+}
+
+var statusInErrorRe = regexp.MustCompile(`(?:RESPONSE|StatusCode:?|HTTP)\s*(\d{3})`)
+
+// StatusFromError extracts the HTTP status carried by err, or 0 if there is none.
+// Errors that were flattened to strings (as zedUpload does) are parsed from their text.
+func StatusFromError(err error) int {
+	if err == nil {
+		return 0
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	if m := statusInErrorRe.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
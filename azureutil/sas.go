@@ -0,0 +1,153 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+)
+
+// DefaultSasClockSkew is how far GenerateBlobSasURI backdates the SAS start time, so
+// that a service whose clock runs behind ours does not reject the SAS as not yet valid.
+const DefaultSasClockSkew = 5 * time.Minute
+
+// Oldest signed versions of each string-to-sign layout of a blob service SAS.
+const (
+	sasVersionResource        = "2018-11-09" // adds signedResource and signedSnapshotTime
+	sasVersionEncryptionScope = "2020-12-06" // adds signedEncryptionScope
+)
+
+// SasOptions tunes GenerateBlobSasURIWithOptions.
+type SasOptions struct {
+	// Version is the signed version (sv), sas.Version when empty. Versions from
+	// 2018-11-09 on are supported, each is signed with its own list of fields.
+	Version string
+	// StartSkew backdates the start time (st) to tolerate clock skew; 0 starts now.
+	StartSkew time.Duration
+	// Permissions are the signed permissions (sp) in any order, e.g. "rw"; read
+	// only when empty. See ParseSasPermissions.
+	Permissions string
+	// ContentDisposition (rscd) replaces the Content-Disposition of the blob in the
+	// responses to this SAS, so that links to the same blob can save it under
+	// different names. Empty keeps the blob's own.
+	ContentDisposition string
+	// Identifier (si) is the ID of a stored access policy of the container, see
+	// SetContainerAccessPolicies, for a SAS that can be revoked by removing the
+	// policy. The policy sets what the SAS leaves out: its permissions unless
+	// Permissions are given, and its start and expiry when the duration is 0.
+	Identifier string
+}
+
+// DefaultSasOptions returns the options GenerateBlobSasURI uses.
+func DefaultSasOptions() SasOptions {
+	return SasOptions{Version: sas.Version, StartSkew: DefaultSasClockSkew}
+}
+
+// ParseSasPermissions checks the permissions of a blob SAS, one letter each, and
+// returns them in the order the service expects, e.g. "rw" for "wr".
+func ParseSasPermissions(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("no SAS permissions")
+	}
+	var p sas.BlobPermissions
+	for _, r := range s {
+		switch r {
+		case 'r':
+			p.Read = true
+		case 'a':
+			p.Add = true
+		case 'c':
+			p.Create = true
+		case 'w':
+			p.Write = true
+		case 'd':
+			p.Delete = true
+		case 'x':
+			p.DeletePreviousVersion = true
+		case 'y':
+			p.PermanentDelete = true
+		case 't':
+			p.Tag = true
+		case 'm':
+			p.Move = true
+		case 'e':
+			p.Execute = true
+		case 'o':
+			p.Ownership = true
+		case 'p':
+			p.Permissions = true
+		case 'i':
+			p.SetImmutabilityPolicy = true
+		default:
+			return "", fmt.Errorf("unsupported SAS permission %q in %q, expected some of racwdxytmeopi", r, s)
+		}
+	}
+	return p.String(), nil
+}
+
+// signBlobSas returns the query of an HTTPS-only blob SAS with permissions, valid
+// from start to expiry, signed for version. A non-empty contentDisposition is
+// signed and sent as the rscd override. With a stored access policy identifier,
+// empty permissions and zero times are left to the policy.
+func signBlobSas(accountName, accountKey, containerName, blobName, version, permissions, contentDisposition,
+	identifier string, start, expiry time.Time) (string, error) {
+	if version < sasVersionResource {
+		return "", fmt.Errorf("unsupported SAS version %q, need %s or later", version, sasVersionResource)
+	}
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials: %v", err)
+	}
+
+	const resource = "b"
+	var st, se string
+	if !start.IsZero() {
+		st = start.UTC().Format(sas.TimeFormat)
+	}
+	if !expiry.IsZero() {
+		se = expiry.UTC().Format(sas.TimeFormat)
+	}
+	canonicalName := "/blob/" + accountName + "/" + containerName + "/" + blobName
+
+	// https://learn.microsoft.com/rest/api/storageservices/create-service-sas#version-2020-12-06-and-later
+	fields := []string{
+		permissions,
+		st,
+		se,
+		canonicalName,
+		identifier,
+		"", // signedIP
+		string(sas.ProtocolHTTPS),
+		version,
+		resource,
+		"", // signedSnapshotTime
+	}
+	if version >= sasVersionEncryptionScope {
+		fields = append(fields, "") // signedEncryptionScope
+	}
+	fields = append(fields, "", contentDisposition, "", "", "") // rscc, rscd, rsce, rscl, rsct
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	query := url.Values{
+		"sv":  {version},
+		"spr": {string(sas.ProtocolHTTPS)},
+		"sr":  {resource},
+		"sig": {base64.StdEncoding.EncodeToString(mac.Sum(nil))},
+	}
+	for k, v := range map[string]string{"st": st, "se": se, "sp": permissions, "si": identifier,
+		"rscd": contentDisposition} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	return query.Encode(), nil
+}
@@ -0,0 +1,42 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// SnapshotAzureBlob takes a read-only snapshot of a blob and returns its snapshot
+// timestamp, which identifies it from then on.
+func SnapshotAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (string, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to get blob client: %v", err)
+	}
+	resp, err := blobClient.CreateSnapshot(context.Background(), nil)
+	if err != nil {
+		return "", fmt.Errorf("could not snapshot blob: %w", compactResponseError(err))
+	}
+	return deref(resp.Snapshot), nil
+}
+
+// ListAzureBlobSnapshots lists the blobs whose name starts with prefix together with
+// their snapshots (List Blobs with include=snapshots). Each snapshot is a BlobInfo of
+// its own with Snapshot set; Azure lists the snapshots of a blob, oldest first, before
+// the blob itself.
+func ListAzureBlobSnapshots(
+	accountURL, accountName, accountKey, containerName, prefix string,
+	httpClient *http.Client,
+) ([]BlobInfo, error) {
+	return listAzureBlobInfo(accountURL, accountName, accountKey, containerName, prefix,
+		httpClient, container.ListBlobsInclude{Snapshots: true})
+}
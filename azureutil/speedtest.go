@@ -0,0 +1,88 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SpeedTestOptions tunes SpeedTestAzureBlob.
+type SpeedTestOptions struct {
+	// Offset and Length select the bytes read, Length 0 reading to the end of the blob.
+	Offset int64
+	Length int64
+	// Concurrency is the number of chunks read in parallel, 0 for as many as
+	// DownloadAzureBlob reads.
+	Concurrency int
+}
+
+// ChunkTiming is how long one chunk of a speed test took.
+type ChunkTiming struct {
+	Size    int64
+	Latency time.Duration // until the response headers arrived
+	Elapsed time.Duration // until the last byte arrived
+}
+
+// SpeedTestResult is what SpeedTestAzureBlob measured.
+type SpeedTestResult struct {
+	Bytes   int64
+	Elapsed time.Duration // of the whole transfer
+	Chunks  []ChunkTiming // in the order they finished
+}
+
+// SpeedTestAzureBlob downloads a range of the blob as DownloadAzureBlob does, in
+// parallel SingleMB chunks, but discards the data, to measure the throughput of the
+// network rather than of the local disk.
+func SpeedTestAzureBlob(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	opts SpeedTestOptions,
+) (SpeedTestResult, error) {
+	if opts.Offset < 0 || opts.Length < 0 || opts.Concurrency < 0 {
+		return SpeedTestResult{}, fmt.Errorf("invalid speed test range %d+%d or concurrency %d",
+			opts.Offset, opts.Length, opts.Concurrency)
+	}
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return SpeedTestResult{}, fmt.Errorf("failed to get clients: %v", err)
+	}
+
+	ctx := context.Background()
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return SpeedTestResult{}, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	objSize := *props.ContentLength
+	if opts.Offset >= objSize && objSize > 0 {
+		return SpeedTestResult{}, fmt.Errorf("offset %d is beyond the %d bytes of %s", opts.Offset, objSize, remoteFile)
+	}
+	count := objSize - opts.Offset
+	if opts.Length > 0 && opts.Length < count {
+		count = opts.Length
+	}
+	parallel := opts.Concurrency
+	if parallel == 0 {
+		parallel = parallelism
+	}
+
+	var result SpeedTestResult
+	started := time.Now()
+	err = downloadChunks(ctx, blobClient, objSize, opts.Offset, count, parallel, nil, discardAt{}, func(c chunkStat) {
+		result.Bytes += c.size
+		result.Chunks = append(result.Chunks, ChunkTiming{Size: c.size, Latency: c.latency, Elapsed: c.elapsed})
+	})
+	result.Elapsed = time.Since(started)
+	return result, err
+}
+
+// discardAt is an io.WriterAt that drops what it is given.
+type discardAt struct{}
+
+func (discardAt) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,81 @@
+// Copyright(c) 2025 Zededa, Inc.
+// All rights reserved.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// BlobTier is the access tier of a blob and, while it is rehydrated out of the
+// Archive tier, the state of that.
+type BlobTier struct {
+	Tier string `json:"tier"` // Hot, Cool, Cold or Archive
+	// e.g. rehydrate-pending-to-hot, empty unless a rehydration is pending
+	ArchiveStatus     string `json:"archiveStatus,omitempty"`
+	RehydratePriority string `json:"rehydratePriority,omitempty"` // Standard or High, set while rehydrating
+}
+
+// Rehydrating reports whether the blob is still on its way out of the Archive tier.
+func (t BlobTier) Rehydrating() bool {
+	return t.ArchiveStatus != ""
+}
+
+// GetAzureBlobTier returns the access tier of a blob.
+func GetAzureBlobTier(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+) (BlobTier, error) {
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return BlobTier{}, fmt.Errorf("failed to get blob client: %v", err)
+	}
+
+	resp, err := blobClient.GetProperties(context.Background(), nil)
+	if err != nil {
+		return BlobTier{}, fmt.Errorf("could not get blob properties: %w", compactResponseError(err))
+	}
+	return BlobTier{
+		Tier:              deref(resp.AccessTier),
+		ArchiveStatus:     deref(resp.ArchiveStatus),
+		RehydratePriority: deref(resp.RehydratePriority),
+	}, nil
+}
+
+// SetAzureBlobTier moves a blob to tier. Moving it out of the Archive tier only
+// starts a rehydration at priority (Standard when empty), GetAzureBlobTier tells
+// when it is done.
+func SetAzureBlobTier(
+	accountURL, accountName, accountKey, containerName, remoteFile string,
+	httpClient *http.Client,
+	tier, priority string,
+) error {
+	defer forgetBlobs(accountURL, containerName, remoteFile)
+	if !slices.Contains(blob.PossibleAccessTierValues(), blob.AccessTier(tier)) {
+		return fmt.Errorf("unsupported access tier %q", tier)
+	}
+	opts := &blob.SetTierOptions{}
+	if priority != "" {
+		if !slices.Contains(blob.PossibleRehydratePriorityValues(), blob.RehydratePriority(priority)) {
+			return fmt.Errorf("unsupported rehydrate priority %q", priority)
+		}
+		p := blob.RehydratePriority(priority)
+		opts.RehydratePriority = &p
+	}
+
+	_, blobClient, err := getContainerAndBlockBlobClients(
+		accountURL, accountName, accountKey, containerName, remoteFile, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to get blob client: %v", err)
+	}
+	if _, err := blobClient.SetTier(context.Background(), blob.AccessTier(tier), opts); err != nil {
+		return fmt.Errorf("could not set access tier: %w", compactResponseError(err))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// BlockListConfig is everything runBlockList needs once flags and environment are resolved.
+type BlockListConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	HTTPClient  *http.Client
+}
+
+// runBlockList writes the committed and the uncommitted blocks of cfg.RemoteFile to
+// w, with their sizes, e.g. to see how far a stuck upload got: the blocks runUpload
+// staged and has not committed yet are the uncommitted ones.
+func runBlockList(cfg BlockListConfig, w io.Writer) error {
+	committed, uncommitted, err := azure.GetAzureBlocks(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	if err != nil {
+		return err
+	}
+	if err := writeBlocks(w, "Committed", cfg.RemoteFile, committed); err != nil {
+		return err
+	}
+	return writeBlocks(w, "Uncommitted", cfg.RemoteFile, uncommitted)
+}
+
+func writeBlocks(w io.Writer, state, remoteFile string, blocks []azure.Block) error {
+	var total int64
+	for _, b := range blocks {
+		total += b.Size
+	}
+	if _, err := fmt.Fprintf(w, "%s blocks of %s: %d, %d bytes\n", state, remoteFile, len(blocks), total); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if _, err := fmt.Fprintf(w, "  %s %d%s\n", b.ID, b.Size, blockIDText(b.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockIDText is the decoded block ID, quoted after a space, when it is printable
+// text as the IDs of runUpload are; empty otherwise.
+func blockIDText(id string) string {
+	raw, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	text := string(raw)
+	if strconv.Quote(text) != `"`+text+`"` {
+		return ""
+	}
+	return " " + strconv.Quote(text)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBlockList(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	cfg := fakeAccountOf(srv).upload("upload.bin", localFile)
+	cfg.BlockSize = 4
+	require.NoError(t, os.WriteFile(localFile, []byte("0123456789"), 0644))
+	_, err := runUpload(cfg)
+	require.NoError(t, err)
+	// a second version gets stuck before its commit
+	require.NoError(t, os.WriteFile(localFile, []byte("abcdef"), 0644))
+	cfg.HTTPClient = &http.Client{Transport: &commitTransport{}}
+	_, err = runUpload(cfg)
+	require.ErrorContains(t, err, "connection reset")
+
+	var out bytes.Buffer
+	require.NoError(t, runBlockList(fakeAccountOf(srv).blockList("upload.bin"), &out))
+	committed, uncommitted, ok := bytes.Cut(out.Bytes(), []byte("Uncommitted blocks"))
+	require.True(t, ok, out.String())
+	require.Equal(t, "Committed blocks of upload.bin: 3, 10 bytes\n"+
+		"  YmxvY2stMDAwMDAwMDA= 4 \"block-00000000\"\n"+
+		"  YmxvY2stMDAwMDAwMDE= 4 \"block-00000001\"\n"+
+		"  YmxvY2stMDAwMDAwMDI= 2 \"block-00000002\"\n", string(committed))
+	require.Contains(t, string(uncommitted), " of upload.bin: 2, 6 bytes\n")
+	require.Contains(t, string(uncommitted), "  YmxvY2stMDAwMDAwMDA= 4 \"block-00000000\"\n")
+	require.Contains(t, string(uncommitted), "  YmxvY2stMDAwMDAwMDE= 2 \"block-00000001\"\n")
+}
+
+func TestBlockIDText(t *testing.T) {
+	require.Equal(t, ` "block-00000007"`, blockIDText(uploadBlockID(7)))
+	require.Empty(t, blockIDText("AAEC"), "not text")
+	require.Empty(t, blockIDText("not base64!"))
+}
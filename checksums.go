@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updateChecksumManifest records sum, the hex SHA-256 of localFile, in the
+// SHA256SUMS-style manifest, replacing what it said of localFile before, so that a
+// manifest shared by several runs lists each file downloaded once. localFile is
+// named relative to the directory of the manifest when it is under it, which is
+// where `sha256sum -c` is to be run; absolute otherwise.
+func updateChecksumManifest(manifest, localFile, sum string) error {
+	name, err := manifestName(manifest, localFile)
+	if err != nil {
+		return err
+	}
+	line := manifestLine(sum, name)
+	var lines []string
+	if f, err := os.Open(manifest); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			l := scanner.Text()
+			if l != "" && !sameManifestEntry(l, name) {
+				lines = append(lines, l)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read checksum manifest: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read checksum manifest: %w", err)
+	}
+	lines = append(lines, line)
+
+	// written aside and renamed, a failed run leaves the previous manifest whole
+	tmp := manifest + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum manifest: %w", err)
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write checksum manifest: %w", err)
+	}
+	return nil
+}
+
+// manifestName is how the manifest refers to localFile.
+func manifestName(manifest, localFile string) (string, error) {
+	abs, err := filepath.Abs(localFile)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(filepath.Dir(manifest))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(rel), nil
+	}
+	return abs, nil
+}
+
+// manifestLine is a line of sha256sum's text format, "<digest>  <name>". A name
+// with a backslash or a newline is escaped like sha256sum does, the line then
+// starting with a backslash.
+func manifestLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return sum + "  " + name
+	}
+	escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	return "\\" + sum + "  " + escaped
+}
+
+// sameManifestEntry reports whether line is the entry of name.
+func sameManifestEntry(line, name string) bool {
+	_, entry, ok := strings.Cut(line, "  ")
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(line, "\\") {
+		entry = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(entry)
+	}
+	return entry == name
+}
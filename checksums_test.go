@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// downloadInto runs a download of content to name in dir, recording it in manifest.
+func downloadInto(t *testing.T, dir, name, content, manifest string) {
+	d := &fakeDownloader{
+		content:  []byte(content),
+		attempts: [][]fakeEvent{{{localName: name, asize: int64(len(content))}}},
+	}
+	cfg := testConfig(t, d)
+	cfg.LocalFile = filepath.Join(dir, name)
+	cfg.ObjSize = int64(len(content))
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.NoError(t, updateChecksumManifest(manifest, cfg.LocalFile, result.SHA256))
+}
+
+// sha256sumCheck runs sha256sum -c on manifest in its directory.
+func sha256sumCheck(t *testing.T, manifest string) {
+	path, err := exec.LookPath("sha256sum")
+	if err != nil {
+		t.Skip("sha256sum is not installed")
+	}
+	cmd := exec.Command(path, "-c", "--strict", filepath.Base(manifest))
+	cmd.Dir = filepath.Dir(manifest)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "%s", out)
+}
+
+func TestChecksumManifestVerifies(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "os"), 0755))
+	downloadInto(t, dir, "disk.img", "disk", manifest)
+	downloadInto(t, dir, "os/kernel", "kernel", manifest)
+	downloadInto(t, dir, `odd\name`, "odd", manifest)
+	// downloaded again, other content: its entry is replaced
+	downloadInto(t, dir, "disk.img", "disk v2", manifest)
+
+	data, err := os.ReadFile(manifest)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasSuffix(lines[0], "  os/kernel"), lines[0])
+	require.True(t, strings.HasPrefix(lines[1], `\`) && strings.HasSuffix(lines[1], `  odd\\name`), lines[1])
+	require.True(t, strings.HasSuffix(lines[2], "  disk.img"), lines[2])
+	sha256sumCheck(t, manifest)
+}
+
+func TestChecksumManifestOutsideItsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "sums", "SHA256SUMS")
+	require.NoError(t, os.Mkdir(filepath.Dir(manifest), 0755))
+	downloadInto(t, dir, "disk.img", "disk", manifest)
+
+	data, err := os.ReadFile(manifest)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(string(data), "  "+filepath.Join(dir, "disk.img")+"\n"), "absolute")
+	sha256sumCheck(t, manifest)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// compareBufferSize is how much of each side firstDifference holds in memory.
+const compareBufferSize = 64 * 1024
+
+// CompareConfig is everything runCompare needs once flags and environment are resolved.
+// RemoteFile is compared with either OtherRemote, another blob of Container, or OtherLocal.
+type CompareConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	OtherRemote string
+	OtherLocal  string
+	// stream both sides to find the first differing byte when they differ
+	ByteDiff   bool
+	HTTPClient *http.Client
+}
+
+// CompareResult describes how two objects compare. The MD5s are only filled in when
+// the sizes match.
+type CompareResult struct {
+	A, B         string
+	SizeA, SizeB int64
+	MD5A, MD5B   string
+	// offset of the first differing byte, -1 unless a byte diff found one
+	FirstDiff int64
+}
+
+// Identical reports whether both objects have the same size and MD5.
+func (r CompareResult) Identical() bool {
+	return r.SizeA == r.SizeB && r.MD5A != "" && r.MD5A == r.MD5B
+}
+
+func (r CompareResult) String() string {
+	var s string
+	switch {
+	case r.Identical():
+		return fmt.Sprintf("%s and %s are identical (%d bytes, md5 %s)", r.A, r.B, r.SizeA, r.MD5A)
+	case r.SizeA != r.SizeB:
+		s = fmt.Sprintf("%s and %s differ in size: %d vs %d bytes", r.A, r.B, r.SizeA, r.SizeB)
+	default:
+		s = fmt.Sprintf("%s and %s differ in content: md5 %s vs %s", r.A, r.B, r.MD5A, r.MD5B)
+	}
+	if r.FirstDiff >= 0 {
+		s += fmt.Sprintf(", first difference at byte %d", r.FirstDiff)
+	}
+	return s
+}
+
+// compareSide is one of the two objects runCompare looks at.
+type compareSide struct {
+	name string
+	// size and, if known without reading the content, MD5
+	stat func() (int64, string, error)
+	open func() (io.ReadCloser, error)
+}
+
+// md5 returns the stored MD5 of the side or, if it has none, hashes its content.
+func (s compareSide) md5(stored string) (string, error) {
+	if stored != "" {
+		return stored, nil
+	}
+	r, err := s.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return readerMD5(r)
+}
+
+func (cfg CompareConfig) remoteSide(name string) compareSide {
+	return compareSide{
+		name: name,
+		stat: func() (int64, string, error) {
+			return azure.GetAzureBlobMetaData(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+				cfg.Container, name, cfg.HTTPClient)
+		},
+		open: func() (io.ReadCloser, error) {
+			r, _, err := azure.DownloadAzureBlobByChunks(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+				cfg.Container, name, "", cfg.HTTPClient)
+			return r, err
+		},
+	}
+}
+
+func localSide(path string) compareSide {
+	return compareSide{
+		name: path,
+		stat: func() (int64, string, error) {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return 0, "", err
+			}
+			return fi.Size(), "", nil
+		},
+		open: func() (io.ReadCloser, error) { return os.Open(path) },
+	}
+}
+
+// runCompare compares cfg.RemoteFile with the other object by size, then by MD5,
+// using the stored Content-MD5 of blobs that have one, and with cfg.ByteDiff finds
+// the first byte where they differ. Content is streamed, never held in memory.
+func runCompare(cfg CompareConfig) (CompareResult, error) {
+	a := cfg.remoteSide(cfg.RemoteFile)
+	var b compareSide
+	switch {
+	case cfg.OtherRemote != "" && cfg.OtherLocal != "":
+		return CompareResult{}, errors.New("compare with either a blob or a local file, not both")
+	case cfg.OtherRemote != "":
+		b = cfg.remoteSide(cfg.OtherRemote)
+	case cfg.OtherLocal != "":
+		b = localSide(cfg.OtherLocal)
+	default:
+		return CompareResult{}, errors.New("nothing to compare with")
+	}
+
+	result := CompareResult{A: a.name, B: b.name, FirstDiff: -1}
+	sizeA, storedA, err := a.stat()
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", a.name, err)
+	}
+	sizeB, storedB, err := b.stat()
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", b.name, err)
+	}
+	result.SizeA, result.SizeB = sizeA, sizeB
+
+	if sizeA == sizeB {
+		if result.MD5A, err = a.md5(storedA); err != nil {
+			return result, fmt.Errorf("%s: %w", a.name, err)
+		}
+		if result.MD5B, err = b.md5(storedB); err != nil {
+			return result, fmt.Errorf("%s: %w", b.name, err)
+		}
+		if result.Identical() {
+			return result, nil
+		}
+	}
+	if !cfg.ByteDiff {
+		return result, nil
+	}
+
+	ra, err := a.open()
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", a.name, err)
+	}
+	defer ra.Close()
+	rb, err := b.open()
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", b.name, err)
+	}
+	defer rb.Close()
+	result.FirstDiff, err = firstDifference(ra, rb)
+	return result, err
+}
+
+// firstDifference returns the offset of the first byte where a and b differ, the
+// length of the shorter one if it is a prefix of the other, or -1 if they are equal.
+func firstDifference(a, b io.Reader) (int64, error) {
+	bufA := make([]byte, compareBufferSize)
+	bufB := make([]byte, compareBufferSize)
+	var offset int64
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, errB
+		}
+		n := min(na, nb)
+		for i := range n {
+			if bufA[i] != bufB[i] {
+				return offset + int64(i), nil
+			}
+		}
+		if na != nb {
+			return offset + int64(n), nil
+		}
+		offset += int64(n)
+		if errA != nil { // a short read on both sides, they ended together
+			return -1, nil
+		}
+	}
+}
+
+// readerMD5 returns the hex MD5 of everything r yields.
+func readerMD5(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compareTestConfig(t *testing.T, blobs map[string]auditBlob) CompareConfig {
+	withoutRetries(t)
+	srv := httptest.NewServer(&auditStore{blobs: blobs})
+	t.Cleanup(srv.Close)
+	return fakeAccountOf(srv).compare("a.bin")
+}
+
+func TestRunCompareIdentical(t *testing.T) {
+	data := []byte("same content")
+	cfg := compareTestConfig(t, map[string]auditBlob{
+		"a.bin": {data: data, contentMD5: md5Of(data)},
+		"b.bin": {data: data}, // no stored MD5, hashed instead
+	})
+	cfg.OtherRemote = "b.bin"
+	cfg.ByteDiff = true
+
+	result, err := runCompare(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Identical(), result.String())
+	require.Equal(t, int64(-1), result.FirstDiff)
+	require.Contains(t, result.String(), "identical")
+
+	local := filepath.Join(t.TempDir(), "local.bin")
+	require.NoError(t, os.WriteFile(local, data, 0644))
+	cfg.OtherRemote, cfg.OtherLocal = "", local
+	result, err = runCompare(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Identical(), result.String())
+}
+
+func TestRunCompareSizeMismatch(t *testing.T) {
+	cfg := compareTestConfig(t, map[string]auditBlob{
+		"a.bin": {data: []byte("prefix and more")},
+		"b.bin": {data: []byte("prefix")},
+	})
+	cfg.OtherRemote = "b.bin"
+
+	result, err := runCompare(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Identical())
+	require.Equal(t, int64(len("prefix and more")), result.SizeA)
+	require.Equal(t, int64(len("prefix")), result.SizeB)
+	require.Empty(t, result.MD5A, "not hashed when the sizes differ")
+	require.Equal(t, int64(-1), result.FirstDiff)
+	require.Contains(t, result.String(), "differ in size")
+
+	cfg.ByteDiff = true
+	result, err = runCompare(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("prefix")), result.FirstDiff, "b ends where a goes on")
+}
+
+func TestRunCompareContentMismatch(t *testing.T) {
+	a := bytes.Repeat([]byte("x"), 3*compareBufferSize)
+	b := bytes.Clone(a)
+	b[2*compareBufferSize+7] = 'y'
+	cfg := compareTestConfig(t, map[string]auditBlob{"a.bin": {data: a, contentMD5: md5Of(a)}})
+	cfg.OtherLocal = filepath.Join(t.TempDir(), "local.bin")
+	require.NoError(t, os.WriteFile(cfg.OtherLocal, b, 0644))
+
+	result, err := runCompare(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Identical())
+	require.NotEqual(t, result.MD5A, result.MD5B)
+	require.Equal(t, int64(-1), result.FirstDiff)
+
+	cfg.ByteDiff = true
+	result, err = runCompare(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*compareBufferSize+7), result.FirstDiff)
+	require.Contains(t, result.String(), "differ in content")
+	require.Contains(t, result.String(), "first difference at byte 131079")
+}
+
+func TestRunCompareMissingBlob(t *testing.T) {
+	cfg := compareTestConfig(t, map[string]auditBlob{"a.bin": {data: []byte("x")}})
+	cfg.OtherRemote = "gone.bin"
+
+	_, err := runCompare(cfg)
+	require.ErrorContains(t, err, "gone.bin")
+}
+
+func TestFirstDifference(t *testing.T) {
+	for name, tc := range map[string]struct {
+		a, b string
+		want int64
+	}{
+		"equal":        {"abc", "abc", -1},
+		"both empty":   {"", "", -1},
+		"first byte":   {"abc", "xbc", 0},
+		"last byte":    {"abc", "abx", 2},
+		"a is shorter": {"ab", "abc", 2},
+		"b is empty":   {"abc", "", 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := firstDifference(strings.NewReader(tc.a), strings.NewReader(tc.b))
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
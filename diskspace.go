@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+)
+
+// ErrInsufficientSpace is returned when the filesystem of the local file cannot hold
+// the rest of the download.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// freeSpace returns the bytes available to unprivileged users on the filesystem of
+// dir. A variable so that tests can simulate a full disk.
+var freeSpace = func(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// checkDiskSpace fails if the filesystem localFile is on has no room for the
+// objSize bytes of the object that are not in done yet.
+func checkDiskSpace(localFile string, objSize int64, done types.DownloadedParts) error {
+	needed := objSize
+	for _, p := range done.Parts {
+		needed -= p.Size
+	}
+	if needed <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(localFile)
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("checking free space of %s: %w", dir, err)
+	}
+	if uint64(needed) > free {
+		return fmt.Errorf("%w: %s needs %d more bytes, %d free on %s",
+			ErrInsufficientSpace, localFile, needed, free, dir)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+)
+
+// withFreeSpace makes freeSpace report free bytes for the duration of the test.
+func withFreeSpace(t *testing.T, free uint64, err error) {
+	saved := freeSpace
+	freeSpace = func(string) (uint64, error) { return free, err }
+	t.Cleanup(func() { freeSpace = saved })
+}
+
+func TestRunDownloadChecksDiskSpace(t *testing.T) {
+	withFreeSpace(t, 3, nil)
+	d := &fakeDownloader{}
+	cfg := testConfig(t, d)
+	cfg.CheckDiskSpace = true
+
+	_, err := runDownload(cfg)
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+	require.ErrorContains(t, err, "needs 4 more bytes, 3 free")
+	require.Empty(t, d.started, "nothing is transferred")
+}
+
+func TestRunDownloadDiskSpaceCountsResumedParts(t *testing.T) {
+	withFreeSpace(t, 2, nil)
+	d := &fakeDownloader{
+		content:  []byte("data"),
+		attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+	}
+	cfg := testConfig(t, d)
+	cfg.CheckDiskSpace = true
+	cfg.ResumePartSize = 2
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+	saveDownloadedParts(cfg.LocalFile, types.DownloadedParts{
+		PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}},
+	})
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err, "only the 2 missing bytes need room")
+	require.True(t, result.Resumed)
+}
+
+func TestCheckDiskSpaceStatfsError(t *testing.T) {
+	withFreeSpace(t, 0, errors.New("no such file or directory"))
+	err := checkDiskSpace("/missing/local.bin", 4, types.DownloadedParts{})
+	require.ErrorContains(t, err, "checking free space of /missing")
+}
+
+func TestFreeSpace(t *testing.T) {
+	free, err := freeSpace(t.TempDir())
+	require.NoError(t, err)
+	require.NotZero(t, free)
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lf-edge/eve-libs/nettrace"
+	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// Config is everything runDownload needs once flags and environment are resolved.
+type Config struct {
+	Downloader downloader
+	RemoteFile string
+	LocalFile  string
+	// 0 when unknown: the transport then applies no size limit and the first
+	// progress event that reports a total sets it. MaxParts is not applied to it.
+	ObjSize int64
+	// identity of the content of RemoteFile, e.g. its ETag: parts saved for another
+	// are not resumed from. Empty when unknown, the parts are then trusted as they are.
+	ContentID string
+	// refuse to download an ObjSize above this, 0 means no limit
+	MaxSize int64
+	// fail before the transfer if the filesystem of LocalFile has no room for the
+	// rest of ObjSize, only meaningful when ObjSize is the actual size
+	CheckDiskSpace bool
+	// part size the transport resumes with, 0 when it always starts over
+	ResumePartSize int64
+	// an ObjSize of more parts than this is neither resumed nor has its progress
+	// saved, 0 means no limit
+	MaxParts int
+	// keep a hash of each part in the progress file and, before resuming, check
+	// that the local file still holds those bytes, downloading again the parts that
+	// do not. The hashes of a progress file that has them are checked and kept
+	// without it too.
+	VerifyResume bool
+	// percent of the parts VerifyResume checks, picked at random, for a quicker start
+	// of a large resume; all of them are checked once one of those fails. 0 checks
+	// all of them.
+	VerifyResumeSample float64
+	resumeRand         *rand.Rand // picks the sample, nil for a random one
+	// once a download that resumed from saved parts completes, whether from the
+	// progress file or on a retry, check the MD5 of the whole local file against
+	// ExpectedMD5 regardless of VerifyResume
+	VerifyAfterResume bool
+	// hex MD5 of RemoteFile, empty when it has none
+	ExpectedMD5 string
+	Retry       azure.RetryPolicy
+	// total retries allowed for the whole download, 0 means no limit
+	RetryBudget      int
+	ProgressInterval time.Duration
+	ProgressStep     float64 // percent, 0 disables
+	// nil writes no JSON progress events
+	ProgressEvents *progressEvents
+	// collect a network trace with each logged progress event
+	TracingEnabled bool
+	// nil when /metrics is not served
+	Metrics *downloadMetrics
+	// no progress updates at all
+	Quiet bool
+	// delete LocalFile and its progress file when the download fails for good, so
+	// that nothing is resumed from but nothing half-written is left behind either
+	CleanupOnError bool
+	// directory for the progress file instead of next to LocalFile, empty for none
+	Workspace string
+	// account, container and name of the remote object, keys its files in Workspace
+	RemoteID string
+	// the lines of the download, e.g. with the fields of transferLog; nil logs to
+	// the package's log
+	Log *base.LogObject
+}
+
+// logger returns cfg.Log, or the package's log without one.
+func (cfg Config) logger() *base.LogObject {
+	if cfg.Log != nil {
+		return cfg.Log
+	}
+	return log
+}
+
+// ErrRetryBudgetExceeded is returned once Config.RetryBudget retries are used up.
+var ErrRetryBudgetExceeded = errors.New("retry budget exceeded")
+
+// ErrProgressWentBackwards is returned when a transfer reports less downloaded than
+// before, a sign of a wrong resume offset or a local file opened anew.
+var ErrProgressWentBackwards = errors.New("download progress went backwards")
+
+// ErrResumeCorrupt is returned when a resumed download does not match the MD5 of
+// the remote object. The local file is kept for inspection.
+var ErrResumeCorrupt = errors.New("resumed download does not match the remote object")
+
+// ErrTooLarge is returned when Config.ObjSize exceeds Config.MaxSize.
+var ErrTooLarge = errors.New("object exceeds the size limit")
+
+// Result describes a finished download.
+type Result struct {
+	Bytes    int64
+	Duration time.Duration
+	Resumed  bool   // some parts were already on disk from an earlier run
+	MD5      string // hex digest of the local file
+	SHA256   string // hex digest of the local file, or of what was streamed
+	// failed attempts that were retried, and the error of the last of them
+	RetryCount         int
+	LastTransientError error
+}
+
+// transferEvent is the part of *zedUpload.DronaRequest the download loop reads.
+type transferEvent interface {
+	GetDoneParts() types.DownloadedParts
+	IsDnUpdate() bool
+	Progress() (int64, int64, uint)
+	IsError() bool
+	GetDnStatus() error
+	GetLocalName() string
+	GetAsize() int64
+}
+
+// digestEvent is a terminal transferEvent of a transfer that hashed the whole
+// object as it wrote it, so that the local file need not be read back for them.
+type digestEvent interface {
+	Digests() (md5Sum, sha256Sum string, ok bool)
+}
+
+// downloader starts transfers; zedUpload in production, a fake in tests.
+type downloader interface {
+	// start posts one download and streams its events; stop releases it.
+	start(remoteFile, localFile string, objSize int64,
+		doneParts types.DownloadedParts) (events <-chan transferEvent, stop func(), err error)
+	// trace collects the network trace of the running transfer. An error turns
+	// tracing off for the rest of the download.
+	trace() error
+}
+
+// defaultTraceLabel describes the network traces of a dronaDownloader without a traceLabel.
+const defaultTraceLabel = "DownloadTrace"
+
+// dronaDownloader runs transfers on a zedUpload endpoint.
+type dronaDownloader struct {
+	ep zedUpload.DronaEndPoint
+	// description of the collected network traces, to tell concurrent runs apart
+	traceLabel string
+}
+
+func (d dronaDownloader) start(remoteFile, localFile string, objSize int64,
+	doneParts types.DownloadedParts) (<-chan transferEvent, func(), error) {
+	respChan := make(chan *zedUpload.DronaRequest)
+
+	req := d.ep.NewRequest(zedUpload.SyncOpDownload, remoteFile, localFile, objSize, true, respChan)
+	if req == nil {
+		return nil, nil, fmt.Errorf("failed to create request")
+	}
+	req = req.WithDoneParts(doneParts)
+	req = req.WithCancel(context.Background())
+	req = req.WithLogger(logger)
+
+	req.Post()
+
+	done := make(chan struct{})
+	events, _ := forwardEvents(respChan, done, respDrainIdle)
+	stop := func() {
+		close(done)
+		req.Cancel()
+	}
+	return events, stop, nil
+}
+
+// respDrainIdle is how long forwardEvents keeps draining a channel nothing arrives on.
+const respDrainIdle = 5 * time.Second
+
+// forwardEvents passes the events of in on until the terminal one, the first that
+// is not a progress update, and closes the returned channel after it. zedUpload
+// never closes in and its worker blocks on every send, e.g. the final response of
+// a cancelled request: after the terminal event, or once done is closed, in is
+// drained until it is closed or quiet for idle. drained is closed when in is no
+// longer read.
+func forwardEvents[T transferEvent](in <-chan T, done <-chan struct{},
+	idle time.Duration) (events <-chan transferEvent, drained <-chan struct{}) {
+	out := make(chan transferEvent)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		forward(in, out, done)
+		close(out)
+		drain(in, idle)
+	}()
+	return out, finished
+}
+
+// forward passes events from in to out until the terminal one, in is closed or done is.
+func forward[T transferEvent](in <-chan T, out chan<- transferEvent, done <-chan struct{}) {
+	for {
+		select {
+		case resp, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case out <- resp:
+			case <-done:
+				return
+			}
+			if !resp.IsDnUpdate() {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// drain discards what arrives on in until it is closed or nothing arrived for idle.
+func drain[T any](in <-chan T, idle time.Duration) {
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-in:
+			if !ok {
+				return
+			}
+			timer.Reset(idle)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (d dronaDownloader) trace() (err error) {
+	label := d.traceLabel
+	if label == "" {
+		label = defaultTraceLabel
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("nettrace panicked: %v", r)
+		}
+	}()
+	_, _, err = d.ep.GetNetTrace(label)
+	return err
+}
+
+// enableNetTracing turns on network tracing of ep, reporting whether it could.
+// Tracing is a diagnostic, a failure to set it up (e.g. no permission for
+// conntrack in a container) must not stop the download.
+func enableNetTracing(ep zedUpload.DronaEndPoint, opts ...nettrace.TraceOpt) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("Net tracing disabled, setting it up panicked: %v", r)
+			ok = false
+		}
+	}()
+	if err := ep.WithNetTracing(opts...); err != nil {
+		log.Warnf("Net tracing disabled, setting it up failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// transferLog returns l with the fields that tell the lines of a transfer from
+// those of others in aggregated logs.
+func transferLog(l *base.LogObject, transport, container, blob string) *base.LogObject {
+	return l.CloneAndAddFields(map[string]interface{}{
+		"transport": transport,
+		"container": container,
+		"blob":      blob,
+	})
+}
+
+// runDownload downloads cfg.RemoteFile to cfg.LocalFile, resuming from the
+// progress file and retrying failed attempts as cfg.Retry and cfg.RetryBudget allow.
+func runDownload(cfg Config) (Result, error) {
+	log := cfg.logger()
+	if cfg.MaxSize > 0 && cfg.ObjSize > cfg.MaxSize {
+		return Result{}, fmt.Errorf("%w: %s is %d bytes, the limit is %d",
+			ErrTooLarge, cfg.RemoteFile, cfg.ObjSize, cfg.MaxSize)
+	}
+	started := time.Now()
+	progressBase := sidecarBase(cfg.Workspace, cfg.RemoteID, cfg.LocalFile)
+	if cfg.ResumePartSize > 0 && cfg.MaxParts > 0 {
+		// i.e. the part size of the transport is too small for the object
+		if n := partCount(cfg.ObjSize, cfg.ResumePartSize); n > int64(cfg.MaxParts) {
+			log.Noticef("%s takes %d parts of %d bytes, more than the %d allowed; its progress is not saved",
+				cfg.RemoteFile, n, cfg.ResumePartSize, cfg.MaxParts)
+			progressBase = ""
+		}
+	}
+	progress := loadProgress(progressBase)
+	if len(progress.Downloaded.Parts) > 0 && progress.ContentID != "" && cfg.ContentID != "" &&
+		progress.ContentID != cfg.ContentID {
+		log.Noticef("%s changed since %s was partially downloaded (%s, now %s); restarting download",
+			cfg.RemoteFile, cfg.LocalFile, progress.ContentID, cfg.ContentID)
+		progress = progressFile{}
+	}
+	downloadedParts := resumableParts(log, progress.Downloaded, cfg.ResumePartSize, cfg.LocalFile)
+	var hashes resumeHashes // nil hashes nothing
+	// recorded hashes are checked whatever cfg.VerifyResume, e.g. for a partial file
+	// moved with its progress file, and the download keeps them up
+	if cfg.VerifyResume || len(progress.Hashes) > 0 {
+		hashes = newResumeHashes(progress.Hashes)
+		if len(downloadedParts.Parts) > 0 {
+			downloadedParts = verifyResume(cfg, downloadedParts, hashes)
+		}
+	}
+	result := Result{Resumed: len(downloadedParts.Parts) > 0}
+	if cfg.CheckDiskSpace {
+		if err := checkDiskSpace(cfg.LocalFile, cfg.ObjSize, downloadedParts); err != nil {
+			return result, err
+		}
+	}
+	var throttle *progressThrottle // nil logs nothing
+	if !cfg.Quiet {
+		throttle = newProgressThrottle(cfg.ProgressInterval, cfg.ProgressStep)
+		throttle.events = cfg.ProgressEvents
+	}
+	tracing := cfg.TracingEnabled // until collecting a trace fails
+	resumed := result.Resumed     // including from the parts of a failed attempt
+
+	for failures, attempt := 0, 1; ; attempt++ {
+		attemptLog := log.CloneAndAddField("attempt", attempt)
+		before := downloadedParts.Hash()
+		done, err := downloadOnce(attemptLog, cfg.Downloader, cfg.RemoteFile, cfg.LocalFile, progressBase,
+			cfg.ContentID, &cfg.ObjSize, &downloadedParts, hashes, throttle, &tracing, cfg.Metrics)
+		if err == nil {
+			result.Bytes = done.GetAsize()
+			if d, ok := done.(digestEvent); ok {
+				result.MD5, result.SHA256, _ = d.Digests()
+			}
+			break
+		}
+		// MaxRetries bounds failures in a row, an attempt that saved parts starts over
+		failures++
+		if downloadedParts.Hash() != before {
+			failures = 1
+		}
+		status := azure.StatusFromError(err)
+		if failures > cfg.Retry.MaxRetries || !cfg.Retry.IsRetryableStatus(status) {
+			if cfg.CleanupOnError {
+				removePartial(log, cfg.LocalFile, progressBase)
+			}
+			return result, err
+		}
+		if cfg.RetryBudget > 0 && result.RetryCount >= cfg.RetryBudget {
+			if cfg.CleanupOnError {
+				removePartial(log, cfg.LocalFile, progressBase)
+			} else {
+				saveProgress(progressBase, cfg.ContentID, downloadedParts, hashes)
+			}
+			return result, fmt.Errorf("%w (%d retries): %w", ErrRetryBudgetExceeded, result.RetryCount, err)
+		}
+		result.RetryCount++
+		result.LastTransientError = err
+		resumed = resumed || len(downloadedParts.Parts) > 0
+		cfg.Metrics.incRetries()
+		delay := cfg.Retry.Backoff(failures)
+		attemptLog.Warnf("Download attempt %d failed with HTTP %d, retrying in %v", result.RetryCount, status, delay)
+		time.Sleep(delay)
+	}
+	result.Duration = time.Since(started)
+
+	if result.MD5 == "" {
+		// e.g. zedUpload, or a resumed download hashed only what it wrote
+		sum, sha, err := fileDigests(cfg.LocalFile)
+		if err != nil {
+			return result, err
+		}
+		result.MD5, result.SHA256 = sum, sha
+	}
+	sum := result.MD5
+	if cfg.VerifyAfterResume && resumed {
+		if err := verifyAfterResume(cfg, sum); err != nil {
+			return result, err
+		}
+		log.Noticef("Resumed download of %s matches the MD5 of %s", cfg.LocalFile, cfg.RemoteFile)
+	}
+	return result, nil
+}
+
+// verifyAfterResume checks sum, the MD5 of the local file of a resumed download,
+// against the one of the remote object.
+func verifyAfterResume(cfg Config, sum string) error {
+	if cfg.ExpectedMD5 == "" {
+		return fmt.Errorf("cannot verify the resumed download of %s, %s has no MD5; %s is kept",
+			cfg.LocalFile, cfg.RemoteFile, cfg.LocalFile)
+	}
+	if !strings.EqualFold(sum, cfg.ExpectedMD5) {
+		return fmt.Errorf("%w: %s has MD5 %s, %s has %s; %s is kept",
+			ErrResumeCorrupt, cfg.LocalFile, sum, cfg.RemoteFile, cfg.ExpectedMD5, cfg.LocalFile)
+	}
+	return nil
+}
+
+// removePartial deletes the local file of a failed download and its progress file,
+// if any.
+func removePartial(log *base.LogObject, localFile, progressBase string) {
+	names := []string{localFile}
+	if progressBase != "" {
+		names = append(names, progressBase+progressFileSuffix)
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to remove %s of the failed download: %s", name, err)
+		}
+	}
+	log.Noticef("Removed the partial download of %s", localFile)
+}
+
+// resumableParts returns the parts the transport can resume from, starting over
+// (and saying so) when it cannot use them.
+func resumableParts(log *base.LogObject, parts types.DownloadedParts, partSize int64,
+	localFile string) types.DownloadedParts {
+	if len(parts.Parts) == 0 {
+		return parts
+	}
+	switch {
+	case partSize == 0:
+		log.Noticef("Resume is not supported by this transport, restarting download of %s", localFile)
+	case parts.PartSize != partSize:
+		log.Noticef("Progress of %s was saved with part size %d, the transport uses %d; restarting download",
+			localFile, parts.PartSize, partSize)
+	case !holdsParts(localFile, parts):
+		// e.g. deleted or truncated since, the offsets point at nothing
+		log.Noticef("%s is missing or shorter than its saved progress; restarting download", localFile)
+	default:
+		return parts
+	}
+	return types.DownloadedParts{}
+}
+
+// holdsParts reports whether localFile exists and reaches the end of each of parts.
+func holdsParts(localFile string, parts types.DownloadedParts) bool {
+	info, err := os.Stat(localFile)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	for _, p := range parts.Parts {
+		if p.Ind*parts.PartSize+p.Size > info.Size() {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyResume returns the parts of the partial cfg.LocalFile that still hold what
+// they wrote, checking cfg.VerifyResumeSample percent of them first: when those are
+// intact, the rest are trusted unread.
+func verifyResume(cfg Config, parts types.DownloadedParts, hashes resumeHashes) types.DownloadedParts {
+	log := cfg.logger()
+	if cfg.VerifyResumeSample > 0 && cfg.VerifyResumeSample < 100 {
+		rnd := cfg.resumeRand
+		if rnd == nil {
+			rnd = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		}
+		sample := sampleParts(parts, cfg.VerifyResumeSample, rnd)
+		err := hashes.verify(cfg.LocalFile, sample)
+		if err == nil {
+			log.Functionf("Verified %d of the %d parts of %s", len(sample.Parts), len(parts.Parts), cfg.LocalFile)
+			return parts
+		}
+		log.Warnf("Sampled part of %s failed verification: %v; verifying all parts", cfg.LocalFile, err)
+	}
+	kept, failed, err := hashes.verified(cfg.LocalFile, parts)
+	if err != nil {
+		log.Warnf("Partial download of %s cannot be trusted: %v; restarting download", cfg.LocalFile, err)
+		return types.DownloadedParts{}
+	}
+	for _, err := range failed {
+		log.Warnf("Partial download of %s cannot be trusted: %v; downloading that part again", cfg.LocalFile, err)
+	}
+	return kept
+}
+
+// downloadOnce runs a single download and waits for its terminal event, returning
+// it on success. downloadedParts is updated in place, and saved to the
+// progress file of progressBase with contentID and the hashes of the new parts, so
+// a retry resumes where this attempt stopped.
+// An objSize of 0 is set from the first progress event that reports a total, the
+// one later events must not go past.
+func downloadOnce(log *base.LogObject, d downloader, remoteFile, localFile, progressBase, contentID string,
+	objSize *int64, downloadedParts *types.DownloadedParts, hashes resumeHashes, throttle *progressThrottle,
+	tracingEnabled *bool, metrics *downloadMetrics) (transferEvent, error) {
+	downloadedPartsHash := downloadedParts.Hash()
+
+	events, stop, err := d.start(remoteFile, localFile, *objSize, *downloadedParts)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+	metrics.startAttempt(time.Now())
+
+	var lastSize int64
+	warnedNoTotal := false
+	for resp := range events {
+		newParts := resp.GetDoneParts()
+		if downloadedPartsHash != newParts.Hash() {
+			metrics.observeParts(len(newParts.Parts)-len(downloadedParts.Parts), time.Now())
+			*downloadedParts = newParts
+			downloadedPartsHash = newParts.Hash()
+			if err := hashes.update(localFile, newParts); err != nil {
+				log.Errorf("failed to hash downloaded parts, they will not be resumed from: %v", err)
+			}
+			saveProgress(progressBase, contentID, *downloadedParts, hashes)
+		}
+
+		if resp.IsDnUpdate() {
+			currentSize, totalSize, _ := resp.Progress()
+			if *objSize == 0 && totalSize > 0 {
+				*objSize = totalSize
+				log.Functionf("Size of %s is %d bytes, as reported by the transfer", remoteFile, totalSize)
+			}
+			totalSize = *objSize
+			if totalSize == 0 && !warnedNoTotal {
+				// nothing to check the progress against until it does
+				log.Warnf("The transfer of %s does not report its size", remoteFile)
+				warnedNoTotal = true
+			}
+			if totalSize > 0 && currentSize > totalSize {
+				return nil, fmt.Errorf("aborting: current > total size (%v > %v)", currentSize, totalSize)
+			}
+			if currentSize < lastSize {
+				saveProgress(progressBase, contentID, *downloadedParts, hashes)
+				return nil, fmt.Errorf("aborting: %w (%v after %v bytes)", ErrProgressWentBackwards, currentSize, lastSize)
+			}
+			lastSize = currentSize
+			metrics.observeProgress(currentSize, time.Now())
+			if throttle.shouldLog(currentSize, totalSize) {
+				log.Functionf("Progress: %v/%v for %s", currentSize, totalSize, resp.GetLocalName())
+				throttle.report(resp.GetLocalName(), currentSize, totalSize)
+				if *tracingEnabled {
+					if err := d.trace(); err != nil {
+						log.Warnf("Net tracing disabled, collecting the trace failed: %v", err)
+						*tracingEnabled = false
+					}
+				}
+			}
+			continue
+		}
+
+		if resp.IsError() {
+			return nil, resp.GetDnStatus()
+		}
+
+		log.Functionf("Download done: %s (%d bytes)", resp.GetLocalName(), resp.GetAsize())
+		return resp, nil
+	}
+	return nil, fmt.Errorf("response channel closed before download finished")
+}
+
+// fileDigests returns the hex MD5 and SHA-256 of the file at path, read once.
+func fileDigests(path string) (md5Sum, sha256Sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	m, s := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(m, s), f); err != nil {
+		return "", "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(m.Sum(nil)), hex.EncodeToString(s.Sum(nil)), nil
+}
+
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
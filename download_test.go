@@ -0,0 +1,935 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve-libs/nettrace"
+	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestMain(m *testing.M) {
+	logger = logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	log = base.NewSourceLogObject(logger, "main_test", 1234)
+	os.Exit(m.Run())
+}
+
+// fakeEvent is a scripted transferEvent.
+type fakeEvent struct {
+	parts          types.DownloadedParts
+	update         bool
+	current, total int64
+	err            error
+	localName      string
+	asize          int64
+}
+
+func (e fakeEvent) GetDoneParts() types.DownloadedParts { return e.parts }
+func (e fakeEvent) IsDnUpdate() bool                    { return e.update }
+func (e fakeEvent) Progress() (int64, int64, uint)      { return e.current, e.total, 0 }
+func (e fakeEvent) IsError() bool                       { return e.err != nil }
+func (e fakeEvent) GetDnStatus() error                  { return e.err }
+func (e fakeEvent) GetLocalName() string                { return e.localName }
+func (e fakeEvent) GetAsize() int64                     { return e.asize }
+
+// fakeDownloader replays one script per attempt and writes content to the
+// local file on the attempt that completes.
+type fakeDownloader struct {
+	attempts [][]fakeEvent
+	content  []byte
+	started  []types.DownloadedParts
+	objSizes []int64 // passed to start
+	traces   int
+	traceErr error // returned by trace
+}
+
+func (d *fakeDownloader) start(remoteFile, localFile string, objSize int64,
+	doneParts types.DownloadedParts) (<-chan transferEvent, func(), error) {
+	n := len(d.started)
+	d.started = append(d.started, doneParts)
+	d.objSizes = append(d.objSizes, objSize)
+	if n >= len(d.attempts) {
+		return nil, nil, errors.New("unexpected attempt")
+	}
+	script := d.attempts[n]
+	if last := script[len(script)-1]; !last.update && last.err == nil {
+		if err := os.WriteFile(localFile, d.content, 0644); err != nil {
+			return nil, nil, err
+		}
+	}
+	events := make(chan transferEvent, len(script))
+	for _, e := range script {
+		events <- e
+	}
+	close(events)
+	return events, func() {}, nil
+}
+
+func (d *fakeDownloader) trace() error {
+	d.traces++
+	return d.traceErr
+}
+
+func testConfig(t *testing.T, d downloader) Config {
+	dir := t.TempDir()
+	return Config{
+		Downloader: d,
+		RemoteFile: filepath.Join(dir, "remote.bin"),
+		LocalFile:  filepath.Join(dir, "local.bin"),
+		ObjSize:    4,
+		Retry:      azure.RetryPolicy{MaxRetries: 1, StatusCodes: []int{503}},
+	}
+}
+
+func TestRunDownloadRetriesAndResumes(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{
+				{parts: half, update: true, current: 2, total: 4},
+				{parts: half, err: errors.New("RESPONSE 503: Service Unavailable")},
+			},
+			{
+				{parts: half, update: true, current: 4, total: 4},
+				{parts: half, localName: "local.bin", asize: 4},
+			},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.TracingEnabled = true
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Bytes)
+	require.False(t, result.Resumed, "no progress file before the run")
+	sum := md5.Sum([]byte("data"))
+	require.Equal(t, hex.EncodeToString(sum[:]), result.MD5)
+	require.Equal(t, 1, result.RetryCount)
+	require.EqualError(t, result.LastTransientError, "RESPONSE 503: Service Unavailable")
+
+	// the retry picks up the parts the failed attempt reported
+	require.Len(t, d.started, 2)
+	require.Equal(t, half, d.started[1])
+	require.Equal(t, 2, d.traces)
+}
+
+// captureLog records what log prints, at every level, until the test ends.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewLocal(logger)
+	logger.SetLevel(logrus.TraceLevel)
+	logger.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		logger.ReplaceHooks(make(logrus.LevelHooks))
+		logger.SetLevel(logrus.PanicLevel)
+		logger.SetOutput(os.Stderr)
+	})
+	return hook
+}
+
+func TestRunDownloadLogsTransferFields(t *testing.T) {
+	hook := captureLog(t)
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{{err: errors.New("RESPONSE 503: Service Unavailable")}},
+			{
+				{update: true, current: 4, total: 4, localName: "local.bin"},
+				{localName: "local.bin", asize: 4},
+			},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.Log = transferLog(log, "azure", "images", "disk.img")
+
+	_, err := runDownload(cfg)
+	require.NoError(t, err)
+	attempts := map[string]interface{}{}
+	require.NotEmpty(t, hook.AllEntries())
+	for _, e := range hook.AllEntries() {
+		require.Equal(t, "azure", e.Data["transport"], e.Message)
+		require.Equal(t, "images", e.Data["container"], e.Message)
+		require.Equal(t, "disk.img", e.Data["blob"], e.Message)
+		if strings.HasPrefix(e.Message, "Download attempt") || strings.HasPrefix(e.Message, "Progress") {
+			attempts[e.Message] = e.Data["attempt"]
+		}
+	}
+	require.Equal(t, map[string]interface{}{
+		"Download attempt 1 failed with HTTP 503, retrying in 0s": 1,
+		"Progress: 4/4 for local.bin":                             2,
+	}, attempts)
+}
+
+func TestRunDownloadResumesFromProgressFile(t *testing.T) {
+	saved := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	for name, tc := range map[string]struct {
+		partSize int64
+		resumed  bool
+	}{
+		"matching part size":     {partSize: 2, resumed: true},
+		"different part size":    {partSize: 8},
+		"transport can't resume": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content:  []byte("data"),
+				attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = tc.partSize
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+			saveDownloadedParts(cfg.LocalFile, saved)
+
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.Equal(t, tc.resumed, result.Resumed)
+			if tc.resumed {
+				require.Equal(t, saved, d.started[0])
+			} else {
+				require.Empty(t, d.started[0].Parts)
+			}
+		})
+	}
+}
+
+func TestRunDownloadRestartsOnMalformedProgress(t *testing.T) {
+	d := &fakeDownloader{
+		content:  []byte("data"),
+		attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	require.NoError(t, os.WriteFile(cfg.LocalFile+progressFileSuffix,
+		[]byte(`{"version":1,"downloaded":{"PartSize":2,"Parts":[{"i":0,"s":2000000}]}}`), 0644))
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Resumed)
+	require.Empty(t, d.started[0].Parts, "started over")
+}
+
+func TestRunDownloadRestartsWithoutLocalFile(t *testing.T) {
+	saved := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 1, Size: 2}}}
+	for name, partial := range map[string][]byte{
+		"deleted":   nil,
+		"truncated": []byte("da"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content:  []byte("data"),
+				attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			if partial != nil {
+				require.NoError(t, os.WriteFile(cfg.LocalFile, partial, 0644))
+			}
+			saveDownloadedParts(cfg.LocalFile, saved)
+
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.False(t, result.Resumed)
+			require.Empty(t, d.started[0].Parts, "started over")
+			got, err := os.ReadFile(cfg.LocalFile)
+			require.NoError(t, err)
+			require.Equal(t, []byte("data"), got)
+		})
+	}
+}
+
+func TestRunDownloadMaxParts(t *testing.T) {
+	saved := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	for name, tc := range map[string]struct {
+		maxParts int
+		resumed  bool
+	}{
+		"within":   {maxParts: 2, resumed: true},
+		"too many": {maxParts: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content:  []byte("data"),
+				attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			cfg.MaxParts = tc.maxParts
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+			saveDownloadedParts(cfg.LocalFile, saved)
+
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.Equal(t, tc.resumed, result.Resumed)
+		})
+	}
+}
+
+func TestRunDownloadSizeFromTransfer(t *testing.T) {
+	for name, tc := range map[string]struct {
+		attempts [][]fakeEvent
+		objSizes []int64
+		err      string
+	}{
+		"reported late": {
+			attempts: [][]fakeEvent{{
+				{update: true, current: 1},
+				{update: true, current: 2, total: 4},
+				{update: true, current: 4, total: 4},
+				{localName: "local.bin", asize: 4},
+			}},
+			objSizes: []int64{0},
+		},
+		"never reported": {
+			attempts: [][]fakeEvent{{
+				{update: true, current: 2},
+				{update: true, current: 4},
+				{localName: "local.bin", asize: 4},
+			}},
+			objSizes: []int64{0},
+		},
+		"exceeded": {
+			attempts: [][]fakeEvent{{
+				{update: true, current: 2, total: 4},
+				{update: true, current: 6}, // the total reported first stands
+			}},
+			objSizes: []int64{0},
+			err:      "aborting: current > total size (6 > 4)",
+		},
+		"kept for the retry": {
+			attempts: [][]fakeEvent{
+				{
+					{update: true, current: 2, total: 4},
+					{err: errors.New("RESPONSE 503: Service Unavailable")},
+				},
+				{{localName: "local.bin", asize: 4}},
+			},
+			objSizes: []int64{0, 4},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{content: []byte("data"), attempts: tc.attempts}
+			cfg := testConfig(t, d)
+			cfg.ObjSize = 0
+
+			result, err := runDownload(cfg)
+			require.Equal(t, tc.objSizes, d.objSizes)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, int64(4), result.Bytes)
+		})
+	}
+}
+
+func TestRunDownloadVerifyAfterResume(t *testing.T) {
+	saved := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	sum := md5.Sum([]byte("data"))
+	want := hex.EncodeToString(sum[:])
+	for name, tc := range map[string]struct {
+		content     string // what the resumed download ends up with
+		expectedMD5 string
+		noProgress  bool
+		err         string
+	}{
+		"matches":             {content: "data", expectedMD5: strings.ToUpper(want)},
+		"corrupted":           {content: "daXa", expectedMD5: want, err: "resumed download does not match"},
+		"no MD5 of the blob":  {content: "data", err: "has no MD5"},
+		"not resumed, no MD5": {content: "daXa", noProgress: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content:  []byte(tc.content),
+				attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			cfg.VerifyAfterResume = true
+			cfg.ExpectedMD5 = tc.expectedMD5
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+			if !tc.noProgress {
+				saveDownloadedParts(cfg.LocalFile, saved)
+			}
+
+			result, err := runDownload(cfg)
+			require.Equal(t, !tc.noProgress, result.Resumed)
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.err)
+			if tc.expectedMD5 != "" {
+				require.ErrorIs(t, err, ErrResumeCorrupt)
+			}
+			got, err := os.ReadFile(cfg.LocalFile)
+			require.NoError(t, err, "the file is kept for inspection")
+			require.Equal(t, []byte(tc.content), got)
+		})
+	}
+}
+
+func TestRunDownloadVerifyAfterRetry(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("daXa"),
+		attempts: [][]fakeEvent{
+			{{parts: half, err: errors.New("RESPONSE 503: Service Unavailable")}},
+			{{parts: half, localName: "local.bin", asize: 4}},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	cfg.VerifyAfterResume = true
+	sum := md5.Sum([]byte("data"))
+	cfg.ExpectedMD5 = hex.EncodeToString(sum[:])
+
+	result, err := runDownload(cfg)
+	require.ErrorIs(t, err, ErrResumeCorrupt, "the retry resumed from the parts of the first attempt")
+	require.False(t, result.Resumed)
+	require.Equal(t, 1, result.RetryCount)
+}
+
+func TestRunDownloadVerifiesResume(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	for name, tc := range map[string]struct {
+		corrupt bool
+		resumed bool
+	}{
+		"intact":    {resumed: true},
+		"corrupted": {corrupt: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content: []byte("data"),
+				attempts: [][]fakeEvent{
+					{{parts: half, err: errors.New("RESPONSE 403: Forbidden")}},
+					{{localName: "local.bin", asize: 4}},
+				},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			cfg.VerifyResume = true
+			// what the first attempt wrote before it failed
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+			_, err := runDownload(cfg)
+			require.Error(t, err)
+			saved := loadProgress(cfg.LocalFile)
+			require.Equal(t, half, saved.Downloaded)
+			require.Len(t, saved.Hashes, 1, "the part is hashed")
+
+			if tc.corrupt {
+				require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("Xa"), 0644))
+			}
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.Equal(t, tc.resumed, result.Resumed)
+			if tc.resumed {
+				require.Equal(t, half, d.started[1])
+			} else {
+				require.Empty(t, d.started[1].Parts, "started over")
+			}
+		})
+	}
+}
+
+func TestRunDownloadChecksRecordedHashes(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{{parts: half, err: errors.New("RESPONSE 403: Forbidden")}},
+			{{localName: "local.bin", asize: 4}},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	cfg.VerifyResume = true
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+	_, err := runDownload(cfg)
+	require.Error(t, err)
+
+	// changed since, and resumed without -verify-resume
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("Xa"), 0644))
+	cfg.VerifyResume = false
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Resumed, "the recorded hash is checked")
+	require.Empty(t, d.started[1].Parts)
+}
+
+func TestRunDownloadVerifyResumeNeedsHashes(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content:  []byte("data"),
+		attempts: [][]fakeEvent{{{localName: "local.bin", asize: 4}}},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	cfg.VerifyResume = true
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+	// saved without -verify-resume
+	saveDownloadedParts(cfg.LocalFile, half)
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Resumed, "parts without a hash are not trusted")
+	require.Empty(t, d.started[0].Parts)
+}
+
+func TestRunDownloadVerifiesResumeSample(t *testing.T) {
+	parts := types.DownloadedParts{PartSize: 2}
+	for i := int64(0); i < 4; i++ {
+		parts.Parts = append(parts.Parts, &types.PartDefinition{Ind: i, Size: 2})
+	}
+	newRand := func() *rand.Rand { return rand.New(rand.NewPCG(1, 2)) }
+	sampled := sampleParts(parts, 25, newRand()).Parts
+	require.Len(t, sampled, 1)
+	for name, inSample := range map[string]bool{"sampled part corrupted": true, "other part corrupted": false} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content: []byte("abcdefgh"),
+				attempts: [][]fakeEvent{
+					{{parts: parts, err: errors.New("RESPONSE 403: Forbidden")}},
+					{{localName: "local.bin", asize: 8}},
+				},
+			}
+			cfg := testConfig(t, d)
+			cfg.ObjSize = 8
+			cfg.ResumePartSize = 2
+			cfg.VerifyResume = true
+			cfg.VerifyResumeSample = 25
+			cfg.resumeRand = newRand()
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("abcdefgh"), 0644))
+			_, err := runDownload(cfg)
+			require.Error(t, err)
+
+			corrupted := sampled[0].Ind
+			if !inSample {
+				corrupted = (corrupted + 1) % 4
+			}
+			f, err := os.OpenFile(cfg.LocalFile, os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte("X"), corrupted*2)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			cfg.resumeRand = newRand()
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.True(t, result.Resumed)
+			var resumed []int64
+			for _, p := range d.started[1].Parts {
+				resumed = append(resumed, p.Ind)
+			}
+			if inSample {
+				require.Len(t, resumed, 3, "all parts verified, the corrupted one dropped")
+				require.NotContains(t, resumed, corrupted)
+			} else {
+				require.Len(t, resumed, 4, "only the sample is verified")
+			}
+		})
+	}
+}
+
+func TestRunDownloadResumesMovedPartialFile(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	for name, workspace := range map[string]bool{"with its progress": false, "alone, in a workspace": true} {
+		t.Run(name, func(t *testing.T) {
+			d := &fakeDownloader{
+				content: []byte("data"),
+				attempts: [][]fakeEvent{
+					{{parts: half, err: errors.New("RESPONSE 403: Forbidden")}},
+					{{localName: "local.bin", asize: 4}},
+				},
+			}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			cfg.VerifyResume = true
+			cfg.ContentID = `"0x8DD000000000001"`
+			if workspace {
+				cfg.Workspace = t.TempDir()
+				cfg.RemoteID = "https://account.blob.core.windows.net/container/remote.bin"
+			}
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+			_, err := runDownload(cfg)
+			require.Error(t, err)
+
+			// e.g. the disk came back under another mount point
+			moved := filepath.Join(t.TempDir(), "remounted", "local.bin")
+			require.NoError(t, os.MkdirAll(filepath.Dir(moved), 0755))
+			require.NoError(t, os.Rename(cfg.LocalFile, moved))
+			if !workspace {
+				require.NoError(t, os.Rename(cfg.LocalFile+progressFileSuffix, moved+progressFileSuffix))
+			}
+			cfg.LocalFile = moved
+
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.True(t, result.Resumed)
+			require.Equal(t, half, d.started[1], "resumed at the new path")
+		})
+	}
+}
+
+func TestRunDownloadRestartsOnChangedContent(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("new!"),
+		attempts: [][]fakeEvent{
+			{{parts: half, err: errors.New("RESPONSE 403: Forbidden")}},
+			{{localName: "local.bin", asize: 4}},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	cfg.ContentID = `"0x8DD000000000001"`
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+	_, err := runDownload(cfg)
+	require.Error(t, err)
+	require.Equal(t, cfg.ContentID, loadProgress(cfg.LocalFile).ContentID)
+
+	// the blob was uploaded again in between
+	cfg.ContentID = `"0x8DD000000000002"`
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.False(t, result.Resumed)
+	require.Empty(t, d.started[1].Parts, "started over")
+}
+
+func TestRunDownloadUsesWorkspace(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	full := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}, {Ind: 1, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{{parts: half, err: errors.New("RESPONSE 403: Forbidden")}},
+			{{parts: full, localName: "local.bin", asize: 4}},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+	cfg.Workspace = t.TempDir()
+	cfg.RemoteID = blobRemoteID("https://account.blob.core.windows.net?sv=2022-11-02&sig=first", "container", "remote.bin")
+	require.Equal(t, "https://account.blob.core.windows.net/container/remote.bin", cfg.RemoteID, "without the SAS")
+	progressFile := sidecarBase(cfg.Workspace, cfg.RemoteID, cfg.LocalFile) + progressFileSuffix
+	require.Equal(t, cfg.Workspace, filepath.Dir(progressFile))
+	// what the first attempt wrote before it failed
+	require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+
+	_, err := runDownload(cfg)
+	require.Error(t, err)
+	require.FileExists(t, progressFile)
+	require.NoFileExists(t, cfg.LocalFile+progressFileSuffix, "nothing is written next to the output")
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Resumed)
+	require.Equal(t, half, d.started[1], "resumed from the workspace")
+	require.NoFileExists(t, cfg.LocalFile+progressFileSuffix)
+
+	other := sidecarBase(cfg.Workspace, "https://account.blob.core.windows.net/other/remote.bin", cfg.LocalFile)
+	require.NotEqual(t, progressFile, other+progressFileSuffix, "keyed by the remote object")
+}
+
+func TestRunDownloadSkipsTraceWhenDisabled(t *testing.T) {
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{
+				{update: true, current: 2, total: 4},
+				{update: true, current: 4, total: 4},
+				{localName: "local.bin", asize: 4},
+			},
+		},
+	}
+
+	_, err := runDownload(testConfig(t, d))
+	require.NoError(t, err)
+	require.Zero(t, d.traces)
+}
+
+func TestRunDownloadRetryBudget(t *testing.T) {
+	var attempts [][]fakeEvent
+	for i := range 5 {
+		// every attempt makes a little progress, so MaxRetries alone never stops it
+		parts := types.DownloadedParts{PartSize: 1, Parts: []*types.PartDefinition{{Ind: int64(i), Size: 1}}}
+		attempts = append(attempts, []fakeEvent{{parts: parts, err: errors.New("RESPONSE 503: Service Unavailable")}})
+	}
+	d := &fakeDownloader{attempts: attempts}
+	cfg := testConfig(t, d)
+	cfg.RetryBudget = 2
+
+	_, err := runDownload(cfg)
+	require.ErrorIs(t, err, ErrRetryBudgetExceeded)
+	require.Len(t, d.started, 3)
+	require.Equal(t, attempts[2][0].parts, loadDownloadedParts(cfg.LocalFile), "progress is kept")
+}
+
+func TestRunDownloadQuiet(t *testing.T) {
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{
+				{update: true, current: 2, total: 4},
+				{localName: "local.bin", asize: 4},
+			},
+		},
+	}
+	cfg := testConfig(t, d)
+	cfg.TracingEnabled = true
+	cfg.Quiet = true
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Bytes)
+	require.Zero(t, result.RetryCount)
+	require.NoError(t, result.LastTransientError)
+	require.Zero(t, d.traces, "no progress is reported, so nothing is traced either")
+}
+
+func TestRunDownloadGivesUpOnNonRetryableStatus(t *testing.T) {
+	d := &fakeDownloader{
+		attempts: [][]fakeEvent{
+			{{err: errors.New("RESPONSE 404: The specified blob does not exist.")}},
+		},
+	}
+
+	_, err := runDownload(testConfig(t, d))
+	require.ErrorContains(t, err, "404")
+	require.Len(t, d.started, 1)
+}
+
+func TestRunDownloadCleanupOnError(t *testing.T) {
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	for name, tc := range map[string]struct {
+		cleanup     bool
+		retryBudget int
+	}{
+		"kept":                     {},
+		"removed":                  {cleanup: true},
+		"kept, budget exceeded":    {retryBudget: 1},
+		"removed, budget exceeded": {cleanup: true, retryBudget: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			failure := []fakeEvent{{parts: half, err: errors.New("RESPONSE 503: Service Unavailable")}}
+			d := &fakeDownloader{attempts: [][]fakeEvent{failure, failure, failure}}
+			cfg := testConfig(t, d)
+			cfg.ResumePartSize = 2
+			cfg.RetryBudget = tc.retryBudget
+			cfg.CleanupOnError = tc.cleanup
+			require.NoError(t, os.WriteFile(cfg.LocalFile, []byte("da"), 0644))
+
+			_, err := runDownload(cfg)
+			require.ErrorContains(t, err, "503")
+			_, statErr := os.Stat(cfg.LocalFile)
+			_, progressErr := os.Stat(cfg.LocalFile + progressFileSuffix)
+			if tc.cleanup {
+				require.ErrorIs(t, statErr, os.ErrNotExist)
+				require.ErrorIs(t, progressErr, os.ErrNotExist)
+			} else {
+				require.NoError(t, statErr)
+				require.NoError(t, progressErr)
+				require.Equal(t, half, loadDownloadedParts(cfg.LocalFile))
+			}
+		})
+	}
+}
+
+func TestRunDownloadRejectsOversizedProgress(t *testing.T) {
+	d := &fakeDownloader{
+		attempts: [][]fakeEvent{
+			{{update: true, current: 5, total: 4}},
+		},
+	}
+
+	_, err := runDownload(testConfig(t, d))
+	require.ErrorContains(t, err, "current > total")
+}
+
+func TestRunDownloadRefusesOverMaxSize(t *testing.T) {
+	d := &fakeDownloader{}
+	cfg := testConfig(t, d)
+	cfg.MaxSize = cfg.ObjSize - 1
+
+	_, err := runDownload(cfg)
+	require.ErrorIs(t, err, ErrTooLarge)
+	require.Empty(t, d.started, "nothing is transferred")
+	require.NoFileExists(t, cfg.LocalFile)
+
+	cfg.MaxSize = cfg.ObjSize
+	d.attempts = [][]fakeEvent{{{localName: cfg.LocalFile, asize: 4}}}
+	d.content = []byte("data")
+	_, err = runDownload(cfg)
+	require.NoError(t, err, "an object of exactly MaxSize is allowed")
+}
+
+// traceEndPoint records the descriptions GetNetTrace is called with; any other
+// method of the embedded nil endpoint panics.
+type traceEndPoint struct {
+	zedUpload.DronaEndPoint
+	descriptions []string
+}
+
+func (ep *traceEndPoint) GetNetTrace(description string) (nettrace.AnyNetTrace, []nettrace.PacketCapture, error) {
+	ep.descriptions = append(ep.descriptions, description)
+	return nettrace.HTTPTrace{NetTrace: nettrace.NetTrace{Description: description}}, nil, nil
+}
+
+func TestDronaDownloaderTraceLabel(t *testing.T) {
+	ep := &traceEndPoint{}
+	dronaDownloader{ep: ep, traceLabel: "run-42"}.trace()
+	dronaDownloader{ep: ep}.trace()
+	require.Equal(t, []string{"run-42", defaultTraceLabel}, ep.descriptions)
+}
+
+// brokenTraceEndPoint fails to set up tracing, as without permission for conntrack
+// in a container, and panics when asked for a trace.
+type brokenTraceEndPoint struct {
+	zedUpload.DronaEndPoint
+}
+
+func (ep *brokenTraceEndPoint) WithNetTracing(opts ...nettrace.TraceOpt) error {
+	return errors.New("failed to open conntrack netlink socket: operation not permitted")
+}
+
+func (ep *brokenTraceEndPoint) GetNetTrace(description string) (nettrace.AnyNetTrace, []nettrace.PacketCapture, error) {
+	panic("network tracing was not enabled")
+}
+
+func TestNetTracingFailureIsNotFatal(t *testing.T) {
+	ep := &brokenTraceEndPoint{}
+	require.False(t, enableNetTracing(ep, &nettrace.WithConntrack{}))
+	require.ErrorContains(t, dronaDownloader{ep: ep}.trace(), "nettrace panicked")
+
+	half := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		content: []byte("data"),
+		attempts: [][]fakeEvent{
+			{
+				{parts: half, update: true, current: 2, total: 4},
+				{parts: half, err: errors.New("RESPONSE 503: Service Unavailable")},
+			},
+			{
+				{parts: half, update: true, current: 4, total: 4},
+				{parts: half, localName: "local.bin", asize: 4},
+			},
+		},
+		traceErr: errors.New("nettrace panicked: network tracing was not enabled"),
+	}
+	cfg := testConfig(t, d)
+	cfg.TracingEnabled = true
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err, "the download goes on without tracing")
+	require.Equal(t, int64(4), result.Bytes)
+	require.Equal(t, 1, d.traces, "tracing stays off after the first failure, across retries")
+}
+
+func TestRunDownloadRejectsShrinkingProgress(t *testing.T) {
+	part := types.DownloadedParts{PartSize: 2, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	d := &fakeDownloader{
+		attempts: [][]fakeEvent{{
+			{update: true, parts: part, current: 2, total: 4},
+			{update: true, parts: part, current: 3, total: 4},
+			{update: true, parts: part, current: 1, total: 4},
+			{localName: "local.bin", asize: 4},
+		}},
+	}
+	cfg := testConfig(t, d)
+	cfg.ResumePartSize = 2
+
+	_, err := runDownload(cfg)
+	require.ErrorIs(t, err, ErrProgressWentBackwards)
+	require.ErrorContains(t, err, "1 after 3 bytes")
+	require.Len(t, d.started, 1, "not retried")
+	require.Equal(t, part, loadDownloadedParts(cfg.LocalFile), "progress is kept")
+}
+
+// produce sends events on an unbuffered channel it never closes, like zedUpload,
+// and closes sent once every send went through.
+func produce(events ...fakeEvent) (<-chan fakeEvent, <-chan struct{}) {
+	in := make(chan fakeEvent)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for _, e := range events {
+			in <- e
+		}
+	}()
+	return in, sent
+}
+
+func requireClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not finish", what)
+	}
+}
+
+func TestForwardEventsDrainsAfterTerminal(t *testing.T) {
+	in, sent := produce(
+		fakeEvent{update: true, current: 2, total: 4},
+		fakeEvent{localName: "local.bin", asize: 4},
+		// a producer that keeps going after the terminal event
+		fakeEvent{update: true, current: 4, total: 4},
+		fakeEvent{localName: "local.bin", asize: 4},
+	)
+	done := make(chan struct{})
+	defer close(done)
+
+	events, drained := forwardEvents(in, done, 50*time.Millisecond)
+	var got []transferEvent
+	for e := range events {
+		got = append(got, e)
+	}
+	require.Len(t, got, 2, "nothing is passed on after the terminal event")
+	requireClosed(t, sent, "the producer")
+	requireClosed(t, drained, "draining")
+}
+
+func TestForwardEventsDrainsAfterStop(t *testing.T) {
+	var script []fakeEvent
+	for i := int64(1); i <= 10; i++ {
+		script = append(script, fakeEvent{update: true, current: i, total: 10})
+	}
+	in, sent := produce(script...)
+	done := make(chan struct{})
+
+	events, drained := forwardEvents(in, done, 50*time.Millisecond)
+	<-events
+	close(done) // the consumer gives up, as downloadOnce on an error
+	requireClosed(t, sent, "the producer")
+	requireClosed(t, drained, "draining")
+	for range events {
+	}
+}
+
+func TestForwardEventsGivesUpOnQuietProducer(t *testing.T) {
+	in := make(chan fakeEvent) // never sent on nor closed
+	done := make(chan struct{})
+
+	events, drained := forwardEvents(in, done, 20*time.Millisecond)
+	close(done)
+	requireClosed(t, drained, "draining")
+	_, ok := <-events
+	require.False(t, ok)
+}
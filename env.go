@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const envFileName = ".env"
+
+// envFileFromArgs returns the value of -env-file in args, if any. Other flags take
+// their defaults from the environment, so the file must be loaded before flag.Parse.
+func envFileFromArgs(args []string) string {
+	return flagFromArgs(args, "env-file")
+}
+
+// flagFromArgs returns the value of flag in args ahead of flag.Parse, "" if not given.
+func flagFromArgs(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != flag {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// envFileCandidates lists where a .env file is looked for: the working directory,
+// then the directory of the executable.
+func envFileCandidates() []string {
+	candidates := []string{envFileName}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), envFileName))
+	}
+	return candidates
+}
+
+// loadEnvFile loads explicit, which must exist, or else the first candidate that does.
+// Returns the file loaded, "" if there was none.
+func loadEnvFile(explicit string, candidates []string) (string, error) {
+	if explicit != "" {
+		if err := godotenv.Load(explicit); err != nil {
+			return "", fmt.Errorf("failed to load env file %s: %w", explicit, err)
+		}
+		return explicit, nil
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := godotenv.Load(path); err != nil {
+			return "", fmt.Errorf("failed to load env file %s: %w", path, err)
+		}
+		return path, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvFileFromArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-env-file", "/etc/dl.env"}, "/etc/dl.env"},
+		{[]string{"--env-file=/etc/dl.env", "-outdir", "x"}, "/etc/dl.env"},
+		{[]string{"-outdir", "x"}, ""},
+		{[]string{"--", "-env-file", "/etc/dl.env"}, ""},
+		{[]string{"-env-file"}, ""},
+	} {
+		require.Equal(t, tc.want, envFileFromArgs(tc.args), "%q", tc.args)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	second := filepath.Join(dir, "second.env")
+	require.NoError(t, os.WriteFile(second, []byte("ENV_FILE_TEST_VALUE=second\n"), 0644))
+	t.Setenv("ENV_FILE_TEST_VALUE", "")
+	os.Unsetenv("ENV_FILE_TEST_VALUE")
+
+	loaded, err := loadEnvFile("", []string{filepath.Join(dir, "missing.env"), second})
+	require.NoError(t, err)
+	require.Equal(t, second, loaded)
+	require.Equal(t, "second", os.Getenv("ENV_FILE_TEST_VALUE"))
+
+	loaded, err = loadEnvFile("", []string{filepath.Join(dir, "missing.env")})
+	require.NoError(t, err)
+	require.Empty(t, loaded)
+
+	_, err = loadEnvFile(filepath.Join(dir, "missing.env"), []string{second})
+	require.Error(t, err, "an explicit -env-file must exist")
+}
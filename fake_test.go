@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// Offline tests talk to a fake storage account instead of real Azure.
+const (
+	fakeAccountName = "fakeaccount"
+	fakeContainer   = "fakecontainer"
+)
+
+var fakeAccountKey = base64.StdEncoding.EncodeToString([]byte("fake-account-key"))
+
+// fakeAccount is the fake storage account a test server stands in for. The
+// configs of the operations under test start from it.
+type fakeAccount struct {
+	url string
+	key string
+}
+
+func fakeAccountOf(srv *httptest.Server) fakeAccount {
+	return fakeAccount{url: srv.URL, key: fakeAccountKey}
+}
+
+// signedWith is a with the SAS token sas instead of the account key, registered
+// for the test.
+func (a fakeAccount) signedWith(t *testing.T, sas string) fakeAccount {
+	azure.SetAccountSAS(a.url, sas)
+	t.Cleanup(func() { azure.SetAccountSAS(a.url, "") })
+	return fakeAccount{url: a.url}
+}
+
+func (a fakeAccount) stream(remoteFile string) StreamConfig {
+	return StreamConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) upload(remoteFile, localFile string) UploadConfig {
+	return UploadConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, LocalFile: localFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) audit() AuditConfig {
+	return AuditConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) compare(remoteFile string) CompareConfig {
+	return CompareConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) inspect(remoteFile string) InspectConfig {
+	return InspectConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) blockList(remoteFile string) BlockListConfig {
+	return BlockListConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) sas(remoteFile string) SasConfig {
+	return SasConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
+
+func (a fakeAccount) speedTest(remoteFile string) SpeedTestConfig {
+	return SpeedTestConfig{AccountURL: a.url, AccountName: fakeAccountName, AccountKey: a.key,
+		Container: fakeContainer, RemoteFile: remoteFile, HTTPClient: &http.Client{}}
+}
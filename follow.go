@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+)
+
+// waitForBlob polls exists every interval until it reports the blob, for at most
+// maxWait (0 waits forever), logging the polls to log. Errors of exists end the wait.
+func waitForBlob(log *base.LogObject, remoteFile string, exists func() (bool, error),
+	interval, maxWait time.Duration) error {
+	started := time.Now()
+	for attempt := 1; ; attempt++ {
+		found, err := exists()
+		if err != nil {
+			return fmt.Errorf("failed to check for %s: %w", remoteFile, err)
+		}
+		if found {
+			log.Noticef("%s appeared after %d polls", remoteFile, attempt)
+			return nil
+		}
+		waited := time.Since(started)
+		if maxWait > 0 && waited+interval > maxWait {
+			return fmt.Errorf("%s did not appear within %v", remoteFile, maxWait)
+		}
+		log.Functionf("Poll %d: %s does not exist yet, checking again in %v", attempt, remoteFile, interval)
+		time.Sleep(interval)
+	}
+}
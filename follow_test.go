@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForBlobAppears(t *testing.T) {
+	polls := 0
+	exists := func() (bool, error) {
+		polls++
+		return polls == 3, nil
+	}
+
+	require.NoError(t, waitForBlob(log, "late.bin", exists, time.Millisecond, time.Minute))
+	require.Equal(t, 3, polls)
+}
+
+func TestWaitForBlobGivesUp(t *testing.T) {
+	polls := 0
+	exists := func() (bool, error) {
+		polls++
+		return false, nil
+	}
+
+	err := waitForBlob(log, "never.bin", exists, 10*time.Millisecond, 35*time.Millisecond)
+	require.ErrorContains(t, err, "never.bin did not appear within 35ms")
+	require.GreaterOrEqual(t, polls, 2)
+	require.LessOrEqual(t, polls, 4, "no poll is started that would end after the deadline")
+}
+
+func TestWaitForBlobError(t *testing.T) {
+	err := waitForBlob(log, "denied.bin", func() (bool, error) {
+		return false, errors.New("AuthorizationFailure (HTTP 403)")
+	}, time.Millisecond, 0)
+	require.ErrorContains(t, err, "AuthorizationFailure")
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// httpPartSize is the unit httpDownloader records finished parts in.
+const httpPartSize = 4 * 1024 * 1024
+
+// httpDownloader fetches plain HTTP objects itself: zedUpload's HTTP transport
+// always starts over, this one resumes after the parts already on disk with a
+// Range request, and starts over only if the server ignores it.
+type httpDownloader struct {
+	baseURL string // remote names are appended escaped, after a "/"
+	query   string // added to every request, e.g. a SAS token
+	client  *http.Client
+	// writes to the local file are gathered into this many bytes, 0 writes what
+	// each read of the response returned
+	bufferSize int
+	log        *base.LogObject // of the download, nil for the package's log
+}
+
+// logger returns d.log, or the package's log without one.
+func (d httpDownloader) logger() *base.LogObject {
+	if d.log != nil {
+		return d.log
+	}
+	return log
+}
+
+// httpEvent is the transferEvent of an httpDownloader, or of an azureDownloader.
+type httpEvent struct {
+	parts          types.DownloadedParts
+	update         bool
+	current, total int64
+	err            error
+	localName      string
+	asize          int64
+	// of the whole object, set when it was all written by this transfer
+	md5Sum, sha256Sum string
+}
+
+func (e httpEvent) GetDoneParts() types.DownloadedParts { return e.parts }
+func (e httpEvent) IsDnUpdate() bool                    { return e.update }
+func (e httpEvent) Progress() (int64, int64, uint)      { return e.current, e.total, 0 }
+func (e httpEvent) IsError() bool                       { return e.err != nil }
+func (e httpEvent) GetDnStatus() error                  { return e.err }
+func (e httpEvent) GetLocalName() string                { return e.localName }
+func (e httpEvent) GetAsize() int64                     { return e.asize }
+
+func (e httpEvent) Digests() (string, string, bool) {
+	return e.md5Sum, e.sha256Sum, e.md5Sum != ""
+}
+
+func (d httpDownloader) start(remoteFile, localFile string, objSize int64,
+	doneParts types.DownloadedParts) (<-chan transferEvent, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan transferEvent)
+	go func() {
+		defer close(events)
+		send := func(e httpEvent) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		parts, err := d.download(ctx, remoteFile, localFile, doneParts, send)
+		if err != nil {
+			send(httpEvent{parts: parts, err: err})
+		}
+	}()
+	return events, cancel, nil
+}
+
+// trace is a no-op, net tracing is only available with the zedUpload transports.
+func (d httpDownloader) trace() error { return nil }
+
+// download fetches remoteFile into localFile from the end of the contiguous parts
+// of doneParts, reporting progress through send. It returns the parts written so
+// far, on failure too.
+func (d httpDownloader) download(ctx context.Context, remoteFile, localFile string,
+	doneParts types.DownloadedParts, send func(httpEvent) bool) (types.DownloadedParts, error) {
+	log := d.logger()
+	parts := contiguousParts(doneParts)
+	if parts.PartSize != httpPartSize {
+		parts = types.DownloadedParts{PartSize: httpPartSize}
+	}
+	var offset int64
+	for _, p := range parts.Parts {
+		offset += p.Size
+	}
+
+	objectURL := d.baseURL + "/" + azure.EscapeBlobName(remoteFile)
+	if d.query != "" {
+		objectURL += "?" + d.query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return parts, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return parts, err
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset || end != size-1 {
+			return parts, fmt.Errorf("unexpected Content-Range %q resuming at byte %d",
+				resp.Header.Get("Content-Range"), offset)
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != end-start+1 {
+			return parts, fmt.Errorf("Content-Length %d does not match Content-Range %q",
+				resp.ContentLength, resp.Header.Get("Content-Range"))
+		}
+		total = size
+		log.Noticef("Resuming download of %s at byte %d of %d", remoteFile, offset, total)
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable &&
+		resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset):
+		// the earlier run stopped after the last byte but before reporting it
+		send(httpEvent{parts: parts, localName: localFile, asize: offset})
+		return parts, nil
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			log.Noticef("Server does not support range requests (Accept-Ranges: %q), downloading %s from the start",
+				resp.Header.Get("Accept-Ranges"), remoteFile)
+			parts = types.DownloadedParts{PartSize: httpPartSize}
+			offset = 0
+		}
+	default:
+		return parts, fmt.Errorf("RESPONSE %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	f, err := os.OpenFile(localFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return parts, err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return parts, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return parts, err
+	}
+
+	out := newOutputWriter(f, d.bufferSize)
+	// from the start, the digests of the object come with writing it
+	var dst io.Writer = out
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if offset == 0 {
+		dst = io.MultiWriter(out, md5Hash, sha256Hash)
+	}
+	current := offset
+	for {
+		n, err := io.CopyN(dst, resp.Body, httpPartSize)
+		current += n
+		if n == httpPartSize || (err == io.EOF && n > 0) {
+			// a part is only recorded once it is in the file
+			if err := out.Flush(); err != nil {
+				return parts, err
+			}
+			parts.Parts = append(parts.Parts, &types.PartDefinition{Ind: int64(len(parts.Parts)), Size: n})
+			reported := total
+			if reported < 0 {
+				reported = current // length unknown, e.g. a chunked response
+			}
+			if !send(httpEvent{parts: parts, update: true, current: current, total: reported}) {
+				return parts, ctx.Err()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the part in progress is not recorded, a resume writes it again
+			return parts, err
+		}
+	}
+	if total >= 0 && current != total {
+		return parts, fmt.Errorf("download of %s ended at byte %d of %d", remoteFile, current, total)
+	}
+	done := httpEvent{parts: parts, localName: localFile, asize: current}
+	if offset == 0 {
+		done.md5Sum = hex.EncodeToString(md5Hash.Sum(nil))
+		done.sha256Sum = hex.EncodeToString(sha256Hash.Sum(nil))
+	}
+	send(done)
+	return parts, nil
+}
+
+// outputWriter is where a download writes the local file.
+type outputWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// newOutputWriter buffers the writes to w in size bytes, e.g. to write a network
+// filesystem in fewer, larger requests. 0 leaves them unbuffered.
+func newOutputWriter(w io.Writer, size int) outputWriter {
+	if size <= 0 {
+		return unbufferedWriter{w}
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+type unbufferedWriter struct {
+	io.Writer
+}
+
+func (unbufferedWriter) Flush() error { return nil }
+
+// contiguousParts keeps the parts of p that follow each other from the first one,
+// all of full size except possibly the last.
+func contiguousParts(p types.DownloadedParts) types.DownloadedParts {
+	byIndex := map[int64]int64{}
+	for _, part := range p.Parts {
+		byIndex[part.Ind] = part.Size
+	}
+	out := types.DownloadedParts{PartSize: p.PartSize}
+	for i := int64(0); ; i++ {
+		size, ok := byIndex[i]
+		if !ok || size <= 0 || size > p.PartSize {
+			break
+		}
+		out.Parts = append(out.Parts, &types.PartDefinition{Ind: i, Size: size})
+		if size < p.PartSize {
+			break
+		}
+	}
+	return out
+}
+
+// parseContentRange parses "bytes start-end/size" of a 206 response.
+func parseContentRange(h string) (start, end, size int64, err error) {
+	spec, ok := strings.CutPrefix(h, "bytes ")
+	rng, total, ok2 := strings.Cut(spec, "/")
+	from, to, ok3 := strings.Cut(rng, "-")
+	if !ok || !ok2 || !ok3 {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	if start, err = strconv.ParseInt(from, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	if end, err = strconv.ParseInt(to, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil || end >= size {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	return start, end, size, nil
+}
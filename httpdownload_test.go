@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+)
+
+// rangeServer serves content at /container/blob.bin, cutting the first response
+// short after cutAfter bytes. Without ranges it ignores Range headers like a server
+// that does not support them.
+type rangeServer struct {
+	content  []byte
+	cutAfter int
+	ranges   bool
+
+	mu     sync.Mutex
+	ranged []string // Range header of each request
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	first := len(s.ranged) == 0
+	s.ranged = append(s.ranged, r.Header.Get("Range"))
+	s.mu.Unlock()
+	if first {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		_, _ = w.Write(s.content[:s.cutAfter]) // the connection drops after this
+		return
+	}
+	if s.ranges {
+		http.ServeContent(w, r, "blob.bin", time.Time{}, bytes.NewReader(s.content))
+		return
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	_, _ = w.Write(s.content)
+}
+
+func httpTestConfig(t *testing.T, srv *httptest.Server, size int) Config {
+	return Config{
+		Downloader:     httpDownloader{baseURL: srv.URL + "/container", client: srv.Client()},
+		RemoteFile:     "blob.bin",
+		LocalFile:      filepath.Join(t.TempDir(), "blob.bin"),
+		ObjSize:        int64(size),
+		ResumePartSize: httpPartSize,
+	}
+}
+
+func TestHTTPDownloaderResumes(t *testing.T) {
+	for name, ranges := range map[string]bool{
+		"server supports ranges": true,
+		"server ignores ranges":  false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			content := bytes.Repeat([]byte("0123456789abcdef"), (2*httpPartSize+100)/16)
+			content = append(content, []byte("tail")...)
+			rs := &rangeServer{content: content, cutAfter: httpPartSize + 1000, ranges: ranges}
+			srv := httptest.NewServer(rs)
+			t.Cleanup(srv.Close)
+			cfg := httpTestConfig(t, srv, len(content))
+
+			_, err := runDownload(cfg)
+			require.Error(t, err, "the first response is cut short")
+			require.Len(t, loadDownloadedParts(cfg.LocalFile).Parts, 1, "one full part made it")
+
+			result, err := runDownload(cfg)
+			require.NoError(t, err)
+			require.True(t, result.Resumed)
+			require.Equal(t, int64(len(content)), result.Bytes)
+			got, err := os.ReadFile(cfg.LocalFile)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(content, got), "local file matches the remote object")
+			require.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", httpPartSize)}, rs.ranged)
+		})
+	}
+}
+
+func TestHTTPDownloaderHashesWhileWriting(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), (httpPartSize+100)/16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	d := httpDownloader{baseURL: srv.URL + "/container", client: srv.Client()}
+	localFile := filepath.Join(t.TempDir(), "blob.bin")
+	var last httpEvent
+	send := func(e httpEvent) bool { last = e; return true }
+
+	parts, err := d.download(context.Background(), "blob.bin", localFile, types.DownloadedParts{}, send)
+	require.NoError(t, err)
+	md5Sum, sha256Sum, ok := last.Digests()
+	require.True(t, ok, "written from the start")
+	require.Equal(t, hex.EncodeToString(md5Of(content)), md5Sum)
+	sha := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sha[:]), sha256Sum)
+
+	// only the first part is resumed from, the rest was not hashed
+	parts.Parts = parts.Parts[:1]
+	_, err = d.download(context.Background(), "blob.bin", localFile, parts, send)
+	require.NoError(t, err)
+	_, _, ok = last.Digests()
+	require.False(t, ok, "resumed, the file is read back for them")
+}
+
+func TestHTTPDownloaderRejectsWrongContentRange(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), httpPartSize+10)
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:httpPartSize+5])
+			return
+		}
+		// answers a different range than asked for
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	cfg := httpTestConfig(t, srv, len(content))
+
+	_, err := runDownload(cfg)
+	require.Error(t, err)
+	_, err = runDownload(cfg)
+	require.ErrorContains(t, err, "unexpected Content-Range")
+}
+
+func TestHTTPDownloaderRejectsPartialRange(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), httpPartSize+10)
+	for name, tc := range map[string]struct {
+		header  func(offset int) (contentRange string, length int)
+		wantErr string
+	}{
+		"range ends early": {
+			header: func(offset int) (string, int) {
+				return fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-5, len(content)), len(content) - 4 - offset
+			},
+			wantErr: "unexpected Content-Range",
+		},
+		"length differs from range": {
+			header: func(offset int) (string, int) {
+				return fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)), len(content)
+			},
+			wantErr: "does not match Content-Range",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					_, _ = w.Write(content[:httpPartSize+5])
+					return
+				}
+				contentRange, length := tc.header(httpPartSize)
+				w.Header().Set("Content-Range", contentRange)
+				w.Header().Set("Content-Length", strconv.Itoa(length))
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write(content[:length])
+			}))
+			t.Cleanup(srv.Close)
+			cfg := httpTestConfig(t, srv, len(content))
+
+			_, err := runDownload(cfg)
+			require.Error(t, err)
+			_, err = runDownload(cfg)
+			require.ErrorContains(t, err, tc.wantErr)
+			info, statErr := os.Stat(cfg.LocalFile)
+			require.NoError(t, statErr)
+			require.LessOrEqual(t, info.Size(), int64(len(content)), "nothing misplaced is written")
+			require.Len(t, loadDownloadedParts(cfg.LocalFile).Parts, 1, "only the first part counts")
+		})
+	}
+}
+
+func TestHTTPDownloaderAlreadyComplete(t *testing.T) {
+	content := []byte("small object")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := httpTestConfig(t, srv, len(content))
+	require.NoError(t, os.WriteFile(cfg.LocalFile, content, 0644))
+	// a finished download of less than one part, only the final event was lost
+	saveDownloadedParts(cfg.LocalFile, types.DownloadedParts{
+		PartSize: httpPartSize,
+		Parts:    []*types.PartDefinition{{Ind: 0, Size: int64(len(content))}},
+	})
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), result.Bytes)
+}
+
+func TestHTTPDownloaderEscapesName(t *testing.T) {
+	content := []byte("special characters")
+	var gotPath, gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.EscapedPath(), r.URL.RawQuery
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	cfg := httpTestConfig(t, srv, len(content))
+	cfg.Downloader = httpDownloader{baseURL: srv.URL + "/container", query: "sv=2020-12-06&sig=abc%2B",
+		client: srv.Client()}
+	cfg.RemoteFile = "folder/a b+c#d.txt"
+
+	_, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "/container/folder/a%20b+c%23d.txt", gotPath)
+	require.Equal(t, "sv=2020-12-06&sig=abc%2B", gotQuery, "the SAS token is passed through as is")
+}
+
+// slowWriter counts the writes that reach it, e.g. the requests a network
+// filesystem would send, each taking delay.
+type slowWriter struct {
+	writes int
+	bytes  int
+	delay  time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.bytes += len(p)
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestOutputWriterBufferSize(t *testing.T) {
+	for size, writes := range map[int]int{0: 100, 64: 16, 4096: 1} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			target := &slowWriter{}
+			out := newOutputWriter(target, size)
+			for i := 0; i < 100; i++ {
+				_, err := out.Write([]byte("0123456789"))
+				require.NoError(t, err)
+			}
+			require.NoError(t, out.Flush())
+			require.Equal(t, writes, target.writes)
+			require.Equal(t, 1000, target.bytes)
+		})
+	}
+}
+
+func TestHTTPDownloaderBuffered(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*httpPartSize+100)/16)
+	srv := httptest.NewServer(&rangeServer{content: content, cutAfter: len(content), ranges: true})
+	t.Cleanup(srv.Close)
+	cfg := httpTestConfig(t, srv, len(content))
+	dl := cfg.Downloader.(httpDownloader)
+	dl.bufferSize = 1000 // not a divisor of the part size
+	cfg.Downloader = dl
+
+	result, err := runDownload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), result.Bytes)
+	got, err := os.ReadFile(cfg.LocalFile)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, got), "local file matches the remote object")
+}
+
+// BenchmarkOutputBufferSlowTarget copies 4 MiB in the 32 KiB reads of a response
+// body to a target that takes 100µs per write, as a network filesystem might.
+func BenchmarkOutputBufferSlowTarget(b *testing.B) {
+	data := bytes.Repeat([]byte("x"), httpPartSize)
+	for _, size := range []int{0, 256 * 1024, 1024 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				target := &slowWriter{delay: 100 * time.Microsecond}
+				out := newOutputWriter(target, size)
+				for rest := data; len(rest) > 0; rest = rest[min(len(rest), 32*1024):] {
+					_, _ = out.Write(rest[:min(len(rest), 32*1024)])
+				}
+				_ = out.Flush()
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// BlobReport is everything -op inspect knows about a blob, for support tickets.
+type BlobReport struct {
+	Container    string               `json:"container"`
+	Name         string               `json:"name"`
+	Size         int64                `json:"size"`
+	MD5          string               `json:"md5,omitempty"`
+	BlobType     string               `json:"blobType"`
+	ETag         string               `json:"etag"`
+	LastModified time.Time            `json:"lastModified"`
+	Properties   azure.BlobProperties `json:"properties"` // without Metadata and Tags, reported on their own
+	Metadata     map[string]string    `json:"metadata"`
+	Tags         map[string]string    `json:"tags"`
+	Tier         azure.BlobTier       `json:"tier"`
+	Lease        azure.BlobLease      `json:"lease"`
+	Copy         *azure.BlobCopy      `json:"copy,omitempty"`
+}
+
+// InspectConfig is everything runInspect needs once flags and environment are resolved.
+type InspectConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	HTTPClient  *http.Client
+}
+
+// runInspect collects the report of cfg.RemoteFile. Every part of it is a request
+// of its own, the first one failing fails the report.
+func runInspect(cfg InspectConfig) (BlobReport, error) {
+	report := BlobReport{Container: cfg.Container, Name: cfg.RemoteFile}
+	var err error
+	report.Size, report.MD5, err = azure.GetAzureBlobMetaData(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	if err != nil {
+		return report, err
+	}
+	state, err := azure.GetAzureBlobState(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	if err != nil {
+		return report, err
+	}
+	report.BlobType, report.ETag, report.LastModified = state.BlobType, state.ETag, state.LastModified
+	report.Lease, report.Copy = state.Lease, state.Copy
+
+	props, err := azure.GetAzureBlobProperties(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	if err != nil {
+		return report, err
+	}
+	report.Metadata, report.Tags = props.Metadata, props.Tags
+	props.Metadata, props.Tags = nil, nil
+	report.Properties = props
+	if report.Metadata == nil {
+		report.Metadata = map[string]string{} // {} rather than null
+	}
+	if report.Tags == nil {
+		report.Tags = map[string]string{}
+	}
+
+	if report.Tier, err = azure.GetAzureBlobTier(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// writeBlobReport writes r as indented JSON.
+func writeBlobReport(w io.Writer, r BlobReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
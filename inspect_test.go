@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectReport(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{
+		"images/disk.qcow2": {data: []byte("disk image"), contentMD5: md5Of([]byte("disk image")),
+			headers: http.Header{
+				"Content-Type":       {"application/x-qemu-disk"},
+				"X-Ms-Meta-Build":    {"1234"},
+				"X-Ms-Access-Tier":   {"Cool"},
+				"X-Ms-Lease-State":   {"available"},
+				"X-Ms-Lease-Status":  {"unlocked"},
+				"X-Ms-Copy-Status":   {"success"},
+				"X-Ms-Copy-Source":   {"https://other.blob.core.windows.net/c/disk.qcow2"},
+				"X-Ms-Copy-Progress": {"10/10"},
+				"ETag":               {`"0x8DD000000000001"`},
+				"Last-Modified":      {"Tue, 01 Jul 2025 10:00:00 GMT"},
+			}},
+	}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	report, err := runInspect(fakeAccountOf(srv).inspect("images/disk.qcow2"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, writeBlobReport(&out, report))
+
+	var got map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	for _, key := range []string{"container", "name", "size", "md5", "blobType", "etag", "lastModified",
+		"properties", "metadata", "tags", "tier", "lease", "copy"} {
+		require.Contains(t, got, key)
+	}
+	require.JSONEq(t, `{"contentType":"application/x-qemu-disk"}`, string(got["properties"]))
+	require.JSONEq(t, `{"Build":"1234"}`, string(got["metadata"]))
+	require.JSONEq(t, `{}`, string(got["tags"]))
+	require.JSONEq(t, `{"tier":"Cool"}`, string(got["tier"]))
+	require.JSONEq(t, `{"state":"available","status":"unlocked"}`, string(got["lease"]))
+	require.Equal(t, "success", report.Copy.Status)
+	require.Equal(t, int64(len("disk image")), report.Size)
+}
+
+func TestInspectReportWithoutCopy(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{"plain": {data: []byte("x")}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	report, err := runInspect(fakeAccountOf(srv).inspect("plain"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, writeBlobReport(&out, report))
+	require.NotContains(t, out.String(), `"copy"`, "only blobs written by Copy Blob have one")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxKeyFileSize bounds what readAccountKey reads, an account key is under 100 bytes.
+const maxKeyFileSize = 4096
+
+// readAccountKey returns the account key held in the file at path or, with fd >= 0,
+// read from that inherited file descriptor (0 for stdin), so that it never shows up
+// in argv or the environment. Surrounding whitespace, e.g. a trailing newline, is
+// dropped. Neither given returns "".
+func readAccountKey(path string, fd int) (string, error) {
+	var r io.Reader
+	switch {
+	case path != "" && fd >= 0:
+		return "", errors.New("-key-file and -key-fd are mutually exclusive")
+	case path != "":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open key file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	case fd >= 0:
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+		if f == nil {
+			return "", fmt.Errorf("invalid key file descriptor %d", fd)
+		}
+		defer f.Close()
+		r = f
+	default:
+		return "", nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxKeyFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read account key: %w", err)
+	}
+	if len(data) > maxKeyFileSize {
+		return "", fmt.Errorf("account key longer than %d bytes", maxKeyFileSize)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", errors.New("account key is empty")
+	}
+	return key, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAccountKeyFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.key")
+	require.NoError(t, os.WriteFile(path, []byte(fakeAccountKey+"\n"), 0600))
+
+	key, err := readAccountKey(path, -1)
+	require.NoError(t, err)
+	require.Equal(t, fakeAccountKey, key)
+}
+
+func TestReadAccountKeyFromFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	_, err = w.WriteString(fakeAccountKey + "\n")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// readAccountKey closes the descriptor it is given: hand it a copy, or the
+	// finalizer of r would close the number again once another file reuses it
+	t.Cleanup(func() { r.Close() })
+	fd, err := syscall.Dup(int(r.Fd()))
+	require.NoError(t, err)
+	key, err := readAccountKey("", fd)
+	require.NoError(t, err)
+	require.Equal(t, fakeAccountKey, key)
+}
+
+func TestReadAccountKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.key")
+	require.NoError(t, os.WriteFile(empty, []byte("\n"), 0600))
+
+	key, err := readAccountKey("", -1)
+	require.NoError(t, err)
+	require.Empty(t, key, "no key source given")
+
+	_, err = readAccountKey(empty, -1)
+	require.ErrorContains(t, err, "empty")
+	_, err = readAccountKey(filepath.Join(dir, "missing.key"), -1)
+	require.ErrorContains(t, err, "failed to open key file")
+	_, err = readAccountKey(empty, 0)
+	require.ErrorContains(t, err, "mutually exclusive")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// Output formats of -op list.
+const (
+	listFormatPlain = "plain"
+	listFormatJSON  = "json"
+	listFormatCSV   = "csv"
+)
+
+// writeBlobList renders blobs in format: plain is one name per line, json an array
+// of azure.BlobInfo and csv a name,size,lastmodified,md5 table with a header row.
+func writeBlobList(w io.Writer, format string, blobs []azure.BlobInfo) error {
+	switch format {
+	case listFormatPlain:
+		for _, b := range blobs {
+			if _, err := fmt.Fprintln(w, b.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case listFormatJSON:
+		if blobs == nil {
+			blobs = []azure.BlobInfo{} // [] rather than null for an empty container
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(blobs)
+	case listFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"name", "size", "lastmodified", "md5"}); err != nil {
+			return err
+		}
+		for _, b := range blobs {
+			record := []string{b.Name, strconv.FormatInt(b.Size, 10), "", b.ContentMD5}
+			if !b.LastModified.IsZero() {
+				record[2] = b.LastModified.UTC().Format(time.RFC3339)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported list format %q, expected %s, %s or %s",
+			format, listFormatPlain, listFormatJSON, listFormatCSV)
+	}
+}
+
+// writeSnapshotList renders a listing with snapshots like writeBlobList: plain
+// lines add the snapshot after a tab on the snapshots, csv has a
+// name,snapshot,size,lastmodified,md5 header row and json the snapshot field.
+func writeSnapshotList(w io.Writer, format string, blobs []azure.BlobInfo) error {
+	switch format {
+	case listFormatPlain:
+		for _, b := range blobs {
+			line := b.Name
+			if b.Snapshot != "" {
+				line += "\t" + b.Snapshot
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case listFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"name", "snapshot", "size", "lastmodified", "md5"}); err != nil {
+			return err
+		}
+		for _, b := range blobs {
+			record := []string{b.Name, b.Snapshot, strconv.FormatInt(b.Size, 10), "", b.ContentMD5}
+			if !b.LastModified.IsZero() {
+				record[3] = b.LastModified.UTC().Format(time.RFC3339)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return writeBlobList(w, format, blobs)
+	}
+}
+
+// writePathList renders a Data Lake listing like writeBlobList: plain names end
+// with "/" for directories, csv has a name,isdir,size,lastmodified header row.
+func writePathList(w io.Writer, format string, paths []azure.PathInfo) error {
+	switch format {
+	case listFormatPlain:
+		for _, p := range paths {
+			name := p.Name
+			if p.IsDirectory {
+				name += "/"
+			}
+			if _, err := fmt.Fprintln(w, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case listFormatJSON:
+		if paths == nil {
+			paths = []azure.PathInfo{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(paths)
+	case listFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"name", "isdir", "size", "lastmodified"}); err != nil {
+			return err
+		}
+		for _, p := range paths {
+			record := []string{p.Name, strconv.FormatBool(p.IsDirectory), strconv.FormatInt(p.Size, 10), ""}
+			if !p.LastModified.IsZero() {
+				record[3] = p.LastModified.UTC().Format(time.RFC3339)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported list format %q, expected %s, %s or %s",
+			format, listFormatPlain, listFormatJSON, listFormatCSV)
+	}
+}
+
+// Orders of -latest-by.
+const (
+	latestByMtime = "mtime"
+	latestByName  = "name"
+)
+
+// pickLatest returns the blob with the greatest LastModified, or with the
+// lexicographically greatest name when by is latestByName. Ties on the
+// modification time go to the greater name.
+func pickLatest(blobs []azure.BlobInfo, by string) (azure.BlobInfo, error) {
+	if by != latestByMtime && by != latestByName {
+		return azure.BlobInfo{}, fmt.Errorf("unsupported -latest-by %q, expected %s or %s", by, latestByMtime, latestByName)
+	}
+	if len(blobs) == 0 {
+		return azure.BlobInfo{}, fmt.Errorf("no blobs to pick from")
+	}
+	latest := blobs[0]
+	for _, b := range blobs[1:] {
+		newer := b.Name > latest.Name
+		if by == latestByMtime && !b.LastModified.Equal(latest.LastModified) {
+			newer = b.LastModified.After(latest.LastModified)
+		}
+		if newer {
+			latest = b
+		}
+	}
+	return latest, nil
+}
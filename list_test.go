@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+var listedBlobs = []azure.BlobInfo{
+	{
+		Name:         "images/a.qcow2",
+		Size:         4096,
+		LastModified: time.Date(2025, 7, 1, 10, 0, 1, 0, time.UTC),
+		ContentMD5:   "0cc175b9c0f1b6a831c399e269772661",
+	},
+	{
+		Name:         "images/b, with comma.qcow2",
+		Size:         2,
+		LastModified: time.Date(2025, 7, 2, 11, 30, 0, 0, time.UTC),
+	},
+}
+
+func TestWriteBlobListPlain(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeBlobList(&buf, listFormatPlain, listedBlobs))
+	require.Equal(t, "images/a.qcow2\nimages/b, with comma.qcow2\n", buf.String())
+}
+
+func TestWriteBlobListJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeBlobList(&buf, listFormatJSON, listedBlobs))
+
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, []map[string]any{
+		{"name": "images/a.qcow2", "size": 4096.0, "lastModified": "2025-07-01T10:00:01Z",
+			"md5": "0cc175b9c0f1b6a831c399e269772661"},
+		{"name": "images/b, with comma.qcow2", "size": 2.0, "lastModified": "2025-07-02T11:30:00Z"},
+	}, decoded)
+
+	buf.Reset()
+	require.NoError(t, writeBlobList(&buf, listFormatJSON, nil))
+	require.Equal(t, "[]\n", buf.String())
+}
+
+func TestWriteBlobListCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeBlobList(&buf, listFormatCSV, listedBlobs))
+
+	records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"name", "size", "lastmodified", "md5"},
+		{"images/a.qcow2", "4096", "2025-07-01T10:00:01Z", "0cc175b9c0f1b6a831c399e269772661"},
+		{"images/b, with comma.qcow2", "2", "2025-07-02T11:30:00Z", ""},
+	}, records)
+}
+
+func TestWriteBlobListUnknownFormat(t *testing.T) {
+	require.ErrorContains(t, writeBlobList(&bytes.Buffer{}, "yaml", listedBlobs), `unsupported list format "yaml"`)
+}
+
+func TestWritePathList(t *testing.T) {
+	paths := []azure.PathInfo{
+		{Name: "images/boot", IsDirectory: true},
+		{Name: "images/boot/kernel", Size: 42, LastModified: time.Date(2025, 7, 1, 10, 0, 1, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writePathList(&buf, listFormatPlain, paths))
+	require.Equal(t, "images/boot/\nimages/boot/kernel\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writePathList(&buf, listFormatCSV, paths))
+	records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"name", "isdir", "size", "lastmodified"},
+		{"images/boot", "true", "0", ""},
+		{"images/boot/kernel", "false", "42", "2025-07-01T10:00:01Z"},
+	}, records)
+
+	buf.Reset()
+	require.NoError(t, writePathList(&buf, listFormatJSON, nil))
+	require.Equal(t, "[]\n", buf.String())
+}
+
+func TestWriteSnapshotList(t *testing.T) {
+	blobs := []azure.BlobInfo{
+		{Name: "images/a.qcow2", Snapshot: "2025-07-01T12:00:01.0000000Z", Size: 5},
+		{Name: "images/a.qcow2", Size: 6, LastModified: time.Date(2025, 7, 2, 11, 30, 0, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeSnapshotList(&buf, listFormatPlain, blobs))
+	require.Equal(t, "images/a.qcow2\t2025-07-01T12:00:01.0000000Z\nimages/a.qcow2\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeSnapshotList(&buf, listFormatCSV, blobs))
+	records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"name", "snapshot", "size", "lastmodified", "md5"},
+		{"images/a.qcow2", "2025-07-01T12:00:01.0000000Z", "5", "", ""},
+		{"images/a.qcow2", "", "6", "2025-07-02T11:30:00Z", ""},
+	}, records)
+
+	buf.Reset()
+	require.NoError(t, writeSnapshotList(&buf, listFormatJSON, blobs[:1]))
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, "2025-07-01T12:00:01.0000000Z", decoded[0]["snapshot"])
+}
+
+func TestPickLatest(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 7, d, 12, 0, 0, 0, time.UTC) }
+	blobs := []azure.BlobInfo{
+		{Name: "images/v1.qcow2", LastModified: day(1)},
+		{Name: "images/v3.qcow2", LastModified: day(2)}, // rebuilt v3 before v2 was re-uploaded
+		{Name: "images/v2.qcow2", LastModified: day(5)},
+		{Name: "images/v0.qcow2", LastModified: day(5)},
+	}
+
+	latest, err := pickLatest(blobs, latestByMtime)
+	require.NoError(t, err)
+	require.Equal(t, "images/v2.qcow2", latest.Name, "newest, ties go to the greater name")
+
+	latest, err = pickLatest(blobs, latestByName)
+	require.NoError(t, err)
+	require.Equal(t, "images/v3.qcow2", latest.Name)
+
+	_, err = pickLatest(nil, latestByMtime)
+	require.ErrorContains(t, err, "no blobs")
+	_, err = pickLatest(blobs, "size")
+	require.ErrorContains(t, err, `unsupported -latest-by "size"`)
+}
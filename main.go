@@ -1,23 +1,27 @@
 package main
 
 import (
-	"context"
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
-	"sync"
-
-	"github.com/joho/godotenv"
+	"time"
 
 	_ "net/http/pprof"
 
-	"github.com/lf-edge/eve-libs/nettrace"
 	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve-libs/zedUpload/awsutil"
 	"github.com/lf-edge/eve-libs/zedUpload/types"
 	"github.com/lf-edge/eve/pkg/pillar/base"
 	"github.com/sirupsen/logrus"
+
+	azure "testAzureDownload/azureutil"
 )
 
 var (
@@ -28,44 +32,507 @@ var (
 const (
 	SyncAwsTr          zedUpload.SyncTransportType = "s3"
 	SyncAzureTr        zedUpload.SyncTransportType = "azure"
+	SyncHttpTr         zedUpload.SyncTransportType = "http"
 	progressFileSuffix                             = ".progress"
 )
 
 type Notify struct{}
 type CancelChannel chan Notify
 
+// progressFileVersion is the format of the .progress file, to be bumped whenever
+// progressFile changes incompatibly. Version 2 added Ranges, files of version 1
+// are still read.
+const progressFileVersion = 2
+
+// progressFile is the .progress file: the DownloadedParts of the download with the
+// version of its format. Files of an unknown version are discarded on load. It
+// holds no path, the parts are offsets into whatever file it is found for, so a
+// partial file can be moved along with its .progress, or alone with -workspace.
+type progressFile struct {
+	Version    int                   `json:"version"`
+	Downloaded types.DownloadedParts `json:"downloaded"`
+	// the runs of complete parts, left out of Downloaded on save to keep the file of
+	// a large download small, see coalesceParts
+	Ranges []partRange `json:"ranges,omitempty"`
+	// identity of the remote content the parts are from, see Config.ContentID
+	ContentID string `json:"contentID,omitempty"`
+	// what the parts wrote to the local file, kept with Config.VerifyResume
+	Hashes []partHash `json:"hashes,omitempty"`
+}
+
+// Bounds of a .progress file that is worth resuming from. A megabyte part of a
+// terabyte blob needs a million parts. Each takes up to maxProgressPartBytes of
+// JSON: about 40 if it did not coalesce into ranges, and about 110 for its hash
+// with -verify-resume.
+const (
+	maxProgressParts     = 1 << 20
+	maxProgressPartBytes = 40 + 110
+	maxProgressFileSize  = maxProgressParts*maxProgressPartBytes + 1<<20
+)
+
+// loadDownloadedParts returns the parts recorded for locFilename, none if there is
+// no usable progress file. Files written before the format was versioned hold the
+// bare DownloadedParts and are still read.
 func loadDownloadedParts(locFilename string) types.DownloadedParts {
-	var downloadedParts types.DownloadedParts
+	return loadProgress(locFilename).Downloaded
+}
+
+// loadProgress is loadDownloadedParts with the hashes of the parts, if any. An
+// empty locFilename has no progress.
+func loadProgress(locFilename string) progressFile {
+	if locFilename == "" {
+		return progressFile{}
+	}
 	fd, err := os.Open(locFilename + progressFileSuffix)
-	if err == nil {
-		decoder := json.NewDecoder(fd)
-		err = decoder.Decode(&downloadedParts)
-		if err != nil {
-			log.Errorf("failed to decode progress file: %s", err)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to read progress file: %s", err)
 		}
-		err := fd.Close()
-		if err != nil {
-			log.Errorf("failed to close progress file: %s", err)
+		return progressFile{}
+	}
+	defer fd.Close()
+	// the file is read back from disk, where anything may have been put in its place
+	data, err := io.ReadAll(io.LimitReader(fd, maxProgressFileSize+1))
+	if err != nil {
+		log.Errorf("failed to read progress file: %s", err)
+		return progressFile{}
+	}
+	if len(data) > maxProgressFileSize {
+		log.Warnf("Ignoring progress file of more than %d bytes, starting over", maxProgressFileSize)
+		return progressFile{}
+	}
+	file, err := decodeProgressFile(data)
+	if err != nil {
+		log.Warnf("Ignoring progress file: %v, starting over", err)
+		return progressFile{}
+	}
+	return file
+}
+
+// decodeProgressFile decodes a .progress file strictly: unknown fields, trailing
+// data, more than maxProgressParts parts, parts that do not fit the part size or
+// hashes of parts that are not recorded are errors. Ranges are expanded into the
+// parts they stand for.
+func decodeProgressFile(data []byte) (progressFile, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return progressFile{}, fmt.Errorf("failed to decode progress file: %v", err)
+	}
+	var file progressFile
+	switch header.Version {
+	case progressFileVersion, 1:
+		if err := decodeStrict(data, &file); err != nil {
+			return progressFile{}, err
+		}
+	case 0:
+		if err := decodeStrict(data, &file.Downloaded); err != nil {
+			return progressFile{}, err
+		}
+	default:
+		return progressFile{}, fmt.Errorf("unsupported version %d (supported: %d)",
+			header.Version, progressFileVersion)
+	}
+
+	if header.Version == 1 && file.Ranges != nil {
+		return progressFile{}, fmt.Errorf("ranges in a version 1 progress file")
+	}
+	parts := file.Downloaded
+	if len(parts.Parts) > maxProgressParts {
+		return progressFile{}, fmt.Errorf("%d parts, at most %d are expected",
+			len(parts.Parts), maxProgressParts)
+	}
+	parts, err := expandRanges(parts, file.Ranges, maxProgressParts)
+	if err != nil {
+		return progressFile{}, err
+	}
+	file.Downloaded, file.Ranges = parts, nil
+	if len(parts.Parts) > 0 && parts.PartSize <= 0 {
+		return progressFile{}, fmt.Errorf("parts without a part size")
+	}
+	seen := make(map[int64]bool, len(parts.Parts))
+	for _, p := range parts.Parts {
+		switch {
+		case p == nil:
+			return progressFile{}, fmt.Errorf("empty part")
+		case p.Ind < 0 || p.Size < 0 || p.Size > parts.PartSize:
+			return progressFile{}, fmt.Errorf("part %d of size %d does not fit part size %d",
+				p.Ind, p.Size, parts.PartSize)
+		case seen[p.Ind]:
+			return progressFile{}, fmt.Errorf("part %d recorded twice", p.Ind)
+		}
+		seen[p.Ind] = true
+	}
+	for _, h := range file.Hashes {
+		if !seen[h.Ind] {
+			return progressFile{}, fmt.Errorf("hash of part %d, which is not recorded", h.Ind)
 		}
+		delete(seen, h.Ind) // one hash per part
 	}
-	return downloadedParts
+	return file, nil
+}
+
+// decodeStrict decodes the single JSON value of data into v, without unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode progress file: %v", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("failed to decode progress file: data after the progress")
+	}
+	return nil
 }
 
 func saveDownloadedParts(locFilename string, downloadedParts types.DownloadedParts) {
-	fd, err := os.Create(locFilename + progressFileSuffix)
+	saveProgress(locFilename, "", downloadedParts, nil)
+}
+
+// saveProgress is saveDownloadedParts with the content identity and the hashes of
+// the parts. An empty locFilename saves nothing.
+func saveProgress(locFilename, contentID string, downloadedParts types.DownloadedParts, hashes resumeHashes) {
+	if locFilename == "" {
+		return
+	}
+	rest, ranges := coalesceParts(downloadedParts)
+	// written aside and renamed over, so that a crash leaves the previous file whole
+	fd, err := os.CreateTemp(filepath.Dir(locFilename), filepath.Base(locFilename)+progressFileSuffix+".*")
 	if err != nil {
 		log.Errorf("error creating progress file: %s", err)
-	} else {
-		encoder := json.NewEncoder(fd)
-		err = encoder.Encode(downloadedParts)
-		if err != nil {
-			log.Errorf("failed to encode progress file: %s", err)
-		}
-		err := fd.Close()
-		if err != nil {
-			log.Errorf("failed to close progress file: %s", err)
+		return
+	}
+	err = json.NewEncoder(fd).Encode(progressFile{
+		Version:    progressFileVersion,
+		Downloaded: rest,
+		Ranges:     ranges,
+		ContentID:  contentID,
+		Hashes:     hashes.of(downloadedParts),
+	})
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fd.Name(), locFilename+progressFileSuffix)
+	}
+	if err != nil {
+		log.Errorf("failed to write progress file: %s", err)
+		os.Remove(fd.Name())
+	}
+}
+
+// localPathUnder maps a remote blob name onto outDir, mirroring its virtual
+// directories, and creates the intermediate directories.
+// Names that would escape outDir (absolute or containing "..") are refused.
+func localPathUnder(outDir, remoteFile string) (string, error) {
+	name := strings.TrimLeft(filepath.FromSlash(remoteFile), string(filepath.Separator))
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("remote name %q escapes the output directory", remoteFile)
 		}
 	}
+	if name == "" || filepath.Clean(name) == "." {
+		return "", fmt.Errorf("remote name %q has no file name", remoteFile)
+	}
+	localFile := filepath.Join(outDir, name)
+	if err := os.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directories for %s: %w", localFile, err)
+	}
+	return localFile, nil
+}
+
+// startBulkTotals returns the totals of a bulk operation, logged once per interval
+// and, with a debug address, served at /metrics. stop logs them a last time.
+func startBulkTotals(interval time.Duration, debugAddr string, quiet bool) (*transferTotals, func()) {
+	totals := newTransferTotals()
+	serveDebug(debugAddr, totals, quiet)
+	return totals, totals.logEvery(interval)
+}
+
+// serveDebug serves pprof and metrics, at /metrics, on addr in the background. An
+// empty addr serves nothing.
+func serveDebug(addr string, metrics http.Handler, quiet bool) {
+	if addr == "" {
+		return
+	}
+	http.Handle("/metrics", metrics)
+	go func() {
+		if !quiet {
+			fmt.Printf("pprof and metrics listening on %s\n", addr)
+		}
+		_ = http.ListenAndServe(addr, nil)
+	}()
+}
+
+// azureLogger hands the request log of azureutil to log, at the debug level for
+// each try and at the error level for the failures it retries.
+type azureLogger struct{ log *base.LogObject }
+
+func (l azureLogger) Debugf(format string, args ...interface{}) { l.log.Functionf(format, args...) }
+func (l azureLogger) Errorf(format string, args ...interface{}) { l.log.Errorf(format, args...) }
+
+// cliFlags are the values of the command line flags.
+type cliFlags struct {
+	retryOn            string
+	retryJitter        float64
+	retryBudget        int
+	connectionString   string
+	blobURL            string
+	keyFile            string
+	keyFD              int
+	secondaryKey       string
+	allowMismatch      bool
+	endpointSuffix     string
+	outDir             string
+	manifest           string
+	ignoreMissing      bool
+	dedupeByHash       bool
+	workspace          string
+	progressInterval   time.Duration
+	progressStep       float64
+	progressFormat     string
+	debugAddr          string
+	netTrace           bool
+	traceLabel         string
+	op                 string
+	dfs                bool
+	recursive          bool
+	hierarchy          bool
+	prefix             string
+	latestBy           string
+	snapshots          bool
+	listFormat         string
+	auditWorkers       int
+	compareBlob        string
+	compareFile        string
+	byteDiff           bool
+	tier               string
+	rehydratePriority  string
+	noWait             bool
+	rehydrateInterval  time.Duration
+	rehydrateTimeout   time.Duration
+	speedOffset        int64
+	speedLength        int64
+	speedConcurrency   int
+	sasTTL             time.Duration
+	contentDisposition string
+	contentType        string
+	sasPerms           string
+	sasPolicy          string
+	insecureProxyOnly  bool
+	recordHTTP         string
+	replayHTTP         string
+	metadataTimeout    time.Duration
+	metadataCacheTTL   time.Duration
+	maxRequests        int
+	maxTotalBandwidth  int64
+	follow             bool
+	followInterval     time.Duration
+	followTimeout      time.Duration
+	maxSize            int64
+	verifyResume       bool
+	verifyResumeSample float64
+	verifyAfterResume  bool
+	checksumManifest   string
+	cleanupOnError     bool
+	maxParts           int
+	outputBufferSize   int
+	requireImmutable   bool
+	saveMeta           bool
+	localFlag          string
+	remoteFlag         string
+	uploadWorkers      int
+	symlinks           string
+	restoreMeta        bool
+	sasCommand         string
+	quiet              bool
+	logLevel           string
+	tlsSettings        TLSSettings
+	timeouts           azure.ClientTimeouts
+}
+
+// defineFlags declares the flags, to be parsed into the returned cliFlags.
+func defineFlags() *cliFlags {
+	f := &cliFlags{}
+	// already loaded above, declared so they show up in -help
+	flag.String("env-file", "", "load environment from this file instead of searching ./.env and the executable's directory")
+	flag.String("config", "", "YAML file of named connection profiles, see -profile")
+	flag.String("profile", "", "take transport, endpoint, container and credentials from this profile of -config (overrides the environment)")
+	flag.StringVar(&f.retryOn, "retry-on", os.Getenv("RETRY_ON"),
+		"comma-separated HTTP statuses to retry, e.g. 429,503 (401, 403 and 404 are never retried); "+
+			"applies to the requests of the azure and HTTP clients, rejected for downloads through the zedUpload transports")
+	flag.Float64Var(&f.retryJitter, "retry-jitter", azure.DefaultJitterFraction,
+		"spread each retry delay randomly over this fraction of it, e.g. 0.2 for ±20% (0 disables); "+
+			"rejected for downloads through the zedUpload transports")
+	flag.IntVar(&f.retryBudget, "part-retry-budget", 0,
+		"abort once this many retries were spent on the download as a whole, keeping its progress (0 means no limit)")
+	flag.StringVar(&f.connectionString, "connection-string", os.Getenv("AZURE_STORAGE_CONNECTION_STRING"),
+		"Azure storage connection string, replaces ACCOUNT_URL, ACCOUNT_NAME and ACCOUNT_KEY")
+	flag.StringVar(&f.blobURL, "url", "",
+		"the blob as one URL, https://<account>.blob.core.windows.net/<container>/<blob>, optionally with a SAS "+
+			"as its query; replaces ACCOUNT_URL, CONTAINER and REMOTE_FILE, and with a SAS ACCOUNT_KEY")
+	flag.StringVar(&f.keyFile, "key-file", "", "read ACCOUNT_KEY from this file instead of the environment")
+	flag.IntVar(&f.keyFD, "key-fd", -1, "read ACCOUNT_KEY from this inherited file descriptor, e.g. 0 for stdin")
+	flag.StringVar(&f.secondaryKey, "secondary-key", os.Getenv("SECONDARY_ACCOUNT_KEY"),
+		"the other azure account key, used when ACCOUNT_KEY is rejected during key rotation")
+	flag.BoolVar(&f.allowMismatch, "allow-mismatch", false,
+		"accept an ACCOUNT_URL whose host does not start with ACCOUNT_NAME, e.g. an emulator or a custom endpoint")
+	flag.StringVar(&f.endpointSuffix, "endpoint-suffix", os.Getenv("AZURE_ENDPOINT_SUFFIX"),
+		"Azure storage endpoint suffix used when ACCOUNT_URL is unset, e.g. core.usgovcloudapi.net (default core.windows.net)")
+	flag.StringVar(&f.outDir, "outdir", os.Getenv("OUTPUT_DIR"),
+		"download under this directory, mirroring the remote path (overrides LOCAL_FILE)")
+	flag.StringVar(&f.manifest, "manifest", "",
+		"download every blob named in this file, one per line, under -outdir instead of REMOTE_FILE (azure only)")
+	flag.BoolVar(&f.ignoreMissing, "ignore-missing", false,
+		"-manifest: skip the blobs that do not exist instead of failing them")
+	flag.BoolVar(&f.dedupeByHash, "dedupe-by-hash", false,
+		"-manifest: download each Content-MD5 once, hardlinking (or copying) the entries that share it")
+	flag.StringVar(&f.workspace, "workspace", os.Getenv("WORKSPACE_DIR"),
+		"keep progress files in this directory instead of next to the local file")
+	flag.DurationVar(&f.progressInterval, "progress-interval", 2*time.Second,
+		"log download progress at most once per interval")
+	flag.Float64Var(&f.progressStep, "progress-step", 5,
+		"also log progress whenever it advanced by this many percent (0 disables)")
+	flag.StringVar(&f.progressFormat, "progress-format", progressFormatPlain,
+		"download: plain only logs progress, json also writes a JSON line with current, total, rate and "+
+			"eta_seconds once per -progress-interval, to stdout (stderr when downloading to stdout)")
+	flag.StringVar(&f.debugAddr, "debug-addr", "0.0.0.0:6060",
+		"serve pprof and Prometheus /metrics on this address (empty disables)")
+	flag.BoolVar(&f.netTrace, "nettrace", true,
+		"trace connections, DNS queries and HTTP of the download and log the trace with progress; "+
+			"azure with an account key only traces when set explicitly, which downloads through zedUpload "+
+			"without pinning the ranges to one version of the blob")
+	flag.StringVar(&f.traceLabel, "trace-label", defaultTraceLabel,
+		"nettrace: describe the collected traces with this, e.g. to tell concurrent runs apart")
+	flag.StringVar(&f.op, "op", "download",
+		"download REMOTE_FILE to LOCAL_FILE, upload LOCAL_FILE to REMOTE_FILE, list or audit the blobs under -prefix, "+
+			"download the latest of them, compare REMOTE_FILE with -compare-blob or -compare-file, "+
+			"rehydrate REMOTE_FILE into -tier, inspect it, printing all its properties as JSON, delete it, "+
+			"print a SAS URL of it (sas), print its committed and uncommitted blocks (blocklist), "+
+			"or download it to nowhere to measure the throughput of the link (speedtest) "+
+			"(upload, list, audit, latest, compare, rehydrate, inspect, delete, sas, blocklist and speedtest are azure only)")
+	flag.BoolVar(&f.dfs, "dfs", false,
+		"list and delete: use the Data Lake (dfs) endpoint of an account with a hierarchical namespace, "+
+			"which knows real directories; -prefix is then the directory listed")
+	flag.BoolVar(&f.recursive, "recursive", false,
+		"with -dfs: list subdirectories too, delete a directory with everything in it; "+
+			"with -hierarchy: list the blobs of every virtual directory under -prefix")
+	flag.BoolVar(&f.hierarchy, "hierarchy", false,
+		"list: only the level under -prefix, with the virtual directories that / makes of blob names ending in /, "+
+			"like aws s3 ls; without it every blob name under -prefix is listed as is")
+	flag.StringVar(&f.prefix, "prefix", "", "list, audit and latest: only blobs whose name starts with this")
+	flag.StringVar(&f.latestBy, "latest-by", latestByMtime, "latest: pick the blob modified last (mtime) or with the greatest name (name)")
+	flag.BoolVar(&f.snapshots, "snapshots", false, "list: include the snapshots of each blob, with their snapshot timestamp")
+	flag.StringVar(&f.listFormat, "list-format", listFormatPlain, "list: output as plain (one name per line), json or csv")
+	flag.IntVar(&f.auditWorkers, "audit-workers", defaultAuditWorkers, "audit: blobs verified concurrently")
+	flag.StringVar(&f.compareBlob, "compare-blob", "", "compare: the other blob, in the same container")
+	flag.StringVar(&f.compareFile, "compare-file", "", "compare: a local file instead of another blob")
+	flag.BoolVar(&f.byteDiff, "byte-diff", false, "compare: when they differ, stream both to find the first differing byte")
+	flag.StringVar(&f.tier, "tier", "Hot", "rehydrate: the access tier to move REMOTE_FILE to")
+	flag.StringVar(&f.rehydratePriority, "rehydrate-priority", "Standard", "rehydrate: Standard or High")
+	flag.BoolVar(&f.noWait, "no-wait", false, "rehydrate: return once the tier is set instead of waiting for the rehydration")
+	flag.DurationVar(&f.rehydrateInterval, "rehydrate-interval", 5*time.Minute, "rehydrate: poll the tier this often")
+	flag.DurationVar(&f.rehydrateTimeout, "rehydrate-timeout", 24*time.Hour, "rehydrate: give up after waiting this long (0 waits forever)")
+	flag.Int64Var(&f.speedOffset, "speedtest-offset", 0, "speedtest: start reading REMOTE_FILE at this byte")
+	flag.Int64Var(&f.speedLength, "speedtest-length", 0, "speedtest: read this many bytes, 0 for the rest of REMOTE_FILE")
+	flag.IntVar(&f.speedConcurrency, "speedtest-concurrency", 0, "speedtest: chunks read in parallel, 0 for the downloader default")
+	flag.DurationVar(&f.sasTTL, "sas-ttl", time.Hour, "sas: how long the printed URL works")
+	flag.StringVar(&f.contentDisposition, "content-disposition", "",
+		"upload: store this Content-Disposition with the blob; sas: present the blob with it instead, "+
+			`e.g. 'attachment; filename="disk.qcow2"' to have a browser save it under that name`)
+	flag.StringVar(&f.contentType, "content-type", "",
+		"upload: store this Content-Type with the blob instead of the one of the file extension "+
+			"(application/octet-stream for an unknown one) or of -restore-meta")
+	flag.StringVar(&f.sasPerms, "sas-perms", "r", "sas: the permissions of the URL, e.g. r to read or rw to read and write")
+	flag.StringVar(&f.sasPolicy, "sas-policy", "",
+		"sas: refer to this stored access policy of the container, which sets the permissions and expiry "+
+			"instead of -sas-perms and -sas-ttl, so that removing it revokes the URL")
+	flag.StringVar(&f.tlsSettings.CAFile, "ca-file", "",
+		"trust only the PEM certificates of this file instead of the system roots, e.g. a private CA")
+	flag.StringVar(&f.tlsSettings.MinVersion, "tls-min", "",
+		"refuse TLS versions before this one, 1.2 or 1.3 (default 1.2; the zedUpload transports support no other)")
+	flag.BoolVar(&f.tlsSettings.InsecureSkipVerify, "tls-insecure-skip-verify", false,
+		"accept any server certificate: for development against self-signed endpoints only, never in production")
+	flag.BoolVar(&f.insecureProxyOnly, "no-verify-tls-on-proxy-only", false,
+		"send all requests through the https:// proxy of HTTPS_PROXY without verifying its certificate, "+
+			"origins are still verified: for development behind a self-signed proxy only")
+	flag.StringVar(&f.recordHTTP, "record-http", "",
+		"record the requests and responses of the azure and HTTP clients to this cassette file, secrets scrubbed "+
+			"(rejected for downloads through the zedUpload transports)")
+	flag.StringVar(&f.replayHTTP, "replay-http", "",
+		"answer the requests of the azure and HTTP clients from this cassette file of -record-http, offline "+
+			"(rejected for downloads through the zedUpload transports)")
+	f.timeouts = azure.DefaultClientTimeouts()
+	flag.DurationVar(&f.timeouts.Dial, "dial-timeout", f.timeouts.Dial, "timeout for connecting (not used by the zedUpload transports)")
+	flag.DurationVar(&f.timeouts.ResponseHeader, "header-timeout", f.timeouts.ResponseHeader,
+		"timeout for the response headers of each request, so that a connection that never answers, "+
+			"e.g. through a misbehaving load balancer, is retried (rejected for downloads through the zedUpload transports)")
+	flag.DurationVar(&f.timeouts.Idle, "idle-timeout", f.timeouts.Idle,
+		"abort a transfer that made no progress for this long, there is no overall timeout "+
+			"(rejected for downloads through the zedUpload transports)")
+	flag.DurationVar(&f.metadataTimeout, "metadata-timeout", azure.DefaultMetadataTimeout,
+		"deadline of each metadata call, e.g. the existence check and the blob properties, retries included; 0 for none")
+	flag.DurationVar(&f.metadataCacheTTL, "metadata-cache-ttl", 0,
+		"keep existence checks and blob properties for this long, e.g. for -follow polling many blobs; 0 for no cache")
+	flag.IntVar(&f.maxRequests, "max-concurrent-requests", 0,
+		"cap the azure requests in flight at once, across parallel transfers; "+
+			"0 for no cap (rejected for downloads through the zedUpload transports: aws, and azure with an explicit -nettrace)")
+	flag.Int64Var(&f.maxTotalBandwidth, "max-total-bandwidth", 0,
+		"cap the bytes per second of all transfers together, shared evenly among those running at once; "+
+			"0 for no cap (rejected for downloads through the zedUpload transports: aws, and azure with an explicit -nettrace)")
+	flag.BoolVar(&f.follow, "follow", false,
+		"download: wait for REMOTE_FILE to appear before downloading it (azure only)")
+	flag.DurationVar(&f.followInterval, "follow-interval", 10*time.Second, "follow: poll for the blob this often")
+	flag.DurationVar(&f.followTimeout, "follow-timeout", 30*time.Minute, "follow: give up after waiting this long (0 waits forever)")
+	flag.Int64Var(&f.maxSize, "max-size", 0,
+		"download: refuse a remote file larger than this many bytes, 0 means no limit (azure only)")
+	flag.BoolVar(&f.verifyResume, "verify-resume", false,
+		"download: keep a hash of each downloaded part with the progress and, before resuming, "+
+			"check the partial file still holds those bytes, downloading again the parts that do not; "+
+			"the hashes of a progress file that has them are checked and kept without it too")
+	flag.Float64Var(&f.verifyResumeSample, "verify-resume-sample", 0,
+		"download: with -verify-resume, check only this percentage of the parts, picked at random, "+
+			"and all of them once one fails (0 checks all of them)")
+	flag.BoolVar(&f.verifyAfterResume, "verify-after-resume", false,
+		"download: once a resumed download completes, check the MD5 of the whole file against the blob's "+
+			"Content-MD5, failing (and keeping the file) on a mismatch (azure only)")
+	flag.StringVar(&f.checksumManifest, "checksum-manifest", "",
+		"download: record the SHA-256 of the downloaded file, or with -manifest of each downloaded file, in this "+
+			"SHA256SUMS-style file, for sha256sum -c run in its directory; an entry for the same file is replaced")
+	flag.BoolVar(&f.cleanupOnError, "cleanup-on-error", false,
+		"download: delete the partial file and its progress when the download fails after its retries, "+
+			"instead of keeping them to resume from")
+	flag.IntVar(&f.maxParts, "max-parts", maxProgressParts,
+		"download: do not resume a download that takes more parts than this, so its progress stays small "+
+			"(the most the progress file holds is the default)")
+	flag.IntVar(&f.outputBufferSize, "output-buffer-size", 0,
+		"download: write LOCAL_FILE in chunks of this many bytes, e.g. 1048576 for a network filesystem, "+
+			"0 writes data as it arrives (SAS downloads only, the zedUpload transports write on their own)")
+	flag.BoolVar(&f.requireImmutable, "require-immutable", false,
+		"download: refuse unless REMOTE_FILE is under an immutability policy that has not expired, "+
+			"for content whose provenance has to be shown (azure only)")
+	flag.BoolVar(&f.saveMeta, "save-meta", false,
+		"download: keep the content type, metadata and tags of the blob in LOCAL_FILE"+metaSidecarSuffix+" (azure only)")
+	flag.StringVar(&f.localFlag, "local", "", "the local file, - to download to stdout, or for upload a directory to upload recursively (overrides LOCAL_FILE)")
+	flag.StringVar(&f.remoteFlag, "remote", "",
+		"the remote file, or the prefix the files of an uploaded directory are put under (overrides REMOTE_FILE)")
+	flag.IntVar(&f.uploadWorkers, "upload-workers", defaultUploadWorkers, "upload of a directory: files uploaded concurrently")
+	flag.StringVar(&f.symlinks, "symlinks", symlinksSkip, "upload of a directory: skip or fail on symbolic links")
+	flag.BoolVar(&f.restoreMeta, "restore-meta", false,
+		"upload: set the content type, metadata and tags kept in LOCAL_FILE"+metaSidecarSuffix+" by -save-meta")
+	flag.StringVar(&f.sasCommand, "sas-command", "",
+		"upload with a SAS: when the service rejects the SAS partway, e.g. once it expires, run this command "+
+			"with sh -c for a new one, printed as the SAS query, and go on with it")
+	flag.BoolVar(&f.quiet, "quiet", false,
+		"log errors only and no progress, just print the final result")
+	flag.StringVar(&f.logLevel, "log-level", logrus.TraceLevel.String(),
+		"log at this level and above: trace, debug (the progress of each file), info (totals of bulk operations), "+
+			"warning or error")
+	return f
 }
 
 func main() {
@@ -73,9 +540,156 @@ func main() {
 	logger.SetLevel(logrus.TraceLevel)
 	log = base.NewSourceLogObject(logger, "main", 1234)
 
-	_ = godotenv.Load()
+	envFile, err := loadEnvFile(envFileFromArgs(os.Args[1:]), envFileCandidates())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	configFile, profileName := flagFromArgs(os.Args[1:], "config"), flagFromArgs(os.Args[1:], "profile")
+	if profileName != "" {
+		if configFile == "" {
+			log.Fatalf("-profile needs -config")
+		}
+		if err := applyProfile(configFile, profileName); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	f := defineFlags()
+	flag.Parse()
 
+	if level, err := logrus.ParseLevel(f.logLevel); err != nil {
+		log.Fatalf("Invalid -log-level: %v", err)
+	} else {
+		logger.SetLevel(level)
+	}
+	if f.quiet {
+		logger.SetLevel(logrus.ErrorLevel)
+	}
+	if envFile != "" {
+		log.Noticef("Loaded environment from %s", envFile)
+	} else {
+		log.Noticef("No %s file found, using the process environment only", envFileName)
+	}
+	if profileName != "" {
+		log.Noticef("Using profile %s of %s", profileName, configFile)
+	}
+
+	retryPolicy := azure.DefaultRetryPolicy()
+	if f.retryOn != "" {
+		codes, err := azure.ParseRetryStatusCodes(f.retryOn)
+		if err != nil {
+			log.Fatalf("Invalid -retry-on: %v", err)
+		}
+		retryPolicy.StatusCodes = codes
+	}
+	if f.retryJitter < 0 || f.retryJitter > 1 {
+		log.Fatalf("Invalid -retry-jitter: %v, expected a fraction between 0 and 1", f.retryJitter)
+	}
+	retryPolicy.JitterFraction = f.retryJitter
+	azure.SetRetryPolicy(retryPolicy)
+	if f.metadataTimeout < 0 {
+		log.Fatalf("Invalid -metadata-timeout: %v", f.metadataTimeout)
+	}
+	azure.SetMetadataTimeout(f.metadataTimeout)
+	if f.metadataCacheTTL < 0 {
+		log.Fatalf("Invalid -metadata-cache-ttl: %v", f.metadataCacheTTL)
+	}
+	azure.SetMetadataCacheTTL(f.metadataCacheTTL)
+	if f.maxRequests < 0 {
+		log.Fatalf("Invalid -max-concurrent-requests: %d", f.maxRequests)
+	}
+	azure.SetMaxConcurrentRequests(f.maxRequests)
+	if f.maxTotalBandwidth < 0 {
+		log.Fatalf("Invalid -max-total-bandwidth: %d", f.maxTotalBandwidth)
+	}
+	azure.SetMaxTotalBandwidth(f.maxTotalBandwidth)
+
+	runner, ok := ops[f.op]
+	if !ok {
+		log.Fatalf("Unsupported -op: %s", f.op)
+	}
+	c := newOpConfig(f, retryPolicy)
+	if c.recorder != nil {
+		defer c.recorder.Close()
+	}
+	if runner.azureOnly && c.transport != "azure" {
+		log.Fatalf("-op %s is only supported with TRANSPORT=azure", f.op)
+	}
+	checkOpFlags(c)
+	// the lines of the op say which transport and container they are about, those
+	// of a download also which blob
+	opLog := log.CloneAndAddFields(map[string]interface{}{
+		"transport": c.transport,
+		"container": c.container,
+	})
+	azure.SetLogger(azureLogger{log: opLog})
+	runner.run(opLog, c)
+}
+
+// newOpConfig resolves the transport, the account and the files of the op from f
+// and the environment, and the clients it makes its requests with.
+func newOpConfig(f *cliFlags, retryPolicy azure.RetryPolicy) *opConfig {
 	transport := os.Getenv("TRANSPORT")
+	tlsConfig, caPEM, err := f.tlsSettings.config()
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	if f.tlsSettings.InsecureSkipVerify {
+		log.Warnf("Server certificates are not verified (-tls-insecure-skip-verify), the connection can be intercepted")
+	}
+	newHTTPClient := func() *http.Client {
+		return azure.NewHTTPClientWithTLS(f.timeouts, tlsConfig)
+	}
+	if f.insecureProxyOnly {
+		proxy, err := httpsProxyFromEnv()
+		if err == nil {
+			_, err = azure.NewHTTPClientWithInsecureProxy(f.timeouts, tlsConfig, proxy, nil)
+		}
+		if err != nil {
+			log.Fatalf("Invalid -no-verify-tls-on-proxy-only: %v", err)
+		}
+		warn := func(req *http.Request) {
+			log.Warnf("INSECURE: the TLS certificate of proxy %s is not verified (-no-verify-tls-on-proxy-only), "+
+				"it sees everything sent to %s", proxy.Host, req.URL.Host)
+		}
+		newHTTPClient = func() *http.Client {
+			client, _ := azure.NewHTTPClientWithInsecureProxy(f.timeouts, tlsConfig, proxy, warn)
+			return client
+		}
+	}
+	var recorder *azure.Recorder
+	switch {
+	case f.recordHTTP != "" && f.replayHTTP != "":
+		log.Fatalf("-record-http and -replay-http exclude each other")
+	case f.recordHTTP != "":
+		// one recorder for all clients, so that the cassette has the whole run
+		recorder = azure.NewRecorder(newHTTPClient().Transport, f.recordHTTP)
+		newHTTPClient = func() *http.Client { return &http.Client{Transport: recorder} }
+	case f.replayHTTP != "":
+		replayer, err := azure.LoadCassette(f.replayHTTP)
+		if err != nil {
+			log.Fatalf("Invalid -replay-http: %v", err)
+		}
+		newHTTPClient = func() *http.Client { return &http.Client{Transport: replayer} }
+	}
+	if f.symlinks != symlinksSkip && f.symlinks != symlinksFail {
+		log.Fatalf("Unsupported -symlinks: %s", f.symlinks)
+	}
+	switch f.listFormat {
+	case listFormatPlain, listFormatJSON, listFormatCSV:
+	default:
+		log.Fatalf("Unsupported -list-format: %s", f.listFormat)
+	}
+	// events at a steady pace, those -progress-step would add come in bursts
+	var progressEvts *progressEvents
+	switch f.progressFormat {
+	case progressFormatPlain:
+	case progressFormatJSON:
+		f.progressStep = 0
+		progressEvts = newProgressEvents(os.Stdout)
+	default:
+		log.Fatalf("Unsupported -progress-format: %s", f.progressFormat)
+	}
 
 	// Azure values
 	azureURL := os.Getenv("ACCOUNT_URL")
@@ -84,6 +698,7 @@ func main() {
 	azureLocalFile := os.Getenv("LOCAL_FILE")
 	azureAccountName := os.Getenv("ACCOUNT_NAME")
 	azureAccountKey := os.Getenv("ACCOUNT_KEY")
+	var azureSAS string // the credential instead of the key, kept out of azureURL
 
 	// AWS values
 	awsRegion := os.Getenv("AWS_ACCOUNT_URL") // this is actually the region
@@ -94,6 +709,37 @@ func main() {
 	awsSecretKey := os.Getenv("AWS_KEY_SECRET")
 	//awsToken := os.Getenv("AWS_TOKEN")
 
+	if f.blobURL != "" {
+		if f.connectionString != "" {
+			log.Fatalf("-url and -connection-string are exclusive")
+		}
+		if transport != "" && transport != "azure" {
+			log.Fatalf("-url is only supported with TRANSPORT=azure")
+		}
+		u, c, b, sas, err := azure.ParseAzureBlobURL(f.blobURL)
+		if err != nil {
+			log.Fatalf("Invalid -url: %v", err)
+		}
+		transport = "azure"
+		azureURL, azureContainer, azureRemoteFile = u, c, b
+		if sas != "" {
+			if f.keyFile != "" || f.keyFD >= 0 {
+				log.Fatalf("-url with a SAS takes no -key-file or -key-fd")
+			}
+			// the SAS is the credential, as with a SAS connection string
+			azureSAS = sas
+			azureAccountKey = ""
+		}
+	}
+
+	if transport == "" {
+		transport, err = inferTransport(azureURL, azureAccountName, f.connectionString, awsRegion)
+		if err != nil {
+			log.Fatalf("TRANSPORT is not set: %v", err)
+		}
+		log.Functionf("TRANSPORT is not set, using %s", transport)
+	}
+
 	var (
 		auth       *zedUpload.AuthInput
 		accountURL string
@@ -101,10 +747,45 @@ func main() {
 		remoteFile string
 		localFile  string
 		syncTr     zedUpload.SyncTransportType
+		// zedUpload's Azure transport always fetches every part again
+		resumePartSize int64
+		// set when the download bypasses zedUpload
+		httpDl *httpDownloader
 	)
 
 	switch transport {
 	case "azure":
+		if f.connectionString != "" {
+			var err error
+			azureURL, azureAccountName, azureAccountKey, azureSAS, err = azure.ParseAzureConnectionString(f.connectionString)
+			if err != nil {
+				log.Fatalf("Invalid connection string: %v", err)
+			}
+		}
+		// an ACCOUNT_URL with a SAS as its query, the shape of a "Blob service SAS URL"
+		if u, err := url.Parse(azureURL); err == nil && u.RawQuery != "" {
+			if azureSAS == "" {
+				azureSAS = u.RawQuery
+			}
+			u.RawQuery = ""
+			azureURL = strings.TrimSuffix(u.String(), "/")
+		}
+		if key, err := readAccountKey(f.keyFile, f.keyFD); err != nil {
+			log.Fatalf("Invalid account key: %v", err)
+		} else if key != "" {
+			azureAccountKey = key
+		}
+		if azureURL == "" && azureAccountName != "" {
+			azureURL = azure.BlobAccountURL(azureAccountName, f.endpointSuffix)
+		}
+		if !f.allowMismatch {
+			if err := azure.CheckAccountURL(azureURL, azureAccountName); err != nil {
+				log.Fatalf("Invalid account: %v (pass -allow-mismatch for a custom endpoint)", err)
+			}
+		}
+		if f.secondaryKey != "" {
+			azure.SetSecondaryAccountKey(azureAccountKey, f.secondaryKey)
+		}
 		syncTr = SyncAzureTr
 		auth = &zedUpload.AuthInput{
 			AuthType: "password",
@@ -115,6 +796,20 @@ func main() {
 		container = azureContainer
 		remoteFile = azureRemoteFile
 		localFile = azureLocalFile
+		if azureAccountKey == "" {
+			// SAS connection string: zedUpload's Azure transport only signs with a
+			// shared key, but a SAS blob URL can be fetched as a plain HTTP object
+			if azureSAS == "" {
+				log.Fatalf("Azure needs either ACCOUNT_KEY or a SAS connection string")
+			}
+			azure.SetAccountSAS(accountURL, azureSAS)
+			syncTr = SyncHttpTr
+			auth = nil
+			// fetched by httpDownloader, which unlike zedUpload's HTTP transport resumes
+			httpDl = &httpDownloader{baseURL: accountURL + "/" + container, query: azureSAS,
+				client: newHTTPClient()}
+			resumePartSize = httpPartSize
+		}
 	case "aws":
 		syncTr = SyncAwsTr
 		if strings.HasPrefix(awsRegion, "http") {
@@ -125,6 +820,7 @@ func main() {
 			Uname:    awsAccessKey,
 			Password: awsSecretKey,
 		}
+		resumePartSize = awsutil.S3PartSize
 		accountURL = awsRegion
 		container = awsContainer
 		remoteFile = awsRemoteFile
@@ -133,77 +829,91 @@ func main() {
 		log.Fatalf("Unsupported TRANSPORT: %s", transport)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		fmt.Println("pprof listening on :6060")
-		_ = http.ListenAndServe("0.0.0.0:6060", nil)
-	}()
-
-	traceOpts := []nettrace.TraceOpt{
-		&nettrace.WithLogging{CustomLogger: &base.LogrusWrapper{Log: log}},
-		&nettrace.WithConntrack{},
-		&nettrace.WithDNSQueryTrace{},
+	if f.localFlag != "" {
+		localFile = f.localFlag
 	}
-
-	dCtx, _ := zedUpload.NewDronaCtx("mydownloader", 0)
-	dEndPoint, err := dCtx.NewSyncerDest(syncTr, accountURL, container, auth)
-	if err != nil {
-		log.Fatalf("Failed to create endpoint: %v", err)
+	if f.remoteFlag != "" {
+		remoteFile = f.remoteFlag
 	}
-	dEndPoint.WithNetTracing(traceOpts...)
-
-	downloadedParts := loadDownloadedParts(remoteFile)
-	downloadedPartsHash := downloadedParts.Hash()
-
-	respChan := make(chan *zedUpload.DronaRequest)
-	objSize := int64(3750756352)
-
-	req := dEndPoint.NewRequest(zedUpload.SyncOpDownload, remoteFile, localFile, objSize, true, respChan)
-
-	if req == nil {
-		log.Errorf("Failed to create request")
-		return
+	// the human-readable lines go to stderr once stdout carries the blob
+	var out io.Writer = os.Stdout
+	if localFile == stdoutLocalFile && (f.op == "download" || f.op == "latest") {
+		out = os.Stderr
 	}
-	req = req.WithDoneParts(downloadedParts)
-	req = req.WithCancel(context.Background())
-	defer req.Cancel()
-	req = req.WithLogger(logger)
 
-	req.Post()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for resp := range respChan {
-			newParts := resp.GetDoneParts()
-			if downloadedPartsHash != newParts.Hash() {
-				downloadedParts = newParts
-				downloadedPartsHash = newParts.Hash()
-				saveDownloadedParts(localFile, downloadedParts)
-			}
+	if f.workspace != "" {
+		if err := os.MkdirAll(f.workspace, 0755); err != nil {
+			log.Fatalf("Failed to create -workspace: %v", err)
+		}
+	}
 
-			if resp.IsDnUpdate() {
-				currentSize, totalSize, _ := resp.Progress()
-				log.Functionf("Progress: %v/%v for %s", currentSize, totalSize, resp.GetLocalName())
-				if currentSize > totalSize {
-					log.Errorf("Aborting: current > total size (%v > %v)", currentSize, totalSize)
-					return
-				}
-				dEndPoint.GetNetTrace("DownloadTrace")
-				continue
-			}
+	return &opConfig{
+		cliFlags:       f,
+		transport:      transport,
+		accountURL:     accountURL,
+		accountName:    azureAccountName,
+		accountKey:     azureAccountKey,
+		sas:            azureSAS,
+		container:      container,
+		remoteFile:     remoteFile,
+		localFile:      localFile,
+		auth:           auth,
+		syncTr:         syncTr,
+		resumePartSize: resumePartSize,
+		httpDl:         httpDl,
+		caPEM:          caPEM,
+		retryPolicy:    retryPolicy,
+		newHTTPClient:  newHTTPClient,
+		recorder:       recorder,
+		progressEvts:   progressEvts,
+		out:            out,
+	}
+}
 
-			if resp.IsError() {
-				log.Errorf("Download failed: %v", resp.GetDnStatus())
-				return
-			}
+// checkOpFlags rejects the flags that do not apply to c.op, or lack a flag they need.
+func checkOpFlags(c *opConfig) {
+	if (c.ignoreMissing || c.dedupeByHash) && c.manifest == "" {
+		log.Fatalf("-ignore-missing and -dedupe-by-hash need -manifest")
+	}
+	if c.manifest != "" {
+		if c.op != "download" || c.transport != "azure" {
+			log.Fatalf("-manifest is only supported with -op download and TRANSPORT=azure")
+		}
+		if c.outDir == "" {
+			log.Fatalf("-manifest needs -outdir")
+		}
+		if c.saveMeta {
+			log.Fatalf("-save-meta is not supported with -manifest")
+		}
+	}
+	if c.snapshots && (c.op != "list" || c.dfs) {
+		log.Fatalf("-snapshots is only supported with -op list on the blob endpoint")
+	}
+	if c.hierarchy && (c.op != "list" || c.dfs || c.snapshots) {
+		log.Fatalf("-hierarchy is only supported with -op list on the blob endpoint, without -snapshots")
+	}
+	if c.dfs && c.op != "list" && c.op != "delete" {
+		log.Fatalf("-dfs is only supported with -op list and -op delete")
+	}
+}
 
-			log.Functionf("Download done: %s (%d bytes)", resp.GetLocalName(), resp.GetAsize())
-			return
+// flagSet reports whether the flag name was set on the command line, rather
+// than left at its default.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
-	}()
-	wg.Wait()
-	fmt.Println("Download succeeded")
+	})
+	return set
+}
+
+// printDownloadResult writes the closing lines of a successful download to w.
+func printDownloadResult(w io.Writer, result Result) {
+	fmt.Fprintf(w, "Download succeeded: %d bytes in %v (resumed: %v, md5: %s, retries: %d)\n",
+		result.Bytes, result.Duration.Round(time.Millisecond), result.Resumed, result.MD5, result.RetryCount)
+	if result.LastTransientError != nil {
+		fmt.Fprintf(w, "Last transient error: %v\n", result.LastTransientError)
+	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPathUnderNested(t *testing.T) {
+	outDir := t.TempDir()
+
+	localFile, err := localPathUnder(outDir, "images/v2/disk.qcow2")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(outDir, "images", "v2", "disk.qcow2"), localFile)
+
+	info, err := os.Stat(filepath.Join(outDir, "images", "v2"))
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+
+	// a leading slash is a virtual directory, not the filesystem root
+	localFile, err = localPathUnder(outDir, "/flat.bin")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(outDir, "flat.bin"), localFile)
+}
+
+func TestLocalPathUnderRejectsTraversal(t *testing.T) {
+	outDir := t.TempDir()
+	for _, name := range []string{"../etc/passwd", "images/../../escape", "a/..", "", "/"} {
+		_, err := localPathUnder(outDir, name)
+		require.Error(t, err, name)
+	}
+}
+
+func TestProgressFileRoundTrip(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "local.bin")
+	parts := types.DownloadedParts{PartSize: 4, Parts: []*types.PartDefinition{{Ind: 0, Size: 4}, {Ind: 1, Size: 2}}}
+	saveDownloadedParts(base, parts)
+
+	data, err := os.ReadFile(base + progressFileSuffix)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":2,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4},{"i":1,"s":2}]}}`, string(data))
+	require.Equal(t, parts, loadDownloadedParts(base))
+}
+
+func TestProgressFileCoalescesParts(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "local.bin")
+	// a gap at 5000, a partial part and a short last one
+	want := types.DownloadedParts{PartSize: 4}
+	for i := int64(0); i < 10000; i++ {
+		switch i {
+		case 5000:
+		case 7000:
+			want.Parts = append(want.Parts, &types.PartDefinition{Ind: i, Size: 1})
+		case 9999:
+			want.Parts = append(want.Parts, &types.PartDefinition{Ind: i, Size: 3})
+		default:
+			want.Parts = append(want.Parts, &types.PartDefinition{Ind: i, Size: 4})
+		}
+	}
+	// completed out of order
+	parts := types.DownloadedParts{PartSize: 4, Parts: slices.Clone(want.Parts)}
+	slices.Reverse(parts.Parts)
+	saveDownloadedParts(base, parts)
+
+	data, err := os.ReadFile(base + progressFileSuffix)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":2,"downloaded":{"PartSize":4,"Parts":[{"i":7000,"s":1},{"i":9999,"s":3}]},`+
+		`"ranges":[{"from":0,"to":4999},{"from":5001,"to":6999},{"from":7001,"to":9998}]}`, string(data))
+
+	require.Equal(t, want, loadDownloadedParts(base))
+}
+
+func TestProgressFileVersions(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		want    types.DownloadedParts
+	}{
+		"unversioned": {
+			content: `{"PartSize":4,"Parts":[{"i":0,"s":4}]}`,
+			want:    types.DownloadedParts{PartSize: 4, Parts: []*types.PartDefinition{{Ind: 0, Size: 4}}},
+		},
+		"future version": {
+			content: `{"version":3,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4}]},"checksums":["x"]}`,
+		},
+		"corrupt": {
+			content: `{"version":1,"downl`,
+		},
+		"unknown field": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4,"x":1}]}}`,
+		},
+		"trailing data": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4}]}}{"version":1}`,
+		},
+		"null part": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[null]}}`,
+		},
+		"part larger than part size": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":5}]}}`,
+		},
+		"negative index": {
+			content: `{"PartSize":4,"Parts":[{"i":-1,"s":4}]}`,
+		},
+		"duplicate part": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4},{"i":0,"s":4}]}}`,
+		},
+		"hash of an unknown part": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[{"i":0,"s":4}]},` +
+				`"hashes":[{"i":1,"s":4,"sha256":"x"}]}`,
+		},
+		"ranges": {
+			content: `{"version":2,"downloaded":{"PartSize":4,"Parts":[{"i":3,"s":1}]},"ranges":[{"from":0,"to":1}]}`,
+			want: types.DownloadedParts{PartSize: 4, Parts: []*types.PartDefinition{
+				{Ind: 0, Size: 4}, {Ind: 1, Size: 4}, {Ind: 3, Size: 1}}},
+		},
+		"ranges in version 1": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":[]},"ranges":[{"from":0,"to":1}]}`,
+		},
+		"overlapping ranges": {
+			content: `{"version":2,"downloaded":{"PartSize":4,"Parts":[{"i":1,"s":4}]},"ranges":[{"from":0,"to":1}]}`,
+		},
+		"reversed range": {
+			content: `{"version":2,"downloaded":{"PartSize":4,"Parts":[]},"ranges":[{"from":1,"to":0}]}`,
+		},
+		"huge range": {
+			content: `{"version":2,"downloaded":{"PartSize":4,"Parts":[]},"ranges":[{"from":0,"to":9223372036854775807}]}`,
+		},
+		"no part size": {
+			content: `{"version":1,"downloaded":{"Parts":[{"i":0,"s":0}]}}`,
+		},
+		"deeply nested": {
+			content: `{"version":1,"downloaded":{"PartSize":4,"Parts":` + strings.Repeat("[", 100000) +
+				strings.Repeat("]", 100000) + `}}`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "local.bin")
+			require.NoError(t, os.WriteFile(base+progressFileSuffix, []byte(tc.content), 0644))
+			require.Equal(t, tc.want, loadDownloadedParts(base))
+		})
+	}
+}
+
+func TestProgressFileTooLarge(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "local.bin")
+	// valid JSON, only too much of it
+	content := `{"version":1,"downloaded":{"PartSize":4,"Parts":[]}}` + strings.Repeat(" ", maxProgressFileSize)
+	require.NoError(t, os.WriteFile(base+progressFileSuffix, []byte(content), 0644))
+	require.Equal(t, types.DownloadedParts{}, loadDownloadedParts(base))
+}
+
+func TestProgressFileTooManyParts(t *testing.T) {
+	parts := types.DownloadedParts{PartSize: 1}
+	for i := range maxProgressParts + 1 {
+		parts.Parts = append(parts.Parts, &types.PartDefinition{Ind: int64(i), Size: 1})
+	}
+	data, err := json.Marshal(progressFile{Version: progressFileVersion, Downloaded: parts})
+	require.NoError(t, err)
+	require.Less(t, len(data), maxProgressFileSize, "within the size cap")
+
+	_, err = decodeProgressFile(data)
+	require.ErrorContains(t, err, "at most")
+}
+
+func TestProgressFileWithHashesWithinCap(t *testing.T) {
+	// the most a file may hold: parts that do not coalesce, each with a hash
+	const partSize = 1 << 40
+	parts := types.DownloadedParts{PartSize: partSize}
+	var hashes []partHash
+	for i := range maxProgressParts {
+		parts.Parts = append(parts.Parts, &types.PartDefinition{Ind: int64(i), Size: partSize - 1})
+		hashes = append(hashes, partHash{Ind: int64(i), Size: partSize - 1, SHA256: strings.Repeat("f", 64)})
+	}
+	data, err := json.Marshal(progressFile{Version: progressFileVersion, Downloaded: parts, Hashes: hashes})
+	require.NoError(t, err)
+	require.Less(t, len(data), maxProgressFileSize, "within the size cap")
+}
+
+func TestSaveProgressReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "local.bin")
+	parts := types.DownloadedParts{PartSize: 4, Parts: []*types.PartDefinition{{Ind: 0, Size: 2}}}
+	saveDownloadedParts(base, parts)
+	parts.Parts[0].Size = 3
+	saveDownloadedParts(base, parts)
+	require.Equal(t, parts, loadDownloadedParts(base))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left")
+}
+
+func TestProgressFileMissing(t *testing.T) {
+	require.Empty(t, loadDownloadedParts(filepath.Join(t.TempDir(), "local.bin")).Parts)
+}
+
+func TestPrintDownloadResult(t *testing.T) {
+	var out bytes.Buffer
+	printDownloadResult(&out, Result{Bytes: 2048, Duration: 1500 * time.Millisecond, MD5: "abc", RetryCount: 2,
+		LastTransientError: errors.New("connection reset")})
+	require.Equal(t, "Download succeeded: 2048 bytes in 1.5s (resumed: false, md5: abc, retries: 2)\n"+
+		"Last transient error: connection reset\n", out.String())
+
+	out.Reset()
+	printDownloadResult(&out, Result{Bytes: 1, Resumed: true, MD5: "abc"})
+	require.Equal(t, "Download succeeded: 1 bytes in 0s (resumed: true, md5: abc, retries: 0)\n", out.String())
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// ManifestConfig is everything runManifestDownload needs once flags and environment
+// are resolved. RemoteFile of the embedded StreamConfig is set for each entry.
+type ManifestConfig struct {
+	StreamConfig
+	Manifest string // file of blob names, one per line
+	OutDir   string // the blobs are downloaded under it, as with -outdir
+	// skip the blobs that do not exist instead of failing them
+	IgnoreMissing bool
+	// SHA256SUMS-style file to record each downloaded blob in, empty for none
+	ChecksumManifest string
+	// look up the Content-MD5 of each blob first, and hardlink or copy an entry whose
+	// MD5 was already downloaded by an earlier one instead of downloading it again
+	DedupeByHash bool
+}
+
+// ManifestEntryResult is the download of one blob of the manifest.
+type ManifestEntryResult struct {
+	Blob      string
+	LocalFile string
+	Result    Result
+	Missing   bool // skipped, the blob does not exist
+	// the LocalFile of the earlier entry with the same Content-MD5 this one was
+	// linked or copied from, with DedupeByHash; empty when it was downloaded
+	LinkedFrom string
+	Err        error
+}
+
+// ManifestResult summarizes a manifest download. Entries are in manifest order.
+type ManifestResult struct {
+	Entries  []ManifestEntryResult
+	Duration time.Duration
+}
+
+// OK reports whether every entry was downloaded or skipped as missing.
+func (r ManifestResult) OK() bool {
+	return r.Failed() == 0
+}
+
+// Failed counts the entries that could not be downloaded.
+func (r ManifestResult) Failed() int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Missing counts the entries skipped because their blob does not exist.
+func (r ManifestResult) Missing() int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Missing {
+			n++
+		}
+	}
+	return n
+}
+
+func (r ManifestResult) String() string {
+	var bytes int64
+	for _, e := range r.Entries {
+		bytes += e.Result.Bytes
+	}
+	return fmt.Sprintf("%d blobs, %d succeeded, %d failed, %d skipped missing (%d bytes in %v)",
+		len(r.Entries), len(r.Entries)-r.Failed()-r.Missing(), r.Failed(), r.Missing(),
+		bytes, r.Duration.Round(time.Millisecond))
+}
+
+// readManifest returns the blob names of a manifest: one per line, leaving out
+// blank lines and # comments.
+func readManifest(manifest string) ([]string, error) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest: %w", err)
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s: %w", manifest, err)
+	}
+	return names, nil
+}
+
+// runManifestDownload downloads every blob named in cfg.Manifest under cfg.OutDir,
+// one after the other. Each is written to a temporary file next to its target and
+// renamed over it once complete, so that a failed entry leaves nothing behind. With
+// cfg.IgnoreMissing a blob that does not exist is skipped; a missing container, like
+// any other error, still fails the entry. Per-entry failures are reported in the
+// result; an unreadable manifest or a name that would escape cfg.OutDir is an error
+// before anything is downloaded. With cfg.ChecksumManifest each downloaded entry is
+// recorded in it as soon as it is complete, failing the entry if that fails. With
+// cfg.DedupeByHash an entry whose listed Content-MD5 is that of an entry downloaded
+// before it, and checked on the way, is hardlinked to that file, or copied if the
+// link fails, e.g. across filesystems. A blob without a Content-MD5 is downloaded.
+func runManifestDownload(cfg ManifestConfig) (ManifestResult, error) {
+	log := cfg.logger()
+	started := time.Now()
+	names, err := readManifest(cfg.Manifest)
+	if err != nil {
+		return ManifestResult{}, err
+	}
+	result := ManifestResult{Entries: make([]ManifestEntryResult, len(names))}
+	for i, name := range names {
+		localFile, err := localPathUnder(cfg.OutDir, name)
+		if err != nil {
+			return ManifestResult{}, fmt.Errorf("invalid manifest entry %q: %w", name, err)
+		}
+		result.Entries[i] = ManifestEntryResult{Blob: name, LocalFile: localFile}
+	}
+
+	downloaded := map[string]*ManifestEntryResult{} // by Content-MD5, with DedupeByHash
+	for i := range result.Entries {
+		e := &result.Entries[i]
+		entryCfg := cfg.StreamConfig
+		entryCfg.RemoteFile = e.Blob
+		entryCfg.Log = log.CloneAndAddField("blob", e.Blob)
+		var listedMD5 string
+		if cfg.DedupeByHash {
+			_, listedMD5, e.Err = azure.GetAzureBlobMetaData(entryCfg.AccountURL, entryCfg.AccountName,
+				entryCfg.AccountKey, entryCfg.Container, e.Blob, entryCfg.HTTPClient)
+		}
+		switch same := downloaded[listedMD5]; {
+		case e.Err != nil:
+		case same != nil:
+			if e.Err = linkOrCopy(same.LocalFile, e.LocalFile); e.Err == nil {
+				log.Noticef("Manifest: %s has the content of %s, linked to %s", e.Blob, same.Blob, same.LocalFile)
+				e.LinkedFrom = same.LocalFile
+				e.Result = Result{MD5: same.Result.MD5, SHA256: same.Result.SHA256}
+			}
+		default:
+			e.Result, e.Err = downloadManifestEntry(entryCfg, e.LocalFile)
+			if cfg.DedupeByHash && e.Err == nil && listedMD5 != "" && e.Result.MD5 == listedMD5 {
+				downloaded[listedMD5] = e
+			}
+		}
+		if e.Err == nil && cfg.ChecksumManifest != "" {
+			if err := updateChecksumManifest(cfg.ChecksumManifest, e.LocalFile, e.Result.SHA256); err != nil {
+				e.Err = fmt.Errorf("recording the checksum failed: %w", err)
+			}
+		}
+		switch {
+		case e.Err == nil:
+		case cfg.IgnoreMissing && blobNotFound(e.Err):
+			log.Noticef("Manifest: skipping %s, it does not exist", e.Blob)
+			e.Missing, e.Err = true, nil
+		default:
+			log.Errorf("Manifest: %s: %v", e.Blob, e.Err)
+		}
+	}
+	result.Duration = time.Since(started)
+	return result, nil
+}
+
+func downloadManifestEntry(cfg StreamConfig, localFile string) (Result, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(localFile), "."+filepath.Base(localFile)+".*")
+	if err != nil {
+		return Result{}, err
+	}
+	w := bufio.NewWriter(tmp)
+	result, err := runStreamDownload(cfg, w)
+	if err == nil {
+		err = tmp.Chmod(0644) // not the 0600 of a temporary file
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), localFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return result, err
+}
+
+// linkOrCopy puts a hardlink to src at dst, or a copy of src if it cannot be
+// linked, replacing dst. Like a download, it goes through a temporary file next to
+// dst, so that a failure leaves nothing behind.
+func linkOrCopy(src, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpName) // only the name is wanted, os.Link does not replace
+	if err = os.Link(src, tmpName); err != nil {
+		err = copyFile(src, tmpName)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, dst)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// copyFile copies src to the new file dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// blobNotFound tells whether err is the service reporting that the blob does not
+// exist, rather than e.g. its container.
+func blobNotFound(err error) bool {
+	var svcErr *azure.ServiceError
+	return errors.As(err, &svcErr) && svcErr.ErrorCode == "BlobNotFound"
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunManifestDownload(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{
+		"images/a.img": {data: []byte("first image")},
+		"images/b.img": {data: []byte("second image")},
+	}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+fakeContainer+"/images/denied.img" {
+			w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		store.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "blobs.txt")
+	require.NoError(t, os.WriteFile(manifest, []byte(
+		"# nightly images\nimages/a.img\nimages/not-yet.img\n\nimages/b.img\nimages/denied.img\n"), 0644))
+	outDir := filepath.Join(dir, "out")
+
+	for name, tc := range map[string]struct {
+		ignoreMissing bool
+		failed        int
+		missing       int
+	}{
+		"fails missing blobs": {failed: 2},
+		"ignore missing":      {ignoreMissing: true, failed: 1, missing: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(outDir))
+			result, err := runManifestDownload(ManifestConfig{
+				StreamConfig:  streamTestConfig(srv, ""),
+				Manifest:      manifest,
+				OutDir:        outDir,
+				IgnoreMissing: tc.ignoreMissing,
+			})
+			require.NoError(t, err)
+			require.Len(t, result.Entries, 4)
+			require.Equal(t, tc.failed, result.Failed())
+			require.Equal(t, tc.missing, result.Missing())
+			require.False(t, result.OK())
+			require.Contains(t, result.String(), "2 succeeded")
+
+			got, err := os.ReadFile(filepath.Join(outDir, "images", "a.img"))
+			require.NoError(t, err)
+			require.Equal(t, "first image", string(got))
+			got, err = os.ReadFile(filepath.Join(outDir, "images", "b.img"))
+			require.NoError(t, err)
+			require.Equal(t, "second image", string(got))
+			require.ErrorContains(t, result.Entries[3].Err, "AuthorizationFailure", "a real error still fails")
+			require.Equal(t, tc.ignoreMissing, result.Entries[1].Missing)
+			entries, err := os.ReadDir(filepath.Join(outDir, "images"))
+			require.NoError(t, err)
+			require.Len(t, entries, 2, "nothing is left of the entries that failed")
+		})
+	}
+}
+
+func TestRunManifestDownloadChecksumManifest(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{
+		"images/a.img": {data: []byte("first image")},
+		"images/b.img": {data: []byte("second image")},
+	}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "blobs.txt")
+	require.NoError(t, os.WriteFile(manifest, []byte("images/a.img\nimages/missing.img\nimages/b.img\n"), 0644))
+	outDir := filepath.Join(dir, "out")
+	sums := filepath.Join(outDir, "SHA256SUMS")
+
+	result, err := runManifestDownload(ManifestConfig{
+		StreamConfig:     streamTestConfig(srv, ""),
+		Manifest:         manifest,
+		OutDir:           outDir,
+		IgnoreMissing:    true,
+		ChecksumManifest: sums,
+	})
+	require.NoError(t, err)
+	require.True(t, result.OK())
+
+	a, b := sha256.Sum256([]byte("first image")), sha256.Sum256([]byte("second image"))
+	got, err := os.ReadFile(sums)
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(a[:])+"  images/a.img\n"+hex.EncodeToString(b[:])+"  images/b.img\n",
+		string(got), "one line per downloaded entry, none for the missing one")
+}
+
+func TestRunManifestDownloadDedupeByHash(t *testing.T) {
+	withoutRetries(t)
+	content := []byte("the same installer under two names")
+	store := &auditStore{blobs: map[string]auditBlob{
+		"releases/1.0/installer.raw": {data: content, contentMD5: md5Of(content)},
+		"latest/installer.raw":       {data: content, contentMD5: md5Of(content)},
+	}}
+	var gets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets = append(gets, r.URL.Path)
+		}
+		store.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "blobs.txt")
+	require.NoError(t, os.WriteFile(manifest, []byte("releases/1.0/installer.raw\nlatest/installer.raw\n"), 0644))
+	outDir := filepath.Join(dir, "out")
+
+	result, err := runManifestDownload(ManifestConfig{
+		StreamConfig: streamTestConfig(srv, ""),
+		Manifest:     manifest,
+		OutDir:       outDir,
+		DedupeByHash: true,
+	})
+	require.NoError(t, err)
+	require.True(t, result.OK())
+	require.Equal(t, []string{"/" + fakeContainer + "/releases/1.0/installer.raw"}, gets, "the content is downloaded once")
+
+	first, second := result.Entries[0], result.Entries[1]
+	require.Empty(t, first.LinkedFrom)
+	require.Equal(t, first.LocalFile, second.LinkedFrom)
+	require.Equal(t, first.Result.SHA256, second.Result.SHA256)
+	got, err := os.ReadFile(second.LocalFile)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	firstInfo, err := os.Stat(first.LocalFile)
+	require.NoError(t, err)
+	secondInfo, err := os.Stat(second.LocalFile)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(firstInfo, secondInfo), "hardlinked")
+}
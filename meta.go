@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// metaSidecarSuffix names the file next to a downloaded blob that keeps its
+// content properties, metadata and tags for a later upload to restore.
+const metaSidecarSuffix = ".meta.json"
+
+// saveBlobMeta writes props to the .meta.json sidecar of localFile.
+func saveBlobMeta(localFile string, props azure.BlobProperties) error {
+	data, err := json.MarshalIndent(props, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(localFile+metaSidecarSuffix, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metadata sidecar: %w", err)
+	}
+	return nil
+}
+
+// loadBlobMeta reads the .meta.json sidecar of localFile, nil if there is none.
+func loadBlobMeta(localFile string) (*azure.BlobProperties, error) {
+	data, err := os.ReadFile(localFile + metaSidecarSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata sidecar: %w", err)
+	}
+	var props azure.BlobProperties
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata sidecar %s: %w", localFile+metaSidecarSuffix, err)
+	}
+	return &props, nil
+}
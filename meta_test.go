@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestBlobMetaSidecar(t *testing.T) {
+	localFile := filepath.Join(t.TempDir(), "disk.qcow2")
+	props, err := loadBlobMeta(localFile)
+	require.NoError(t, err)
+	require.Nil(t, props, "no sidecar")
+
+	want := azure.BlobProperties{
+		ContentType: "application/x-qemu-disk",
+		Metadata:    map[string]string{"build": "1234"},
+		Tags:        map[string]string{"stage": "rc"},
+	}
+	require.NoError(t, saveBlobMeta(localFile, want))
+	props, err = loadBlobMeta(localFile)
+	require.NoError(t, err)
+	require.Equal(t, &want, props)
+
+	require.NoError(t, os.WriteFile(localFile+metaSidecarSuffix, []byte("{"), 0644))
+	_, err = loadBlobMeta(localFile)
+	require.ErrorContains(t, err, "failed to decode metadata sidecar")
+}
+
+func TestRunUploadRestoresMeta(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	localFile := filepath.Join(t.TempDir(), "disk.qcow2")
+	require.NoError(t, os.WriteFile(localFile, []byte("disk image"), 0644))
+	// as written by a download with -save-meta
+	require.NoError(t, saveBlobMeta(localFile, azure.BlobProperties{
+		ContentType: "application/x-qemu-disk",
+		Metadata:    map[string]string{"build": "1234"},
+		Tags:        map[string]string{"stage": "rc"},
+	}))
+	cfg := fakeAccountOf(srv).upload("disk.qcow2", localFile)
+	cfg.RestoreMeta = true
+
+	_, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "application/x-qemu-disk", store.committed.Get("x-ms-blob-content-type"))
+	require.Equal(t, "1234", store.committed.Get("x-ms-meta-build"))
+	require.Equal(t, "stage=rc", store.committed.Get("x-ms-tags"))
+
+	cfg.RestoreMeta = false
+	_, err = runUpload(cfg)
+	require.NoError(t, err)
+	require.Empty(t, store.committed.Get("x-ms-meta-build"), "the sidecar is only used when asked to")
+
+	require.NoError(t, os.WriteFile(localFile+metaSidecarSuffix, []byte("{"), 0644))
+	cfg.RestoreMeta = true
+	store.putBlocks = 0
+	_, err = runUpload(cfg)
+	require.ErrorContains(t, err, "metadata sidecar")
+	require.Zero(t, store.putBlocks, "nothing is staged with a broken sidecar")
+}
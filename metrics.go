@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chunkDurationBuckets are the upper bounds, in seconds, of the chunk duration histogram.
+var chunkDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// downloadMetrics is exposed at /metrics in the Prometheus text format (0.0.4).
+// The handful of series is rendered by hand rather than through client_golang,
+// which would pull a dozen modules into the vendor tree.
+// A nil *downloadMetrics records nothing.
+type downloadMetrics struct {
+	mu sync.Mutex
+
+	bytes      int64   // downloaded so far, as last reported by the transport
+	throughput float64 // bytes per second between the last two progress events
+	retries    int64
+
+	chunkCounts []uint64 // per bucket of chunkDurationBuckets, not cumulative
+	chunkSum    float64
+	chunkCount  uint64
+
+	lastProgress time.Time
+	lastPart     time.Time
+}
+
+func newDownloadMetrics() *downloadMetrics {
+	return &downloadMetrics{chunkCounts: make([]uint64, len(chunkDurationBuckets))}
+}
+
+// observeProgress records a progress event of current bytes at now.
+func (m *downloadMetrics) observeProgress(current int64, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.lastProgress.IsZero() && now.After(m.lastProgress) && current >= m.bytes {
+		m.throughput = float64(current-m.bytes) / now.Sub(m.lastProgress).Seconds()
+	}
+	m.bytes = current
+	m.lastProgress = now
+}
+
+// observeParts records that n more parts completed at now. zedUpload only reports
+// finished parts, so a chunk's duration is the time since the previous completion.
+func (m *downloadMetrics) observeParts(n int, now time.Time) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.lastPart.IsZero() {
+		d := now.Sub(m.lastPart).Seconds() / float64(n)
+		for i := 0; i < n; i++ {
+			m.observeChunkLocked(d)
+		}
+	}
+	m.lastPart = now
+}
+
+func (m *downloadMetrics) observeChunkLocked(seconds float64) {
+	for i, bound := range chunkDurationBuckets {
+		if seconds <= bound {
+			m.chunkCounts[i]++
+			break
+		}
+	}
+	m.chunkSum += seconds
+	m.chunkCount++
+}
+
+// startAttempt marks the beginning of a transfer attempt, the first part of
+// which is timed from here.
+func (m *downloadMetrics) startAttempt(now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.lastPart = now
+	m.lastProgress = time.Time{}
+	m.mu.Unlock()
+}
+
+func (m *downloadMetrics) incRetries() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+func (m *downloadMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP download_bytes Bytes downloaded so far.")
+	fmt.Fprintln(w, "# TYPE download_bytes gauge")
+	fmt.Fprintf(w, "download_bytes %d\n", m.bytes)
+	fmt.Fprintln(w, "# HELP download_throughput_bytes_per_second Download rate between the last two progress events.")
+	fmt.Fprintln(w, "# TYPE download_throughput_bytes_per_second gauge")
+	fmt.Fprintf(w, "download_throughput_bytes_per_second %s\n", formatFloat(m.throughput))
+	fmt.Fprintln(w, "# HELP download_retries_total Failed download attempts that were retried.")
+	fmt.Fprintln(w, "# TYPE download_retries_total counter")
+	fmt.Fprintf(w, "download_retries_total %d\n", m.retries)
+	fmt.Fprintln(w, "# HELP download_chunk_duration_seconds Time to complete one downloaded part.")
+	fmt.Fprintln(w, "# TYPE download_chunk_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range chunkDurationBuckets {
+		cumulative += m.chunkCounts[i]
+		fmt.Fprintf(w, "download_chunk_duration_seconds_bucket{le=%q} %d\n", formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "download_chunk_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.chunkCount)
+	fmt.Fprintf(w, "download_chunk_duration_seconds_sum %s\n", formatFloat(m.chunkSum))
+	fmt.Fprintf(w, "download_chunk_duration_seconds_count %d\n", m.chunkCount)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
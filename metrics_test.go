@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadMetrics(t *testing.T) {
+	m := newDownloadMetrics()
+	start := time.Unix(0, 0)
+	m.startAttempt(start)
+	m.observeProgress(0, start)
+	m.observeParts(1, start.Add(200*time.Millisecond))
+	m.observeParts(2, start.Add(2200*time.Millisecond)) // 1s each
+	m.observeProgress(4096, start.Add(2*time.Second))
+	m.incRetries()
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	require.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	for _, line := range []string{
+		"download_bytes 4096\n",
+		"download_throughput_bytes_per_second 2048\n",
+		"download_retries_total 1\n",
+		`download_chunk_duration_seconds_bucket{le="0.1"} 0` + "\n",
+		`download_chunk_duration_seconds_bucket{le="0.25"} 1` + "\n",
+		`download_chunk_duration_seconds_bucket{le="1"} 3` + "\n",
+		`download_chunk_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"download_chunk_duration_seconds_sum 2.2\n",
+		"download_chunk_duration_seconds_count 3\n",
+	} {
+		require.Contains(t, body, line)
+	}
+}
+
+func TestDownloadMetricsNil(t *testing.T) {
+	var m *downloadMetrics
+	require.NotPanics(t, func() {
+		m.startAttempt(time.Now())
+		m.observeProgress(1, time.Now())
+		m.observeParts(1, time.Now())
+		m.incRetries()
+	})
+}
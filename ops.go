@@ -0,0 +1,666 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lf-edge/eve-libs/nettrace"
+	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// opConfig is what main resolved from the flags and the environment, handed to the
+// runner of the op.
+type opConfig struct {
+	*cliFlags
+	transport string
+	// the azure account, accountURL is the region for aws; accountKey is empty with
+	// a SAS, registered with azure.SetAccountSAS
+	accountURL, accountName, accountKey, sas string
+	container, remoteFile, localFile         string
+	// the zedUpload transfer of a download
+	auth   *zedUpload.AuthInput
+	syncTr zedUpload.SyncTransportType
+	// zedUpload's Azure transport always fetches every part again
+	resumePartSize int64
+	// set when the download bypasses zedUpload
+	httpDl        *httpDownloader
+	caPEM         []byte // of -ca-file, nil for the system roots
+	retryPolicy   azure.RetryPolicy
+	newHTTPClient func() *http.Client
+	recorder      *azure.Recorder // of -record-http, nil without
+	progressEvts  *progressEvents // nil without -progress-format json
+	// the human-readable lines, stderr once stdout carries the blob
+	out io.Writer
+}
+
+// opRunner runs an -op with the config main resolved, logging to log.
+type opRunner struct {
+	run       func(log *base.LogObject, c *opConfig)
+	azureOnly bool
+}
+
+// ops are the runners of the -op values.
+var ops = map[string]opRunner{
+	"download":  {run: opDownload},
+	"upload":    {run: opUpload, azureOnly: true},
+	"list":      {run: opList, azureOnly: true},
+	"audit":     {run: opAudit, azureOnly: true},
+	"latest":    {run: opLatest, azureOnly: true},
+	"compare":   {run: opCompare, azureOnly: true},
+	"rehydrate": {run: opRehydrate, azureOnly: true},
+	"inspect":   {run: opInspect, azureOnly: true},
+	"delete":    {run: opDelete, azureOnly: true},
+	"sas":       {run: opSas, azureOnly: true},
+	"blocklist": {run: opBlockList, azureOnly: true},
+	"speedtest": {run: opSpeedTest, azureOnly: true},
+}
+
+// downloadManifest downloads every blob of -manifest under -outdir.
+func downloadManifest(log *base.LogObject, c *opConfig) {
+	result, err := runManifestDownload(ManifestConfig{
+		StreamConfig: StreamConfig{
+			AccountURL:  c.accountURL,
+			AccountName: c.accountName,
+			AccountKey:  c.accountKey,
+			Container:   c.container,
+			MaxSize:     c.maxSize,
+			HTTPClient:  c.newHTTPClient(),
+			Log:         log,
+		},
+		Manifest:         c.manifest,
+		OutDir:           c.outDir,
+		IgnoreMissing:    c.ignoreMissing,
+		ChecksumManifest: c.checksumManifest,
+		DedupeByHash:     c.dedupeByHash,
+	})
+	if err != nil {
+		log.Fatalf("Manifest download failed: %v", err)
+	}
+	for _, e := range result.Entries {
+		switch {
+		case e.Err != nil:
+			fmt.Printf("FAILED %s: %v\n", e.Blob, e.Err)
+		case e.Missing:
+			fmt.Printf("MISSING %s\n", e.Blob)
+		case e.LinkedFrom != "":
+			fmt.Printf("OK %s -> %s: same content as %s (md5: %s)\n", e.Blob, e.LocalFile, e.LinkedFrom, e.Result.MD5)
+		default:
+			fmt.Printf("OK %s -> %s: %d bytes (md5: %s)\n", e.Blob, e.LocalFile, e.Result.Bytes, e.Result.MD5)
+		}
+	}
+	fmt.Printf("Download: %s\n", result)
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
+
+// opDownload downloads REMOTE_FILE to LOCAL_FILE, or the blobs of -manifest.
+func opDownload(log *base.LogObject, c *opConfig) {
+	if c.manifest != "" {
+		downloadManifest(log, c)
+		return
+	}
+	// the lines of the download say which blob they are about
+	downloadBlob(transferLog(log, c.transport, c.container, c.remoteFile), c)
+}
+
+// downloadBlob downloads REMOTE_FILE to LOCAL_FILE, or streams it to stdout, a
+// device or a pipe.
+func downloadBlob(log *base.LogObject, c *opConfig) {
+	if c.follow {
+		if c.transport != "azure" {
+			log.Fatalf("-follow is only supported with TRANSPORT=azure")
+		}
+		client := c.newHTTPClient()
+		exists := func() (bool, error) {
+			return azure.BlobExists(c.accountURL, c.accountName, c.accountKey, c.container, c.remoteFile, client)
+		}
+		if err := waitForBlob(log, c.remoteFile, exists, c.followInterval, c.followTimeout); err != nil {
+			log.Fatalf("Follow failed: %v", err)
+		}
+	}
+
+	if c.requireImmutable {
+		if c.transport != "azure" {
+			log.Fatalf("-require-immutable is only supported with TRANSPORT=azure")
+		}
+		i, err := azure.CheckAzureBlobImmutable(c.accountURL, c.accountName, c.accountKey,
+			c.container, c.remoteFile, c.newHTTPClient())
+		if err != nil {
+			log.Fatalf("Refusing to download: %v", err)
+		}
+		log.Noticef("%s is under a %s immutability policy until %v", c.remoteFile, i.Mode, i.ExpiresOn)
+	}
+
+	// a device or a named pipe is streamed into like stdout
+	toStream := false
+	if c.localFile != stdoutLocalFile && c.outDir == "" {
+		var err error
+		if toStream, err = isStreamDestination(c.localFile); err != nil {
+			log.Fatalf("Invalid local file: %v", err)
+		}
+	}
+
+	if c.localFile == stdoutLocalFile || toStream {
+		streamDownload(log, c, toStream)
+		return
+	}
+
+	var objSize int64 // unknown, the transfer reports it
+	sizeKnown := false
+	var contentID, expectedMD5 string
+	if c.transport == "azure" {
+		stat, err := azure.StatAzureBlob(c.accountURL, c.accountName, c.accountKey,
+			c.container, c.remoteFile, c.newHTTPClient())
+		switch {
+		case err == nil:
+			objSize, sizeKnown = stat.Size, true
+			contentID, expectedMD5 = stat.ETag, stat.MD5
+			// zedUpload signs itself, with the key that worked for the lookup
+			if key := azure.AccountKeyInUse(c.accountKey); c.auth != nil && key != c.accountKey {
+				log.Noticef("ACCOUNT_KEY was rejected, downloading with the secondary key")
+				c.auth.Password = key
+			}
+		case c.maxSize > 0:
+			log.Fatalf("Cannot check -max-size, size of %s unknown: %v", c.remoteFile, err)
+		case c.verifyAfterResume:
+			log.Fatalf("Cannot use -verify-after-resume, the MD5 of %s is unknown: %v", c.remoteFile, err)
+		default:
+			log.Warnf("Could not look up the size of %s, taking it from the transfer: %v", c.remoteFile, err)
+		}
+	} else if c.maxSize > 0 {
+		log.Fatalf("-max-size is only supported with TRANSPORT=azure")
+	} else if c.saveMeta {
+		log.Fatalf("-save-meta is only supported with TRANSPORT=azure")
+	} else if c.verifyAfterResume {
+		log.Fatalf("-verify-after-resume is only supported with TRANSPORT=azure")
+	}
+
+	if c.verifyResumeSample < 0 || c.verifyResumeSample > 100 {
+		log.Fatalf("Invalid -verify-resume-sample %v, expected a percentage from 0 to 100", c.verifyResumeSample)
+	}
+	if c.verifyResumeSample > 0 && !c.verifyResume {
+		log.Fatalf("-verify-resume-sample needs -verify-resume")
+	}
+	if c.maxParts < 1 || c.maxParts > maxProgressParts {
+		log.Fatalf("Invalid -max-parts %d, expected 1 to %d", c.maxParts, maxProgressParts)
+	}
+
+	if c.outDir != "" {
+		var err error
+		c.localFile, err = localPathUnder(c.outDir, c.remoteFile)
+		if err != nil {
+			log.Fatalf("Invalid -outdir target: %v", err)
+		}
+	}
+
+	var metrics *downloadMetrics // nil records nothing
+	if c.debugAddr != "" {
+		metrics = newDownloadMetrics()
+		serveDebug(c.debugAddr, metrics, c.quiet)
+	}
+
+	traceOpts := []nettrace.TraceOpt{
+		&nettrace.WithLogging{CustomLogger: &base.LogrusWrapper{Log: log}},
+		&nettrace.WithConntrack{},
+		&nettrace.WithDNSQueryTrace{},
+	}
+
+	dl, tracing := newDownloader(log, c, traceOpts)
+
+	result, err := runDownload(Config{
+		Downloader:         dl,
+		RemoteFile:         c.remoteFile,
+		LocalFile:          c.localFile,
+		ObjSize:            objSize,
+		ContentID:          contentID,
+		MaxSize:            c.maxSize,
+		CheckDiskSpace:     sizeKnown,
+		ResumePartSize:     c.resumePartSize,
+		MaxParts:           c.maxParts,
+		VerifyResume:       c.verifyResume,
+		VerifyResumeSample: c.verifyResumeSample,
+		VerifyAfterResume:  c.verifyAfterResume,
+		ExpectedMD5:        expectedMD5,
+		Retry:              c.retryPolicy,
+		RetryBudget:        c.retryBudget,
+		ProgressInterval:   c.progressInterval,
+		ProgressStep:       c.progressStep,
+		ProgressEvents:     c.progressEvts,
+		TracingEnabled:     tracing,
+		Metrics:            metrics,
+		Quiet:              c.quiet,
+		CleanupOnError:     c.cleanupOnError,
+		Workspace:          c.workspace,
+		RemoteID:           blobRemoteID(c.accountURL, c.container, c.remoteFile),
+		Log:                log,
+	})
+	if err != nil {
+		log.Fatalf("Download failed: %v", err)
+	}
+	if c.checksumManifest != "" {
+		if err := updateChecksumManifest(c.checksumManifest, c.localFile, result.SHA256); err != nil {
+			log.Fatalf("Recording the checksum failed: %v", err)
+		}
+	}
+	if c.saveMeta {
+		props, err := azure.GetAzureBlobProperties(c.accountURL, c.accountName, c.accountKey,
+			c.container, c.remoteFile, c.newHTTPClient())
+		if err == nil {
+			err = saveBlobMeta(c.localFile, props)
+		}
+		if err != nil {
+			log.Fatalf("Saving the blob metadata failed: %v", err)
+		}
+	}
+	printDownloadResult(c.out, result)
+}
+
+// streamDownload downloads REMOTE_FILE to stdout, or to the device or named pipe
+// LOCAL_FILE is with toStream, without resume.
+func streamDownload(log *base.LogObject, c *opConfig, toStream bool) {
+	if c.transport != "azure" || c.accountKey == "" {
+		log.Fatalf("Downloading to stdout, a device or a pipe is only supported with TRANSPORT=azure and an account key")
+	}
+	if c.outDir != "" || c.saveMeta || c.checksumManifest != "" {
+		log.Fatalf("-outdir, -save-meta and -checksum-manifest need a regular local file, not stdout, a device or a pipe")
+	}
+	var throttle *progressThrottle
+	if !c.quiet {
+		throttle = newProgressThrottle(c.progressInterval, c.progressStep)
+		if c.progressEvts != nil && !toStream {
+			throttle.events = newProgressEvents(os.Stderr) // stdout carries the blob
+		} else {
+			throttle.events = c.progressEvts
+		}
+	}
+	streamCfg := StreamConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		MaxSize:     c.maxSize,
+		Progress:    throttle,
+		HTTPClient:  c.newHTTPClient(),
+		Log:         log,
+	}
+	var result Result
+	var err error
+	if toStream {
+		log.Noticef("%s is not a regular file, streaming to it without resume", c.localFile)
+		result, err = runStreamToFile(streamCfg, c.localFile)
+	} else {
+		result, err = runStreamDownload(streamCfg, os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Download failed: %v", err)
+	}
+	fmt.Fprintf(c.out, "Download succeeded: %d bytes in %v (md5: %s)\n",
+		result.Bytes, result.Duration.Round(time.Millisecond), result.MD5)
+}
+
+// newDownloader returns the downloader of the op, and whether it collects a net
+// trace.
+func newDownloader(log *base.LogObject, c *opConfig, traceOpts []nettrace.TraceOpt) (downloader, bool) {
+	var dl downloader
+	tracing := c.netTrace
+	if c.outputBufferSize < 0 {
+		log.Fatalf("Invalid -output-buffer-size: %d", c.outputBufferSize)
+	}
+	switch {
+	case c.httpDl != nil:
+		if c.netTrace {
+			log.Noticef("Net tracing is not available for HTTP downloads")
+		}
+		c.httpDl.bufferSize = c.outputBufferSize
+		c.httpDl.log = log
+		dl = *c.httpDl
+	case c.transport == "azure" && !(c.netTrace && flagSet("nettrace")):
+		if c.netTrace {
+			log.Functionf("Net tracing of azure downloads needs an explicit -nettrace, downloading without it")
+			tracing = false
+		}
+		if c.outputBufferSize > 0 {
+			log.Fatalf("-output-buffer-size is not supported by the %s transport", c.transport)
+		}
+		// azureutil pins the ranges to one version of the blob, zedUpload does not;
+		// net tracing only hooks into the clients of zedUpload
+		dl = azureDownloader{accountURL: c.accountURL, accountName: c.accountName,
+			accountKey: c.accountKey, container: c.container, client: c.newHTTPClient()}
+	default:
+		if c.transport == "azure" {
+			log.Noticef("With -nettrace, the ranges of the download are not pinned to one version of the blob")
+		}
+		if c.outputBufferSize > 0 {
+			log.Fatalf("-output-buffer-size is not supported by the %s transport", c.transport)
+		}
+		if c.maxTotalBandwidth > 0 {
+			log.Fatalf("-max-total-bandwidth is not supported by the %s transport", c.syncTr)
+		}
+		if c.maxRequests > 0 {
+			log.Fatalf("-max-concurrent-requests is not supported by the %s transport", c.syncTr)
+		}
+		// their defaults apply to the clients of transfers other than the download too
+		if flagSet("header-timeout") || flagSet("idle-timeout") {
+			log.Fatalf("-header-timeout and -idle-timeout are not supported by the %s transport", c.syncTr)
+		}
+		if c.recordHTTP != "" || c.replayHTTP != "" {
+			log.Fatalf("-record-http and -replay-http are not supported by the %s transport", c.syncTr)
+		}
+		// zedUpload retries with its own vendored copy of azureutil
+		if c.retryOn != "" || flagSet("retry-jitter") {
+			log.Fatalf("-retry-on, RETRY_ON and -retry-jitter are not supported by the %s transport", c.syncTr)
+		}
+		dCtx, _ := zedUpload.NewDronaCtx("mydownloader", 0)
+		// zedUpload builds its own clients, it only takes trusted certificates
+		if c.tlsSettings.InsecureSkipVerify || (c.tlsSettings.MinVersion != "" && c.tlsSettings.MinVersion != "1.2") {
+			log.Fatalf("-tls-min %s and -tls-insecure-skip-verify are not supported by the %s transport",
+				c.tlsSettings.MinVersion, c.syncTr)
+		}
+		if c.insecureProxyOnly {
+			log.Fatalf("-no-verify-tls-on-proxy-only is not supported by the %s transport", c.syncTr)
+		}
+		dEndPoint, err := dCtx.NewSyncerDest(c.syncTr, c.accountURL, c.container, c.auth)
+		if err != nil {
+			log.Fatalf("Failed to create endpoint: %v", err)
+		}
+		if c.caPEM != nil {
+			if err := dEndPoint.WithTrustedCerts([][]byte{c.caPEM}); err != nil {
+				log.Fatalf("Failed to trust -ca-file: %v", err)
+			}
+		}
+		if tracing {
+			tracing = enableNetTracing(dEndPoint, traceOpts...)
+		}
+		dl = dronaDownloader{ep: dEndPoint, traceLabel: c.traceLabel}
+	}
+	return dl, tracing
+}
+
+// opLatest downloads the blob under -prefix modified last, or with the greatest name.
+func opLatest(log *base.LogObject, c *opConfig) {
+	blobs, err := azure.ListAzureBlobInfo(c.accountURL, c.accountName, c.accountKey,
+		c.container, c.prefix, c.newHTTPClient())
+	if err != nil {
+		log.Fatalf("List failed: %v", err)
+	}
+	latest, err := pickLatest(blobs, c.latestBy)
+	if err != nil {
+		log.Fatalf("No blob to download under %q: %v", c.prefix, err)
+	}
+	fmt.Fprintf(c.out, "Selected %s (modified %s)\n", latest.Name, latest.LastModified.Format(time.RFC3339))
+	c.remoteFile = latest.Name
+	opDownload(log, c)
+}
+
+// opUpload uploads LOCAL_FILE, a file or a directory, to REMOTE_FILE.
+func opUpload(log *base.LogObject, c *opConfig) {
+	uploadCfg := UploadConfig{
+		AccountURL:         c.accountURL,
+		AccountName:        c.accountName,
+		AccountKey:         c.accountKey,
+		Container:          c.container,
+		RemoteFile:         c.remoteFile,
+		LocalFile:          c.localFile,
+		HTTPClient:         c.newHTTPClient(),
+		Workspace:          c.workspace,
+		RestoreMeta:        c.restoreMeta,
+		ContentDisposition: c.contentDisposition,
+		ContentType:        c.contentType,
+	}
+	// an interrupted upload stops sending and keeps its progress to resume from
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	uploadCfg.Context = ctx
+	if c.sasCommand != "" {
+		if c.accountKey != "" || c.sas == "" {
+			log.Fatalf("-sas-command is only supported for uploads with a SAS")
+		}
+		uploadCfg.RenewSAS = sasFromCommand(c.sasCommand)
+	}
+	if info, err := os.Stat(c.localFile); err == nil && info.IsDir() {
+		var stop func()
+		uploadCfg.Totals, stop = startBulkTotals(c.progressInterval, c.debugAddr, c.quiet)
+		result, err := runUploadDir(UploadDirConfig{
+			UploadConfig: uploadCfg,
+			Workers:      c.uploadWorkers,
+			Symlinks:     c.symlinks,
+		})
+		stop()
+		if err != nil {
+			log.Fatalf("Upload failed: %v", err)
+		}
+		for _, f := range result.Files {
+			if f.Err != nil {
+				fmt.Printf("FAILED %s: %v\n", f.Path, f.Err)
+				continue
+			}
+			fmt.Printf("OK %s -> %s: %d bytes (resumed: %v, md5: %s)\n",
+				f.Path, f.Blob, f.Result.Bytes, f.Result.Resumed, f.Result.MD5)
+		}
+		for _, name := range result.Skipped {
+			fmt.Printf("SKIPPED %s (symbolic link)\n", name)
+		}
+		fmt.Printf("Upload: %s\n", result)
+		if !result.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+	result, err := runUpload(uploadCfg)
+	if err != nil {
+		log.Fatalf("Upload failed: %v", err)
+	}
+	fmt.Printf("Upload succeeded: %d bytes in %v (resumed: %v, md5: %s)\n",
+		result.Bytes, result.Duration.Round(time.Millisecond), result.Resumed, result.MD5)
+}
+
+// opList prints the blobs under -prefix, or with -dfs the paths of the directory.
+func opList(log *base.LogObject, c *opConfig) {
+	if c.dfs {
+		dfsURL := azure.DataLakeURLFromBlob(c.accountURL)
+		paths, err := azure.ListDataLakePaths(dfsURL, c.accountName, c.accountKey,
+			c.container, c.prefix, c.recursive, c.newHTTPClient())
+		if err != nil {
+			log.Fatalf("List failed: %v", err)
+		}
+		if err := writePathList(os.Stdout, c.listFormat, paths); err != nil {
+			log.Fatalf("List failed: %v", err)
+		}
+		return
+	}
+	list, write := azure.ListAzureBlobInfo, writeBlobList
+	if c.snapshots {
+		list, write = azure.ListAzureBlobSnapshots, writeSnapshotList
+	}
+	if c.hierarchy {
+		list = func(accountURL, accountName, accountKey, containerName, prefix string,
+			httpClient *http.Client) ([]azure.BlobInfo, error) {
+			return azure.ListAzureBlobHierarchy(accountURL, accountName, accountKey, containerName, prefix,
+				c.recursive, httpClient)
+		}
+	}
+	blobs, err := list(c.accountURL, c.accountName, c.accountKey,
+		c.container, c.prefix, c.newHTTPClient())
+	if err != nil {
+		log.Fatalf("List failed: %v", err)
+	}
+	if err := write(os.Stdout, c.listFormat, blobs); err != nil {
+		log.Fatalf("List failed: %v", err)
+	}
+}
+
+// opDelete deletes REMOTE_FILE, or with -dfs the path of the directory.
+func opDelete(log *base.LogObject, c *opConfig) {
+	var err error
+	if c.dfs {
+		dfsURL := azure.DataLakeURLFromBlob(c.accountURL)
+		err = azure.DeleteDataLakePath(dfsURL, c.accountName, c.accountKey,
+			c.container, c.remoteFile, c.recursive, c.newHTTPClient())
+	} else {
+		err = azure.DeleteAzureBlob(c.accountURL, c.accountName, c.accountKey,
+			c.container, c.remoteFile, c.newHTTPClient())
+	}
+	if err != nil {
+		log.Fatalf("Delete failed: %v", err)
+	}
+	fmt.Printf("Deleted %s\n", c.remoteFile)
+}
+
+// opAudit verifies the Content-MD5 of the blobs under -prefix.
+func opAudit(log *base.LogObject, c *opConfig) {
+	totals, stop := startBulkTotals(c.progressInterval, c.debugAddr, c.quiet)
+	result, err := runAudit(AuditConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		Prefix:      c.prefix,
+		Workers:     c.auditWorkers,
+		HTTPClient:  c.newHTTPClient(),
+		Totals:      totals,
+	})
+	stop()
+	if err != nil {
+		log.Fatalf("Audit failed: %v", err)
+	}
+	fmt.Printf("Audit: %s\n", result)
+	for _, name := range result.Mismatched {
+		fmt.Printf("MISMATCH %s\n", name)
+	}
+	for _, name := range result.Failed {
+		fmt.Printf("FAILED %s\n", name)
+	}
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
+
+// opCompare compares REMOTE_FILE with -compare-blob or -compare-file.
+func opCompare(log *base.LogObject, c *opConfig) {
+	result, err := runCompare(CompareConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		OtherRemote: c.compareBlob,
+		OtherLocal:  c.compareFile,
+		ByteDiff:    c.byteDiff,
+		HTTPClient:  c.newHTTPClient(),
+	})
+	if err != nil {
+		log.Fatalf("Compare failed: %v", err)
+	}
+	fmt.Printf("Compare: %s\n", result)
+	if !result.Identical() {
+		os.Exit(1)
+	}
+}
+
+// opSas prints a SAS URL of REMOTE_FILE.
+func opSas(log *base.LogObject, c *opConfig) {
+	err := runSas(SasConfig{
+		AccountURL:         c.accountURL,
+		AccountName:        c.accountName,
+		AccountKey:         c.accountKey,
+		Container:          c.container,
+		RemoteFile:         c.remoteFile,
+		TTL:                c.sasTTL,
+		Permissions:        c.sasPerms,
+		Policy:             c.sasPolicy,
+		HTTPClient:         c.newHTTPClient(),
+		ContentDisposition: c.contentDisposition,
+	}, os.Stdout)
+	if err != nil {
+		log.Fatalf("SAS failed: %v", err)
+	}
+}
+
+// opBlockList prints the committed and uncommitted blocks of REMOTE_FILE.
+func opBlockList(log *base.LogObject, c *opConfig) {
+	err := runBlockList(BlockListConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		HTTPClient:  c.newHTTPClient(),
+	}, os.Stdout)
+	if err != nil {
+		log.Fatalf("Block list failed: %v", err)
+	}
+}
+
+// opInspect prints all the properties of REMOTE_FILE as JSON.
+func opInspect(log *base.LogObject, c *opConfig) {
+	report, err := runInspect(InspectConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		HTTPClient:  c.newHTTPClient(),
+	})
+	if err != nil {
+		log.Fatalf("Inspect failed: %v", err)
+	}
+	if err := writeBlobReport(os.Stdout, report); err != nil {
+		log.Fatalf("Inspect failed: %v", err)
+	}
+}
+
+// opSpeedTest downloads REMOTE_FILE to nowhere to measure the throughput of the link.
+func opSpeedTest(log *base.LogObject, c *opConfig) {
+	report, err := runSpeedTest(SpeedTestConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		Offset:      c.speedOffset,
+		Length:      c.speedLength,
+		Concurrency: c.speedConcurrency,
+		HTTPClient:  c.newHTTPClient(),
+	})
+	if err != nil {
+		log.Fatalf("Speed test failed: %v", err)
+	}
+	fmt.Printf("Speed test: %s\n", report)
+}
+
+// opRehydrate moves REMOTE_FILE into -tier.
+func opRehydrate(log *base.LogObject, c *opConfig) {
+	result, err := runRehydrate(RehydrateConfig{
+		AccountURL:  c.accountURL,
+		AccountName: c.accountName,
+		AccountKey:  c.accountKey,
+		Container:   c.container,
+		RemoteFile:  c.remoteFile,
+		Tier:        c.tier,
+		Priority:    c.rehydratePriority,
+		NoWait:      c.noWait,
+		Interval:    c.rehydrateInterval,
+		MaxWait:     c.rehydrateTimeout,
+		HTTPClient:  c.newHTTPClient(),
+	})
+	if err != nil {
+		log.Fatalf("Rehydrate failed: %v", err)
+	}
+	if result.Rehydrating() {
+		fmt.Printf("Rehydrate: %s is in the %s tier, %s (%s priority)\n",
+			c.remoteFile, result.Tier, result.ArchiveStatus, result.RehydratePriority)
+	} else {
+		fmt.Printf("Rehydrate: %s is in the %s tier\n", c.remoteFile, result.Tier)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+)
+
+// partRange stands for the complete parts From to To, both included, in a
+// .progress file.
+type partRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// coalesceParts splits parts into runs of two or more consecutive complete parts,
+// and the parts in none of them, so that a download of many parts saves a few
+// ranges instead. The parts are returned in index order.
+func coalesceParts(parts types.DownloadedParts) (types.DownloadedParts, []partRange) {
+	sorted := append([]*types.PartDefinition(nil), parts.Parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Ind < sorted[j].Ind })
+	rest := types.DownloadedParts{PartSize: parts.PartSize}
+	var ranges []partRange
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j].Size == parts.PartSize && sorted[j+1].Size == parts.PartSize &&
+			sorted[j+1].Ind == sorted[j].Ind+1 {
+			j++
+		}
+		if j > i {
+			ranges = append(ranges, partRange{From: sorted[i].Ind, To: sorted[j].Ind})
+		} else {
+			rest.Parts = append(rest.Parts, sorted[i])
+		}
+		i = j + 1
+	}
+	return rest, ranges
+}
+
+// expandRanges is the reverse of coalesceParts, returning parts with the parts of
+// ranges added, in index order. More than maxParts parts in all is an error, before
+// any of them is allocated.
+func expandRanges(parts types.DownloadedParts, ranges []partRange, maxParts int) (types.DownloadedParts, error) {
+	if len(ranges) == 0 {
+		return parts, nil
+	}
+	total := int64(len(parts.Parts))
+	for _, r := range ranges {
+		if r.From < 0 || r.To < r.From {
+			return types.DownloadedParts{}, fmt.Errorf("invalid range of parts %d-%d", r.From, r.To)
+		}
+		if r.To-r.From >= int64(maxParts)-total {
+			return types.DownloadedParts{}, fmt.Errorf("ranges of more than %d parts", maxParts)
+		}
+		total += r.To - r.From + 1
+	}
+	all := types.DownloadedParts{PartSize: parts.PartSize, Parts: make([]*types.PartDefinition, 0, total)}
+	all.Parts = append(all.Parts, parts.Parts...)
+	for _, r := range ranges {
+		for ind := r.From; ind <= r.To; ind++ {
+			all.Parts = append(all.Parts, &types.PartDefinition{Ind: ind, Size: parts.PartSize})
+		}
+	}
+	sort.Slice(all.Parts, func(i, j int) bool { return all.Parts[i].Ind < all.Parts[j].Ind })
+	return all, nil
+}
+
+// partCount is the number of parts of partSize bytes objSize takes.
+func partCount(objSize, partSize int64) int64 {
+	return (objSize + partSize - 1) / partSize
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profilesFile is the -config file: named sets of connection settings.
+//
+//	profiles:
+//	  azure-prod:
+//	    transport: azure
+//	    endpoint: https://prodaccount.blob.core.windows.net
+//	    account: prodaccount
+//	    key_env: PROD_ACCOUNT_KEY
+//	    container: images
+type profilesFile struct {
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// profile holds the settings otherwise taken from the environment. Secrets are best
+// given as the name of the variable holding them (key_env) rather than inline (key).
+type profile struct {
+	Transport      string `yaml:"transport"`
+	Endpoint       string `yaml:"endpoint"` // account URL for azure, region for aws
+	EndpointSuffix string `yaml:"endpoint_suffix"`
+	Account        string `yaml:"account"` // account name for azure, key ID for aws
+	Key            string `yaml:"key"`
+	KeyEnv         string `yaml:"key_env"`
+	Container      string `yaml:"container"`
+	RemoteFile     string `yaml:"remote_file"`
+	LocalFile      string `yaml:"local_file"`
+}
+
+// loadProfile reads name from the profiles file at path.
+func loadProfile(path, name string) (profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return profile{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file profilesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return profile{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	p, ok := file.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	return p, nil
+}
+
+// environ maps the profile onto the environment variables of its transport.
+// Empty settings are left out, so the environment still supplies them.
+func (p profile) environ() (map[string]string, error) {
+	key := p.Key
+	if p.KeyEnv != "" {
+		if key != "" {
+			return nil, fmt.Errorf("profile sets both key and key_env")
+		}
+		var ok bool
+		if key, ok = os.LookupEnv(p.KeyEnv); !ok {
+			return nil, fmt.Errorf("profile key_env %s is not set", p.KeyEnv)
+		}
+	}
+
+	var names [6]string // endpoint, account, key, container, remote file, local file
+	switch p.Transport {
+	case "azure":
+		names = [...]string{"ACCOUNT_URL", "ACCOUNT_NAME", "ACCOUNT_KEY", "CONTAINER", "REMOTE_FILE", "LOCAL_FILE"}
+	case "aws":
+		names = [...]string{"AWS_ACCOUNT_URL", "AWS_KEY_ID", "AWS_KEY_SECRET", "AWS_CONTAINER", "AWS_REMOTE_FILE", "AWS_LOCAL_FILE"}
+	default:
+		return nil, fmt.Errorf("unsupported profile transport %q", p.Transport)
+	}
+
+	env := map[string]string{"TRANSPORT": p.Transport}
+	for i, value := range []string{p.Endpoint, p.Account, key, p.Container, p.RemoteFile, p.LocalFile} {
+		if value != "" {
+			env[names[i]] = value
+		}
+	}
+	if p.EndpointSuffix != "" {
+		env["AZURE_ENDPOINT_SUFFIX"] = p.EndpointSuffix
+	}
+	return env, nil
+}
+
+// applyProfile loads the profile and sets its variables in the environment, over
+// whatever was there. Flags default to the environment, so they still win.
+func applyProfile(path, name string) error {
+	p, err := loadProfile(path, name)
+	if err != nil {
+		return err
+	}
+	env, err := p.environ()
+	if err != nil {
+		return fmt.Errorf("profile %q: %w", name, err)
+	}
+	for k, v := range env {
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
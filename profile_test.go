@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testProfiles = `
+profiles:
+  azure-prod:
+    transport: azure
+    endpoint: https://prodaccount.blob.core.windows.net
+    endpoint_suffix: core.usgovcloudapi.net
+    account: prodaccount
+    key_env: TEST_PROFILE_KEY
+    container: images
+  aws-dev:
+    transport: aws
+    endpoint: me-central-1
+    account: AKIADEV
+    key: inline-secret
+    container: dev-bucket
+    remote_file: image.qcow2
+  broken:
+    transport: azure
+    key: inline
+    key_env: TEST_PROFILE_KEY
+`
+
+func writeProfiles(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testProfiles), 0644))
+	return path
+}
+
+func TestLoadProfileSelection(t *testing.T) {
+	path := writeProfiles(t)
+	t.Setenv("TEST_PROFILE_KEY", "prod-secret")
+
+	p, err := loadProfile(path, "azure-prod")
+	require.NoError(t, err)
+	env, err := p.environ()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"TRANSPORT":             "azure",
+		"ACCOUNT_URL":           "https://prodaccount.blob.core.windows.net",
+		"AZURE_ENDPOINT_SUFFIX": "core.usgovcloudapi.net",
+		"ACCOUNT_NAME":          "prodaccount",
+		"ACCOUNT_KEY":           "prod-secret",
+		"CONTAINER":             "images",
+	}, env)
+
+	p, err = loadProfile(path, "aws-dev")
+	require.NoError(t, err)
+	env, err = p.environ()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"TRANSPORT":       "aws",
+		"AWS_ACCOUNT_URL": "me-central-1",
+		"AWS_KEY_ID":      "AKIADEV",
+		"AWS_KEY_SECRET":  "inline-secret",
+		"AWS_CONTAINER":   "dev-bucket",
+		"AWS_REMOTE_FILE": "image.qcow2",
+	}, env)
+
+	_, err = loadProfile(path, "missing")
+	require.ErrorContains(t, err, `profile "missing" not found`)
+	p, err = loadProfile(path, "broken")
+	require.NoError(t, err)
+	_, err = p.environ()
+	require.ErrorContains(t, err, "both key and key_env")
+}
+
+func TestLoadProfileKeyEnvUnset(t *testing.T) {
+	path := writeProfiles(t)
+	t.Setenv("TEST_PROFILE_KEY", "")
+	os.Unsetenv("TEST_PROFILE_KEY")
+
+	err := applyProfile(path, "azure-prod")
+	require.ErrorContains(t, err, "key_env TEST_PROFILE_KEY is not set")
+}
+
+func TestApplyProfilePrecedence(t *testing.T) {
+	path := writeProfiles(t)
+	t.Setenv("TEST_PROFILE_KEY", "prod-secret")
+	t.Setenv("CONTAINER", "from-env")
+	t.Setenv("REMOTE_FILE", "from-env.bin")
+	t.Setenv("AZURE_ENDPOINT_SUFFIX", "")
+	t.Setenv("TRANSPORT", "aws")
+	for _, name := range []string{"ACCOUNT_URL", "ACCOUNT_NAME", "ACCOUNT_KEY"} {
+		t.Setenv(name, "")
+	}
+
+	require.NoError(t, applyProfile(path, "azure-prod"))
+	require.Equal(t, "azure", os.Getenv("TRANSPORT"), "the profile overrides the environment")
+	require.Equal(t, "images", os.Getenv("CONTAINER"))
+	require.Equal(t, "from-env.bin", os.Getenv("REMOTE_FILE"), "what the profile leaves out comes from the environment")
+
+	// flags take their defaults from the environment, like in main
+	for args, want := range map[string]string{
+		"":                                       "core.usgovcloudapi.net",
+		"-endpoint-suffix=core.chinacloudapi.cn": "core.chinacloudapi.cn",
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		suffix := fs.String("endpoint-suffix", os.Getenv("AZURE_ENDPOINT_SUFFIX"), "")
+		var argv []string
+		if args != "" {
+			argv = []string{args}
+		}
+		require.NoError(t, fs.Parse(argv))
+		require.Equal(t, want, *suffix, "flags override the profile")
+	}
+}
+
+func TestFlagFromArgs(t *testing.T) {
+	args := []string{"-config", "p.yaml", "--profile=azure-prod", "-op", "upload"}
+	require.Equal(t, "p.yaml", flagFromArgs(args, "config"))
+	require.Equal(t, "azure-prod", flagFromArgs(args, "profile"))
+	require.Empty(t, flagFromArgs(args, "env-file"))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// Values of -progress-format.
+const (
+	progressFormatPlain = "plain"
+	progressFormatJSON  = "json"
+)
+
+// progressThrottle decides which progress events are worth logging: at most one
+// per interval, or sooner once the transfer moved by step percent.
+// Completion (current >= total) is always reported.
+type progressThrottle struct {
+	interval time.Duration
+	step     float64 // percent of the total size, 0 disables
+	now      func() time.Time
+
+	last    time.Time
+	lastPct float64
+	started bool
+
+	events *progressEvents // nil writes none
+}
+
+func newProgressThrottle(interval time.Duration, step float64) *progressThrottle {
+	return &progressThrottle{interval: interval, step: step, now: time.Now}
+}
+
+// shouldLog reports whether the event (current, total) should be logged and, if so,
+// records it as the last logged one. A nil throttle logs nothing.
+func (p *progressThrottle) shouldLog(current, total int64) bool {
+	if p == nil {
+		return false
+	}
+	now := p.now()
+	var pct float64
+	if total > 0 {
+		pct = float64(current) * 100 / float64(total)
+	}
+	switch {
+	case !p.started:
+	case total > 0 && current >= total:
+	case now.Sub(p.last) >= p.interval:
+	case p.step > 0 && pct-p.lastPct >= p.step:
+	default:
+		return false
+	}
+	p.started = true
+	p.last = now
+	p.lastPct = pct
+	return true
+}
+
+// progressEvents writes the progress events a throttle lets through as JSON lines,
+// for -progress-format json, with the rate smoothed over the events and the time
+// left at that rate.
+type progressEvents struct {
+	enc *json.Encoder
+
+	last      time.Time
+	lastBytes int64
+	rate      float64 // bytes per second, 0 until the second event
+	failed    bool
+}
+
+func newProgressEvents(w io.Writer) *progressEvents {
+	return &progressEvents{enc: json.NewEncoder(w)}
+}
+
+// rateSmoothing is the weight of the rate since the previous event against the
+// smoothed rate until then, so that one slow or fast interval does not swing the eta.
+const rateSmoothing = 0.3
+
+// progressEvent is a line of -progress-format json.
+type progressEvent struct {
+	Event      string `json:"event"` // "progress"
+	File       string `json:"file"`
+	Current    int64  `json:"current"`
+	Total      int64  `json:"total"`       // 0 while unknown
+	Rate       int64  `json:"rate"`        // bytes per second
+	ETASeconds *int64 `json:"eta_seconds"` // null while the rate or the total is unknown
+}
+
+// report writes the event for (current, total) of name, to be called for the
+// events shouldLog lets through. A nil throttle, or one without events, writes
+// nothing.
+func (p *progressThrottle) report(name string, current, total int64) {
+	if p == nil || p.events == nil || p.events.failed {
+		return
+	}
+	e := p.events
+	now := p.now()
+	switch {
+	case e.last.IsZero() || current < e.lastBytes:
+		// the first event, or a retry that started over: no rate to go on
+	case now.After(e.last):
+		rate := float64(current-e.lastBytes) / now.Sub(e.last).Seconds()
+		if e.rate == 0 {
+			e.rate = rate
+		} else {
+			e.rate = rateSmoothing*rate + (1-rateSmoothing)*e.rate
+		}
+	}
+	e.last, e.lastBytes = now, current
+
+	event := progressEvent{Event: "progress", File: name, Current: current, Total: total,
+		Rate: int64(math.Round(e.rate))}
+	if e.rate > 0 && total > 0 {
+		eta := int64(math.Ceil(float64(max(total-current, 0)) / e.rate))
+		event.ETASeconds = &eta
+	}
+	if err := e.enc.Encode(event); err != nil {
+		log.Warnf("Progress events disabled, writing one failed: %v", err)
+		e.failed = true
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressThrottle(t *testing.T) {
+	now := time.Unix(0, 0)
+	throttle := newProgressThrottle(2*time.Second, 10)
+	throttle.now = func() time.Time { return now }
+
+	const total = 1000
+	require.True(t, throttle.shouldLog(10, total), "first event is logged")
+
+	now = now.Add(500 * time.Millisecond)
+	require.False(t, throttle.shouldLog(20, total), "too soon, too small")
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, throttle.shouldLog(120, total), "moved by 10%")
+
+	now = now.Add(time.Second)
+	require.False(t, throttle.shouldLog(130, total))
+
+	now = now.Add(time.Second)
+	require.True(t, throttle.shouldLog(140, total), "interval elapsed")
+
+	now = now.Add(time.Millisecond)
+	require.True(t, throttle.shouldLog(total, total), "completion is always logged")
+}
+
+func TestProgressEvents(t *testing.T) {
+	now := time.Unix(0, 0)
+	var out bytes.Buffer
+	throttle := newProgressThrottle(time.Second, 0)
+	throttle.now = func() time.Time { return now }
+	throttle.events = newProgressEvents(&out)
+
+	// 1000 bytes/s for 3s, then 2000 bytes/s, in updates every 100ms
+	const total = 9000
+	var current int64
+	var reported []time.Time
+	for current < total {
+		now = now.Add(100 * time.Millisecond)
+		if now.Sub(time.Unix(0, 0)) <= 3*time.Second {
+			current += 100
+		} else {
+			current = min(current+200, total)
+		}
+		if throttle.shouldLog(current, total) {
+			throttle.report("disk.img", current, total)
+			reported = append(reported, now)
+		}
+	}
+
+	var events []progressEvent
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var line json.RawMessage
+		require.NoError(t, dec.Decode(&line))
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(line, &fields))
+		require.Contains(t, fields, "rate")
+		require.Contains(t, fields, "eta_seconds")
+		var event progressEvent
+		require.NoError(t, json.Unmarshal(line, &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, len(reported))
+	require.Len(t, events, 7) // at 0.1s, 1.1s .. 5.1s, and the completion at 5.5s
+	for i := 1; i < len(reported)-1; i++ {
+		require.Equal(t, time.Second, reported[i].Sub(reported[i-1]), "spaced by the interval")
+	}
+
+	require.Equal(t, progressEvent{Event: "progress", File: "disk.img", Current: 100, Total: total}, events[0],
+		"no rate before the second event")
+	require.EqualValues(t, 1000, events[1].Rate)
+	require.EqualValues(t, 8, *events[1].ETASeconds, "7900 bytes left")
+	// 2000 bytes/s from 3s on: the smoothed rate moves towards it
+	require.Greater(t, events[3].Rate, events[2].Rate)
+	require.Less(t, events[3].Rate, int64(2000))
+	for _, e := range events[1:] {
+		require.Equal(t, int64(math.Ceil(float64(total-e.Current)/float64(e.Rate))), *e.ETASeconds)
+	}
+	last := events[len(events)-1]
+	require.EqualValues(t, total, last.Current)
+	require.Zero(t, *last.ETASeconds)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const archiveTier = "Archive"
+
+// RehydrateConfig is everything runRehydrate needs once flags and environment are resolved.
+type RehydrateConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	Tier        string // the tier to move the blob to
+	Priority    string // rehydrate priority, Standard when empty
+	NoWait      bool   // return once the tier is set instead of once the blob is in it
+	Interval    time.Duration
+	MaxWait     time.Duration // 0 waits forever
+	HTTPClient  *http.Client
+}
+
+// runRehydrate sets the tier of cfg.RemoteFile and, unless cfg.NoWait, waits until
+// the blob is in it. It returns the tier last seen.
+func runRehydrate(cfg RehydrateConfig) (azure.BlobTier, error) {
+	status := func() (azure.BlobTier, error) {
+		return azure.GetAzureBlobTier(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+			cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	}
+	current, err := status()
+	if err != nil {
+		return azure.BlobTier{}, err
+	}
+	if strings.EqualFold(current.Tier, cfg.Tier) && !current.Rehydrating() {
+		log.Noticef("%s is already in the %s tier", cfg.RemoteFile, current.Tier)
+		return current, nil
+	}
+	if !current.Rehydrating() {
+		fmt.Printf("Rehydrate: %s\n", tierTransitions(current.Tier, cfg.Tier, cfg.Priority))
+		priority := ""
+		if strings.EqualFold(current.Tier, archiveTier) {
+			priority = cfg.Priority // only a rehydration has one
+		}
+		if err := azure.SetAzureBlobTier(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+			cfg.Container, cfg.RemoteFile, cfg.HTTPClient, cfg.Tier, priority); err != nil {
+			return current, err
+		}
+	} else {
+		log.Noticef("%s is already rehydrating (%s, %s priority)", cfg.RemoteFile,
+			current.ArchiveStatus, current.RehydratePriority)
+	}
+	if cfg.NoWait {
+		return status()
+	}
+	return waitForTier(cfg.RemoteFile, cfg.Tier, status, cfg.Interval, cfg.MaxWait)
+}
+
+// waitForTier polls status every interval until the blob is in tier with no
+// rehydration pending, for at most maxWait (0 waits forever). Errors of status end
+// the wait.
+func waitForTier(remoteFile, tier string, status func() (azure.BlobTier, error),
+	interval, maxWait time.Duration) (azure.BlobTier, error) {
+	started := time.Now()
+	var last azure.BlobTier
+	for attempt := 1; ; attempt++ {
+		current, err := status()
+		if err != nil {
+			return last, fmt.Errorf("failed to get the tier of %s: %w", remoteFile, err)
+		}
+		if current != last {
+			log.Noticef("%s: tier %s %s", remoteFile, current.Tier, current.ArchiveStatus)
+			last = current
+		}
+		if strings.EqualFold(current.Tier, tier) && !current.Rehydrating() {
+			log.Noticef("%s reached the %s tier after %v", remoteFile, current.Tier,
+				time.Since(started).Round(time.Second))
+			return current, nil
+		}
+		waited := time.Since(started)
+		if maxWait > 0 && waited+interval > maxWait {
+			return current, fmt.Errorf("%s did not reach the %s tier within %v", remoteFile, tier, maxWait)
+		}
+		log.Functionf("Poll %d: %s is rehydrating, checking again in %v", attempt, remoteFile, interval)
+		time.Sleep(interval)
+	}
+}
+
+// tierTransitions describes the states a blob passes through moving from one tier
+// to another, with the time Azure documents for it.
+func tierTransitions(from, to, priority string) string {
+	if !strings.EqualFold(from, archiveTier) {
+		return fmt.Sprintf("%s -> %s, immediately", from, to)
+	}
+	estimate := "up to 15 hours at Standard priority"
+	if strings.EqualFold(priority, "High") {
+		estimate = "usually under 1 hour at High priority for blobs below 10 GB"
+	}
+	return fmt.Sprintf("%s -> rehydrate-pending-to-%s -> %s, %s",
+		from, strings.ToLower(to), to, estimate)
+}
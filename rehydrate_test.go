@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestWaitForTierRehydrated(t *testing.T) {
+	polls := 0
+	status := func() (azure.BlobTier, error) {
+		polls++
+		if polls < 3 {
+			return azure.BlobTier{Tier: "Archive", ArchiveStatus: "rehydrate-pending-to-hot"}, nil
+		}
+		return azure.BlobTier{Tier: "Hot"}, nil
+	}
+
+	tier, err := waitForTier("cold.img", "Hot", status, time.Millisecond, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, azure.BlobTier{Tier: "Hot"}, tier)
+	require.Equal(t, 3, polls)
+}
+
+func TestWaitForTierGivesUp(t *testing.T) {
+	status := func() (azure.BlobTier, error) {
+		return azure.BlobTier{Tier: "Archive", ArchiveStatus: "rehydrate-pending-to-cool"}, nil
+	}
+
+	tier, err := waitForTier("cold.img", "Cool", status, 10*time.Millisecond, 35*time.Millisecond)
+	require.ErrorContains(t, err, "cold.img did not reach the Cool tier within 35ms")
+	require.True(t, tier.Rehydrating(), "the last state seen is returned")
+}
+
+func TestWaitForTierError(t *testing.T) {
+	_, err := waitForTier("cold.img", "Hot", func() (azure.BlobTier, error) {
+		return azure.BlobTier{}, errors.New("AuthorizationFailure (HTTP 403)")
+	}, time.Millisecond, 0)
+	require.ErrorContains(t, err, "AuthorizationFailure")
+}
+
+func TestTierTransitions(t *testing.T) {
+	require.Equal(t, "Hot -> Cool, immediately", tierTransitions("Hot", "Cool", "Standard"))
+	require.Equal(t, "Archive -> rehydrate-pending-to-hot -> Hot, up to 15 hours at Standard priority",
+		tierTransitions("Archive", "Hot", "Standard"))
+	require.Contains(t, tierTransitions("Archive", "Cool", "High"), "under 1 hour at High priority")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+
+	"github.com/lf-edge/eve-libs/zedUpload/types"
+)
+
+// partHash is the SHA-256 of the first Size bytes a part wrote to the local file.
+type partHash struct {
+	Ind    int64  `json:"i"`
+	Size   int64  `json:"s"`
+	SHA256 string `json:"sha256"`
+}
+
+// resumeHashes are the hashes of the parts of a download by part index. The
+// lengths in DownloadedParts only say how much was written, these say what, so
+// that a resume can tell a partial file that was changed since. A nil
+// resumeHashes hashes nothing.
+type resumeHashes map[int64]partHash
+
+func newResumeHashes(saved []partHash) resumeHashes {
+	h := make(resumeHashes, len(saved))
+	for _, p := range saved {
+		h[p.Ind] = p
+	}
+	return h
+}
+
+// update hashes the parts that are new or grew since the last update, reading
+// them back from localFile. Parts no longer in parts are forgotten.
+func (h resumeHashes) update(localFile string, parts types.DownloadedParts) error {
+	if h == nil {
+		return nil
+	}
+	recorded := make(map[int64]bool, len(parts.Parts))
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for _, p := range parts.Parts {
+		recorded[p.Ind] = true
+		if old, ok := h[p.Ind]; ok && old.Size == p.Size {
+			continue
+		}
+		if f == nil {
+			var err error
+			if f, err = os.Open(localFile); err != nil {
+				return err
+			}
+		}
+		sum, err := hashPart(f, parts.PartSize, p)
+		if err != nil {
+			return err
+		}
+		h[p.Ind] = partHash{Ind: p.Ind, Size: p.Size, SHA256: sum}
+	}
+	for ind := range h {
+		if !recorded[ind] {
+			delete(h, ind)
+		}
+	}
+	return nil
+}
+
+// verify checks that localFile still holds what each of parts wrote to it. A part
+// without a hash, e.g. recorded by a run without Config.VerifyResume, fails too.
+func (h resumeHashes) verify(localFile string, parts types.DownloadedParts) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, p := range parts.Parts {
+		want, ok := h[p.Ind]
+		if !ok || want.Size != p.Size {
+			return fmt.Errorf("part %d has no hash", p.Ind)
+		}
+		sum, err := hashPart(f, parts.PartSize, p)
+		if err != nil {
+			return err
+		}
+		if sum != want.SHA256 {
+			return fmt.Errorf("part %d changed since it was downloaded", p.Ind)
+		}
+	}
+	return nil
+}
+
+// verified returns the parts of which localFile still holds what they wrote, with
+// why each of the others failed. The hashes of those are forgotten, so that they
+// are hashed again once downloaded again. The error is for a localFile that cannot
+// be read at all.
+func (h resumeHashes) verified(localFile string, parts types.DownloadedParts) (types.DownloadedParts, []error, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return types.DownloadedParts{}, nil, err
+	}
+	defer f.Close()
+	kept := types.DownloadedParts{PartSize: parts.PartSize}
+	var failed []error
+	for _, p := range parts.Parts {
+		want, ok := h[p.Ind]
+		if !ok || want.Size != p.Size {
+			failed = append(failed, fmt.Errorf("part %d has no hash", p.Ind))
+			delete(h, p.Ind)
+			continue
+		}
+		sum, err := hashPart(f, parts.PartSize, p)
+		if err == nil && sum != want.SHA256 {
+			err = fmt.Errorf("part %d changed since it was downloaded", p.Ind)
+		}
+		if err != nil {
+			failed = append(failed, err)
+			delete(h, p.Ind)
+			continue
+		}
+		kept.Parts = append(kept.Parts, p)
+	}
+	return kept, failed, nil
+}
+
+// sampleParts picks percent of parts at random, at least one, in part order.
+func sampleParts(parts types.DownloadedParts, percent float64, rnd *rand.Rand) types.DownloadedParts {
+	n := int(math.Ceil(float64(len(parts.Parts)) * percent / 100))
+	n = max(1, min(n, len(parts.Parts)))
+	picked := rnd.Perm(len(parts.Parts))[:n]
+	sort.Ints(picked)
+	sample := types.DownloadedParts{PartSize: parts.PartSize}
+	for _, i := range picked {
+		sample.Parts = append(sample.Parts, parts.Parts[i])
+	}
+	return sample
+}
+
+// of returns the hashes of parts, in part order, to be saved with them.
+func (h resumeHashes) of(parts types.DownloadedParts) []partHash {
+	var list []partHash
+	for _, p := range parts.Parts {
+		if ph, ok := h[p.Ind]; ok && ph.Size == p.Size {
+			list = append(list, ph)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Ind < list[j].Ind })
+	return list
+}
+
+func hashPart(f *os.File, partSize int64, p *types.PartDefinition) (string, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.NewSectionReader(f, p.Ind*partSize, p.Size))
+	if err != nil {
+		return "", fmt.Errorf("failed to hash part %d of %s: %w", p.Ind, f.Name(), err)
+	}
+	if n != p.Size {
+		return "", fmt.Errorf("part %d of %s has %d of its %d bytes", p.Ind, f.Name(), n, p.Size)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// SasConfig is everything runSas needs once flags and environment are resolved.
+type SasConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	TTL         time.Duration // how long the link works
+	Permissions string        // signed permissions, e.g. r or rw
+	// ID of a stored access policy of Container that sets TTL and Permissions
+	// instead, for a link that can be revoked; empty for none
+	Policy string
+	// replaces the Content-Disposition of the blob for this link, empty for none
+	ContentDisposition string
+	HTTPClient         *http.Client
+}
+
+// runSas mints a SAS URL of cfg.RemoteFile, e.g. to hand to support, and writes it to
+// w on a line of its own, so that a script can capture it. The blob has to exist.
+func runSas(cfg SasConfig, w io.Writer) error {
+	opts := azure.DefaultSasOptions()
+	opts.ContentDisposition = cfg.ContentDisposition
+	var ttl time.Duration // the policy's
+	if cfg.Policy != "" {
+		opts.Identifier = cfg.Policy
+	} else {
+		if cfg.TTL <= 0 {
+			return fmt.Errorf("invalid SAS TTL %v, it has to be positive", cfg.TTL)
+		}
+		perms, err := azure.ParseSasPermissions(cfg.Permissions)
+		if err != nil {
+			return err
+		}
+		opts.Permissions = perms
+		ttl = cfg.TTL
+	}
+	sasURL, err := azure.GenerateBlobSasURIWithOptions(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient, ttl, opts)
+	if err != nil {
+		return err
+	}
+	if cfg.Policy != "" {
+		log.Noticef("SAS of %s under the access policy %s of %s", cfg.RemoteFile, cfg.Policy, cfg.Container)
+	} else {
+		log.Noticef("SAS of %s with permissions %s expires at %s", cfg.RemoteFile, opts.Permissions,
+			time.Now().Add(cfg.TTL).UTC().Format(time.RFC3339))
+	}
+	_, err = fmt.Fprintln(w, sasURL)
+	return err
+}
+
+// sasFromCommand returns a UploadConfig.RenewSAS that runs command with sh -c, e.g. an
+// az storage blob generate-sas, and takes what it prints as the new SAS.
+func sasFromCommand(command string) func() (string, error) {
+	return func() (string, error) {
+		cmd := exec.Command("sh", "-c", command)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("-sas-command: %w", err)
+		}
+		sas := strings.TrimSpace(out.String())
+		if sas == "" {
+			return "", fmt.Errorf("-sas-command printed no SAS")
+		}
+		return sas, nil
+	}
+}
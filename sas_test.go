@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/stretchr/testify/require"
+)
+
+// sasChecker serves what store serves to anyone with a valid read SAS, and anything
+// but Get Blob to the shared key requests of runSas itself.
+func sasChecker(t *testing.T, accountName, accountKey, container string, store http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("sig") == "" {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			store.ServeHTTP(w, r)
+			return
+		}
+		st, err := time.Parse(sas.TimeFormat, q.Get("st"))
+		require.NoError(t, err)
+		se, err := time.Parse(sas.TimeFormat, q.Get("se"))
+		require.NoError(t, err)
+		cred, err := service.NewSharedKeyCredential(accountName, accountKey)
+		require.NoError(t, err)
+		_, blobName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		params, err := sas.BlobSignatureValues{
+			Protocol:      sas.ProtocolHTTPS,
+			StartTime:     st,
+			ExpiryTime:    se,
+			ContainerName: container,
+			BlobName:      blobName,
+			Permissions:   q.Get("sp"),
+		}.SignWithSharedKey(cred)
+		require.NoError(t, err)
+		now := time.Now()
+		if params.Signature() != q.Get("sig") || !strings.Contains(q.Get("sp"), "r") ||
+			now.Before(st) || now.After(se) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		store.ServeHTTP(w, r)
+	})
+}
+
+func sasTestConfig(srv *httptest.Server) SasConfig {
+	cfg := fakeAccountOf(srv).sas("logs/device 1.tar.gz")
+	cfg.TTL = time.Hour
+	cfg.Permissions = "r"
+	return cfg
+}
+
+func TestRunSasPrintsFetchableURL(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{"logs/device 1.tar.gz": {data: []byte("support bundle")}}}
+	srv := httptest.NewServer(sasChecker(t, fakeAccountName, fakeAccountKey, fakeContainer, store))
+	t.Cleanup(srv.Close)
+
+	var out bytes.Buffer
+	require.NoError(t, runSas(sasTestConfig(srv), &out))
+	sasURL, ok := strings.CutSuffix(out.String(), "\n")
+	require.True(t, ok, "a line of its own")
+	require.NotContains(t, sasURL, "\n")
+
+	resp, err := http.Get(sasURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "support bundle", string(body))
+
+	// signed, not just passed through: a tampered URL is refused
+	resp, err = http.Get(strings.Replace(sasURL, "sp=r", "sp=rw", 1))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestRunSasValidates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s %s", r.Method, r.URL)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := sasTestConfig(srv)
+	cfg.TTL = 0
+	require.ErrorContains(t, runSas(cfg, io.Discard), "invalid SAS TTL")
+	cfg.TTL = -time.Minute
+	require.ErrorContains(t, runSas(cfg, io.Discard), "invalid SAS TTL")
+
+	cfg = sasTestConfig(srv)
+	cfg.Permissions = ""
+	require.ErrorContains(t, runSas(cfg, io.Discard), "no SAS permissions")
+	cfg.Permissions = "rz"
+	require.ErrorContains(t, runSas(cfg, io.Discard), "unsupported SAS permission 'z'")
+}
+
+func TestRunSasWithPolicy(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{"logs/device 1.tar.gz": {data: []byte("support bundle")}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	cfg := sasTestConfig(srv)
+	cfg.TTL, cfg.Permissions, cfg.Policy = 0, "", "support"
+	var out bytes.Buffer
+	require.NoError(t, runSas(cfg, &out))
+	u, err := url.Parse(strings.TrimSpace(out.String()))
+	require.NoError(t, err)
+	require.Equal(t, "support", u.Query().Get("si"))
+	require.False(t, u.Query().Has("sp"), "the policy's")
+	require.False(t, u.Query().Has("se"), "the policy's")
+}
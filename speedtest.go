@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// SpeedTestConfig is everything runSpeedTest needs once flags and environment are resolved.
+type SpeedTestConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	Offset      int64
+	Length      int64 // 0 reads to the end of the blob
+	Concurrency int   // chunks in flight, 0 for the downloader default
+	HTTPClient  *http.Client
+}
+
+// speedReport sums up a speed test, throughputs in MB/s of 10^6 bytes.
+type speedReport struct {
+	Bytes   int64
+	Elapsed time.Duration
+	Chunks  int
+	Overall float64 // of the whole transfer
+	// of the chunks on their own: they overlap, so these are below Overall
+	Min, Avg, Max float64
+	// until the response headers of a chunk arrived
+	P50, P90, P99 time.Duration
+}
+
+func (r speedReport) String() string {
+	return fmt.Sprintf("%d bytes in %v, %d chunks: %.1f MB/s overall, per chunk min/avg/max %.1f/%.1f/%.1f MB/s, "+
+		"latency p50/p90/p99 %v/%v/%v", r.Bytes, r.Elapsed.Round(time.Millisecond), r.Chunks, r.Overall,
+		r.Min, r.Avg, r.Max, r.P50.Round(time.Millisecond), r.P90.Round(time.Millisecond), r.P99.Round(time.Millisecond))
+}
+
+// runSpeedTest downloads the selected range of cfg.RemoteFile to nowhere, to see what
+// the link does without the local disk in the way.
+func runSpeedTest(cfg SpeedTestConfig) (speedReport, error) {
+	result, err := azure.SpeedTestAzureBlob(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient, azure.SpeedTestOptions{
+			Offset:      cfg.Offset,
+			Length:      cfg.Length,
+			Concurrency: cfg.Concurrency,
+		})
+	if err != nil {
+		return speedReport{}, err
+	}
+	return newSpeedReport(result), nil
+}
+
+func newSpeedReport(result azure.SpeedTestResult) speedReport {
+	r := speedReport{Bytes: result.Bytes, Elapsed: result.Elapsed, Chunks: len(result.Chunks)}
+	if result.Elapsed > 0 {
+		r.Overall = mbPerSecond(result.Bytes, result.Elapsed)
+	}
+	var latencies []time.Duration
+	var rates []float64
+	for _, c := range result.Chunks {
+		latencies = append(latencies, c.Latency)
+		if c.Elapsed > 0 {
+			rates = append(rates, mbPerSecond(c.Size, c.Elapsed))
+		}
+	}
+	if len(rates) > 0 {
+		r.Min, r.Max = slices.Min(rates), slices.Max(rates)
+		for _, rate := range rates {
+			r.Avg += rate
+		}
+		r.Avg /= float64(len(rates))
+	}
+	slices.Sort(latencies)
+	r.P50 = percentile(latencies, 50)
+	r.P90 = percentile(latencies, 90)
+	r.P99 = percentile(latencies, 99)
+	return r
+}
+
+func mbPerSecond(n int64, d time.Duration) float64 {
+	return float64(n) / 1e6 / d.Seconds()
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, 0 when it is empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+func TestRunSpeedTest(t *testing.T) {
+	withoutRetries(t)
+	body := bytes.Repeat([]byte("x"), int(2*azure.SingleMB+1000))
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := fakeAccountOf(srv).speedTest("link.bin")
+	cfg.Concurrency = 2
+
+	t.Run("whole blob", func(t *testing.T) {
+		gets.Store(0)
+		report, err := runSpeedTest(cfg)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(body)), report.Bytes)
+		require.Equal(t, 3, report.Chunks)
+		require.EqualValues(t, 3, gets.Load())
+		require.Positive(t, report.Overall)
+		require.LessOrEqual(t, report.Min, report.Avg)
+		require.LessOrEqual(t, report.Avg, report.Max)
+		require.LessOrEqual(t, report.P50, report.P99)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		gets.Store(0)
+		cfg := cfg
+		cfg.Offset = 500
+		cfg.Length = azure.SingleMB
+		report, err := runSpeedTest(cfg)
+		require.NoError(t, err)
+		require.Equal(t, azure.SingleMB, report.Bytes)
+		require.Equal(t, 1, report.Chunks)
+		require.EqualValues(t, 1, gets.Load())
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 5*time.Millisecond, percentile(sorted, 50))
+	require.Equal(t, 9*time.Millisecond, percentile(sorted, 90))
+	require.Equal(t, 10*time.Millisecond, percentile(sorted, 99))
+	require.Equal(t, time.Millisecond, percentile(sorted, 0))
+	require.Zero(t, percentile(nil, 50))
+}
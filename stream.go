@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// stdoutLocalFile as the local file streams a download to stdout.
+const stdoutLocalFile = "-"
+
+// StreamConfig is everything runStreamDownload needs once flags and environment are resolved.
+type StreamConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	MaxSize     int64 // refuse a blob larger than this, 0 means no limit
+	// nil logs no progress
+	Progress   *progressThrottle
+	HTTPClient *http.Client
+	Log        *base.LogObject // of the stream, nil for the package's log
+}
+
+// logger returns cfg.Log, or the package's log without one.
+func (cfg StreamConfig) logger() *base.LogObject {
+	if cfg.Log != nil {
+		return cfg.Log
+	}
+	return log
+}
+
+// runStreamDownload writes cfg.RemoteFile to w as it arrives, e.g. into a pipe.
+// Nothing is kept on disk, so there is nothing to resume from: a failed stream has
+// to be started over by whoever reads it. Progress only goes to the log. The MD5
+// and SHA-256 of the result are of what was written, hashed on the way.
+func runStreamDownload(cfg StreamConfig, w io.Writer) (Result, error) {
+	started := time.Now()
+	size, _, err := azure.GetAzureBlobMetaData(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+	if err != nil {
+		return Result{}, err
+	}
+	if cfg.MaxSize > 0 && size > cfg.MaxSize {
+		return Result{}, fmt.Errorf("%w: %s is %d bytes, the limit is %d",
+			ErrTooLarge, cfg.RemoteFile, size, cfg.MaxSize)
+	}
+
+	pw := &progressWriter{w: w, total: size, throttle: cfg.Progress, name: cfg.RemoteFile, log: cfg.logger()}
+	sha := sha256.New()
+	n, sum, err := azure.HashAzureBlob(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+		cfg.Container, cfg.RemoteFile, io.MultiWriter(pw, sha), cfg.HTTPClient)
+	result := Result{Bytes: n, MD5: sum, SHA256: hex.EncodeToString(sha.Sum(nil)), Duration: time.Since(started)}
+	if err != nil {
+		return result, err
+	}
+	if n != size {
+		return result, fmt.Errorf("stream of %s ended at byte %d of %d", cfg.RemoteFile, n, size)
+	}
+	return result, nil
+}
+
+// deviceWriteSize is the size of the writes to a device or named pipe: large and a
+// multiple of any sector size, as O_DIRECT would want, without its alignment rules.
+const deviceWriteSize = 4 << 20
+
+// isStreamDestination reports whether localFile is a device or a named pipe, which
+// cannot be seeked in to resume and is streamed into instead. A missing file or a
+// regular one is not; a directory, or a socket, is an error.
+func isStreamDestination(localFile string) (bool, error) {
+	info, err := os.Stat(localFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		return false, nil
+	case mode.IsDir():
+		return false, fmt.Errorf("%s is a directory, not a file to download to", localFile)
+	case mode&(os.ModeDevice|os.ModeNamedPipe) != 0:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s is neither a file, a device nor a named pipe (%v)", localFile, mode.Type())
+	}
+}
+
+// runStreamToFile is runStreamDownload into the device or named pipe localFile, in
+// sequential writes of deviceWriteSize. A pipe blocks it until a reader opens it.
+func runStreamToFile(cfg StreamConfig, localFile string) (Result, error) {
+	f, err := os.OpenFile(localFile, os.O_WRONLY, 0)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open %s: %w", localFile, err)
+	}
+	w := bufio.NewWriterSize(f, deviceWriteSize)
+	result, err := runStreamDownload(cfg, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close %s: %w", localFile, cerr)
+	}
+	return result, err
+}
+
+// progressWriter logs the progress of what is written through it.
+type progressWriter struct {
+	w        io.Writer
+	name     string
+	current  int64
+	total    int64
+	throttle *progressThrottle
+	log      *base.LogObject
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.current += int64(n)
+	if p.throttle.shouldLog(p.current, p.total) {
+		p.log.Functionf("Progress: %v/%v for %s", p.current, p.total, p.name)
+		p.throttle.report(p.name, p.current, p.total)
+	}
+	return n, err
+}
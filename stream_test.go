@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func streamTestConfig(srv *httptest.Server, name string) StreamConfig {
+	return fakeAccountOf(srv).stream(name)
+}
+
+func TestRunStreamDownloadToPipe(t *testing.T) {
+	withoutRetries(t)
+	content := []byte("small blob piped into tar")
+	store := &auditStore{blobs: map[string]auditBlob{"small.tar": {data: content}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	piped := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		piped <- data
+	}()
+	cfg := streamTestConfig(srv, "small.tar")
+	cfg.Progress = newProgressThrottle(0, 0)
+
+	result, err := runStreamDownload(cfg, w)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, content, <-piped)
+	require.Equal(t, int64(len(content)), result.Bytes)
+	require.Equal(t, hex.EncodeToString(md5Of(content)), result.MD5)
+	sha := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sha[:]), result.SHA256, "hashed while streaming")
+	require.NoError(t, r.Close())
+}
+
+func TestRunStreamDownloadMaxSize(t *testing.T) {
+	withoutRetries(t)
+	store := &auditStore{blobs: map[string]auditBlob{"big.img": {data: make([]byte, 100)}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	cfg := streamTestConfig(srv, "big.img")
+	cfg.MaxSize = 99
+
+	var out countingWriter
+	_, err := runStreamDownload(cfg, &out)
+	require.ErrorIs(t, err, ErrTooLarge)
+	require.Zero(t, out.n, "nothing is streamed")
+}
+
+type countingWriter struct{ n int }
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	return len(b), nil
+}
+
+func TestRunStreamToNamedPipe(t *testing.T) {
+	withoutRetries(t)
+	content := bytes.Repeat([]byte("disk image "), deviceWriteSize/5) // more than one write
+	store := &auditStore{blobs: map[string]auditBlob{"disk.img": {data: content}}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	fifo := filepath.Join(t.TempDir(), "disk.fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+	isStream, err := isStreamDestination(fifo)
+	require.NoError(t, err)
+	require.True(t, isStream)
+
+	piped := make(chan []byte)
+	go func() {
+		r, err := os.Open(fifo)
+		if err != nil {
+			piped <- nil
+			return
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		piped <- data
+	}()
+
+	result, err := runStreamToFile(streamTestConfig(srv, "disk.img"), fifo)
+	require.NoError(t, err)
+	require.Equal(t, content, <-piped)
+	require.Equal(t, int64(len(content)), result.Bytes)
+	require.Equal(t, hex.EncodeToString(md5Of(content)), result.MD5)
+}
+
+func TestIsStreamDestination(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "disk.img")
+	require.NoError(t, os.WriteFile(regular, []byte("partial"), 0644))
+	for name, tc := range map[string]struct {
+		path     string
+		isStream bool
+		err      string
+	}{
+		"missing":     {path: filepath.Join(dir, "new.img")},
+		"regular":     {path: regular},
+		"char device": {path: os.DevNull, isStream: true},
+		"directory":   {path: dir, err: "is a directory"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			isStream, err := isStreamDestination(tc.path)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.isStream, isStream)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// TLSSettings are the -ca-file, -tls-min and -tls-insecure-skip-verify flags.
+type TLSSettings struct {
+	CAFile     string // PEM certificates trusted instead of the system roots
+	MinVersion string // "1.2" or "1.3", empty for the Go default
+	// accept any certificate, for development against self-signed endpoints only
+	InsecureSkipVerify bool
+}
+
+// isDefault reports whether s leaves TLS to the defaults of each transport.
+func (s TLSSettings) isDefault() bool {
+	return s == TLSSettings{}
+}
+
+// config returns the *tls.Config of s, nil when it is the default, with the PEM of
+// CAFile for the transports that take certificates instead.
+func (s TLSSettings) config() (*tls.Config, []byte, error) {
+	if s.isDefault() {
+		return nil, nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.MinVersion != "" {
+		v, err := parseTLSVersion(s.MinVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.MinVersion = v
+	}
+	var pem []byte
+	if s.CAFile != "" {
+		var err error
+		if pem, err = os.ReadFile(s.CAFile); err != nil {
+			return nil, nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no PEM certificate in CA file %s", s.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, pem, nil
+}
+
+// parseTLSVersion parses a -tls-min value. Versions before 1.2 are refused, the
+// storage services do not accept them either.
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", s)
+	}
+}
+
+// httpsProxyFromEnv returns the proxy of HTTPS_PROXY (or https_proxy), which
+// -no-verify-tls-on-proxy-only sends every request through. It has to be an
+// https:// proxy, there is no certificate to relax otherwise.
+func httpsProxyFromEnv() (*url.URL, error) {
+	raw := os.Getenv("HTTPS_PROXY")
+	if raw == "" {
+		raw = os.Getenv("https_proxy")
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("HTTPS_PROXY is not set")
+	}
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTPS_PROXY: %v", err)
+	}
+	if proxy.Scheme != "https" || proxy.Host == "" {
+		return nil, fmt.Errorf("HTTPS_PROXY %s is not an https:// proxy", proxy.Redacted())
+	}
+	return proxy, nil
+}
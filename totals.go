@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// transferTotals adds up the transfers of a bulk operation, e.g. the files of a
+// directory upload, for a view of the whole rather than of each file. Workers update
+// it concurrently. A nil *transferTotals records nothing.
+type transferTotals struct {
+	bytes   atomic.Int64
+	active  atomic.Int64
+	done    atomic.Int64
+	now     func() time.Time
+	started time.Time
+}
+
+func newTransferTotals() *transferTotals {
+	t := &transferTotals{now: time.Now}
+	t.started = t.now()
+	return t
+}
+
+// transferSnapshot is the state of transferTotals at one point in time.
+type transferSnapshot struct {
+	Bytes      int64
+	Active     int64 // transfers started and not finished
+	Done       int64
+	Elapsed    time.Duration
+	Throughput float64 // bytes per second since the totals were created
+}
+
+func (s transferSnapshot) String() string {
+	return fmt.Sprintf("%d bytes, %d transfers active, %d done, %.0f bytes/s over %v",
+		s.Bytes, s.Active, s.Done, s.Throughput, s.Elapsed.Round(time.Second))
+}
+
+// start marks the beginning of a transfer; finish has to follow it.
+func (t *transferTotals) start() {
+	if t == nil {
+		return
+	}
+	t.active.Add(1)
+}
+
+func (t *transferTotals) finish() {
+	if t == nil {
+		return
+	}
+	t.active.Add(-1)
+	t.done.Add(1)
+}
+
+// add records n more bytes transferred by any of the transfers.
+func (t *transferTotals) add(n int64) {
+	if t == nil {
+		return
+	}
+	t.bytes.Add(n)
+}
+
+// counting returns a writer that passes the writes to w and adds them up.
+func (t *transferTotals) counting(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return totalsWriter{w: w, totals: t}
+}
+
+func (t *transferTotals) snapshot() transferSnapshot {
+	elapsed := t.now().Sub(t.started)
+	s := transferSnapshot{
+		Bytes:   t.bytes.Load(),
+		Active:  t.active.Load(),
+		Done:    t.done.Load(),
+		Elapsed: elapsed,
+	}
+	if elapsed > 0 {
+		s.Throughput = float64(s.Bytes) / elapsed.Seconds()
+	}
+	return s
+}
+
+// logEvery logs the totals once per interval until the returned stop is called,
+// which logs them a last time. The progress of each file stays at the debug level
+// of the transfer itself, this is the line to watch at info.
+func (t *transferTotals) logEvery(interval time.Duration) (stop func()) {
+	if t == nil || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Noticef("Total: %s", t.snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		log.Noticef("Total: %s", t.snapshot())
+	}
+}
+
+// ServeHTTP renders the totals in the Prometheus text format, as downloadMetrics does.
+func (t *transferTotals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := t.snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP transfer_bytes Bytes transferred so far by all transfers.")
+	fmt.Fprintln(w, "# TYPE transfer_bytes gauge")
+	fmt.Fprintf(w, "transfer_bytes %d\n", s.Bytes)
+	fmt.Fprintln(w, "# HELP transfer_throughput_bytes_per_second Overall rate of all transfers since the start.")
+	fmt.Fprintln(w, "# TYPE transfer_throughput_bytes_per_second gauge")
+	fmt.Fprintf(w, "transfer_throughput_bytes_per_second %s\n", formatFloat(s.Throughput))
+	fmt.Fprintln(w, "# HELP transfers_active Transfers in progress.")
+	fmt.Fprintln(w, "# TYPE transfers_active gauge")
+	fmt.Fprintf(w, "transfers_active %d\n", s.Active)
+	fmt.Fprintln(w, "# HELP transfers_done_total Transfers finished, successfully or not.")
+	fmt.Fprintln(w, "# TYPE transfers_done_total counter")
+	fmt.Fprintf(w, "transfers_done_total %d\n", s.Done)
+}
+
+type totalsWriter struct {
+	w      io.Writer
+	totals *transferTotals
+}
+
+func (tw totalsWriter) Write(b []byte) (int, error) {
+	n, err := tw.w.Write(b)
+	tw.totals.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferTotalsFromConcurrentTransfers(t *testing.T) {
+	totals := newTransferTotals()
+	start := time.Unix(0, 0)
+	totals.started = start
+	totals.now = func() time.Time { return start.Add(4 * time.Second) }
+
+	// two fake transfers, one writing through counting, the other adding chunks
+	var wg sync.WaitGroup
+	var buf bytes.Buffer
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		totals.start()
+		defer totals.finish()
+		w := totals.counting(&buf)
+		for i := 0; i < 1000; i++ {
+			_, _ = w.Write([]byte("0123456789"))
+		}
+		started <- struct{}{}
+		<-release
+	}()
+	go func() {
+		defer wg.Done()
+		totals.start()
+		defer totals.finish()
+		for i := 0; i < 1000; i++ {
+			totals.add(6)
+		}
+		started <- struct{}{}
+		<-release
+	}()
+	<-started
+	<-started
+
+	s := totals.snapshot()
+	require.Equal(t, transferSnapshot{Bytes: 16000, Active: 2, Elapsed: 4 * time.Second, Throughput: 4000}, s)
+	rec := httptest.NewRecorder()
+	totals.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		"transfer_bytes 16000\n",
+		"transfer_throughput_bytes_per_second 4000\n",
+		"transfers_active 2\n",
+		"transfers_done_total 0\n",
+	} {
+		require.Contains(t, rec.Body.String(), line)
+	}
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, 10000, buf.Len(), "written through")
+	s = totals.snapshot()
+	require.EqualValues(t, 0, s.Active)
+	require.EqualValues(t, 2, s.Done)
+	require.Equal(t, "16000 bytes, 0 transfers active, 2 done, 4000 bytes/s over 4s", s.String())
+}
+
+func TestNilTransferTotals(t *testing.T) {
+	var totals *transferTotals
+	totals.start()
+	totals.add(1)
+	totals.finish()
+	buf := &bytes.Buffer{}
+	require.Same(t, buf, totals.counting(buf))
+	totals.logEvery(time.Millisecond)()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// transportFromURL returns the transport an account URL is obviously for: azure
+// for the blob or dfs endpoint of an Azure cloud, https://<account>.blob.core.windows.net
+// or a sovereign cloud's, and aws for an s3:// URL or an amazonaws.com host. It is
+// empty when the URL does not tell, e.g. for an emulator or a custom domain.
+func transportFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if strings.EqualFold(u.Scheme, "s3") {
+		return "aws"
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "amazonaws.com" || strings.HasSuffix(host, ".amazonaws.com") {
+		return "aws"
+	}
+	labels := strings.SplitN(host, ".", 3) // <account>.blob.<suffix>
+	if len(labels) == 3 && labels[0] != "" && (labels[1] == "blob" || labels[1] == "dfs") &&
+		slices.Contains(azureEndpointSuffixes, labels[2]) {
+		return "azure"
+	}
+	return ""
+}
+
+// azureEndpointSuffixes are the storage endpoint suffixes of the Azure clouds.
+var azureEndpointSuffixes = []string{"core.windows.net", "core.usgovcloudapi.net", "core.chinacloudapi.cn"}
+
+// inferTransport picks the transport when TRANSPORT is unset, from which of the
+// azure (a connection string, an ACCOUNT_URL of Azure or an ACCOUNT_NAME alone) and
+// aws (AWS_ACCOUNT_URL, the region) settings are present. An ACCOUNT_URL that does
+// not tell, or settings of both, are errors: TRANSPORT has to say.
+func inferTransport(accountURL, accountName, connectionString, awsRegion string) (string, error) {
+	azureSet := false
+	switch {
+	case connectionString != "":
+		azureSet = true
+	case accountURL != "":
+		switch transportFromURL(accountURL) {
+		case "azure":
+			azureSet = true
+		case "aws":
+			return "", fmt.Errorf("ACCOUNT_URL %s is of S3, set TRANSPORT=aws and AWS_ACCOUNT_URL to the region",
+				accountURL)
+		default:
+			return "", fmt.Errorf("cannot tell the transport of ACCOUNT_URL %s, set TRANSPORT", accountURL)
+		}
+	case accountName != "":
+		azureSet = true
+	}
+	switch {
+	case azureSet && awsRegion != "":
+		return "", fmt.Errorf("both azure and aws settings are present, set TRANSPORT")
+	case azureSet:
+		return "azure", nil
+	case awsRegion != "":
+		return "aws", nil
+	}
+	return "", fmt.Errorf("neither azure nor aws settings are present, set TRANSPORT")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportFromURL(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://myaccount.blob.core.windows.net":                 "azure",
+		"https://myaccount.blob.core.windows.net/container?sv=x":  "azure",
+		"HTTPS://MyAccount.Blob.Core.Windows.Net/":                "azure",
+		"https://myaccount.dfs.core.windows.net":                  "azure",
+		"https://myaccount.blob.core.usgovcloudapi.net":           "azure",
+		"https://myaccount.blob.core.chinacloudapi.cn":            "azure",
+		"s3://my-bucket/images/disk.qcow2":                        "aws",
+		"https://s3.me-central-1.amazonaws.com":                   "aws",
+		"https://my-bucket.s3.amazonaws.com/disk.qcow2":           "aws",
+		"http://127.0.0.1:10000/devstoreaccount1":                 "",
+		"https://storage.example.com":                             "",
+		"https://blob.core.windows.net":                           "",
+		"https://myaccount.queue.core.windows.net":                "",
+		"https://myaccount.blob.core.windows.net.attacker.com":    "",
+		"ftp://myaccount.blob.core.windows.net":                   "",
+		"me-central-1":                                            "",
+		"https://notamazonaws.com":                                "",
+		"https://myaccount.blob.core.windows.net:443/container/x": "azure",
+	} {
+		require.Equal(t, want, transportFromURL(rawURL), rawURL)
+	}
+}
+
+func TestInferTransport(t *testing.T) {
+	for name, tc := range map[string]struct {
+		accountURL, accountName, connectionString, awsRegion string
+		want, err                                            string
+	}{
+		"azure url":         {accountURL: "https://myaccount.blob.core.windows.net", want: "azure"},
+		"connection string": {connectionString: "AccountName=myaccount;AccountKey=a2V5", want: "azure"},
+		"account name only": {accountName: "myaccount", want: "azure"},
+		"aws region":        {awsRegion: "me-central-1", want: "aws"},
+		"custom endpoint": {accountURL: "http://127.0.0.1:10000/devstoreaccount1",
+			err: "cannot tell the transport"},
+		"s3 url as account url": {accountURL: "s3://my-bucket", err: "TRANSPORT=aws"},
+		"both": {accountURL: "https://myaccount.blob.core.windows.net", awsRegion: "me-central-1",
+			err: "both azure and aws"},
+		"nothing": {err: "neither azure nor aws"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := inferTransport(tc.accountURL, tc.accountName, tc.connectionString, tc.awsRegion)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	azure "testAzureDownload/azureutil"
+)
+
+const (
+	uploadProgressSuffix = ".upload-progress"
+	defaultUploadBlock   = 8 * 1024 * 1024
+)
+
+// UploadConfig is everything runUpload needs once flags and environment are resolved.
+type UploadConfig struct {
+	AccountURL  string
+	AccountName string
+	AccountKey  string
+	Container   string
+	RemoteFile  string
+	LocalFile   string
+	BlockSize   int64 // 0 means defaultUploadBlock
+	HTTPClient  *http.Client
+	// directory for the .upload-progress sidecar instead of next to LocalFile, empty for none
+	Workspace string
+	// commit the blob with the properties in the .meta.json sidecar of LocalFile, if any
+	RestoreMeta bool
+	// shared with the other uploads of a bulk operation, nil for none
+	Totals *transferTotals
+	// cancels the upload, the block being staged included, nil for none; the blocks
+	// staged before are recorded for a later upload to resume from
+	Context context.Context
+	// set as the Content-Disposition of the blob, over the one of the sidecar
+	ContentDisposition string
+	// set as the Content-Type of the blob, over the one of the sidecar; empty for the
+	// one of the sidecar or else the one of the extension of LocalFile
+	ContentType string
+	// mints a SAS, the query of AccountURL, to go on with once the one of AccountURL
+	// is rejected partway, e.g. expired during a long upload; it may ask a token
+	// service or sign with an account key. The failed request is made again with it.
+	// nil for none.
+	RenewSAS func() (string, error)
+}
+
+// uploadProgress is the .upload-progress sidecar: the blocks of LocalFile already staged.
+// It only applies to the same file (size and modification time) cut into the same blocks.
+type uploadProgress struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	BlockSize int64     `json:"block_size"`
+	Staged    []string  `json:"staged"`
+	// set once every block is staged, right before the commit, with the ETag the
+	// blob had then: a blob with another ETag whose committed blocks are these
+	// was committed by this upload
+	Committing bool   `json:"committing,omitempty"`
+	PrevETag   string `json:"prev_etag,omitempty"`
+}
+
+func loadUploadProgress(locFilename string) uploadProgress {
+	var progress uploadProgress
+	fd, err := os.Open(locFilename + uploadProgressSuffix)
+	if err == nil {
+		if err := json.NewDecoder(fd).Decode(&progress); err != nil {
+			log.Errorf("failed to decode upload progress file: %s", err)
+		}
+		if err := fd.Close(); err != nil {
+			log.Errorf("failed to close upload progress file: %s", err)
+		}
+	}
+	return progress
+}
+
+// saveUploadProgress replaces the sidecar through a synced temporary file, so that a
+// crash leaves either the previous progress or the new one, never a torn write.
+func saveUploadProgress(locFilename string, progress uploadProgress) {
+	// the temporary file ends in the suffix too, for isUploadSidecar
+	fd, err := os.CreateTemp(filepath.Dir(locFilename), filepath.Base(locFilename)+".*"+uploadProgressSuffix)
+	if err != nil {
+		log.Errorf("error creating upload progress file: %s", err)
+		return
+	}
+	err = json.NewEncoder(fd).Encode(progress)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fd.Name(), locFilename+uploadProgressSuffix)
+	}
+	if err != nil {
+		log.Errorf("failed to write upload progress file: %s", err)
+		os.Remove(fd.Name())
+	}
+}
+
+// contentTypeOf is the MIME type of the extension of name, application/octet-stream
+// for an unknown one.
+func contentTypeOf(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// uploadBlockID is the ID of block i. Block IDs of a blob must all have the same length.
+func uploadBlockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", i)))
+}
+
+// runUpload uploads cfg.LocalFile as a block blob, one staged block at a time. Staged
+// blocks are recorded in the .upload-progress sidecar; a restarted upload skips the
+// ones the service still holds uncommitted and only then commits the block list.
+// The sidecar also records when the commit is under way, so that an upload that
+// crashed during it is found committed on restart rather than uploaded again.
+// Cancelling cfg.Context aborts the block being sent and returns its error after
+// the blocks staged so far are recorded.
+func runUpload(cfg UploadConfig) (Result, error) {
+	started := time.Now()
+	blockSize := cfg.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultUploadBlock
+	}
+
+	f, err := os.Open(cfg.LocalFile)
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to open local file %s: %w", cfg.LocalFile, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to stat local file %s: %w", cfg.LocalFile, err)
+	}
+
+	var props *azure.BlobProperties
+	if cfg.RestoreMeta {
+		if props, err = loadBlobMeta(cfg.LocalFile); err != nil {
+			return Result{}, err
+		}
+		if props == nil {
+			log.Noticef("No %s sidecar for %s, uploading without properties", metaSidecarSuffix, cfg.LocalFile)
+		}
+	}
+	if cfg.ContentDisposition != "" {
+		if props == nil {
+			props = &azure.BlobProperties{}
+		}
+		props.ContentDisposition = cfg.ContentDisposition
+	}
+	if cfg.ContentType != "" || props == nil || props.ContentType == "" {
+		if props == nil {
+			props = &azure.BlobProperties{}
+		}
+		props.ContentType = cfg.ContentType
+		if props.ContentType == "" {
+			props.ContentType = contentTypeOf(cfg.LocalFile)
+		}
+	}
+
+	progressBase := sidecarBase(cfg.Workspace, blobRemoteID(cfg.AccountURL, cfg.Container, cfg.RemoteFile), cfg.LocalFile)
+	progress := loadUploadProgress(progressBase)
+	if progress.Size != info.Size() || !progress.ModTime.Equal(info.ModTime()) || progress.BlockSize != blockSize {
+		if len(progress.Staged) > 0 {
+			log.Noticef("Upload progress of %s does not match the file, restarting upload", cfg.LocalFile)
+		}
+		progress = uploadProgress{Size: info.Size(), ModTime: info.ModTime(), BlockSize: blockSize}
+	}
+
+	blockCount := int((info.Size() + blockSize - 1) / blockSize)
+	blocks := make([]string, 0, blockCount)
+	for i := 0; i < blockCount; i++ {
+		blocks = append(blocks, uploadBlockID(i))
+	}
+
+	// a staged block only counts if the service still has it, uncommitted blocks expire
+	var committed, uncommitted []string
+	if len(progress.Staged) > 0 {
+		err = cfg.withRenewedSAS(func() (err error) {
+			committed, uncommitted, err = azure.GetAzureBlockList(cfg.AccountURL, cfg.AccountName,
+				cfg.AccountKey, cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+			return err
+		})
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if progress.Committing && slices.Equal(committed, blocks) {
+		etag, err := cfg.blobETag()
+		if err != nil {
+			return Result{}, err
+		}
+		if etag != progress.PrevETag {
+			log.Noticef("Upload of %s was committed before it was interrupted", cfg.LocalFile)
+			return cfg.finishUpload(Result{Resumed: true}, progressBase, started)
+		}
+	}
+	progress.Committing = false
+	var staged []string
+	for _, id := range progress.Staged {
+		if slices.Contains(uncommitted, id) {
+			staged = append(staged, id)
+		}
+	}
+	progress.Staged = staged
+	result := Result{Resumed: len(staged) > 0}
+
+	ctx := cfg.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for i, id := range blocks {
+		if slices.Contains(progress.Staged, id) {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		offset := int64(i) * blockSize
+		chunk := io.NewSectionReader(f, offset, min(blockSize, info.Size()-offset))
+		err := cfg.withRenewedSAS(func() error {
+			return azure.UploadPartByChunkContext(ctx, cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+				cfg.Container, cfg.RemoteFile, id, cfg.HTTPClient, io.NewSectionReader(chunk, 0, chunk.Size()))
+		})
+		if err != nil && ctx.Err() != nil {
+			break // cancelled, reported below
+		}
+		if err != nil {
+			return result, err
+		}
+		progress.Staged = append(progress.Staged, id)
+		saveUploadProgress(progressBase, progress)
+		result.Bytes += chunk.Size()
+		cfg.Totals.add(chunk.Size())
+		log.Functionf("Staged block %d/%d of %s", i+1, blockCount, cfg.LocalFile)
+	}
+	if err := ctx.Err(); err != nil {
+		saveUploadProgress(progressBase, progress)
+		return result, fmt.Errorf("upload of %s cancelled with %d of %d blocks staged: %w",
+			cfg.LocalFile, len(progress.Staged), blockCount, err)
+	}
+
+	if progress.PrevETag, err = cfg.blobETag(); err != nil {
+		return result, err
+	}
+	progress.Committing = true
+	saveUploadProgress(progressBase, progress)
+	if err := cfg.withRenewedSAS(func() error {
+		return azure.UploadBlockListToBlobWithProperties(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+			cfg.Container, cfg.RemoteFile, cfg.HTTPClient, blocks, props)
+	}); err != nil {
+		return result, err
+	}
+	return cfg.finishUpload(result, progressBase, started)
+}
+
+// blobETag is the ETag of cfg.RemoteFile, empty if it does not exist.
+func (cfg *UploadConfig) blobETag() (string, error) {
+	var stat azure.BlobStat
+	err := cfg.withRenewedSAS(func() (err error) {
+		stat, err = azure.StatAzureBlob(cfg.AccountURL, cfg.AccountName, cfg.AccountKey,
+			cfg.Container, cfg.RemoteFile, cfg.HTTPClient)
+		return err
+	})
+	if azure.StatusFromError(err) == http.StatusNotFound {
+		return "", nil
+	}
+	return stat.ETag, err
+}
+
+// finishUpload removes the sidecar of a committed upload and completes its result.
+func (cfg *UploadConfig) finishUpload(result Result, progressBase string, started time.Time) (Result, error) {
+	if err := os.Remove(progressBase + uploadProgressSuffix); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove upload progress file: %s", err)
+	}
+	result.Duration = time.Since(started)
+
+	sum, err := fileMD5(cfg.LocalFile)
+	if err != nil {
+		return result, err
+	}
+	result.MD5 = sum
+	return result, nil
+}
+
+// withRenewedSAS runs call, and if the service rejects the SAS of cfg.AccountURL runs
+// it once more with the one of cfg.RenewSAS, which replaces it for the calls that
+// follow.
+func (cfg *UploadConfig) withRenewedSAS(call func() error) error {
+	err := call()
+	if err == nil || cfg.RenewSAS == nil || !sasRejected(err) {
+		return err
+	}
+	log.Noticef("SAS rejected uploading %s (%v), renewing it", cfg.LocalFile, err)
+	sas, renewErr := cfg.RenewSAS()
+	if renewErr == nil {
+		sas, renewErr = parseSAS(sas)
+	}
+	if renewErr != nil {
+		return fmt.Errorf("%w; renewing the SAS failed: %v", err, renewErr)
+	}
+	azure.SetAccountSAS(cfg.AccountURL, sas)
+	return call()
+}
+
+// sasRejected tells whether err is the service refusing the credential of a request,
+// as it does with an expired SAS, rather than the operation.
+func sasRejected(err error) bool {
+	var svcErr *azure.ServiceError
+	return errors.As(err, &svcErr) && svcErr.StatusCode == http.StatusForbidden &&
+		svcErr.ErrorCode == "AuthenticationFailed"
+}
+
+// parseSAS returns sas, a SAS query with or without its "?", without the "?".
+func parseSAS(sas string) (string, error) {
+	sas = strings.TrimPrefix(strings.TrimSpace(sas), "?")
+	query, err := url.ParseQuery(sas)
+	if err != nil || query.Get("sig") == "" {
+		return "", fmt.Errorf("not a SAS, no signature")
+	}
+	return sas, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	azure "testAzureDownload/azureutil"
+)
+
+// blockStore is just enough of a block blob for runUpload: Put Block, Get Block List,
+// Put Block List and Get Blob Properties on a single blob.
+type blockStore struct {
+	mu           sync.Mutex
+	staged       map[string][]byte
+	blob         []byte
+	putBlocks    int
+	committed    http.Header // headers of the last Put Block List
+	committedIDs []string
+	sizes        map[string]int // of the committed blocks
+	commits      int            // Put Block List requests, the ETag of the blob
+}
+
+func (s *blockStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	switch {
+	case q.Get("restype") == "container":
+		w.WriteHeader(http.StatusCreated)
+	case q.Get("comp") == "block" && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.staged[q.Get("blockid")] = data
+		s.putBlocks++
+		w.WriteHeader(http.StatusCreated)
+	case q.Get("comp") == "blocklist" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>`)
+		for _, id := range s.committedIDs {
+			fmt.Fprintf(w, `<Block><Name>%s</Name><Size>%d</Size></Block>`, id, s.sizes[id])
+		}
+		fmt.Fprint(w, `</CommittedBlocks><UncommittedBlocks>`)
+		for id, data := range s.staged {
+			fmt.Fprintf(w, `<Block><Name>%s</Name><Size>%d</Size></Block>`, id, len(data))
+		}
+		fmt.Fprint(w, `</UncommittedBlocks></BlockList>`)
+	case q.Get("comp") == "blocklist" && r.Method == http.MethodPut:
+		var list struct {
+			IDs []string `xml:",any"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blob = nil
+		s.sizes = map[string]int{}
+		for _, id := range list.IDs {
+			s.blob = append(s.blob, s.staged[id]...)
+			s.sizes[id] = len(s.staged[id])
+		}
+		s.staged = map[string][]byte{}
+		s.committed = r.Header.Clone()
+		s.committedIDs = list.IDs
+		s.commits++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodHead:
+		if s.commits == 0 {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"0x%d"`, s.commits))
+		w.Header().Set("Content-Length", fmt.Sprint(len(s.blob)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// failingTransport fails every Put Block after the first allow ones, like a
+// connection lost in the middle of an upload.
+type failingTransport struct {
+	allow int
+	seen  int
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut && req.URL.Query().Get("comp") == "block" {
+		t.seen++
+		if t.seen > t.allow {
+			return nil, errors.New("connection reset")
+		}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func withoutRetries(t *testing.T) {
+	saved := azure.GetRetryPolicy()
+	azure.SetRetryPolicy(azure.RetryPolicy{})
+	t.Cleanup(func() { azure.SetRetryPolicy(saved) })
+}
+
+// interruptAndResume uploads localFile, breaking the connection after two blocks,
+// then uploads it again.
+func interruptAndResume(t *testing.T, cfg UploadConfig) Result {
+	cfg.HTTPClient = &http.Client{Transport: &failingTransport{allow: 2}}
+	_, err := runUpload(cfg)
+	require.ErrorContains(t, err, "connection reset")
+	require.Len(t, loadUploadProgress(cfg.LocalFile).Staged, 2)
+
+	cfg.HTTPClient = &http.Client{}
+	result, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Resumed)
+	_, err = os.Stat(cfg.LocalFile + uploadProgressSuffix)
+	require.True(t, os.IsNotExist(err), "progress file is removed once committed")
+	return result
+}
+
+func TestRunUploadResumes(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	data := []byte("0123456789abcdefghij")
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	require.NoError(t, os.WriteFile(localFile, data, 0644))
+
+	cfg := fakeAccountOf(srv).upload("upload.bin", localFile)
+	cfg.BlockSize = 4
+	result := interruptAndResume(t, cfg)
+	require.Equal(t, data, store.blob)
+	require.Equal(t, int64(12), result.Bytes, "only the three missing blocks are sent again")
+	require.Equal(t, 5, store.putBlocks, "two blocks from the first run, three from the second")
+}
+
+func TestRunUploadResumesAzure(t *testing.T) {
+	accountURL := envOrSkip(t, "TEST_AZURE_ACCOUNT_URL")
+	accountName := envOrSkip(t, "TEST_AZURE_ACCOUNT_NAME")
+	accountKey := envOrSkip(t, "TEST_AZURE_ACCOUNT_KEY")
+	container := envOrSkip(t, "TEST_AZURE_CONTAINER")
+	withoutRetries(t)
+
+	blockSize := int64(256 * 1024)
+	data := []byte(strings.Repeat("resumable upload ", int(5*blockSize)/17+1))
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	require.NoError(t, os.WriteFile(localFile, data, 0644))
+	remoteFile := "test-resume-upload-" + uuid.New().String()
+
+	interruptAndResume(t, UploadConfig{
+		AccountURL:  accountURL,
+		AccountName: accountName,
+		AccountKey:  accountKey,
+		Container:   container,
+		RemoteFile:  remoteFile,
+		LocalFile:   localFile,
+		BlockSize:   blockSize,
+	})
+
+	length, _, err := azure.GetAzureBlobMetaData(accountURL, accountName, accountKey, container, remoteFile, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), length)
+
+	// Cleanup
+	require.NoError(t, azure.DeleteAzureBlob(accountURL, accountName, accountKey, container, remoteFile, nil))
+}
+
+func TestRunUploadContentDisposition(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	localFile := filepath.Join(t.TempDir(), "build-1234.raw")
+	require.NoError(t, os.WriteFile(localFile, []byte("installer"), 0644))
+
+	cfg := fakeAccountOf(srv).upload("build-1234.raw", localFile)
+	cfg.ContentDisposition = `attachment; filename="eve-installer.raw"`
+	_, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, `attachment; filename="eve-installer.raw"`, store.committed.Get("x-ms-blob-content-disposition"))
+}
+
+func TestRunUploadRenewsExpiredSAS(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	var sigs []string // of the Put Block requests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig := r.URL.Query().Get("sig")
+		store.mu.Lock()
+		expired := sig == "first" && store.putBlocks >= 2
+		if r.URL.Query().Get("comp") == "block" {
+			sigs = append(sigs, sig)
+		}
+		store.mu.Unlock()
+		if expired {
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		store.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	data := []byte("0123456789abcdefghij")
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	require.NoError(t, os.WriteFile(localFile, data, 0644))
+
+	cfg := fakeAccountOf(srv).signedWith(t, "sv=2022-11-02&sig=first").upload("upload.bin", localFile)
+	cfg.BlockSize = 4
+	_, err := runUpload(cfg)
+	require.ErrorContains(t, err, "AuthenticationFailed", "without RenewSAS the upload stops at the third block")
+	require.Equal(t, 2, store.putBlocks)
+
+	store.staged, store.putBlocks, sigs = map[string][]byte{}, 0, nil
+	os.Remove(localFile + uploadProgressSuffix)
+	renewals := 0
+	cfg.RenewSAS = func() (string, error) {
+		renewals++
+		return "?sv=2022-11-02&sig=second", nil
+	}
+	result, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 1, renewals)
+	require.Equal(t, data, store.blob)
+	require.Equal(t, int64(len(data)), result.Bytes)
+	require.Equal(t, []string{"first", "first", "second", "second", "second"}, sigs,
+		"the third block is staged again with the new SAS, and so are the ones after it")
+}
+
+func TestRunUploadResumesWithNewSASInWorkspace(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	data := []byte("0123456789abcdefghij")
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	require.NoError(t, os.WriteFile(localFile, data, 0644))
+
+	cfg := fakeAccountOf(srv).signedWith(t, "sv=2022-11-02&sig=first").upload("upload.bin", localFile)
+	cfg.BlockSize = 4
+	cfg.Workspace = t.TempDir()
+	cfg.HTTPClient = &http.Client{Transport: &failingTransport{allow: 2}}
+	_, err := runUpload(cfg)
+	require.ErrorContains(t, err, "connection reset")
+
+	// the next run is handed a fresh SAS
+	azure.SetAccountSAS(srv.URL, "sv=2022-11-02&sig=second")
+	cfg.HTTPClient = &http.Client{}
+	result, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Resumed)
+	require.Equal(t, data, store.blob)
+	require.Equal(t, 5, store.putBlocks, "the two blocks staged with the first SAS are not sent again")
+}
+
+// commitTransport breaks the connection on Put Block List, before the request is sent
+// or, with lost, after the service committed the blocks and before its response.
+type commitTransport struct {
+	lost bool
+}
+
+func (t *commitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut || req.URL.Query().Get("comp") != "blocklist" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	if !t.lost {
+		return nil, errors.New("connection reset")
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return nil, errors.New("connection reset")
+}
+
+func TestRunUploadInterruptedCommit(t *testing.T) {
+	withoutRetries(t)
+	for name, lost := range map[string]bool{
+		"before the commit":      false,
+		"response of the commit": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := &blockStore{staged: map[string][]byte{}}
+			srv := httptest.NewServer(store)
+			t.Cleanup(srv.Close)
+			localFile := filepath.Join(t.TempDir(), "upload.bin")
+			cfg := fakeAccountOf(srv).upload("upload.bin", localFile)
+			cfg.BlockSize = 4
+			// an earlier version of the same size leaves the same committed block IDs
+			require.NoError(t, os.WriteFile(localFile, []byte("an earlier version!!"), 0644))
+			_, err := runUpload(cfg)
+			require.NoError(t, err)
+
+			data := []byte("0123456789abcdefghij")
+			require.NoError(t, os.WriteFile(localFile, data, 0644))
+			require.NoError(t, os.Chtimes(localFile, time.Now(), time.Now().Add(time.Minute)))
+			cfg.HTTPClient = &http.Client{Transport: &commitTransport{lost: lost}}
+			_, err = runUpload(cfg)
+			require.ErrorContains(t, err, "connection reset")
+			progress := loadUploadProgress(localFile)
+			require.True(t, progress.Committing)
+			require.Len(t, progress.Staged, 5)
+
+			cfg.HTTPClient = &http.Client{}
+			result, err := runUpload(cfg)
+			require.NoError(t, err)
+			require.True(t, result.Resumed)
+			require.Zero(t, result.Bytes, "no block is staged again")
+			require.Equal(t, data, store.blob)
+			require.Equal(t, 10, store.putBlocks)
+			require.Equal(t, 2, store.commits, "the earlier version's and one of this upload")
+			_, err = os.Stat(localFile + uploadProgressSuffix)
+			require.True(t, os.IsNotExist(err), "progress file is removed once committed")
+		})
+	}
+}
+
+func TestRunUploadContentType(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		file        string
+		contentType string
+		want        string
+	}{
+		"text":    {file: "notes.txt", want: "text/plain"},
+		"json":    {file: "manifest.json", want: "application/json"},
+		"unknown": {file: "disk.unknown-extension", want: "application/octet-stream"},
+		"override": {file: "manifest.json", contentType: "application/vnd.oci.image.index.v1+json",
+			want: "application/vnd.oci.image.index.v1+json"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			localFile := filepath.Join(dir, tc.file)
+			require.NoError(t, os.WriteFile(localFile, []byte("content"), 0644))
+			cfg := fakeAccountOf(srv).upload(tc.file, localFile)
+			cfg.ContentType = tc.contentType
+			_, err := runUpload(cfg)
+			require.NoError(t, err)
+			// the system MIME tables may add a charset to text/plain
+			mediaType, _, _ := strings.Cut(store.committed.Get("x-ms-blob-content-type"), ";")
+			require.Equal(t, tc.want, mediaType)
+		})
+	}
+}
+
+func TestRunUploadCancelled(t *testing.T) {
+	withoutRetries(t)
+	store := &blockStore{staged: map[string][]byte{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var putBlocks int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "block" {
+			if putBlocks++; putBlocks == 3 {
+				// cancelled while the third block is in flight
+				_, _ = io.Copy(io.Discard, r.Body)
+				cancel()
+				<-r.Context().Done()
+				return
+			}
+		}
+		store.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	data := []byte("0123456789abcdefghij")
+	localFile := filepath.Join(t.TempDir(), "upload.bin")
+	require.NoError(t, os.WriteFile(localFile, data, 0644))
+	cfg := fakeAccountOf(srv).upload("upload.bin", localFile)
+	cfg.BlockSize = 4
+	cfg.Context = ctx
+
+	_, err := runUpload(cfg)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 2, store.putBlocks, "the third block is aborted")
+	require.Len(t, loadUploadProgress(localFile).Staged, 2)
+	require.Zero(t, store.commits)
+
+	cfg.Context = nil
+	result, err := runUpload(cfg)
+	require.NoError(t, err)
+	require.True(t, result.Resumed)
+	require.Equal(t, int64(12), result.Bytes)
+	require.Equal(t, data, store.blob)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What runUploadDir does with symbolic links in the tree.
+const (
+	symlinksSkip = "skip"
+	symlinksFail = "fail"
+)
+
+const defaultUploadWorkers = 4
+
+// UploadDirConfig is everything runUploadDir needs once flags and environment are
+// resolved. LocalFile of the embedded UploadConfig is the directory, RemoteFile the
+// prefix the relative path of each file is appended to.
+type UploadDirConfig struct {
+	UploadConfig
+	Workers  int    // files uploaded at once, 0 means defaultUploadWorkers
+	Symlinks string // symlinksSkip or symlinksFail, empty means symlinksSkip
+}
+
+// UploadFileResult is the upload of one file of the tree.
+type UploadFileResult struct {
+	Path   string // relative to the directory
+	Blob   string
+	Result Result
+	Err    error
+}
+
+// UploadDirResult summarizes a directory upload. Files are sorted by Path.
+type UploadDirResult struct {
+	Files    []UploadFileResult
+	Skipped  []string // symbolic links not followed
+	Duration time.Duration
+}
+
+// OK reports whether every file was uploaded.
+func (r UploadDirResult) OK() bool {
+	return r.Failed() == 0
+}
+
+// Failed counts the files that could not be uploaded.
+func (r UploadDirResult) Failed() int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (r UploadDirResult) String() string {
+	var bytes int64
+	for _, f := range r.Files {
+		bytes += f.Result.Bytes
+	}
+	return fmt.Sprintf("%d files, %d failed, %d symlinks skipped (%d bytes in %v)",
+		len(r.Files), r.Failed(), len(r.Skipped), bytes, r.Duration.Round(time.Millisecond))
+}
+
+// runUploadDir uploads every regular file under cfg.LocalFile with runUpload, each
+// as a blob named after its path relative to the directory, under cfg.RemoteFile.
+// The sidecars runUpload itself reads or writes are left out. Per-file failures
+// are reported in the result; walking the tree, or a symbolic link with
+// symlinksFail, is an error before anything is uploaded.
+func runUploadDir(cfg UploadDirConfig) (UploadDirResult, error) {
+	started := time.Now()
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultUploadWorkers
+	}
+
+	var result UploadDirResult
+	var files []string
+	err := filepath.WalkDir(cfg.LocalFile, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cfg.LocalFile, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			if cfg.Symlinks == symlinksFail {
+				return fmt.Errorf("%s is a symbolic link", p)
+			}
+			log.Noticef("Upload: skipping symbolic link %s", p)
+			result.Skipped = append(result.Skipped, rel)
+		case d.Type().IsRegular() && !isUploadSidecar(p, cfg.RestoreMeta):
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return UploadDirResult{}, err
+	}
+
+	result.Files = make([]UploadFileResult, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, rel := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rel string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fileCfg := cfg.UploadConfig
+			fileCfg.LocalFile = filepath.Join(cfg.LocalFile, rel)
+			fileCfg.RemoteFile = path.Join(cfg.RemoteFile, filepath.ToSlash(rel))
+			cfg.Totals.start()
+			r, err := runUpload(fileCfg)
+			cfg.Totals.finish()
+			if err != nil {
+				log.Errorf("Upload: %s: %v", fileCfg.LocalFile, err)
+			}
+			result.Files[i] = UploadFileResult{Path: rel, Blob: fileCfg.RemoteFile, Result: r, Err: err}
+		}(i, rel)
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(started)
+	return result, nil
+}
+
+// isUploadSidecar reports whether name is a file runUpload keeps next to the one it
+// uploads rather than content: its progress and, with restoreMeta, the properties.
+func isUploadSidecar(name string, restoreMeta bool) bool {
+	return strings.HasSuffix(name, uploadProgressSuffix) ||
+		(restoreMeta && strings.HasSuffix(name, metaSidecarSuffix))
+}
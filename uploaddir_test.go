@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dirStore is a blockStore per blob of a single container.
+type dirStore struct {
+	mu    sync.Mutex
+	blobs map[string]*blockStore
+}
+
+func (s *dirStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	b, ok := s.blobs[name]
+	if !ok {
+		b = &blockStore{staged: map[string][]byte{}}
+		s.blobs[name] = b
+	}
+	s.mu.Unlock()
+	b.ServeHTTP(w, r)
+}
+
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func uploadDirTestConfig(srv *httptest.Server, dir string) UploadDirConfig {
+	cfg := UploadDirConfig{UploadConfig: fakeAccountOf(srv).upload("images/", dir), Workers: 2}
+	cfg.BlockSize = 4
+	return cfg
+}
+
+func TestRunUploadDir(t *testing.T) {
+	withoutRetries(t)
+	store := &dirStore{blobs: map[string]*blockStore{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	files := map[string]string{
+		"top.txt":             "at the top",
+		"boot/kernel":         "a kernel of more than one block",
+		"boot/efi/grub.cfg":   "menuentry",
+		"empty":               "",
+		"rootfs/etc/hostname": "eve",
+	}
+	dir := writeTree(t, files)
+	require.NoError(t, os.Symlink("top.txt", filepath.Join(dir, "link.txt")))
+
+	cfg := uploadDirTestConfig(srv, dir)
+	cfg.Totals = newTransferTotals()
+	result, err := runUploadDir(cfg)
+	require.NoError(t, err)
+	require.True(t, result.OK(), result.String())
+	var size int64
+	for _, content := range files {
+		size += int64(len(content))
+	}
+	totals := cfg.Totals.snapshot()
+	require.Equal(t, size, totals.Bytes, "the files add up")
+	require.EqualValues(t, len(files), totals.Done)
+	require.Len(t, result.Files, len(files))
+	require.Equal(t, []string{"link.txt"}, result.Skipped)
+	for name, content := range files {
+		b, ok := store.blobs["images/"+name]
+		require.True(t, ok, "%s was uploaded", name)
+		require.Equal(t, content, string(b.blob), name)
+	}
+	require.Equal(t, "boot/efi/grub.cfg", result.Files[0].Path, "sorted by path")
+	require.Equal(t, "images/boot/efi/grub.cfg", result.Files[0].Blob)
+}
+
+func TestRunUploadDirFailsOnSymlink(t *testing.T) {
+	store := &dirStore{blobs: map[string]*blockStore{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	require.NoError(t, os.Symlink("/etc", filepath.Join(dir, "etc")))
+	cfg := uploadDirTestConfig(srv, dir)
+	cfg.Symlinks = symlinksFail
+
+	_, err := runUploadDir(cfg)
+	require.ErrorContains(t, err, "is a symbolic link")
+	require.Empty(t, store.blobs, "nothing is uploaded")
+}
+
+func TestRunUploadDirReportsFailedFiles(t *testing.T) {
+	withoutRetries(t)
+	store := &dirStore{blobs: map[string]*blockStore{}}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	dir := writeTree(t, map[string]string{"good.txt": "good", "bad.txt": "bad"})
+	// a leftover of an interrupted upload is not content
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "good.txt"+uploadProgressSuffix), []byte("{}"), 0644))
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/bad.txt") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		store.ServeHTTP(w, r)
+	})
+
+	result, err := runUploadDir(uploadDirTestConfig(srv, dir))
+	require.NoError(t, err)
+	require.False(t, result.OK())
+	require.Equal(t, 1, result.Failed())
+	require.Len(t, result.Files, 2, "the progress sidecar is not uploaded")
+	require.Error(t, result.Files[0].Err, "bad.txt")
+	require.NoError(t, result.Files[1].Err, "good.txt")
+	require.Equal(t, "good", string(store.blobs["images/good.txt"].blob))
+}
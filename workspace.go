@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarBase is the name the .progress and .upload-progress suffixes are appended to:
+// localFile itself without a workspace, otherwise a file in workspace named after a
+// hash of remoteID, so that transfers sharing a workspace never collide.
+func sidecarBase(workspace, remoteID, localFile string) string {
+	if workspace == "" {
+		return localFile
+	}
+	sum := sha256.Sum256([]byte(remoteID))
+	return filepath.Join(workspace, hex.EncodeToString(sum[:16]))
+}
+
+// blobRemoteID is the remoteID of sidecarBase for a blob: accountURL without its
+// query, then container and blob. A SAS in the query is left out, a fresh one for
+// the next run or one renewed during the transfer still names the same blob.
+func blobRemoteID(accountURL, container, blob string) string {
+	if u, err := url.Parse(accountURL); err == nil {
+		u.RawQuery, u.ForceQuery, u.Fragment = "", false, ""
+		accountURL = u.String()
+	}
+	return strings.TrimSuffix(accountURL, "/") + "/" + container + "/" + blob
+}